@@ -0,0 +1,497 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const alertRulesCollection = "alert_rules"
+
+// AlertRule fires an alert through the server's notifiers when a condition
+// holds for For:
+//
+//   - container_down: a container is not running
+//   - cpu: a container uses more than Threshold percent CPU
+//   - memory: a container uses more than the Threshold fraction of its limit
+//   - restarts: a container died more than Threshold times within For
+//   - disk: a host filesystem is more than Threshold percent full
+//
+// Container and Mountpoint narrow a rule to one container or filesystem.
+// Rules are evaluated whenever the metrics sampler runs.
+type AlertRule struct {
+	ID         string    `json:"id"`
+	Server     string    `json:"server"`
+	Name       string    `json:"name"`
+	Kind       string    `json:"kind"`
+	Container  string    `json:"container,omitempty"`
+	Mountpoint string    `json:"mountpoint,omitempty"`
+	Threshold  float64   `json:"threshold"`
+	For        string    `json:"for"`
+	Severity   string    `json:"severity"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+var alertRuleKinds = []string{"container_down", "cpu", "memory", "restarts", "disk"}
+
+func (rule *AlertRule) Validate() error {
+	if !containsString(alertRuleKinds, rule.Kind) {
+		return requestErrorf(codeInvalidRequest, "invalid kind %q: use container_down, cpu, memory, restarts or disk", rule.Kind)
+	}
+	if rule.Name == "" {
+		rule.Name = rule.Kind
+	}
+	if rule.For == "" {
+		rule.For = "5m"
+	}
+	if d, err := time.ParseDuration(rule.For); err != nil || d < 0 {
+		return requestErrorf(codeInvalidRequest, "invalid duration for: %s", rule.For)
+	}
+	if rule.Severity == "" {
+		rule.Severity = "critical"
+	}
+	if rule.Severity != "critical" && rule.Severity != "info" {
+		return requestErrorf(codeInvalidRequest, "invalid severity %q: use critical or info", rule.Severity)
+	}
+
+	switch rule.Kind {
+	case "container_down":
+		if rule.Threshold != 0 {
+			return requestErrorf(codeInvalidRequest, "container_down rules take no threshold")
+		}
+	case "cpu":
+		if rule.Threshold <= 0 {
+			return requestErrorf(codeInvalidRequest, "threshold is required: the CPU percentage")
+		}
+	case "memory":
+		if rule.Threshold <= 0 || rule.Threshold > 1 {
+			return requestErrorf(codeInvalidRequest, "threshold is required: the fraction of the memory limit, e.g. 0.9")
+		}
+	case "restarts":
+		if rule.Threshold < 1 {
+			return requestErrorf(codeInvalidRequest, "threshold is required: the number of restarts")
+		}
+		if rule.duration() == 0 {
+			return requestErrorf(codeInvalidRequest, "for is the window restarts are counted in and must not be 0")
+		}
+	case "disk":
+		if rule.Threshold <= 0 || rule.Threshold > 100 {
+			return requestErrorf(codeInvalidRequest, "threshold is required: the percentage of the filesystem in use")
+		}
+	}
+	if rule.Kind == "disk" && rule.Container != "" {
+		return requestErrorf(codeInvalidRequest, "disk rules take a mountpoint, not a container")
+	}
+	if rule.Kind != "disk" && rule.Mountpoint != "" {
+		return requestErrorf(codeInvalidRequest, "only disk rules take a mountpoint")
+	}
+	return nil
+}
+
+func (rule *AlertRule) duration() time.Duration {
+	d, _ := time.ParseDuration(rule.For)
+	return d
+}
+
+// pendingFor is how long a condition has to hold before the alert fires.
+// Restarts are already counted over For.
+func (rule *AlertRule) pendingFor() time.Duration {
+	if rule.Kind == "restarts" {
+		return 0
+	}
+	return rule.duration()
+}
+
+func alertRules(server string) ([]AlertRule, error) {
+	docs, err := store.List(alertRulesCollection)
+	if err != nil {
+		return nil, err
+	}
+	rules := []AlertRule{}
+	for _, key := range sortedMetricKeys(docs) {
+		var rule AlertRule
+		if json.Unmarshal(docs[key], &rule) == nil && rule.Server == server {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+// Alert is a rule whose condition holds for a container or filesystem, the
+// subject. It is firing once the condition has held for the rule's For.
+type Alert struct {
+	Rule    string    `json:"rule"`
+	Name    string    `json:"name"`
+	Server  string    `json:"server"`
+	Subject string    `json:"subject"`
+	Value   float64   `json:"value"`
+	Since   time.Time `json:"since"`
+	Firing  bool      `json:"firing"`
+	FiredAt time.Time `json:"fired_at"`
+}
+
+var (
+	alertsMu sync.Mutex
+	alerts   = map[string]*Alert{}
+)
+
+func alertKey(rule, subject string) string {
+	return rule + "\x00" + subject
+}
+
+// restartHistoryWindow bounds how far back container deaths are remembered.
+const restartHistoryWindow = 24 * time.Hour
+
+var (
+	containerDeathsMu sync.Mutex
+	containerDeaths   = map[string][]time.Time{}
+)
+
+// recordContainerDeath remembers a die event for restarts rules.
+func recordContainerDeath(server, container string, at time.Time) {
+	containerDeathsMu.Lock()
+	defer containerDeathsMu.Unlock()
+	key := server + "\x00" + container
+	cutoff := time.Now().Add(-restartHistoryWindow)
+	kept := []time.Time{at}
+	for _, t := range containerDeaths[key] {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	containerDeaths[key] = kept
+}
+
+// deathsSince counts the deaths of every container of server since t.
+func deathsSince(server string, t time.Time) map[string]int {
+	containerDeathsMu.Lock()
+	defer containerDeathsMu.Unlock()
+	counts := map[string]int{}
+	for key, times := range containerDeaths {
+		name, ok := strings.CutPrefix(key, server+"\x00")
+		if !ok {
+			continue
+		}
+		for _, died := range times {
+			if died.After(t) {
+				counts[name]++
+			}
+		}
+	}
+	return counts
+}
+
+type alertObservation struct {
+	value    float64
+	breached bool
+}
+
+// alertSnapshot is what one sampler run saw of a server, fetched only when a
+// rule needs it.
+type alertSnapshot struct {
+	dm         *DockerManager
+	stats      []ContainerStats
+	containers []Container
+	host       *HostMetrics
+}
+
+func (s *alertSnapshot) observe(rule *AlertRule) (map[string]alertObservation, error) {
+	observations := map[string]alertObservation{}
+	switch rule.Kind {
+	case "cpu", "memory":
+		for _, stats := range s.stats {
+			if rule.Container != "" && stats.Name != rule.Container {
+				continue
+			}
+			value := stats.CPUPercent
+			if rule.Kind == "memory" {
+				if stats.MemoryLimit <= 0 {
+					continue
+				}
+				value = float64(stats.MemoryUsage) / float64(stats.MemoryLimit)
+			}
+			observations[stats.Name] = alertObservation{value, value > rule.Threshold}
+		}
+	case "container_down":
+		if s.containers == nil {
+			containers, _, err := s.dm.CachedContainers(false)
+			if err != nil {
+				return nil, err
+			}
+			s.containers = containers
+		}
+		for _, c := range s.containers {
+			if rule.Container != "" && c.Name != rule.Container {
+				continue
+			}
+			observations[c.Name] = alertObservation{0, c.State != "running"}
+		}
+	case "restarts":
+		for name, count := range deathsSince(s.dm.config.ID(), time.Now().Add(-rule.duration())) {
+			if rule.Container != "" && name != rule.Container {
+				continue
+			}
+			observations[name] = alertObservation{float64(count), float64(count) > rule.Threshold}
+		}
+	case "disk":
+		if s.host == nil {
+			host, err := s.dm.GetHostMetrics()
+			if err != nil {
+				return nil, err
+			}
+			s.host = host
+		}
+		for _, disk := range s.host.Disks {
+			if rule.Mountpoint != "" && disk.Mountpoint != rule.Mountpoint {
+				continue
+			}
+			observations[disk.Mountpoint] = alertObservation{disk.UsedPercent, disk.UsedPercent > rule.Threshold}
+		}
+	}
+	return observations, nil
+}
+
+// alertMessage words a firing alert.
+func alertMessage(rule *AlertRule, a *Alert) string {
+	switch rule.Kind {
+	case "container_down":
+		return fmt.Sprintf("Container %s has been down for %s", a.Subject, rule.For)
+	case "cpu":
+		return fmt.Sprintf("CPU of %s is %.1f%% (above %g%% for %s)", a.Subject, a.Value, rule.Threshold, rule.For)
+	case "memory":
+		return fmt.Sprintf("Memory of %s is at %.0f%% of its limit (above %.0f%% for %s)", a.Subject, a.Value*100, rule.Threshold*100, rule.For)
+	case "restarts":
+		return fmt.Sprintf("Container %s died %.0f times in %s (more than %g)", a.Subject, a.Value, rule.For, rule.Threshold)
+	case "disk":
+		return fmt.Sprintf("Filesystem %s is %.1f%% full (above %g%% for %s)", a.Subject, a.Value, rule.Threshold, rule.For)
+	}
+	return rule.Name + ": " + a.Subject
+}
+
+func alertNotification(rule *AlertRule, a *Alert, resolved bool) *Notification {
+	n := &Notification{
+		ID:       newRequestID(),
+		Event:    "alert",
+		Severity: rule.Severity,
+		Server:   a.Server,
+		Rule:     rule.Name,
+		Message:  alertMessage(rule, a),
+		Time:     time.Now().UTC(),
+	}
+	if rule.Kind != "disk" {
+		n.Container = a.Subject
+	}
+	if resolved {
+		n.Event = "resolved"
+		n.Severity = "info"
+		n.Message = "Resolved: " + rule.Name + " for " + a.Subject
+	}
+	return n
+}
+
+// evaluateAlertRules checks the server's rules against what the sampler saw.
+// An alert fires once when its condition has held long enough and resolves
+// when it no longer holds, including when its subject is gone.
+func evaluateAlertRules(dm *DockerManager, stats []ContainerStats) {
+	rules, err := alertRules(dm.config.ID())
+	if err != nil {
+		dm.logger.Error("failed to read alert rules", "error", err)
+		return
+	}
+	snapshot := &alertSnapshot{dm: dm, stats: stats}
+	for i := range rules {
+		rule := &rules[i]
+		observations, err := snapshot.observe(rule)
+		if err != nil {
+			dm.logger.Error("alert rule evaluation failed", "rule", rule.ID, "error", err)
+			continue
+		}
+		for _, n := range applyObservations(rule, observations, time.Now().UTC()) {
+			dm.logger.Info("alert "+n.Event, "rule", rule.ID, "subject", n.Container, "message", n.Message)
+			notify(dm, n)
+		}
+	}
+}
+
+// applyObservations updates the alerts of rule and returns the notifications
+// to send.
+func applyObservations(rule *AlertRule, observations map[string]alertObservation, now time.Time) []*Notification {
+	alertsMu.Lock()
+	defer alertsMu.Unlock()
+	var notifications []*Notification
+	for subject, observation := range observations {
+		key := alertKey(rule.ID, subject)
+		a := alerts[key]
+		if !observation.breached {
+			if a != nil && a.Firing {
+				notifications = append(notifications, alertNotification(rule, a, true))
+			}
+			delete(alerts, key)
+			continue
+		}
+		if a == nil {
+			a = &Alert{Rule: rule.ID, Name: rule.Name, Server: rule.Server, Subject: subject, Since: now}
+			alerts[key] = a
+		}
+		a.Value = observation.value
+		if !a.Firing && now.Sub(a.Since) >= rule.pendingFor() {
+			a.Firing = true
+			a.FiredAt = now
+			notifications = append(notifications, alertNotification(rule, a, false))
+		}
+	}
+	for key, a := range alerts {
+		if a.Rule != rule.ID {
+			continue
+		}
+		if _, seen := observations[a.Subject]; !seen {
+			if a.Firing {
+				notifications = append(notifications, alertNotification(rule, a, true))
+			}
+			delete(alerts, key)
+		}
+	}
+	return notifications
+}
+
+func activeAlerts(server string) []Alert {
+	alertsMu.Lock()
+	defer alertsMu.Unlock()
+	list := []Alert{}
+	for _, a := range alerts {
+		if a.Server == server {
+			list = append(list, *a)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Since.Before(list[j].Since) })
+	return list
+}
+
+// clearAlerts forgets the alerts of a removed rule without resolving them.
+func clearAlerts(rule string) {
+	alertsMu.Lock()
+	defer alertsMu.Unlock()
+	for key, a := range alerts {
+		if a.Rule == rule {
+			delete(alerts, key)
+		}
+	}
+}
+
+func alertRulesHandler(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		writeError(w, codeUnavailable, "Alert rules are not available")
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		rules, err := alertRules(manager.config.ID())
+		if err != nil {
+			writeFailure(w, fmt.Errorf("Failed to read alert rules: %w", err))
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"success": true,
+			"rules":   rules,
+			"count":   len(rules),
+		})
+	case "POST":
+		var rule AlertRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			writeError(w, codeInvalidRequest, "Invalid JSON format")
+			return
+		}
+		if err := rule.Validate(); err != nil {
+			writeFailure(w, err)
+			return
+		}
+		rule.ID = newRequestID()
+		rule.Server = manager.config.ID()
+		rule.CreatedAt = time.Now().UTC()
+
+		err := store.Put(alertRulesCollection, rule.ID, rule)
+		recordAudit(r, manager.config.ID(), "alert_rule.create", rule.Name, err)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"success": true,
+			"rule":    rule,
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func alertRuleRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if store == nil {
+		writeError(w, codeUnavailable, "Alert rules are not available")
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	var rule AlertRule
+	found, err := store.Get(alertRulesCollection, id, &rule)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	if !found || rule.Server != manager.config.ID() {
+		writeError(w, codeNotFound, "Alert rule not found: "+id)
+		return
+	}
+
+	err = store.Delete(alertRulesCollection, id)
+	recordAudit(r, manager.config.ID(), "alert_rule.remove", rule.Name, err)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	clearAlerts(id)
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"message": "Alert rule " + id + " removed",
+	})
+}
+
+// alertsHandler lists the alerts of the current server, pending and firing.
+func alertsHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+	list := activeAlerts(manager.config.ID())
+	firing := 0
+	for _, a := range list {
+		if a.Firing {
+			firing++
+		}
+	}
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"alerts":  list,
+		"count":   len(list),
+		"firing":  firing,
+	})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ansiPattern matches CSI sequences (colors, cursor movement), OSC sequences
+// (window titles, hyperlinks) and the remaining two-byte escapes.
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+var ansiColors = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+func stripANSI(s string) string {
+	if !strings.Contains(s, "\x1b") {
+		return s
+	}
+	return ansiPattern.ReplaceAllString(s, "")
+}
+
+type ansiStyle struct {
+	fg, bg                       string
+	bold, dim, italic, underline bool
+}
+
+func (s ansiStyle) classes() string {
+	var classes []string
+	if s.fg != "" {
+		classes = append(classes, "ansi-"+s.fg)
+	}
+	if s.bg != "" {
+		classes = append(classes, "ansi-bg-"+s.bg)
+	}
+	for _, flag := range []struct {
+		on   bool
+		name string
+	}{{s.bold, "bold"}, {s.dim, "dim"}, {s.italic, "italic"}, {s.underline, "underline"}} {
+		if flag.on {
+			classes = append(classes, "ansi-"+flag.name)
+		}
+	}
+	return strings.Join(classes, " ")
+}
+
+// ansiColor maps an index into the 256 color palette to a class name; only
+// the 16 standard colors have one.
+func ansiColor(n int) string {
+	switch {
+	case n < 8:
+		return ansiColors[n]
+	case n < 16:
+		return "bright-" + ansiColors[n-8]
+	}
+	return ""
+}
+
+// apply updates the style with the parameters of an SGR sequence.
+func (s *ansiStyle) apply(params string) {
+	codes := strings.Split(params, ";")
+	for i := 0; i < len(codes); i++ {
+		code, err := strconv.Atoi(codes[i])
+		if err != nil {
+			code = 0
+		}
+		switch {
+		case code == 0:
+			*s = ansiStyle{}
+		case code == 1:
+			s.bold = true
+		case code == 2:
+			s.dim = true
+		case code == 3:
+			s.italic = true
+		case code == 4:
+			s.underline = true
+		case code == 22:
+			s.bold, s.dim = false, false
+		case code == 23:
+			s.italic = false
+		case code == 24:
+			s.underline = false
+		case code >= 30 && code <= 37:
+			s.fg = ansiColors[code-30]
+		case code == 39:
+			s.fg = ""
+		case code >= 40 && code <= 47:
+			s.bg = ansiColors[code-40]
+		case code == 49:
+			s.bg = ""
+		case code >= 90 && code <= 97:
+			s.fg = "bright-" + ansiColors[code-90]
+		case code >= 100 && code <= 107:
+			s.bg = "bright-" + ansiColors[code-100]
+		case (code == 38 || code == 48) && i+1 < len(codes):
+			// 5;n picks from the 256 color palette, 2;r;g;b is true color,
+			// which has no class and is skipped.
+			color := ""
+			if codes[i+1] == "5" && i+2 < len(codes) {
+				n, _ := strconv.Atoi(codes[i+2])
+				color = ansiColor(n)
+				i += 2
+			} else if codes[i+1] == "2" {
+				i += 4
+			}
+			if code == 38 {
+				s.fg = color
+			} else {
+				s.bg = color
+			}
+		}
+	}
+}
+
+// ansiToHTML escapes s for HTML and turns SGR color and style sequences into
+// spans with ansi-* classes. Any other escape sequence is dropped.
+func ansiToHTML(s string) string {
+	if !strings.Contains(s, "\x1b") {
+		return html.EscapeString(s)
+	}
+
+	var out strings.Builder
+	var style ansiStyle
+	open := false
+	last := 0
+	for _, loc := range ansiPattern.FindAllStringIndex(s, -1) {
+		out.WriteString(html.EscapeString(s[last:loc[0]]))
+		last = loc[1]
+
+		sequence := s[loc[0]:loc[1]]
+		if !strings.HasPrefix(sequence, "\x1b[") || !strings.HasSuffix(sequence, "m") {
+			continue
+		}
+		style.apply(sequence[2 : len(sequence)-1])
+		if open {
+			out.WriteString("</span>")
+			open = false
+		}
+		if classes := style.classes(); classes != "" {
+			out.WriteString(`<span class="` + classes + `">`)
+			open = true
+		}
+	}
+	out.WriteString(html.EscapeString(s[last:]))
+	if open {
+		out.WriteString("</span>")
+	}
+	return out.String()
+}
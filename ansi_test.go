@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestStripANSI(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"plain text", "plain text"},
+		{"\x1b[31mred\x1b[0m", "red"},
+		{"\x1b[1;32mOK\x1b[m done", "OK done"},
+		{"\x1b[2K\x1b[1Gprogress", "progress"},
+		{"\x1b]0;title\x07body", "body"},
+		{"\x1b]8;;http://example.com\x1b\\link\x1b]8;;\x1b\\", "link"},
+		{"\x1bMup", "up"},
+		{"\x1b[38;5;208morange\x1b[0m", "orange"},
+	} {
+		if got := stripANSI(tc.in); got != tc.want {
+			t.Errorf("stripANSI(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestANSIToHTML(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"<b>&</b>", "&lt;b&gt;&amp;&lt;/b&gt;"},
+		{"\x1b[31mred\x1b[0m plain", `<span class="ansi-red">red</span> plain`},
+		{"\x1b[1;34m<dir>\x1b[0m", `<span class="ansi-blue ansi-bold">&lt;dir&gt;</span>`},
+		{"\x1b[31mred\x1b[42mon green\x1b[39mdefault", `<span class="ansi-red">red</span><span class="ansi-red ansi-bg-green">on green</span><span class="ansi-bg-green">default</span>`},
+		{"\x1b[92mbright", `<span class="ansi-bright-green">bright</span>`},
+		{"\x1b[38;5;9mpalette\x1b[38;5;208mnone", `<span class="ansi-bright-red">palette</span>none`},
+		{"\x1b[38;2;255;0;0;4mtrue color", `<span class="ansi-underline">true color</span>`},
+		{"\x1b[3mit\x1b[23mnot", `<span class="ansi-italic">it</span>not`},
+		{"\x1b[2Kcleared\x1b]0;title\x07", "cleared"},
+	} {
+		if got := ansiToHTML(tc.in); got != tc.want {
+			t.Errorf("ansiToHTML(%q)\n = %q\nwant %q", tc.in, got, tc.want)
+		}
+	}
+}
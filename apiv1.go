@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// apiV1Prefix serves the versioned API. Its routes are those under /api,
+// answering with an Envelope and a status code that matches the outcome
+// instead of 200 with "success": false.
+const apiV1Prefix = "/api/v1/"
+
+const apiVersionKey contextKey = "api_version"
+
+// Envelope is the body of every JSON response of the versioned API: Data on
+// success, Error otherwise, and Meta in both cases.
+type Envelope struct {
+	Data  interface{}            `json:"data"`
+	Error *APIError              `json:"error,omitempty"`
+	Meta  map[string]interface{} `json:"meta"`
+}
+
+// APIError describes a failed request. Code is stable and meant for
+// programs: one of the codes in errors.go, e.g. CONTAINER_NOT_FOUND, or the
+// one of the status; Message is for people. Details carry extra fields such
+// as the confirm_token of a dangerous operation.
+type APIError struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+var errorCodes = map[int]string{
+	http.StatusBadRequest:           codeInvalidRequest,
+	http.StatusUnauthorized:         codeUnauthenticated,
+	http.StatusForbidden:            codeForbidden,
+	http.StatusNotFound:             codeNotFound,
+	http.StatusMethodNotAllowed:     codeMethodNotAllowed,
+	http.StatusConflict:             codeConflict,
+	http.StatusPreconditionRequired: codeConfirmationRequired,
+	http.StatusTooManyRequests:      codeRateLimited,
+	http.StatusServiceUnavailable:   codeUnavailable,
+}
+
+func errorCode(status int) string {
+	if code, ok := errorCodes[status]; ok {
+		return code
+	}
+	return codeInternal
+}
+
+func apiVersionFrom(ctx context.Context) string {
+	version, _ := ctx.Value(apiVersionKey).(string)
+	return version
+}
+
+// withAPIVersions routes /api/v1/... to the /api/... handlers and wraps
+// their JSON responses in an Envelope. Streams, downloads and WebSockets
+// pass through unchanged.
+func withAPIVersions(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, apiV1Prefix) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		legacy := r.WithContext(context.WithValue(r.Context(), apiVersionKey, "v1"))
+		legacy.URL = new(url.URL)
+		*legacy.URL = *r.URL
+		legacy.URL.Path = "/api/" + strings.TrimPrefix(r.URL.Path, apiV1Prefix)
+		legacy.URL.RawPath = ""
+
+		ew := &envelopeWriter{ResponseWriter: w}
+		handler.ServeHTTP(ew, legacy)
+		ew.finish()
+	})
+}
+
+// envelopeWriter buffers a JSON or error response to rewrite it once the
+// handler is done; any other response is written straight through.
+type envelopeWriter struct {
+	http.ResponseWriter
+	status    int
+	decided   bool
+	buffering bool
+	hijacked  bool
+	body      bytes.Buffer
+}
+
+func (ew *envelopeWriter) decide(status int) {
+	if ew.decided {
+		return
+	}
+	ew.decided = true
+	ew.status = status
+	// Some handlers encode JSON without setting a Content-Type; their body
+	// is buffered too and told apart in finish.
+	contentType := ew.Header().Get("Content-Type")
+	ew.buffering = status != http.StatusNotModified && (contentType == "" ||
+		strings.HasPrefix(contentType, "application/json") ||
+		(status >= 400 && strings.HasPrefix(contentType, "text/plain")))
+	if !ew.buffering {
+		ew.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (ew *envelopeWriter) WriteHeader(status int) {
+	ew.decide(status)
+}
+
+func (ew *envelopeWriter) Write(data []byte) (int, error) {
+	ew.decide(http.StatusOK)
+	if ew.buffering {
+		return ew.body.Write(data)
+	}
+	return ew.ResponseWriter.Write(data)
+}
+
+func (ew *envelopeWriter) Flush() {
+	if ew.buffering && ew.Header().Get("Content-Type") == "" {
+		// Only a stream flushes without saying what it sends.
+		ew.passThrough()
+	}
+	if ew.buffering {
+		return
+	}
+	if flusher, ok := ew.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (ew *envelopeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := ew.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	ew.hijacked = true
+	return hijacker.Hijack()
+}
+
+// passThrough stops buffering and writes what was buffered as it is.
+func (ew *envelopeWriter) passThrough() {
+	ew.buffering = false
+	if ew.body.Len() > 0 {
+		ew.Header().Set("Content-Type", http.DetectContentType(ew.body.Bytes()))
+	}
+	ew.ResponseWriter.WriteHeader(ew.status)
+	ew.ResponseWriter.Write(ew.body.Bytes())
+	ew.body.Reset()
+}
+
+func (ew *envelopeWriter) finish() {
+	if ew.hijacked || !ew.buffering {
+		return
+	}
+
+	envelope := Envelope{Meta: map[string]interface{}{"api_version": "v1"}}
+	if id := ew.Header().Get("X-Request-ID"); id != "" {
+		envelope.Meta["request_id"] = id
+	}
+	status := ew.status
+
+	contentType := ew.Header().Get("Content-Type")
+	if contentType == "" {
+		if !json.Valid(ew.body.Bytes()) {
+			ew.passThrough()
+			return
+		}
+		contentType = "application/json"
+	}
+
+	var payload map[string]interface{}
+	if !strings.HasPrefix(contentType, "application/json") {
+		// http.Error's plain text, e.g. "Method not allowed".
+		envelope.Error = &APIError{Code: errorCode(status), Message: strings.TrimSpace(ew.body.String())}
+	} else if err := json.Unmarshal(ew.body.Bytes(), &payload); (err != nil || payload["success"] == nil) && status < 400 {
+		var data interface{}
+		json.Unmarshal(ew.body.Bytes(), &data)
+		envelope.Data = data
+	} else if payload["success"] == true {
+		delete(payload, "success")
+		envelope.Data = payload
+	} else {
+		message, _ := payload["error"].(string)
+		code, _ := payload["code"].(string)
+		if status < 400 {
+			// A "success": false response the handler sent with 200 gets
+			// the status of its code.
+			status = http.StatusInternalServerError
+			if codeStatus, ok := codeStatuses[code]; ok {
+				status = codeStatus
+			}
+		}
+		if code == "" {
+			code = errorCode(status)
+		}
+		delete(payload, "success")
+		delete(payload, "error")
+		delete(payload, "code")
+		delete(payload, "confirmation_required")
+		envelope.Error = &APIError{Code: code, Message: message}
+		if len(payload) > 0 {
+			envelope.Error.Details = payload
+		}
+	}
+
+	body, _ := json.Marshal(envelope)
+	ew.Header().Set("Content-Type", "application/json")
+	ew.ResponseWriter.WriteHeader(status)
+	ew.ResponseWriter.Write(append(body, '\n'))
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net/http"
+	"time"
+)
+
+// attachDetachKeys is passed to docker attach explicitly and sent when the
+// browser goes away, so the remote client detaches instead of closing stdin or
+// forwarding a signal, either of which can take down PID 1.
+const attachDetachKeys = "ctrl-p,ctrl-q"
+
+var attachDetachSequence = []byte{0x10, 0x11}
+
+// AttachContainer connects input and output to the container's main process
+// until it exits or ctx is cancelled, in which case the session is detached.
+func (dm *DockerManager) AttachContainer(ctx context.Context, containerID string, input <-chan []byte, output io.Writer) (err error) {
+	command := fmt.Sprintf("docker attach --sig-proxy=false --detach-keys=%s %s", attachDetachKeys, shellQuote(containerID))
+	_, span := startSpan(ctx, "ssh stream "+commandCategory(command), spanKindClient,
+		"server", dm.config.ID(),
+		"ssh.command.category", commandCategory(command),
+	)
+	defer func() { span.End(err) }()
+
+	client, err := dm.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("SSH session creation failed: %v", err)
+	}
+	defer session.Close()
+
+	// docker attach refuses to attach to a TTY container without a terminal
+	// on its own stdin.
+	if err := session.RequestPty("xterm", 40, 120, ssh.TerminalModes{}); err != nil {
+		return fmt.Errorf("failed to allocate terminal: %v", err)
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	session.Stdout = output
+	session.Stderr = output
+
+	if err := session.Start(command); err != nil {
+		return fmt.Errorf("command '%s' failed: %v", redactCommand(command), err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+
+	for {
+		select {
+		case err := <-done:
+			return err
+		case data, ok := <-input:
+			if !ok {
+				input = nil
+				continue
+			}
+			if _, err := stdin.Write(data); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			stdin.Write(attachDetachSequence)
+			select {
+			case <-done:
+				dm.logger.Info("detached from container", "container", containerID)
+			case <-time.After(3 * time.Second):
+				dm.logger.Error("container did not detach in time, closing connection", "container", containerID)
+			}
+			return nil
+		}
+	}
+}
+
+type websocketWriter struct {
+	conn *websocket.Conn
+}
+
+// Write sends terminal output as binary frames; a frame may end in the middle
+// of a UTF-8 sequence, which text frames would reject.
+func (w websocketWriter) Write(p []byte) (int, error) {
+	w.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if err := w.conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func containerAttachHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		manager.logger.Error("WebSocket upgrade failed", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	input := make(chan []byte, 16)
+	go func() {
+		defer cancel()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			select {
+			case input <- data:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	containerID := mux.Vars(r)["id"]
+	recordAudit(r, manager.config.ID(), "container.attach", containerID, nil)
+	manager.logger.Info("attaching to container", "container", containerID)
+	err = manager.AttachContainer(ctx, containerID, input, websocketWriter{conn})
+	if err != nil && ctx.Err() == nil {
+		manager.logger.Error("attach failed", "container", containerID, "error", err)
+		websocketWriter{conn}.Write([]byte("\r\n[" + err.Error() + "]\r\n"))
+	}
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "detached"))
+	manager.logger.Info("attach session closed", "container", containerID)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const auditCollection = "audit"
+
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	Actor     string    `json:"actor"`
+	Remote    string    `json:"remote"`
+	RequestID string    `json:"request_id"`
+	Server    string    `json:"server"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target"`
+	Result    string    `json:"result"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// actorFrom names who issued the request: the logged in user, or the client
+// address when authentication is disabled.
+func actorFrom(r *http.Request) string {
+	if user := userFrom(r.Context()); user != nil {
+		if token := tokenFrom(r.Context()); token != nil {
+			return user.Username + " (token " + token.Name + ")"
+		}
+		return user.Username
+	}
+	return clientHost(r)
+}
+
+// recordAudit appends a state-changing operation to the audit log. The audit
+// log is never pruned.
+func recordAudit(r *http.Request, server, action, target string, err error) {
+	entry := AuditEntry{
+		Time:      time.Now().UTC(),
+		Actor:     actorFrom(r),
+		Remote:    r.RemoteAddr,
+		RequestID: requestIDFrom(r.Context()),
+		Server:    server,
+		Action:    action,
+		Target:    target,
+	}
+	writeAudit(loggerFrom(r.Context()), entry, err)
+}
+
+// writeAudit fills in the result of entry from err and appends it, also for
+// operations that did not come in over HTTP.
+func writeAudit(logger *slog.Logger, entry AuditEntry, err error) {
+	entry.Result = "success"
+	if err != nil {
+		entry.Result = "failure"
+		entry.Error = err.Error()
+	}
+
+	logger.Info("audit", "actor", entry.Actor, "action", entry.Action, "target", entry.Target, "result", entry.Result)
+	if store == nil {
+		return
+	}
+	if err := store.Append(auditCollection, entry); err != nil {
+		logger.Error("failed to write audit entry", "error", err)
+	}
+}
+
+func queryAudit(r *http.Request) ([]AuditEntry, error) {
+	query := r.URL.Query()
+	since, err := parseSince(query.Get("since"))
+	if err != nil {
+		return nil, err
+	}
+	until, err := parseSince(query.Get("until"))
+	if err != nil {
+		return nil, err
+	}
+
+	filters := map[string]string{
+		"server": query.Get("server"),
+		"actor":  query.Get("actor"),
+		"action": query.Get("action"),
+		"target": query.Get("target"),
+		"result": query.Get("result"),
+	}
+	// ?container= is the common case of ?target=.
+	if container := query.Get("container"); container != "" {
+		filters["target"] = container
+	}
+
+	entries := []AuditEntry{}
+	err = store.Scan(auditCollection, func(data []byte) error {
+		var entry AuditEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil
+		}
+		fields := map[string]string{
+			"server": entry.Server,
+			"actor":  entry.Actor,
+			"action": entry.Action,
+			"target": entry.Target,
+			"result": entry.Result,
+		}
+		for key, want := range filters {
+			if want != "" && fields[key] != want {
+				return nil
+			}
+		}
+		// Restricted users only see entries of servers they may access.
+		if user := userFrom(r.Context()); user != nil && entry.Server != "" && !user.CanAccessServer(entry.Server) {
+			return nil
+		}
+		if entry.Time.Before(since) || (!until.IsZero() && entry.Time.After(until)) {
+			return nil
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		writeError(w, codeUnavailable, "Audit log is not available")
+		return
+	}
+
+	entries, err := queryAudit(r)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			writeError(w, codeInvalidRequest, "Invalid limit: "+value)
+			return
+		}
+	}
+
+	// Newest first, capped at limit.
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"entries": entries,
+		"count":   len(entries),
+	})
+}
+
+// auditExportHandler returns every matching entry, oldest first, as CSV or
+// JSON for archiving outside the app.
+func auditExportHandler(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		writeError(w, codeUnavailable, "Audit log is not available")
+		return
+	}
+
+	entries, err := queryAudit(r)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	filename := "audit-" + time.Now().Format("20060102-150405")
+	if strings.EqualFold(r.URL.Query().Get("format"), "json") {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+		json.NewEncoder(w).Encode(entries)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+	out := csv.NewWriter(w)
+	out.Write([]string{"time", "actor", "remote", "request_id", "server", "action", "target", "result", "error"})
+	for _, e := range entries {
+		out.Write([]string{e.Time.Format(time.RFC3339), e.Actor, e.Remote, e.RequestID, e.Server, e.Action, e.Target, e.Result, e.Error})
+	}
+	out.Flush()
+}
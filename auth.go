@@ -0,0 +1,484 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"html/template"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sessionCookie    = "rdm_session"
+	userKey          = contextKey("user")
+	sessionServerKey = contextKey("session_server")
+	// A client is locked out of logging in for loginLockout after
+	// maxLoginFailures failed attempts.
+	maxLoginFailures = 5
+	loginLockout     = 15 * time.Minute
+)
+
+var (
+	sessionTTL  = envDuration("SESSION_TTL", 12*time.Hour)
+	authEnabled = setting("AUTH_ENABLED") != "false"
+	// dummyHash is compared against for unknown users so a failed login
+	// takes as long whether or not the user exists.
+	dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+)
+
+type session struct {
+	username string
+	csrf     string
+	expires  time.Time
+	// server is the ID of the server the session works on, once one was
+	// configured or selected in it.
+	server string
+}
+
+type loginFailures struct {
+	count int
+	first time.Time
+}
+
+var (
+	sessionsMu sync.Mutex
+	sessions   = map[string]session{}
+	failures   = map[string]*loginFailures{}
+)
+
+// publicPaths are reachable without logging in. Static assets hold nothing
+// secret, and webhook triggers carry their own secret.
+func publicPath(path string) bool {
+	switch path {
+	case "/login", "/health", "/api/auth/login":
+		return true
+	}
+	if strings.HasPrefix(path, "/static/") {
+		return true
+	}
+	return strings.HasPrefix(path, "/api/webhooks/") && strings.HasSuffix(path, "/trigger")
+}
+
+func userFrom(ctx context.Context) *User {
+	user, _ := ctx.Value(userKey).(*User)
+	return user
+}
+
+func writeAuthError(w http.ResponseWriter, status int, message string) {
+	payload := map[string]interface{}{
+		"success": false,
+		"error":   message,
+	}
+	localize(w, payload)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(payload)
+}
+
+func randomToken() string {
+	buf := make([]byte, 32)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// startSession returns the new session's cookie value and the session, whose
+// CSRF token the web interface sends back with every change.
+func startSession(username string) (string, session) {
+	token := randomToken()
+	s := session{username: username, csrf: randomToken(), expires: time.Now().Add(sessionTTL)}
+
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	for key, existing := range sessions {
+		if time.Now().After(existing.expires) {
+			delete(sessions, key)
+		}
+	}
+	sessions[token] = s
+	return token, s
+}
+
+// endSessions logs a user out everywhere, e.g. after removing the account.
+func endSessions(username string) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	for key, s := range sessions {
+		if strings.EqualFold(s.username, username) {
+			delete(sessions, key)
+		}
+	}
+}
+
+// setSessionServer makes the request's session work on server from now on.
+// It reports false for requests without a session.
+func setSessionServer(r *http.Request, server string) bool {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return false
+	}
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	s, ok := sessions[cookie.Value]
+	if ok {
+		s.server = server
+		sessions[cookie.Value] = s
+	}
+	return ok
+}
+
+func sessionServerFrom(ctx context.Context) string {
+	server, _ := ctx.Value(sessionServerKey).(string)
+	return server
+}
+
+// sessionUser returns the logged in user of the request's session cookie and
+// the session.
+func sessionUser(r *http.Request) (*User, session) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil, session{}
+	}
+	sessionsMu.Lock()
+	s, ok := sessions[cookie.Value]
+	if ok && time.Now().After(s.expires) {
+		delete(sessions, cookie.Value)
+		ok = false
+	}
+	sessionsMu.Unlock()
+	if !ok {
+		return nil, session{}
+	}
+
+	user, err := loadUser(s.username)
+	if err != nil || user == nil {
+		return nil, session{}
+	}
+	return user, s
+}
+
+// secureCookies reports whether session cookies get the Secure flag:
+// always or never with SESSION_COOKIE_SECURE=true/false, otherwise when the
+// request arrived over HTTPS, directly or through a proxy.
+func secureCookies(r *http.Request) bool {
+	switch setting("SESSION_COOKIE_SECURE") {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return requestScheme(r) == "https"
+}
+
+// setSessionCookie sets the session cookie and, readable by the web
+// interface's scripts, the CSRF cookie. An empty value clears both.
+func setSessionCookie(w http.ResponseWriter, r *http.Request, value string, s session) {
+	cookie := &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     basePath + "/",
+		Expires:  s.expires,
+		HttpOnly: true,
+		Secure:   secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	}
+	csrf := &http.Cookie{
+		Name:     csrfCookie,
+		Value:    s.csrf,
+		Path:     basePath + "/",
+		Expires:  s.expires,
+		Secure:   secureCookies(r),
+		SameSite: http.SameSiteStrictMode,
+	}
+	if value == "" {
+		cookie.MaxAge = -1
+		csrf.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+	http.SetCookie(w, csrf)
+}
+
+// authMiddleware requires a session or an API token for the web interface
+// and every /api route except the public ones. The user, and the token if
+// one was used, are stored in the request context.
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authEnabled || publicPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if secret := bearerToken(r); secret != "" {
+			user, token := tokenUser(secret)
+			if user == nil {
+				writeAuthError(w, http.StatusUnauthorized, "Invalid or expired API token")
+				return
+			}
+			if !tokenAllows(token, r) {
+				writeAuthError(w, http.StatusForbidden, "API token lacks the scope for this request")
+				return
+			}
+			ctx := context.WithValue(r.Context(), userKey, user)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, tokenKey, token)))
+			return
+		}
+
+		user, s := sessionUser(r)
+		if user == nil {
+			if pagePath(r.URL.Path) {
+				http.Redirect(w, r, basePath+"/login", http.StatusFound)
+				return
+			}
+			writeAuthError(w, http.StatusUnauthorized, "Authentication required")
+			return
+		}
+		ctx := context.WithValue(r.Context(), userKey, user)
+		ctx = context.WithValue(ctx, sessionServerKey, s.server)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, csrfKey, s.csrf)))
+	})
+}
+
+// trustProxyHeaders is set when listening on a Unix socket: only the proxy
+// in front can connect, so X-Real-IP and X-Forwarded-For name the client.
+var trustProxyHeaders bool
+
+func clientHost(r *http.Request) string {
+	if trustProxyHeaders {
+		if ip := r.Header.Get("X-Real-IP"); ip != "" {
+			return ip
+		}
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// loginLocked reports whether the client has failed too often recently.
+func loginLocked(host string) bool {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	f, ok := failures[host]
+	if ok && time.Since(f.first) > loginLockout {
+		delete(failures, host)
+		return false
+	}
+	return ok && f.count >= maxLoginFailures
+}
+
+// recordLoginFailure counts a failed login of host. Counts older than the
+// lockout window are dropped on the way, so clients that fail once and
+// never return do not pile up.
+func recordLoginFailure(host string) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	for key, f := range failures {
+		if time.Since(f.first) > loginLockout {
+			delete(failures, key)
+		}
+	}
+	if f, ok := failures[host]; ok {
+		f.count++
+		return
+	}
+	failures[host] = &loginFailures{count: 1, first: time.Now()}
+}
+
+// authenticate checks a username and password against the local users and,
+// when LDAP_URL is set, the directory. A local account takes precedence over
+// a directory user of the same name.
+func authenticate(username, password string) *User {
+	user, err := loadUser(username)
+	if err != nil {
+		return nil
+	}
+	if user != nil && user.Source != ldapSource {
+		if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+			return nil
+		}
+		return user
+	}
+	if ldapConfig == nil {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil
+	}
+
+	user, err = ldapAuthenticate(ldapConfig, username, password)
+	if err != nil {
+		slog.Warn("ldap login failed", "username", username, "error", err)
+		return nil
+	}
+	return user
+}
+
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req UserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, codeInvalidRequest, "Invalid JSON format")
+		return
+	}
+
+	host := clientHost(r)
+	if loginLocked(host) {
+		writeAuthError(w, http.StatusTooManyRequests, "Too many failed logins, try again later")
+		return
+	}
+	user := authenticate(req.Username, req.Password)
+	if user == nil {
+		recordLoginFailure(host)
+		loggerFrom(r.Context()).Warn("login failed", "username", req.Username, "remote", r.RemoteAddr)
+		writeAuthError(w, http.StatusUnauthorized, "Invalid username or password")
+		return
+	}
+
+	sessionsMu.Lock()
+	delete(failures, host)
+	sessionsMu.Unlock()
+
+	token, s := startSession(user.Username)
+	setSessionCookie(w, r, token, s)
+	if user.Language != "" {
+		setLanguageCookie(w, r, user.Language)
+	}
+	r = r.WithContext(context.WithValue(r.Context(), userKey, user))
+	recordAudit(r, "", "auth.login", user.Username, nil)
+	writeJSON(w, map[string]interface{}{
+		"success":    true,
+		"username":   user.Username,
+		"role":       user.Role,
+		"csrf_token": s.csrf,
+		"expires_at": s.expires.UTC(),
+	})
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		sessionsMu.Lock()
+		delete(sessions, cookie.Value)
+		sessionsMu.Unlock()
+	}
+	setSessionCookie(w, r, "", session{})
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"message": "Logged out",
+	})
+}
+
+func currentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := userFrom(r.Context())
+	if user == nil {
+		writeJSON(w, map[string]interface{}{
+			"success":      true,
+			"auth_enabled": false,
+		})
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success":      true,
+		"auth_enabled": true,
+		"username":     user.Username,
+		"role":         user.Role,
+		"restricted":   user.Restricted && !user.IsAdmin(),
+		"servers":      user.Servers,
+		"language":     languageFrom(r.Context()),
+		"csrf_token":   csrfFrom(r.Context()),
+	})
+}
+
+type PasswordChangeRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+func passwordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := userFrom(r.Context())
+	if user == nil {
+		writeError(w, codeConflict, "Authentication is disabled")
+		return
+	}
+	if user.Source == ldapSource {
+		writeError(w, codeConflict, "Directory users change their password in the directory")
+		return
+	}
+
+	var req PasswordChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, codeInvalidRequest, "Invalid JSON format")
+		return
+	}
+	if authenticate(user.Username, req.CurrentPassword) == nil {
+		writeError(w, codeForbidden, "Current password is wrong")
+		return
+	}
+	if err := validPassword(req.NewPassword); err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	hash, err := hashPassword(req.NewPassword)
+	if err == nil {
+		user.PasswordHash = hash
+		err = saveUser(user)
+	}
+	recordAudit(r, "", "user.password", user.Username, err)
+	if err != nil {
+		writeFailure(w, fmt.Errorf("Failed to change password: %w", err))
+		return
+	}
+
+	// Other sessions may belong to whoever knew the old password.
+	endSessions(user.Username)
+	token, s := startSession(user.Username)
+	setSessionCookie(w, r, token, s)
+	writeJSON(w, map[string]interface{}{
+		"success":    true,
+		"message":    "Password changed",
+		"csrf_token": s.csrf,
+	})
+}
+
+func loginPageHandler(w http.ResponseWriter, r *http.Request) {
+	if user, _ := sessionUser(r); !authEnabled || user != nil {
+		http.Redirect(w, r, basePath+"/", http.StatusFound)
+		return
+	}
+	language := languageFrom(r.Context())
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	loginPages[language].Execute(w, map[string]string{"Lang": language})
+}
+
+// loginPages holds the login page parsed once per language.
+var loginPages = parseLoginPages()
+
+func parseLoginPages() map[string]*template.Template {
+	parsed := map[string]*template.Template{}
+	for _, language := range languages {
+		parsed[language.Code] = template.Must(template.New("login.html").Funcs(templateFuncs).Funcs(translator(language.Code)).ParseFS(webFS, "web/templates/login.html"))
+	}
+	return parsed
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	autoHealLabel            = "rdm.autoheal"
+	autoHealPolicyCollection = "autoheal_policies"
+	autoHealLogCollection    = "autoheal_log"
+	autoHealLogRetention     = 30 * 24 * time.Hour
+)
+
+var (
+	autoHealInterval = envDuration("AUTOHEAL_INTERVAL", 30*time.Second)
+	// autoHealCooldown is the default time between two restarts of a
+	// container, long enough for it to come up and pass its health check.
+	autoHealCooldown = envDuration("AUTOHEAL_COOLDOWN", 5*time.Minute)
+)
+
+const autoHealMaxRetries = 3
+
+// AutoHealPolicy opts a container into auto-heal by name, so the policy
+// survives the container being recreated. The rdm.autoheal=true label does
+// the same with rdm.autoheal.max_retries and rdm.autoheal.cooldown labels for
+// the limits; a stored policy takes precedence.
+type AutoHealPolicy struct {
+	Server     string    `json:"server"`
+	Container  string    `json:"container"`
+	MaxRetries int       `json:"max_retries"`
+	Cooldown   string    `json:"cooldown"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func (p *AutoHealPolicy) Validate() error {
+	if p.MaxRetries == 0 {
+		p.MaxRetries = autoHealMaxRetries
+	}
+	if p.MaxRetries < 1 {
+		return requestErrorf(codeInvalidRequest, "invalid max_retries %d", p.MaxRetries)
+	}
+	if p.Cooldown == "" {
+		p.Cooldown = autoHealCooldown.String()
+	}
+	if d, err := time.ParseDuration(p.Cooldown); err != nil || d < 0 {
+		return requestErrorf(codeInvalidRequest, "invalid cooldown %q", p.Cooldown)
+	}
+	return nil
+}
+
+func (p *AutoHealPolicy) cooldown() time.Duration {
+	d, _ := time.ParseDuration(p.Cooldown)
+	return d
+}
+
+// AutoHealAction is an entry of the auto-heal log: a restart, or giving up
+// after MaxRetries restarts the container did not recover from.
+type AutoHealAction struct {
+	Time      time.Time `json:"time"`
+	Server    string    `json:"server"`
+	Container string    `json:"container"`
+	Reason    string    `json:"reason"`
+	Action    string    `json:"action"`
+	Attempt   int       `json:"attempt"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// AutoHealStatus is a container's policy, where it comes from and how the
+// current round of restarts is going.
+type AutoHealStatus struct {
+	Container   string     `json:"container"`
+	Enabled     bool       `json:"enabled"`
+	Label       bool       `json:"label"`
+	Stored      bool       `json:"stored"`
+	MaxRetries  int        `json:"max_retries,omitempty"`
+	Cooldown    string     `json:"cooldown,omitempty"`
+	Attempts    int        `json:"attempts"`
+	LastAttempt *time.Time `json:"last_attempt,omitempty"`
+	GaveUp      bool       `json:"gave_up"`
+}
+
+type autoHealState struct {
+	attempts int
+	last     time.Time
+	gaveUp   bool
+}
+
+var (
+	autoHealMu     sync.Mutex
+	autoHealStates = map[string]*autoHealState{}
+	// autoHealStopped are containers killed or stopped on purpose since they
+	// last started, which must not be brought back.
+	autoHealStopped = map[string]bool{}
+)
+
+// recordAutoHealEvent notes deliberate stops: docker stop and docker kill
+// send a kill event before the container dies, a crash or OOM kill does not.
+func recordAutoHealEvent(server string, event *DockerEvent) {
+	autoHealMu.Lock()
+	defer autoHealMu.Unlock()
+	switch event.Action {
+	case "kill":
+		autoHealStopped[server+"/"+event.Name] = true
+	case "start":
+		delete(autoHealStopped, server+"/"+event.Name)
+	}
+}
+
+// storedAutoHealPolicies returns the server's stored policies by container
+// name.
+func storedAutoHealPolicies(server string) map[string]AutoHealPolicy {
+	policies := map[string]AutoHealPolicy{}
+	if store == nil {
+		return policies
+	}
+	docs, err := store.List(autoHealPolicyCollection)
+	if err != nil {
+		return policies
+	}
+	for _, data := range docs {
+		var p AutoHealPolicy
+		if json.Unmarshal(data, &p) == nil && p.Server == server {
+			policies[p.Container] = p
+		}
+	}
+	return policies
+}
+
+// autoHealPolicy is the policy of a container and whether it is stored, or
+// nil when the container has not opted in.
+func autoHealPolicy(server, name string, labels map[string]string, stored map[string]AutoHealPolicy) (*AutoHealPolicy, bool) {
+	if p, ok := stored[name]; ok {
+		return &p, true
+	}
+	if !labelEnabled(labels[autoHealLabel]) {
+		return nil, false
+	}
+	p := &AutoHealPolicy{Server: server, Container: name, Cooldown: labels[autoHealLabel+".cooldown"]}
+	p.MaxRetries, _ = strconv.Atoi(labels[autoHealLabel+".max_retries"])
+	if p.Validate() != nil {
+		p = &AutoHealPolicy{Server: server, Container: name}
+		p.Validate()
+	}
+	return p, false
+}
+
+var exitCodePattern = regexp.MustCompile(`^Exited \((\d+)\)`)
+
+// autoHealReason says why a container needs healing, if it does: it is
+// unhealthy, or it exited with an error without being stopped on purpose.
+// Restarting containers are left to docker's own restart policy.
+func autoHealReason(server string, c *Container) string {
+	if c.State == "running" && c.Health == "unhealthy" {
+		return "unhealthy"
+	}
+	match := exitCodePattern.FindStringSubmatch(c.Status)
+	if match == nil || match[1] == "0" {
+		return ""
+	}
+	autoHealMu.Lock()
+	defer autoHealMu.Unlock()
+	if autoHealStopped[server+"/"+c.Name] {
+		return ""
+	}
+	return "exited with code " + match[1]
+}
+
+// autoHeal restarts the server's opted-in containers that need it. After a
+// restart a container gets the policy's cooldown to recover; once it is seen
+// healthy after that, its attempts start over. A container that is still
+// failing after MaxRetries restarts is given up on until it recovers.
+func autoHeal(dm *DockerManager) {
+	containers, _, err := dm.CachedContainers(false)
+	if err != nil {
+		dm.logger.Error("auto-heal check failed", "error", err)
+		return
+	}
+	server := dm.config.ID()
+	stored := storedAutoHealPolicies(server)
+	now := time.Now().UTC()
+
+	for i := range containers {
+		c := &containers[i]
+		policy, _ := autoHealPolicy(server, c.Name, c.Labels, stored)
+		if policy == nil {
+			continue
+		}
+		reason := autoHealReason(server, c)
+
+		autoHealMu.Lock()
+		key := server + "/" + c.Name
+		state := autoHealStates[key]
+		if state == nil {
+			state = &autoHealState{}
+			autoHealStates[key] = state
+		}
+		waiting := now.Sub(state.last) < policy.cooldown()
+		if reason == "" {
+			if !waiting && c.State == "running" && c.Health != "starting" {
+				*state = autoHealState{}
+			}
+			autoHealMu.Unlock()
+			continue
+		}
+		if waiting || state.gaveUp {
+			autoHealMu.Unlock()
+			continue
+		}
+		action := AutoHealAction{Time: now, Server: server, Container: c.Name, Reason: reason, Action: "restart", Attempt: state.attempts + 1}
+		if state.attempts >= policy.MaxRetries {
+			state.gaveUp = true
+			action.Action, action.Attempt = "gave_up", state.attempts
+		} else {
+			state.attempts++
+			state.last = now
+		}
+		autoHealMu.Unlock()
+
+		if action.Action == "restart" {
+			err := dm.RestartContainer(c.ID)
+			writeAudit(dm.logger, AuditEntry{
+				Time:   now,
+				Actor:  "autoheal",
+				Remote: "autoheal",
+				Server: server,
+				Action: "container.restart",
+				Target: c.Name,
+			}, err)
+			if err != nil {
+				action.Error = err.Error()
+			}
+			containerCache.invalidate(server)
+		}
+		recordAutoHealAction(dm, &action)
+	}
+}
+
+func recordAutoHealAction(dm *DockerManager, action *AutoHealAction) {
+	dm.logger.Warn("auto-heal", "container", action.Container, "action", action.Action, "reason", action.Reason, "attempt", action.Attempt, "error", action.Error)
+	if store != nil {
+		if err := store.Append(autoHealLogCollection, action); err != nil {
+			dm.logger.Error("failed to record auto-heal action", "error", err)
+		}
+	}
+
+	n := &Notification{
+		ID:        newRequestID(),
+		Event:     "autoheal",
+		Severity:  "info",
+		Server:    action.Server,
+		Container: action.Container,
+		Time:      action.Time,
+		Message:   fmt.Sprintf("Restarted %s, which %s (attempt %d)", action.Container, action.Reason, action.Attempt),
+	}
+	switch {
+	case action.Action == "gave_up":
+		n.Severity = "critical"
+		n.Message = fmt.Sprintf("Gave up on %s, which %s after %d restarts", action.Container, action.Reason, action.Attempt)
+	case action.Error != "":
+		n.Severity = "critical"
+		n.Message = fmt.Sprintf("Failed to restart %s, which %s: %s", action.Container, action.Reason, action.Error)
+	}
+	notify(dm, n)
+}
+
+// startAutoHealer checks the server's containers every autoHealInterval.
+func startAutoHealer(dm *DockerManager) {
+	if autoHealInterval <= 0 {
+		return
+	}
+
+	server := dm.config.ID()
+	startBackgroundTask("autoheal:"+server, func(ctx context.Context) {
+		dm.logger.Info("auto-healer started", "interval", autoHealInterval)
+		ticker := time.NewTicker(autoHealInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				dm.logger.Info("auto-healer stopped")
+				return
+			case <-ticker.C:
+			}
+			autoHeal(dm)
+		}
+	})
+}
+
+func (dm *DockerManager) AutoHealStatus(containerID string) (*AutoHealStatus, error) {
+	info, err := dm.inspectContainer(containerID)
+	if err != nil {
+		return nil, err
+	}
+	server := dm.config.ID()
+	name := strings.TrimPrefix(info.Name, "/")
+	status := &AutoHealStatus{Container: name, Label: labelEnabled(info.Config.Labels[autoHealLabel])}
+	policy, stored := autoHealPolicy(server, name, info.Config.Labels, storedAutoHealPolicies(server))
+	if policy != nil {
+		status.Enabled = true
+		status.Stored = stored
+		status.MaxRetries = policy.MaxRetries
+		status.Cooldown = policy.Cooldown
+	}
+
+	autoHealMu.Lock()
+	defer autoHealMu.Unlock()
+	if state := autoHealStates[server+"/"+name]; state != nil && state.attempts > 0 {
+		last := state.last
+		status.Attempts = state.attempts
+		status.LastAttempt = &last
+		status.GaveUp = state.gaveUp
+	}
+	return status, nil
+}
+
+type AutoHealRequest struct {
+	Enabled    bool   `json:"enabled"`
+	MaxRetries int    `json:"max_retries"`
+	Cooldown   string `json:"cooldown"`
+}
+
+func containerAutoHealHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+	containerID := mux.Vars(r)["id"]
+
+	switch r.Method {
+	case "GET":
+		status, err := manager.AutoHealStatus(containerID)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"success":  true,
+			"autoheal": status,
+		})
+	case "POST":
+		if store == nil {
+			writeError(w, codeUnavailable, "Stored auto-heal policies are not available; use the "+autoHealLabel+" label")
+			return
+		}
+		var req AutoHealRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, codeInvalidRequest, "Invalid JSON format")
+			return
+		}
+		info, err := manager.inspectContainer(containerID)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+
+		name := strings.TrimPrefix(info.Name, "/")
+		key := manager.config.ID() + "/" + name
+		action := "container.autoheal.disable"
+		if req.Enabled {
+			policy := AutoHealPolicy{
+				Server:     manager.config.ID(),
+				Container:  name,
+				MaxRetries: req.MaxRetries,
+				Cooldown:   req.Cooldown,
+				CreatedAt:  time.Now().UTC(),
+			}
+			if err := policy.Validate(); err != nil {
+				writeFailure(w, fmt.Errorf("Invalid auto-heal policy: %w", err))
+				return
+			}
+			action = "container.autoheal.enable"
+			err = store.Put(autoHealPolicyCollection, key, policy)
+		} else {
+			err = store.Delete(autoHealPolicyCollection, key)
+		}
+		recordAudit(r, manager.config.ID(), action, name, err)
+		if err != nil {
+			writeFailure(w, fmt.Errorf("Failed to save auto-heal policy: %w", err))
+			return
+		}
+		// A changed policy starts a fresh round of attempts.
+		autoHealMu.Lock()
+		delete(autoHealStates, key)
+		autoHealMu.Unlock()
+
+		status, err := manager.AutoHealStatus(containerID)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		manager.logger.Info("container auto-heal changed", "container", name, "enabled", req.Enabled)
+		writeJSON(w, map[string]interface{}{
+			"success":  true,
+			"autoheal": status,
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// autoHealLogHandler returns the auto-heal actions taken on the current
+// server, newest first (?container=, ?limit=, default 100).
+func autoHealLogHandler(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		writeError(w, codeUnavailable, "Auto-heal log is not available")
+		return
+	}
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	limit := 100
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			writeError(w, codeInvalidRequest, "Invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	server := manager.config.ID()
+	actions := []AutoHealAction{}
+	store.Scan(autoHealLogCollection, func(data []byte) error {
+		var action AutoHealAction
+		if json.Unmarshal(data, &action) == nil && action.Server == server &&
+			(query.Get("container") == "" || action.Container == query.Get("container")) {
+			actions = append(actions, action)
+		}
+		return nil
+	})
+	sort.SliceStable(actions, func(i, j int) bool { return actions[i].Time.After(actions[j].Time) })
+	if len(actions) > limit {
+		actions = actions[:limit]
+	}
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"actions": actions,
+		"count":   len(actions),
+	})
+}
+
+func startAutoHealLogPruner() {
+	go func() {
+		for {
+			cutoff := time.Now().Add(-autoHealLogRetention)
+			err := store.Compact(autoHealLogCollection, func(data []byte) bool {
+				var action AutoHealAction
+				return json.Unmarshal(data, &action) == nil && action.Time.After(cutoff)
+			})
+			if err != nil {
+				slog.Error("failed to prune auto-heal log", "error", err)
+			}
+			time.Sleep(24 * time.Hour)
+		}
+	}()
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	autoUpdateLabel          = "rdm.auto-update"
+	pendingUpdatesCollection = "pending_updates"
+	updateHistoryCollection  = "update_history"
+)
+
+var autoUpdateInterval = envDuration("AUTO_UPDATE_INTERVAL", 6*time.Hour)
+
+// PendingUpdate is a newer image waiting for approval on a container
+// labelled rdm.auto-update=approve.
+type PendingUpdate struct {
+	Server     string    `json:"server"`
+	Container  string    `json:"container"`
+	Image      string    `json:"image"`
+	CurrentID  string    `json:"current_image_id"`
+	NewID      string    `json:"new_image_id"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+type UpdateRecord struct {
+	Time       time.Time `json:"time"`
+	Server     string    `json:"server"`
+	Container  string    `json:"container"`
+	Image      string    `json:"image"`
+	OldImageID string    `json:"old_image_id"`
+	NewImageID string    `json:"new_image_id"`
+	Trigger    string    `json:"trigger"`
+	Result     string    `json:"result"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type updateCandidate struct {
+	Container string
+	Image     string
+	ImageID   string
+	Mode      string
+}
+
+// autoUpdateMode maps the label value to "auto", "approve" or "" (opted out).
+func autoUpdateMode(value string) string {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "auto", "yes", "1":
+		return "auto"
+	case "approve", "manual":
+		return "approve"
+	}
+	return ""
+}
+
+func (dm *DockerManager) updateCandidates() ([]updateCandidate, error) {
+	output, err := dm.executeSSHCommand(fmt.Sprintf("docker ps --no-trunc --filter label=%s --format '{{.Names}}|{{.Label %q}}'", autoUpdateLabel, autoUpdateLabel))
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []updateCandidate{}
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "|", 2)
+		if len(parts) < 2 {
+			continue
+		}
+		mode := autoUpdateMode(parts[1])
+		if mode == "" {
+			continue
+		}
+		info, err := dm.inspectContainer(parts[0])
+		if err != nil {
+			dm.logger.Error("failed to inspect update candidate", "container", parts[0], "error", err)
+			continue
+		}
+		candidates = append(candidates, updateCandidate{
+			Container: parts[0],
+			Image:     info.Config.Image,
+			ImageID:   info.Image,
+			Mode:      mode,
+		})
+	}
+	return candidates, nil
+}
+
+// latestImageID pulls the image and reports the ID it now resolves to. A
+// pull is the only registry check that works for every registry and
+// credential helper the host already has configured.
+func (dm *DockerManager) latestImageID(image string) (string, error) {
+	if _, err := dm.executeSSHCommand("docker pull --quiet " + shellQuote(image)); err != nil {
+		return "", fmt.Errorf("failed to pull %s: %v", image, err)
+	}
+	output, err := dm.executeSSHCommand("docker image inspect --format '{{.Id}}' " + shellQuote(image))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(output), nil
+}
+
+// CheckForUpdates looks for newer images of every opted-in container,
+// recreating "auto" containers and queueing "approve" ones.
+func (dm *DockerManager) CheckForUpdates() ([]UpdateRecord, []PendingUpdate, error) {
+	candidates, err := dm.updateCandidates()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	server := dm.config.ID()
+	applied := []UpdateRecord{}
+	pending := []PendingUpdate{}
+	for _, candidate := range candidates {
+		newID, err := dm.latestImageID(candidate.Image)
+		if err != nil {
+			dm.logger.Error("update check failed", "container", candidate.Container, "error", err)
+			continue
+		}
+		if newID == candidate.ImageID {
+			continue
+		}
+
+		if candidate.Mode == "approve" {
+			update := PendingUpdate{
+				Server:     server,
+				Container:  candidate.Container,
+				Image:      candidate.Image,
+				CurrentID:  candidate.ImageID,
+				NewID:      newID,
+				DetectedAt: time.Now().UTC(),
+			}
+			if err := store.Put(pendingUpdatesCollection, server+"/"+candidate.Container, update); err != nil {
+				dm.logger.Error("failed to queue update", "container", candidate.Container, "error", err)
+			}
+			dm.logger.Info("update awaiting approval", "container", candidate.Container, "image", candidate.Image)
+			pending = append(pending, update)
+			continue
+		}
+
+		applied = append(applied, dm.applyUpdate(candidate.Container, candidate.Image, candidate.ImageID, "auto", false))
+	}
+	return applied, pending, nil
+}
+
+func (dm *DockerManager) applyUpdate(container, image, oldID, trigger string, override bool) UpdateRecord {
+	record := UpdateRecord{
+		Time:       time.Now().UTC(),
+		Server:     dm.config.ID(),
+		Container:  container,
+		Image:      image,
+		OldImageID: oldID,
+		Trigger:    trigger,
+		Result:     "success",
+	}
+
+	result, err := dm.RedeployContainer(container, override)
+	if err != nil {
+		record.Result = "failure"
+		record.Error = err.Error()
+		dm.logger.Error("container update failed", "container", container, "error", err)
+	} else {
+		if newID, err := dm.executeSSHCommand("docker inspect --format '{{.Image}}' " + shellQuote(result.NewID)); err == nil {
+			record.NewImageID = strings.TrimSpace(newID)
+		}
+		dm.logger.Info("container updated", "container", container, "image", image, "trigger", trigger)
+	}
+
+	if err := store.Append(updateHistoryCollection, record); err != nil {
+		dm.logger.Error("failed to record update history", "error", err)
+	}
+	return record
+}
+
+// startAutoUpdater checks the server's opted-in containers every
+// autoUpdateInterval.
+func startAutoUpdater(dm *DockerManager) {
+	if store == nil || autoUpdateInterval <= 0 {
+		return
+	}
+
+	startBackgroundTask("autoupdate:"+dm.config.ID(), func(ctx context.Context) {
+		dm.logger.Info("auto-updater started", "interval", autoUpdateInterval)
+		ticker := time.NewTicker(autoUpdateInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				dm.logger.Info("auto-updater stopped")
+				return
+			case <-ticker.C:
+			}
+
+			if _, _, err := dm.CheckForUpdates(); err != nil {
+				dm.logger.Error("auto-update check failed", "error", err)
+			}
+		}
+	})
+}
+
+func pendingUpdates(server string) ([]PendingUpdate, error) {
+	docs, err := store.List(pendingUpdatesCollection)
+	if err != nil {
+		return nil, err
+	}
+	updates := []PendingUpdate{}
+	for _, key := range sortedMetricKeys(docs) {
+		var update PendingUpdate
+		if json.Unmarshal(docs[key], &update) == nil && update.Server == server {
+			updates = append(updates, update)
+		}
+	}
+	return updates, nil
+}
+
+func updatesHandler(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		writeError(w, codeUnavailable, "Update history is not available")
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+	server := manager.config.ID()
+
+	pending, err := pendingUpdates(server)
+	if err != nil {
+		writeFailure(w, fmt.Errorf("Failed to read pending updates: %w", err))
+		return
+	}
+
+	history := []UpdateRecord{}
+	err = store.Scan(updateHistoryCollection, func(data []byte) error {
+		var record UpdateRecord
+		if json.Unmarshal(data, &record) == nil && record.Server == server {
+			history = append(history, record)
+		}
+		return nil
+	})
+	if err != nil {
+		writeFailure(w, fmt.Errorf("Failed to read update history: %w", err))
+		return
+	}
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+	if len(history) > 100 {
+		history = history[:100]
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"success":  true,
+		"pending":  pending,
+		"history":  history,
+		"interval": autoUpdateInterval.String(),
+	})
+}
+
+func updatesCheckHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if store == nil {
+		writeError(w, codeUnavailable, "Update history is not available")
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	applied, pending, err := manager.CheckForUpdates()
+	recordAudit(r, manager.config.ID(), "update.check", "", err)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"applied": applied,
+		"pending": pending,
+	})
+}
+
+func updateActionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if store == nil {
+		writeError(w, codeUnavailable, "Update history is not available")
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	container := vars["name"]
+	key := manager.config.ID() + "/" + container
+
+	var update PendingUpdate
+	found, err := store.Get(pendingUpdatesCollection, key, &update)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	if !found {
+		writeError(w, codeNotFound, "No pending update for "+container)
+		return
+	}
+
+	switch vars["action"] {
+	case "approve":
+		record := manager.applyUpdate(container, update.Image, update.CurrentID, "approved", overrideRequested(r))
+		var updateErr error
+		if record.Error != "" {
+			updateErr = fmt.Errorf("%s", record.Error)
+		}
+		recordAudit(r, manager.config.ID(), "container.update", container, updateErr)
+		if updateErr != nil {
+			writeError(w, codeInternal, record.Error)
+			return
+		}
+		store.Delete(pendingUpdatesCollection, key)
+		writeJSON(w, map[string]interface{}{
+			"success": true,
+			"update":  record,
+			"message": "Container updated successfully",
+		})
+	case "dismiss":
+		err := store.Delete(pendingUpdatesCollection, key)
+		recordAudit(r, manager.config.ID(), "update.dismiss", container, err)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"success": true,
+			"message": "Update dismissed",
+		})
+	default:
+		writeError(w, codeInvalidRequest, "Invalid action")
+	}
+}
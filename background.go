@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+var (
+	backgroundMu    sync.Mutex
+	backgroundTasks = map[string]context.CancelFunc{}
+)
+
+// startBackgroundTask runs fn in its own goroutine. Starting another task
+// with the same key cancels the previous one, so reconfiguring a server does
+// not leave stale collectors behind.
+func startBackgroundTask(key string, fn func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	backgroundMu.Lock()
+	if previous, ok := backgroundTasks[key]; ok {
+		previous()
+	}
+	backgroundTasks[key] = cancel
+	backgroundMu.Unlock()
+
+	go fn(ctx)
+}
+
+func stopBackgroundTask(key string) {
+	backgroundMu.Lock()
+	defer backgroundMu.Unlock()
+	if cancel, ok := backgroundTasks[key]; ok {
+		cancel()
+		delete(backgroundTasks, key)
+	}
+}
+
+// stopBackgroundTasks cancels every task, e.g. on shutdown.
+func stopBackgroundTasks() {
+	backgroundMu.Lock()
+	defer backgroundMu.Unlock()
+	for key, cancel := range backgroundTasks {
+		cancel()
+		delete(backgroundTasks, key)
+	}
+}
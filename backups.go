@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultBackupKeep = 7
+	backupStampFormat = "20060102T150405Z"
+)
+
+var (
+	backupNamePattern  = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+	backupStampPattern = regexp.MustCompile(`^\d{8}T\d{6}Z$`)
+)
+
+// BackupSpec selects what a backup schedule saves and where. Each run writes
+// a set named after its start time, with volumes/<name>.tar.gz and
+// containers/<name>.json plus a compose file per container. Sets beyond
+// Keep are deleted, oldest first.
+type BackupSpec struct {
+	Volumes    []string `json:"volumes"`
+	Containers []string `json:"containers"`
+	// Destination is "local", the manager's data directory, or "remote",
+	// Path on the docker host.
+	Destination string `json:"destination"`
+	Path        string `json:"path,omitempty"`
+	Keep        int    `json:"keep"`
+}
+
+type BackupFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+type BackupSet struct {
+	Name  string       `json:"name"`
+	Time  time.Time    `json:"time"`
+	Files []BackupFile `json:"files"`
+}
+
+func (b *BackupSpec) Validate() error {
+	if len(b.Volumes) == 0 && len(b.Containers) == 0 {
+		return requestErrorf(codeInvalidRequest, "backup needs volumes or containers")
+	}
+	for _, name := range append(append([]string{}, b.Volumes...), b.Containers...) {
+		if !backupNamePattern.MatchString(name) {
+			return requestErrorf(codeInvalidRequest, "invalid name %q", name)
+		}
+	}
+	switch b.Destination {
+	case "":
+		b.Destination = "local"
+	case "local":
+	case "remote":
+		if !path.IsAbs(b.Path) {
+			return requestErrorf(codeInvalidRequest, "remote backups need an absolute path on the host")
+		}
+		b.Path = path.Clean(b.Path)
+	default:
+		return requestErrorf(codeInvalidRequest, "invalid destination %q: use local or remote", b.Destination)
+	}
+	if b.Keep < 0 {
+		return requestErrorf(codeInvalidRequest, "keep must not be negative")
+	}
+	if b.Keep == 0 {
+		b.Keep = defaultBackupKeep
+	}
+	return nil
+}
+
+// backupDir is where the sets of a schedule are kept: locally under the
+// data directory, or on the host.
+func backupDir(s *Schedule) string {
+	if s.Backup.Destination == "remote" {
+		return path.Join(s.Backup.Path, s.ID)
+	}
+	return filepath.Join(store.dir, "backups", s.ID)
+}
+
+func (dm *DockerManager) writeBackupFile(ctx context.Context, s *Schedule, name string, data []byte) error {
+	if s.Backup.Destination == "remote" {
+		dir := shellQuote(path.Dir(name))
+		return dm.streamSSHCommand(ctx, fmt.Sprintf("mkdir -p %s && cat > %s", dir, shellQuote(name)), bytes.NewReader(data), io.Discard)
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, 0600)
+}
+
+func (dm *DockerManager) backupVolumeTo(ctx context.Context, s *Schedule, volume, name string) error {
+	if s.Backup.Destination == "remote" {
+		// Archive on the host, straight into the target directory.
+		dir := path.Dir(name)
+		command := fmt.Sprintf("mkdir -p %s && docker run --rm -v %s:/volume:ro -v %s:/backup alpine tar -czf /backup/%s -C /volume .",
+			shellQuote(dir), shellQuote(volume), shellQuote(dir), shellQuote(path.Base(name)))
+		_, err := dm.executeSSHCommand(command)
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	err = dm.BackupVolume(ctx, volume, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// RunBackup writes a new backup set and applies the retention count. A
+// failure on one volume or container does not stop the others; they are
+// reported together.
+func (dm *DockerManager) RunBackup(ctx context.Context, s *Schedule) (string, error) {
+	stamp := time.Now().UTC().Format(backupStampFormat)
+	root := backupDir(s)
+	join := filepath.Join
+	if s.Backup.Destination == "remote" {
+		join = path.Join
+	}
+	set := join(root, stamp)
+
+	var lines, failures []string
+	for _, volume := range s.Backup.Volumes {
+		if err := dm.backupVolumeTo(ctx, s, volume, join(set, "volumes", volume+".tar.gz")); err != nil {
+			failures = append(failures, fmt.Sprintf("volume %s: %v", volume, err))
+			continue
+		}
+		lines = append(lines, "volume "+volume+" archived")
+	}
+	for _, container := range s.Backup.Containers {
+		req, err := dm.RunConfig(container)
+		if err == nil {
+			var data []byte
+			if data, err = json.MarshalIndent(req, "", "  "); err == nil {
+				err = dm.writeBackupFile(ctx, s, join(set, "containers", container+".json"), data)
+			}
+			if err == nil {
+				err = dm.writeBackupFile(ctx, s, join(set, "containers", container+"-compose.yml"), []byte(req.ComposeFile()))
+			}
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("container %s: %v", container, err))
+			continue
+		}
+		lines = append(lines, "container "+container+" exported")
+	}
+	lines = append(lines, "saved to "+set)
+
+	removed, err := dm.pruneBackups(s)
+	if err != nil {
+		failures = append(failures, "retention: "+err.Error())
+	}
+	for _, name := range removed {
+		lines = append(lines, "removed old backup "+name)
+	}
+
+	if len(failures) > 0 {
+		return strings.Join(lines, "\n"), fmt.Errorf("backup incomplete: %s", strings.Join(failures, "; "))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func (dm *DockerManager) backupSetNames(s *Schedule) ([]string, error) {
+	var names []string
+	if s.Backup.Destination == "remote" {
+		output, err := dm.executeSSHCommand("ls -1 " + shellQuote(backupDir(s)) + " 2>/dev/null || true")
+		if err != nil {
+			return nil, err
+		}
+		names = strings.Fields(output)
+	} else {
+		entries, err := os.ReadDir(backupDir(s))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+	}
+
+	sets := []string{}
+	for _, name := range names {
+		if backupStampPattern.MatchString(name) {
+			sets = append(sets, name)
+		}
+	}
+	sort.Strings(sets)
+	return sets, nil
+}
+
+func (dm *DockerManager) pruneBackups(s *Schedule) ([]string, error) {
+	sets, err := dm.backupSetNames(s)
+	if err != nil || len(sets) <= s.Backup.Keep {
+		return nil, err
+	}
+
+	old := sets[:len(sets)-s.Backup.Keep]
+	for _, name := range old {
+		if s.Backup.Destination == "remote" {
+			_, err = dm.executeSSHCommand("rm -rf " + shellQuote(path.Join(backupDir(s), name)))
+		} else {
+			err = os.RemoveAll(filepath.Join(backupDir(s), name))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return old, nil
+}
+
+// ListBackups returns the schedule's backup sets, newest first.
+func (dm *DockerManager) ListBackups(s *Schedule) ([]BackupSet, error) {
+	names, err := dm.backupSetNames(s)
+	if err != nil {
+		return nil, err
+	}
+
+	sets := []BackupSet{}
+	for i := len(names) - 1; i >= 0; i-- {
+		set := BackupSet{Name: names[i], Files: []BackupFile{}}
+		set.Time, _ = time.Parse(backupStampFormat, names[i])
+
+		if s.Backup.Destination == "remote" {
+			dir := path.Join(backupDir(s), names[i])
+			output, err := dm.executeSSHCommand(fmt.Sprintf("cd %s && find . -type f -exec stat -c '%%s %%n' {} +", shellQuote(dir)))
+			if err != nil {
+				return nil, err
+			}
+			for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+				size, name, ok := strings.Cut(line, " ")
+				if !ok {
+					continue
+				}
+				n, _ := strconv.ParseInt(size, 10, 64)
+				set.Files = append(set.Files, BackupFile{Path: strings.TrimPrefix(name, "./"), Size: n})
+			}
+		} else {
+			dir := filepath.Join(backupDir(s), names[i])
+			filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() {
+					rel, _ := filepath.Rel(dir, p)
+					set.Files = append(set.Files, BackupFile{Path: filepath.ToSlash(rel), Size: info.Size()})
+				}
+				return nil
+			})
+		}
+		sort.Slice(set.Files, func(a, b int) bool { return set.Files[a].Path < set.Files[b].Path })
+		sets = append(sets, set)
+	}
+	return sets, nil
+}
+
+func scheduleBackupsHandler(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		writeError(w, codeUnavailable, "Scheduled tasks are not available")
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+	s, ok := loadSchedule(w, r, manager)
+	if !ok {
+		return
+	}
+	if s.Backup == nil {
+		writeError(w, codeInvalidRequest, "Schedule "+s.Name+" is not a backup")
+		return
+	}
+
+	sets, err := manager.ListBackups(s)
+	if err != nil {
+		writeFailure(w, fmt.Errorf("Failed to list backups: %w", err))
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success":     true,
+		"destination": s.Backup.Destination,
+		"directory":   backupDir(s),
+		"backups":     sets,
+		"count":       len(sets),
+	})
+}
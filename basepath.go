@@ -0,0 +1,61 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// basePath is the URL prefix the app is served under behind a reverse proxy,
+// e.g. "/docker"; empty when it is served at the root.
+var basePath string
+
+// normalizeBasePath turns "docker/", "/docker" and "/docker/" into "/docker"
+// and "/" into "".
+func normalizeBasePath(path string) string {
+	path = strings.Trim(strings.TrimSpace(path), "/")
+	if path == "" {
+		return ""
+	}
+	return "/" + path
+}
+
+// templateFuncs are available to the HTML templates; basePath prefixes the
+// <base> element so the pages' relative links work under a prefix.
+var templateFuncs = template.FuncMap{
+	"basePath": func() string { return basePath },
+}
+
+// withBasePath serves handler under basePath: the prefix is stripped before
+// routing, so routes stay unprefixed, and anything outside it is not found.
+func withBasePath(handler http.Handler) http.Handler {
+	if basePath == "" {
+		return handler
+	}
+	stripped := http.StripPrefix(basePath, handler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == basePath:
+			http.Redirect(w, r, basePath+"/", http.StatusMovedPermanently)
+		case strings.HasPrefix(r.URL.Path, basePath+"/"):
+			stripped.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// requestScheme is the scheme the client used, which a TLS-terminating proxy
+// passes on in X-Forwarded-Proto.
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		return "https"
+	}
+	return "http"
+}
+
+// externalURL is the absolute URL of path, an unprefixed route, as the
+// client reaches it.
+func externalURL(r *http.Request, path string) string {
+	return requestScheme(r) + "://" + r.Host + basePath + path
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// containerCacheTTL is how long a server's container list is reused before
+// it is listed again. Container actions and docker events end it sooner.
+var containerCacheTTL = envDuration("CONTAINER_CACHE_TTL", 5*time.Second)
+
+type cachedContainers struct {
+	done       chan struct{}
+	containers []Container
+	err        error
+	fetched    time.Time
+}
+
+// containerListCache shares container listings between requests, so rapid
+// refreshes don't each run the four SSH commands of GetContainers.
+// Concurrent requests for the same listing wait for a single fetch.
+type containerListCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedContainers
+}
+
+var containerCache = &containerListCache{entries: map[string]*cachedContainers{}}
+
+func containerCacheKey(server string, withSize bool, filters []string) string {
+	return server + "\x00" + strconv.FormatBool(withSize) + "\x00" + strings.Join(filters, "\x00")
+}
+
+// CachedContainers is GetContainers served from the cache when the listing
+// is recent enough. hit reports whether no SSH command ran.
+func (dm *DockerManager) CachedContainers(withSize bool, filters ...string) (containers []Container, hit bool, err error) {
+	if containerCacheTTL <= 0 {
+		containers, err = dm.GetContainers(withSize, filters...)
+		return containers, false, err
+	}
+
+	key := containerCacheKey(dm.config.ID(), withSize, filters)
+	containerCache.mu.Lock()
+	entry, ok := containerCache.entries[key]
+	if ok {
+		select {
+		case <-entry.done:
+			ok = entry.err == nil && time.Since(entry.fetched) < containerCacheTTL
+		default:
+			// Still being fetched by another request.
+		}
+	}
+	if ok {
+		containerCache.mu.Unlock()
+		<-entry.done
+		if entry.err != nil {
+			return nil, false, entry.err
+		}
+		return append([]Container(nil), entry.containers...), true, nil
+	}
+
+	entry = &cachedContainers{done: make(chan struct{})}
+	containerCache.entries[key] = entry
+	containerCache.mu.Unlock()
+
+	entry.containers, entry.err = dm.GetContainers(withSize, filters...)
+	entry.fetched = time.Now()
+	close(entry.done)
+	if entry.err != nil {
+		containerCache.mu.Lock()
+		if containerCache.entries[key] == entry {
+			delete(containerCache.entries, key)
+		}
+		containerCache.mu.Unlock()
+		return nil, false, entry.err
+	}
+	return append([]Container(nil), entry.containers...), false, nil
+}
+
+// invalidate drops every cached listing of server.
+func (c *containerListCache) invalidate(server string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if strings.HasPrefix(key, server+"\x00") {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// containerChangingCommands are the docker commands after which a cached
+// container list may be out of date.
+var containerChangingCommands = []string{
+	"docker start ", "docker stop ", "docker restart ", "docker kill ", "docker rm ",
+	"docker run ", "docker create ", "docker rename ", "docker update ", "docker pause ",
+	"docker unpause ", "docker compose ", "docker stack ", "docker container prune",
+	"docker system prune",
+}
+
+func changesContainers(command string) bool {
+	for _, prefix := range containerChangingCommands {
+		if strings.Contains(command, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// listETag is a weak validator for a container list response; weak because
+// the versioned API wraps the same data differently.
+func listETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `W/"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header names etag.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
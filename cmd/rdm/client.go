@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client calls the versioned HTTP API of a Remote Docker Manager. Token is
+// an API token, sent as a bearer token. Server is the ID of the server
+// requests act on; it may be left blank while only one is configured.
+type Client struct {
+	URL    string
+	Token  string
+	Server string
+	HTTP   *http.Client
+}
+
+// APIError is a failed request as reported in the response envelope.
+type APIError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s (%s)", e.Message, e.Code)
+}
+
+type envelope struct {
+	Data  json.RawMessage `json:"data"`
+	Error *APIError       `json:"error"`
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Request, error) {
+	endpoint := strings.TrimSuffix(c.URL, "/") + "/api/v1/" + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		payload = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, payload)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if c.Server != "" {
+		req.Header.Set("X-Server-ID", c.Server)
+	}
+	return req, nil
+}
+
+// Do sends a request and decodes the data of the response envelope into
+// data, which may be nil.
+func (c *Client) Do(ctx context.Context, method, path string, query url.Values, body, data interface{}) error {
+	req, err := c.newRequest(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result envelope
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("unexpected response from %s: %s", req.URL, resp.Status)
+	}
+	if result.Error != nil {
+		result.Error.Status = resp.StatusCode
+		return result.Error
+	}
+	if data == nil {
+		return nil
+	}
+	return json.Unmarshal(result.Data, data)
+}
+
+// Stream reads a Server-Sent Events response and calls onEvent for each
+// event until the stream ends or ctx is done.
+func (c *Client) Stream(ctx context.Context, path string, query url.Values, onEvent func(event string, data []byte) error) error {
+	req, err := c.newRequest(ctx, "GET", path, query, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Failures before the stream starts come back as an envelope.
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		var result envelope
+		if err := json.NewDecoder(resp.Body).Decode(&result); err == nil && result.Error != nil {
+			result.Error.Status = resp.StatusCode
+			return result.Error
+		}
+		return fmt.Errorf("unexpected response from %s: %s", req.URL, resp.Status)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	event, data := "message", []byte(nil)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if data != nil {
+				if err := onEvent(event, data); err != nil {
+					return err
+				}
+			}
+			event, data = "message", nil
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")...)
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return scanner.Err()
+}
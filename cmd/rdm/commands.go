@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type Server struct {
+	ID       string `json:"id"`
+	Host     string `json:"host"`
+	Port     string `json:"port"`
+	Username string `json:"username"`
+	Current  bool   `json:"current"`
+}
+
+type Container struct {
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	Image        string            `json:"image"`
+	Status       string            `json:"status"`
+	State        string            `json:"state"`
+	Health       string            `json:"health"`
+	Created      time.Time         `json:"created"`
+	Age          string            `json:"age"`
+	PortsDisplay string            `json:"ports_display"`
+	Labels       map[string]string `json:"labels"`
+	OOMKilled    bool              `json:"oom_killed"`
+	CrashLooping bool              `json:"crash_looping"`
+}
+
+type LogEntry struct {
+	Container string    `json:"container,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Stream    string    `json:"stream"`
+	Message   string    `json:"message"`
+}
+
+// printJSON writes value indented, for -o json.
+func printJSON(value interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// printTable writes rows under header, aligned in columns.
+func printTable(header []string, rows [][]string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+func listServers(ctx context.Context) ([]Server, error) {
+	var data struct {
+		Servers []Server `json:"servers"`
+	}
+	err := client.Do(ctx, "GET", "servers", nil, nil, &data)
+	return data.Servers, err
+}
+
+func serversCommand() *cobra.Command {
+	servers := &cobra.Command{
+		Use:   "servers",
+		Short: "Work with the configured servers",
+	}
+	servers.AddCommand(&cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List the servers you may access",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			list, err := listServers(cmd.Context())
+			if err != nil {
+				return err
+			}
+			if output == "json" {
+				return printJSON(list)
+			}
+			rows := [][]string{}
+			for _, s := range list {
+				current := ""
+				if s.Current {
+					current = "*"
+				}
+				rows = append(rows, []string{s.ID, s.Host, s.Port, s.Username, current})
+			}
+			return printTable([]string{"ID", "HOST", "PORT", "USER", "CURRENT"}, rows)
+		},
+	})
+	return servers
+}
+
+func psCommand() *cobra.Command {
+	var labels []string
+	var all bool
+	ps := &cobra.Command{
+		Use:   "ps",
+		Short: "List containers",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			query := url.Values{"label": labels}
+			if !all {
+				query.Set("state", "running")
+			}
+			var data struct {
+				Containers []Container `json:"containers"`
+			}
+			if err := client.Do(cmd.Context(), "GET", "containers", query, nil, &data); err != nil {
+				return err
+			}
+
+			containers := data.Containers
+			if output == "json" {
+				return printJSON(containers)
+			}
+			rows := [][]string{}
+			for _, c := range containers {
+				status := c.Status
+				if c.Health != "" {
+					status += " (" + c.Health + ")"
+				}
+				if c.CrashLooping {
+					status += " (crash-looping)"
+				}
+				if c.OOMKilled {
+					status += " (OOM killed)"
+				}
+				id := c.ID
+				if len(id) > 12 {
+					id = id[:12]
+				}
+				rows = append(rows, []string{id, c.Name, c.Image, c.Age, status, c.PortsDisplay})
+			}
+			return printTable([]string{"CONTAINER ID", "NAME", "IMAGE", "CREATED", "STATUS", "PORTS"}, rows)
+		},
+	}
+	ps.Flags().BoolVarP(&all, "all", "a", false, "show stopped containers too")
+	ps.Flags().StringArrayVarP(&labels, "label", "l", nil, "only containers with this label, key or key=value (repeatable)")
+	return ps
+}
+
+type SearchMatch struct {
+	Server    string    `json:"server"`
+	Container Container `json:"container"`
+	Score     int       `json:"score"`
+	Matched   []string  `json:"matched"`
+}
+
+func searchCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "search QUERY...",
+		Short: "Find containers on every server",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var data struct {
+				Matches []SearchMatch `json:"matches"`
+				Errors  []struct {
+					Server string `json:"server"`
+					Error  string `json:"error"`
+				} `json:"errors"`
+			}
+			query := url.Values{"q": {strings.Join(args, " ")}}
+			if err := client.Do(cmd.Context(), "GET", "search", query, nil, &data); err != nil {
+				return err
+			}
+			for _, failure := range data.Errors {
+				fmt.Fprintf(os.Stderr, "rdm: %s: %s\n", failure.Server, failure.Error)
+			}
+			if output == "json" {
+				return printJSON(data.Matches)
+			}
+			rows := [][]string{}
+			for _, m := range data.Matches {
+				rows = append(rows, []string{m.Server, m.Container.Name, m.Container.Image, m.Container.Status, strings.Join(m.Matched, ",")})
+			}
+			return printTable([]string{"SERVER", "NAME", "IMAGE", "STATUS", "MATCHED"}, rows)
+		},
+	}
+}
+
+func logsCommand() *cobra.Command {
+	var follow, timestamps bool
+	var tail, since, grep string
+	logs := &cobra.Command{
+		Use:   "logs CONTAINER",
+		Short: "Show the logs of a container",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			query := url.Values{"tail": {tail}}
+			if since != "" {
+				query.Set("since", since)
+			}
+			if grep != "" {
+				query.Set("grep", grep)
+			}
+			path := "logs/" + url.PathEscape(args[0])
+
+			show := func(entry LogEntry) error {
+				if output == "json" {
+					return json.NewEncoder(os.Stdout).Encode(entry)
+				}
+				out := os.Stdout
+				if entry.Stream == "stderr" {
+					out = os.Stderr
+				}
+				if timestamps {
+					fmt.Fprint(out, formatTime(entry.Timestamp)+" ")
+				}
+				_, err := fmt.Fprintln(out, entry.Message)
+				return err
+			}
+
+			if !follow {
+				var data struct {
+					Entries []LogEntry `json:"entries"`
+				}
+				if err := client.Do(cmd.Context(), "GET", path, query, nil, &data); err != nil {
+					return err
+				}
+				for _, entry := range data.Entries {
+					if err := show(entry); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+
+			query.Set("follow", "true")
+			return client.Stream(cmd.Context(), path, query, func(event string, data []byte) error {
+				switch event {
+				case "log":
+					var entry LogEntry
+					if err := json.Unmarshal(data, &entry); err != nil {
+						return err
+					}
+					return show(entry)
+				case "error":
+					var failure struct {
+						Error string `json:"error"`
+					}
+					json.Unmarshal(data, &failure)
+					return fmt.Errorf("log stream failed: %s", failure.Error)
+				}
+				return nil
+			})
+		},
+	}
+	logs.Flags().BoolVarP(&follow, "follow", "f", false, "keep streaming new lines")
+	logs.Flags().BoolVarP(&timestamps, "timestamps", "t", false, "show the time of each line")
+	logs.Flags().StringVarP(&tail, "tail", "n", "20", "number of lines from the end, or all")
+	logs.Flags().StringVar(&since, "since", "", "only lines since this time or duration, e.g. 10m")
+	logs.Flags().StringVar(&grep, "grep", "", "only lines containing this text")
+	return logs
+}
+
+func actionCommand(action string) *cobra.Command {
+	var wait bool
+	command := &cobra.Command{
+		Use:   action + " CONTAINER...",
+		Short: strings.ToUpper(action[:1]) + action[1:] + " containers",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			query := url.Values{}
+			if wait {
+				query.Set("wait", "true")
+			}
+			failed := false
+			for _, name := range args {
+				path := "container/" + url.PathEscape(name) + "/" + action
+				if err := client.Do(cmd.Context(), "POST", path, query, nil, nil); err != nil {
+					fmt.Fprintf(os.Stderr, "rdm: %s: %v\n", name, err)
+					failed = true
+					continue
+				}
+				fmt.Println(name)
+			}
+			if failed {
+				return fmt.Errorf("%s failed for some containers", action)
+			}
+			return nil
+		},
+	}
+	if action != "stop" {
+		command.Flags().BoolVar(&wait, "wait", false, "wait until the container is healthy or stably running")
+	}
+	return command
+}
@@ -0,0 +1,74 @@
+// Command rdm manages containers through the HTTP API of a Remote Docker
+// Manager, for people who live in the terminal:
+//
+//	export RDM_URL=https://docker.example.com RDM_TOKEN=rdm_...
+//	rdm servers list
+//	rdm ps --server 10.0.0.5:22
+//	rdm search redis
+//	rdm logs -f web
+//	rdm restart web
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var (
+	client = &Client{HTTP: &http.Client{}}
+	output string
+)
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func main() {
+	root := &cobra.Command{
+		Use:           "rdm",
+		Short:         "Manage Docker hosts through a Remote Docker Manager",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if output != "table" && output != "json" {
+				return fmt.Errorf("unknown output format %q: use table or json", output)
+			}
+			return nil
+		},
+	}
+	root.PersistentFlags().StringVar(&client.URL, "url", envOr("RDM_URL", "http://localhost:8080"), "URL of the Remote Docker Manager, including any base path (RDM_URL)")
+	root.PersistentFlags().StringVar(&client.Token, "token", os.Getenv("RDM_TOKEN"), "API token (RDM_TOKEN)")
+	root.PersistentFlags().StringVarP(&output, "output", "o", "table", "output format: table or json")
+	root.PersistentFlags().StringVar(&client.Server, "server", os.Getenv("RDM_SERVER"), "server ID to act on, see rdm servers list (RDM_SERVER)")
+	root.PersistentFlags().DurationVar(&client.HTTP.Timeout, "timeout", 0, "give up on requests after this long; 0 waits as long as it takes")
+
+	root.AddCommand(serversCommand(), psCommand(), searchCommand(), logsCommand())
+	for _, action := range []string{"start", "stop", "restart"} {
+		root.AddCommand(actionCommand(action))
+	}
+
+	// Ctrl-C ends a followed log stream cleanly.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := root.ExecuteContext(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "rdm:", err)
+		stop()
+		os.Exit(1)
+	}
+}
+
+func formatTime(value time.Time) string {
+	if value.IsZero() {
+		return ""
+	}
+	return value.Local().Format("2006-01-02 15:04:05")
+}
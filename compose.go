@@ -0,0 +1,664 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	composeFileName = "docker-compose.yml"
+	maxComposeFile  = 1 << 20
+)
+
+var composeProjectPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// composeDir holds one directory per deployed project on the remote host.
+// Relative paths are under the SSH user's home directory.
+var composeDir = composeDirFromEnv()
+
+func composeDirFromEnv() string {
+	if dir := strings.TrimSuffix(setting("COMPOSE_DIR"), "/"); dir != "" {
+		return dir
+	}
+	return "rdm-stacks"
+}
+
+// composeShell defines a compose function that prefers the compose plugin
+// and falls back to the standalone docker-compose binary.
+const composeShell = `compose() { if docker compose version >/dev/null 2>&1; then docker compose "$@"; else docker-compose "$@"; fi; }; `
+
+func composeProjectDir(project string) string {
+	return composeDir + "/" + project
+}
+
+// composeLocation is where a project's compose files live on the host.
+// Files empty means the default file in Dir.
+type composeLocation struct {
+	Dir   string
+	Files []string
+}
+
+// command runs compose for project from the project's directory.
+func (loc composeLocation) command(project string, args ...string) string {
+	command := fmt.Sprintf("%scd %s && compose -p %s", composeShell, shellQuote(loc.Dir), shellQuote(project))
+	for _, file := range loc.Files {
+		command += " -f " + shellQuote(file)
+	}
+	return command + " " + strings.Join(args, " ")
+}
+
+func managedComposeLocation(project string) composeLocation {
+	return composeLocation{Dir: composeProjectDir(project)}
+}
+
+// locateComposeProject finds the project in the managed directory or, for
+// projects started elsewhere, from the labels compose puts on containers.
+func (dm *DockerManager) locateComposeProject(project string) (composeLocation, error) {
+	loc := managedComposeLocation(project)
+	output, err := dm.executeSSHCommand(fmt.Sprintf("test -f %s/%s && echo managed || true", shellQuote(loc.Dir), composeFileName))
+	if err != nil {
+		return loc, err
+	}
+	if strings.TrimSpace(output) == "managed" {
+		return loc, nil
+	}
+
+	output, err = dm.executeSSHCommand(fmt.Sprintf("docker ps -a --filter %s --format '{{.Label %q}}|{{.Label %q}}'",
+		shellQuote("label=com.docker.compose.project="+project), "com.docker.compose.project.working_dir", "com.docker.compose.project.config_files"))
+	if err != nil {
+		return loc, err
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(output), "\n")
+	dir, files, _ := strings.Cut(line, "|")
+	if dir == "" {
+		return loc, requestErrorf(codeNotFound, "compose project %s not found", project)
+	}
+	loc = composeLocation{Dir: dir}
+	for _, file := range strings.Split(files, ",") {
+		if file != "" {
+			loc.Files = append(loc.Files, file)
+		}
+	}
+	return loc, nil
+}
+
+func validComposeProject(project string) error {
+	if !composeProjectPattern.MatchString(project) {
+		return requestErrorf(codeInvalidRequest, "invalid project name %q: use lowercase letters, digits, '-' and '_'", project)
+	}
+	return nil
+}
+
+// WriteComposeFile stores content as the project's compose file. It is
+// written next to the current one and only replaces it once docker compose
+// accepts it, so a bad upload leaves the running stack's file intact.
+func (dm *DockerManager) WriteComposeFile(ctx context.Context, project string, content []byte) error {
+	dir := shellQuote(composeProjectDir(project))
+	pending := composeFileName + ".new"
+	if err := dm.streamSSHCommand(ctx, fmt.Sprintf("mkdir -p %s && cat > %s/%s", dir, dir, pending), bytes.NewReader(content), io.Discard); err != nil {
+		return err
+	}
+
+	result, err := dm.runSSHCommand(ctx, composeLocation{Dir: composeProjectDir(project), Files: []string{pending}}.command(project, "config", "-q"))
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		dm.executeSSHCommand(fmt.Sprintf("rm -f %s/%s", dir, pending))
+		return requestErrorf(codeInvalidRequest, "invalid compose file: %s", strings.TrimSpace(result.Stderr+result.Stdout))
+	}
+	_, err = dm.executeSSHCommand(fmt.Sprintf("mv %s/%s %s/%s", dir, pending, dir, composeFileName))
+	return err
+}
+
+// runCompose streams the combined output of a compose command to fn.
+func (dm *DockerManager) runCompose(ctx context.Context, loc composeLocation, project string, fn func(line string) error, args ...string) error {
+	err := dm.streamSSHLines(ctx, loc.command(project, args...)+" 2>&1", fn)
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return fmt.Errorf("docker compose %s exited with status %d", args[0], exitErr.ExitStatus())
+	}
+	return err
+}
+
+func (dm *DockerManager) ComposeUp(ctx context.Context, project string, fn func(line string) error) error {
+	return dm.runCompose(ctx, managedComposeLocation(project), project, fn, composeActions["up"]...)
+}
+
+// composeActions maps the project actions to compose arguments.
+var composeActions = map[string][]string{
+	"up":      {"up", "-d", "--remove-orphans"},
+	"down":    {"down", "--remove-orphans"},
+	"restart": {"restart"},
+	"pull":    {"pull"},
+}
+
+func (dm *DockerManager) ComposeAction(ctx context.Context, project, action string, fn func(line string) error) error {
+	args, ok := composeActions[action]
+	if !ok {
+		return requestErrorf(codeInvalidRequest, "invalid action %q", action)
+	}
+	loc, err := dm.locateComposeProject(project)
+	if err != nil {
+		return err
+	}
+	return dm.runCompose(ctx, loc, project, fn, args...)
+}
+
+type ComposeProject struct {
+	Name        string   `json:"name"`
+	Status      string   `json:"status"`
+	WorkingDir  string   `json:"working_dir"`
+	ConfigFiles []string `json:"config_files"`
+	Managed     bool     `json:"managed"`
+	Services    []string `json:"services"`
+	Containers  int      `json:"containers"`
+	Running     int      `json:"running"`
+}
+
+// ListComposeProjects combines docker compose ls (compose v2 only) with the
+// labels on containers, and adds managed projects that are currently down.
+func (dm *DockerManager) ListComposeProjects() ([]ComposeProject, error) {
+	projects := map[string]*ComposeProject{}
+	project := func(name string) *ComposeProject {
+		if projects[name] == nil {
+			projects[name] = &ComposeProject{Name: name, ConfigFiles: []string{}, Services: []string{}}
+		}
+		return projects[name]
+	}
+
+	output, err := dm.executeSSHCommand("docker compose ls -a --format json 2>/dev/null || echo '[]'")
+	if err != nil {
+		return nil, err
+	}
+	var listed []struct {
+		Name        string
+		Status      string
+		ConfigFiles string
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(output)), &listed); err != nil {
+		dm.logger.Debug("unexpected docker compose ls output", "error", err)
+	}
+	for _, entry := range listed {
+		p := project(entry.Name)
+		p.Status = entry.Status
+		for _, file := range strings.Split(entry.ConfigFiles, ",") {
+			if file != "" {
+				p.ConfigFiles = append(p.ConfigFiles, file)
+			}
+		}
+	}
+
+	output, err = dm.executeSSHCommand(fmt.Sprintf("docker ps -a --filter label=com.docker.compose.project --format '{{.Label %q}}|{{.Label %q}}|{{.State}}|{{.Label %q}}'",
+		"com.docker.compose.project", "com.docker.compose.service", "com.docker.compose.project.working_dir"))
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "|", 4)
+		if len(parts) < 4 || parts[0] == "" {
+			continue
+		}
+		p := project(parts[0])
+		p.Containers++
+		if parts[2] == "running" {
+			p.Running++
+		}
+		if !slices.Contains(p.Services, parts[1]) {
+			p.Services = append(p.Services, parts[1])
+		}
+		p.WorkingDir = parts[3]
+	}
+
+	output, err = dm.executeSSHCommand(fmt.Sprintf("ls -1 %s 2>/dev/null || true", shellQuote(composeDir)))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range strings.Split(output, "\n") {
+		if name = strings.TrimSpace(name); composeProjectPattern.MatchString(name) {
+			p := project(name)
+			p.Managed = true
+			if p.WorkingDir == "" {
+				p.WorkingDir = composeProjectDir(name)
+			}
+		}
+	}
+
+	list := []ComposeProject{}
+	for _, name := range sortedMetricKeys(projects) {
+		p := projects[name]
+		sort.Strings(p.Services)
+		switch {
+		case p.Status != "":
+		case p.Running > 0:
+			p.Status = fmt.Sprintf("running(%d)", p.Running)
+		case p.Containers > 0:
+			p.Status = fmt.Sprintf("exited(%d)", p.Containers)
+		default:
+			p.Status = "down"
+		}
+		list = append(list, *p)
+	}
+	return list, nil
+}
+
+// composeStream reports the output of a compose command as it runs, either
+// as Server-Sent Events ("output" per line, then "done" or "error") when
+// ?stream=true or as a single JSON response once it has finished.
+func composeStream(w http.ResponseWriter, r *http.Request, run func(fn func(line string) error) error) error {
+	if r.URL.Query().Get("stream") != "true" {
+		output := []string{}
+		err := run(func(line string) error {
+			output = append(output, line)
+			return nil
+		})
+		response := map[string]interface{}{
+			"success": err == nil,
+			"output":  strings.Join(output, "\n"),
+		}
+		if err != nil {
+			response["error"] = err.Error()
+		}
+		writeJSON(w, response)
+		return err
+	}
+
+	if !startSSE(w) {
+		return nil
+	}
+	err := run(func(line string) error {
+		return writeSSE(w, "output", "", map[string]string{"line": line})
+	})
+	if err != nil {
+		if r.Context().Err() == nil {
+			writeSSE(w, "error", "", map[string]string{"error": err.Error()})
+		}
+		return err
+	}
+	writeSSE(w, "done", "", map[string]bool{"success": true})
+	return nil
+}
+
+// composeDeployHandler takes the compose file as the request body or as the
+// "file" field of a multipart upload, then brings the project up.
+func composeDeployHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	project := mux.Vars(r)["project"]
+	if err := validComposeProject(project); err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxComposeFile)
+	upload, err := uploadReader(r)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	content, err := io.ReadAll(upload)
+	if err != nil {
+		writeFailure(w, fmt.Errorf("Failed to read compose file: %w", err))
+		return
+	}
+	if len(bytes.TrimSpace(content)) == 0 {
+		writeError(w, codeInvalidRequest, "Compose file is empty")
+		return
+	}
+
+	if err := manager.WriteComposeFile(r.Context(), project, content); err != nil {
+		recordAudit(r, manager.config.ID(), "compose.deploy", project, err)
+		writeFailure(w, err)
+		return
+	}
+
+	manager.logger.Info("deploying compose project", "project", project)
+	err = composeStream(w, r, func(fn func(line string) error) error {
+		return manager.ComposeUp(r.Context(), project, fn)
+	})
+	recordAudit(r, manager.config.ID(), "compose.deploy", project, err)
+	if err != nil {
+		manager.logger.Error("compose deploy failed", "project", project, "error", err)
+	}
+}
+
+func composeProjectsHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	projects, err := manager.ListComposeProjects()
+	if err != nil {
+		manager.logger.Error("failed to list compose projects", "error", err)
+		writeFailure(w, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success":  true,
+		"projects": projects,
+		"count":    len(projects),
+	})
+}
+
+func composeActionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	project, action := vars["project"], vars["action"]
+	if err := validComposeProject(project); err != nil {
+		writeFailure(w, err)
+		return
+	}
+	if _, ok := composeActions[action]; !ok {
+		writeError(w, codeInvalidRequest, "Invalid action")
+		return
+	}
+
+	if action == "down" && !confirmOperation(w, r, manager, requestOperation(r), func() (string, map[string]interface{}, error) {
+		return "Stop and remove all containers and networks of project " + project, nil, nil
+	}) {
+		return
+	}
+
+	manager.logger.Info("compose project action", "project", project, "action", action)
+	err := composeStream(w, r, func(fn func(line string) error) error {
+		return manager.ComposeAction(r.Context(), project, action, fn)
+	})
+	recordAudit(r, manager.config.ID(), "compose."+action, project, err)
+	if err != nil {
+		manager.logger.Error("compose action failed", "project", project, "action", action, "error", err)
+	}
+}
+
+type ComposeContainer struct {
+	Name     string `json:"name"`
+	ID       string `json:"id"`
+	Image    string `json:"image"`
+	State    string `json:"state"`
+	Health   string `json:"health,omitempty"`
+	Status   string `json:"status"`
+	ExitCode int    `json:"exit_code"`
+	Ports    string `json:"ports"`
+}
+
+type ComposeService struct {
+	Name       string             `json:"name"`
+	Containers []ComposeContainer `json:"containers"`
+	Running    int                `json:"running"`
+}
+
+// ComposePs runs docker compose ps and groups the containers by service.
+// Depending on the compose version the JSON output is a single array or one
+// object per line.
+func (dm *DockerManager) ComposePs(project string) ([]ComposeService, error) {
+	loc, err := dm.locateComposeProject(project)
+	if err != nil {
+		return nil, err
+	}
+	output, err := dm.executeSSHCommand(loc.command(project, "ps", "-a", "--format", "json"))
+	if err != nil {
+		return nil, err
+	}
+
+	type psEntry struct {
+		Name       string
+		ID         string
+		Image      string
+		Service    string
+		State      string
+		Health     string
+		Status     string
+		ExitCode   int
+		Publishers []struct {
+			URL           string
+			TargetPort    int
+			PublishedPort int
+			Protocol      string
+		}
+	}
+	var entries []psEntry
+	output = strings.TrimSpace(output)
+	if strings.HasPrefix(output, "[") {
+		if err := json.Unmarshal([]byte(output), &entries); err != nil {
+			return nil, fmt.Errorf("unexpected docker compose ps output: %v", err)
+		}
+	} else {
+		for _, line := range strings.Split(output, "\n") {
+			if line = strings.TrimSpace(line); line == "" {
+				continue
+			}
+			var entry psEntry
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				return nil, fmt.Errorf("unexpected docker compose ps output: %v", err)
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	services := map[string]*ComposeService{}
+	for _, entry := range entries {
+		var ports []string
+		for _, p := range entry.Publishers {
+			if p.PublishedPort == 0 {
+				continue
+			}
+			ports = append(ports, fmt.Sprintf("%s:%d->%d/%s", p.URL, p.PublishedPort, p.TargetPort, p.Protocol))
+		}
+		service := services[entry.Service]
+		if service == nil {
+			service = &ComposeService{Name: entry.Service, Containers: []ComposeContainer{}}
+			services[entry.Service] = service
+		}
+		service.Containers = append(service.Containers, ComposeContainer{
+			Name:     entry.Name,
+			ID:       entry.ID,
+			Image:    entry.Image,
+			State:    entry.State,
+			Health:   entry.Health,
+			Status:   entry.Status,
+			ExitCode: entry.ExitCode,
+			Ports:    strings.Join(ports, ", "),
+		})
+		if entry.State == "running" {
+			service.Running++
+		}
+	}
+
+	list := []ComposeService{}
+	for _, name := range sortedMetricKeys(services) {
+		list = append(list, *services[name])
+	}
+	return list, nil
+}
+
+// composeLogsCommand builds docker compose logs for the given services, or
+// all of them. Lines are prefixed with the container name.
+func composeLogsCommand(loc composeLocation, project string, opts LogOptions, follow bool, services []string) string {
+	args := []string{"logs", "--no-color", "--timestamps"}
+	if follow {
+		args = append(args, "--follow")
+	}
+	if flags := opts.args(); flags != "" {
+		args = append(args, flags)
+	}
+	for _, service := range services {
+		args = append(args, shellQuote(service))
+	}
+	return loc.command(project, args...) + " 2>&1"
+}
+
+// parseComposeLog splits a "name  | timestamp message" line.
+func parseComposeLog(line string) (ContainerLog, bool) {
+	name, rest, ok := strings.Cut(strings.TrimSuffix(line, "\r"), "|")
+	if !ok {
+		return ContainerLog{}, false
+	}
+	entry := ContainerLog{Container: strings.TrimSpace(name), Message: strings.TrimPrefix(rest, " ")}
+	if timestamp, message, ok := strings.Cut(entry.Message, " "); ok {
+		if t, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+			entry.Timestamp, entry.Message = t, message
+		}
+	}
+	return entry, true
+}
+
+// matcher returns the grep filter of opts applied in process, for sources
+// that can't be filtered on the server.
+func (opts LogOptions) matcher() (func(string) bool, error) {
+	if opts.Grep == "" {
+		return func(string) bool { return true }, nil
+	}
+	pattern := opts.Grep
+	if !opts.Regex {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if opts.IgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, requestErrorf(codeInvalidRequest, "invalid grep pattern: %v", err)
+	}
+	return re.MatchString, nil
+}
+
+// ComposeLogs reads or follows the logs of a project's services and passes
+// each line to fn.
+func (dm *DockerManager) ComposeLogs(ctx context.Context, project string, opts LogOptions, follow bool, services []string, fn func(ContainerLog) error) error {
+	match, err := opts.matcher()
+	if err != nil {
+		return err
+	}
+	loc, err := dm.locateComposeProject(project)
+	if err != nil {
+		return err
+	}
+
+	command := composeLogsCommand(loc, project, opts, follow, services)
+	handle := func(line string) error {
+		entry, ok := parseComposeLog(line)
+		if !ok || !match(entry.Message) {
+			return nil
+		}
+		entry.Message = opts.render(entry.Message)
+		return fn(entry)
+	}
+	if follow {
+		return dm.followSSHLines(ctx, command, handle)
+	}
+	return dm.streamSSHLines(ctx, command, handle)
+}
+
+func composePsHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	project := mux.Vars(r)["project"]
+	if err := validComposeProject(project); err != nil {
+		writeFailure(w, err)
+		return
+	}
+	services, err := manager.ComposePs(project)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success":  true,
+		"project":  project,
+		"services": services,
+		"count":    len(services),
+	})
+}
+
+// composeLogsHandler serves docker compose logs for the project, limited to
+// the services given as repeated ?service= parameters, as JSON or, with
+// ?follow=true, as Server-Sent Events like the container logs endpoint.
+func composeLogsHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	project := mux.Vars(r)["project"]
+	if err := validComposeProject(project); err != nil {
+		writeFailure(w, err)
+		return
+	}
+	opts, err := logOptionsFromQuery(query, "20")
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	services := query["service"]
+
+	if query.Get("follow") != "true" {
+		entries := []ContainerLog{}
+		var logs strings.Builder
+		err := manager.ComposeLogs(r.Context(), project, opts, false, services, func(entry ContainerLog) error {
+			entries = append(entries, entry)
+			logs.WriteString(entry.Container + " | " + entry.Message + "\n")
+			return nil
+		})
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		response := map[string]interface{}{
+			"success": true,
+			"project": project,
+			"logs":    logs.String(),
+			"entries": entries,
+		}
+		if opts.Grep != "" {
+			response["matches"] = len(entries)
+		}
+		writeJSON(w, response)
+		return
+	}
+
+	if !startSSE(w) {
+		return
+	}
+	manager.logger.Info("following compose logs", "project", project)
+	err = manager.ComposeLogs(r.Context(), project, opts, true, services, func(entry ContainerLog) error {
+		return writeSSE(w, "log", "", entry)
+	})
+	if err != nil && r.Context().Err() == nil {
+		manager.logger.Error("compose log stream failed", "project", project, "error", err)
+		writeSSE(w, "error", "", map[string]string{"error": err.Error()})
+	}
+	manager.logger.Info("compose log stream closed", "project", project)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"strings"
+	"sync"
+)
+
+// configKeys maps the keys of the configuration file to the environment
+// variables they stand for. Flags win over the environment, which wins over
+// the file, which wins over the defaults.
+var configKeys = map[string]string{
+	"listen.address":          "LISTEN_ADDR",
+	"listen.port":             "PORT",
+	"listen.unix_socket":      "UNIX_SOCKET",
+	"listen.unix_socket_mode": "UNIX_SOCKET_MODE",
+	"listen.base_path":        "BASE_PATH",
+	"listen.shutdown_timeout": "SHUTDOWN_TIMEOUT",
+
+	"tls.cert":               "TLS_CERT",
+	"tls.key":                "TLS_KEY",
+	"tls.acme.domains":       "ACME_DOMAINS",
+	"tls.acme.email":         "ACME_EMAIL",
+	"tls.acme.http_port":     "ACME_HTTP_PORT",
+	"tls.acme.directory_url": "ACME_DIRECTORY_URL",
+
+	"auth.enabled":              "AUTH_ENABLED",
+	"auth.admin_username":       "ADMIN_USERNAME",
+	"auth.admin_password":       "ADMIN_PASSWORD",
+	"auth.session_ttl":          "SESSION_TTL",
+	"auth.cookie_secure":        "SESSION_COOKIE_SECURE",
+	"auth.require_confirmation": "REQUIRE_CONFIRMATION",
+	"auth.confirm_token_ttl":    "CONFIRM_TOKEN_TTL",
+	"auth.ldap.url":             "LDAP_URL",
+	"auth.ldap.start_tls":       "LDAP_START_TLS",
+	"auth.ldap.tls_skip_verify": "LDAP_TLS_SKIP_VERIFY",
+	"auth.ldap.bind_dn":         "LDAP_BIND_DN",
+	"auth.ldap.bind_password":   "LDAP_BIND_PASSWORD",
+	"auth.ldap.base_dn":         "LDAP_BASE_DN",
+	"auth.ldap.user_filter":     "LDAP_USER_FILTER",
+	"auth.ldap.group_base_dn":   "LDAP_GROUP_BASE_DN",
+	"auth.ldap.group_filter":    "LDAP_GROUP_FILTER",
+	"auth.ldap.admin_group":     "LDAP_ADMIN_GROUP",
+	"auth.ldap.required_group":  "LDAP_REQUIRED_GROUP",
+
+	"cors.allowed_origins":     "CORS_ALLOWED_ORIGINS",
+	"cors.allowed_methods":     "CORS_ALLOWED_METHODS",
+	"cors.allowed_headers":     "CORS_ALLOWED_HEADERS",
+	"cors.exposed_headers":     "CORS_EXPOSED_HEADERS",
+	"cors.allow_credentials":   "CORS_ALLOW_CREDENTIALS",
+	"cors.max_age":             "CORS_MAX_AGE",
+	"storage.data_dir":         "DATA_DIR",
+	"storage.compose_dir":      "COMPOSE_DIR",
+	"storage.templates_file":   "TEMPLATES_FILE",
+	"ssh.default_port":         "SSH_DEFAULT_PORT",
+	"ssh.timeout":              "SSH_TIMEOUT",
+	"exec.allowlist":           "EXEC_ALLOWLIST",
+	"containers.cache_ttl":     "CONTAINER_CACHE_TTL",
+	"jobs.workers":             "JOB_WORKERS",
+	"jobs.retention":           "JOB_RETENTION",
+	"updates.interval":         "AUTO_UPDATE_INTERVAL",
+	"gitops.interval":          "GITOPS_INTERVAL",
+	"live.interval":            "LIVE_POLL_INTERVAL",
+	"telegram.api_url":         "TELEGRAM_API_URL",
+	"notify.digest_interval":   "NOTIFY_DIGEST_INTERVAL",
+	"smtp.host":                "SMTP_HOST",
+	"smtp.port":                "SMTP_PORT",
+	"smtp.username":            "SMTP_USERNAME",
+	"smtp.password":            "SMTP_PASSWORD",
+	"smtp.from":                "SMTP_FROM",
+	"smtp.tls":                 "SMTP_TLS",
+	"metrics.interval":         "METRICS_INTERVAL",
+	"metrics.retention":        "METRICS_RETENTION",
+	"metrics.container_gauges": "METRICS_CONTAINER_GAUGES",
+	"disk.check_interval":      "DISK_CHECK_INTERVAL",
+	"disk.warn_percent":        "DISK_WARN_PERCENT",
+	"disk.critical_percent":    "DISK_CRITICAL_PERCENT",
+	"disk.check_paths":         "DISK_CHECK_PATHS",
+	"crash_loop.window":        "CRASH_LOOP_WINDOW",
+	"crash_loop.restarts":      "CRASH_LOOP_RESTARTS",
+	"autoheal.interval":        "AUTOHEAL_INTERVAL",
+	"autoheal.cooldown":        "AUTOHEAL_COOLDOWN",
+	"i18n.default_language":    "DEFAULT_LANGUAGE",
+	"logging.level":            "LOG_LEVEL",
+	"logging.debug":            "DEBUG",
+	"logging.format":           "LOG_FORMAT",
+	"tracing.endpoint":         "OTEL_EXPORTER_OTLP_ENDPOINT",
+	"tracing.traces_endpoint":  "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT",
+	"tracing.headers":          "OTEL_EXPORTER_OTLP_HEADERS",
+	"tracing.service_name":     "OTEL_SERVICE_NAME",
+}
+
+var (
+	configOnce     sync.Once
+	configPath     string
+	configSettings map[string]string
+	configErr      error
+)
+
+// configFilePath is --config from the command line, else CONFIG_FILE. It is
+// looked up before flags are parsed because settings are read while the
+// program initializes.
+func configFilePath() string {
+	args := os.Args[1:]
+	for i, arg := range args {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "config" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return os.Getenv("CONFIG_FILE")
+}
+
+// loadConfigFile reads a YAML configuration file into settings by
+// environment variable name. Lists become comma-separated values.
+func loadConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	settings := map[string]string{}
+	if len(root.Content) == 0 {
+		return settings, nil
+	}
+	var walk func(prefix string, node *yaml.Node) error
+	walk = func(prefix string, node *yaml.Node) error {
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key := node.Content[i].Value
+				if prefix != "" {
+					key = prefix + "." + key
+				}
+				if err := walk(key, node.Content[i+1]); err != nil {
+					return err
+				}
+			}
+			return nil
+		case yaml.SequenceNode, yaml.ScalarNode:
+			env, ok := configKeys[prefix]
+			if !ok {
+				return fmt.Errorf("unknown setting %q in %s (line %d)", prefix, path, node.Line)
+			}
+			// Scalars keep their text, so 0660 stays octal and 08:00 a time.
+			value := node.Value
+			if node.Kind == yaml.SequenceNode {
+				var items []string
+				for _, item := range node.Content {
+					items = append(items, item.Value)
+				}
+				value = strings.Join(items, ",")
+			}
+			settings[env] = value
+			return nil
+		}
+		return fmt.Errorf("unsupported value for %q in %s (line %d)", prefix, path, node.Line)
+	}
+	return settings, walk("", root.Content[0])
+}
+
+// setting returns the environment variable name, falling back to the
+// configuration file. Flags take their defaults from it.
+func setting(name string) string {
+	configOnce.Do(func() {
+		configPath = configFilePath()
+		if configPath != "" {
+			configSettings, configErr = loadConfigFile(configPath)
+		}
+	})
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return configSettings[name]
+}
+
+// configError reports a configuration file that could not be loaded.
+func configError() error {
+	setting("")
+	return configErr
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const confirmTokenHeader = "X-Confirm-Token"
+
+var (
+	confirmTokenTTL = envDuration("CONFIRM_TOKEN_TTL", 2*time.Minute)
+	// REQUIRE_CONFIRMATION=false turns the two-step flow off for trusted
+	// automation.
+	confirmationRequired = setting("REQUIRE_CONFIRMATION") != "false"
+)
+
+type pendingConfirmation struct {
+	operation string
+	actor     string
+	expires   time.Time
+}
+
+var (
+	confirmMu            sync.Mutex
+	pendingConfirmations = map[string]pendingConfirmation{}
+)
+
+// requestOperation identifies exactly what a request asks for: its path, its
+// query without the token and any extra details taken from the body. A token
+// only confirms a request that matches this.
+func requestOperation(r *http.Request, extra ...string) string {
+	query := r.URL.Query()
+	query.Del("confirm_token")
+	parts := append([]string{r.Method, r.URL.Path, query.Encode()}, extra...)
+	return strings.Join(parts, "\n")
+}
+
+func confirmToken(r *http.Request) string {
+	if token := r.URL.Query().Get("confirm_token"); token != "" {
+		return token
+	}
+	return r.Header.Get(confirmTokenHeader)
+}
+
+// confirmOperation runs the two-step flow for dangerous operations. The first
+// request gets a summary and a short-lived, single-use token instead of the
+// operation; repeating it with ?confirm_token= (or the X-Confirm-Token header)
+// goes ahead. It reports whether the caller should proceed, having written
+// the response otherwise. describe is only called when a token is issued.
+func confirmOperation(w http.ResponseWriter, r *http.Request, manager *DockerManager, operation string, describe func() (string, map[string]interface{}, error)) bool {
+	if !confirmationRequired {
+		return true
+	}
+	operation = manager.config.ID() + "\n" + operation
+	actor := actorFrom(r)
+	now := time.Now()
+
+	if token := confirmToken(r); token != "" {
+		confirmMu.Lock()
+		pending, ok := pendingConfirmations[token]
+		if ok && pending.operation == operation && pending.actor == actor {
+			delete(pendingConfirmations, token)
+		}
+		confirmMu.Unlock()
+
+		if !ok || now.After(pending.expires) {
+			writeError(w, codeInvalidRequest, "Confirmation token is invalid or has expired; repeat the request without it for a new one")
+			return false
+		}
+		if pending.operation != operation || pending.actor != actor {
+			writeError(w, codeInvalidRequest, "Confirmation token was issued for a different request")
+			return false
+		}
+		return true
+	}
+
+	summary, details, err := describe()
+	if err != nil {
+		writeFailure(w, err)
+		return false
+	}
+
+	token := newRequestID() + newRequestID()
+	expires := now.Add(confirmTokenTTL)
+	confirmMu.Lock()
+	for key, pending := range pendingConfirmations {
+		if now.After(pending.expires) {
+			delete(pendingConfirmations, key)
+		}
+	}
+	pendingConfirmations[token] = pendingConfirmation{operation: operation, actor: actor, expires: expires}
+	confirmMu.Unlock()
+
+	query := r.URL.Query()
+	query.Set("confirm_token", token)
+
+	path := r.URL.Path
+	if apiVersionFrom(r.Context()) == "v1" {
+		path = apiV1Prefix + strings.TrimPrefix(path, "/api/")
+	}
+	payload := map[string]interface{}{
+		"success":               false,
+		"confirmation_required": true,
+		"code":                  codeConfirmationRequired,
+		"error":                 summary + "; repeat the request with confirm_token to go ahead",
+		"summary":               summary,
+		"confirm_token":         token,
+		"confirm_url":           basePath + path + "?" + query.Encode(),
+		"expires_at":            expires.UTC(),
+	}
+	for key, value := range details {
+		payload[key] = value
+	}
+	writeJSON(w, payload)
+	return false
+}
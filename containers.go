@@ -0,0 +1,758 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ContainerCreateRequest struct {
+	Image         string            `json:"image"`
+	Name          string            `json:"name"`
+	Ports         []string          `json:"ports"`
+	Env           map[string]string `json:"env"`
+	Volumes       []string          `json:"volumes"`
+	RestartPolicy string            `json:"restart_policy"`
+	Network       string            `json:"network"`
+	Labels        map[string]string `json:"labels"`
+	Entrypoint    []string          `json:"entrypoint"`
+	Command       []string          `json:"command"`
+	// Resources are the limits to run the container with, in the notation
+	// of POST /api/containers/{id}/resources.
+	Resources *ResourceUpdateRequest `json:"resources,omitempty"`
+}
+
+var restartPolicyPattern = regexp.MustCompile(`^(no|always|unless-stopped|on-failure(:\d+)?)$`)
+
+func (req *ContainerCreateRequest) Validate() error {
+	if strings.TrimSpace(req.Image) == "" {
+		return requestErrorf(codeInvalidRequest, "image is required")
+	}
+	if req.RestartPolicy != "" && !restartPolicyPattern.MatchString(req.RestartPolicy) {
+		return requestErrorf(codeInvalidRequest, "invalid restart policy %q", req.RestartPolicy)
+	}
+	for key := range req.Env {
+		if key == "" || strings.Contains(key, "=") {
+			return requestErrorf(codeInvalidRequest, "invalid environment variable name %q", key)
+		}
+	}
+	if req.Resources != nil {
+		if _, err := req.Resources.flags(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunCommand renders the request as a detached `docker run` invocation with
+// every user-supplied value shell-quoted.
+func (req *ContainerCreateRequest) RunCommand() string {
+	args := []string{"docker", "run", "-d"}
+	if req.Name != "" {
+		args = append(args, "--name", shellQuote(req.Name))
+	}
+	for _, port := range req.Ports {
+		args = append(args, "-p", shellQuote(port))
+	}
+	for _, key := range sortedKeys(req.Env) {
+		args = append(args, "-e", shellQuote(key+"="+req.Env[key]))
+	}
+	for _, volume := range req.Volumes {
+		args = append(args, "-v", shellQuote(volume))
+	}
+	if req.RestartPolicy != "" {
+		args = append(args, "--restart", shellQuote(req.RestartPolicy))
+	}
+	if req.Network != "" {
+		args = append(args, "--network", shellQuote(req.Network))
+	}
+	for _, key := range sortedKeys(req.Labels) {
+		args = append(args, "--label", shellQuote(key+"="+req.Labels[key]))
+	}
+	if req.Resources != nil {
+		// The flags are validated, so they need no quoting.
+		if flags, err := req.Resources.flags(); err == nil {
+			args = append(args, flags...)
+		}
+	}
+	// --entrypoint takes a single executable; any further entrypoint
+	// arguments go in front of the command.
+	command := req.Command
+	if len(req.Entrypoint) > 0 {
+		args = append(args, "--entrypoint", shellQuote(req.Entrypoint[0]))
+		command = append(append([]string{}, req.Entrypoint[1:]...), req.Command...)
+	}
+	args = append(args, shellQuote(req.Image))
+	for _, arg := range command {
+		args = append(args, shellQuote(arg))
+	}
+	return strings.Join(args, " ")
+}
+
+func (dm *DockerManager) CreateContainer(req ContainerCreateRequest) (string, error) {
+	if err := req.Validate(); err != nil {
+		return "", err
+	}
+	output, err := dm.executeSSHCommand(req.RunCommand())
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(output), nil
+}
+
+func serverContainersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	manager, ok := serverManager(w, r)
+	if !ok {
+		return
+	}
+
+	var req ContainerCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, codeInvalidRequest, "Invalid JSON format")
+		return
+	}
+
+	id, err := manager.CreateContainer(req)
+	recordAudit(r, manager.config.ID(), "container.create", req.Name+" "+req.Image, err)
+	if err != nil {
+		manager.logger.Error("container creation failed", "image", req.Image, "error", err)
+		writeFailure(w, err)
+		return
+	}
+
+	manager.logger.Info("container created", "id", id, "image", req.Image)
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"id":      id,
+		"message": "Container created successfully",
+	})
+}
+
+type containerInspect struct {
+	ID     string `json:"Id"`
+	Name   string
+	Image  string
+	Config struct {
+		Image      string
+		Env        []string
+		Cmd        []string
+		Entrypoint []string
+		Labels     map[string]string
+	}
+	HostConfig struct {
+		Binds        []string
+		NetworkMode  string
+		PortBindings map[string][]struct {
+			HostIP   string `json:"HostIp"`
+			HostPort string
+		}
+		RestartPolicy struct {
+			Name              string
+			MaximumRetryCount int
+		}
+		NanoCpus          int64
+		CpuShares         int64
+		CpuQuota          int64
+		CpuPeriod         int64
+		Memory            int64
+		MemoryReservation int64
+		MemorySwap        int64
+		PidsLimit         *int64
+	}
+	Mounts []struct {
+		Type        string
+		Name        string
+		Destination string
+		RW          bool
+	}
+}
+
+type imageConfig struct {
+	Config struct {
+		Env        []string
+		Cmd        []string
+		Entrypoint []string
+		Labels     map[string]string
+	}
+}
+
+func (dm *DockerManager) inspectContainer(containerID string) (*containerInspect, error) {
+	output, err := dm.executeSSHCommand("docker inspect --type container " + shellQuote(containerID))
+	if err != nil {
+		return nil, err
+	}
+	var details []containerInspect
+	if err := json.Unmarshal([]byte(output), &details); err != nil {
+		return nil, fmt.Errorf("failed to parse container inspect output: %v", err)
+	}
+	if len(details) == 0 {
+		return nil, requestErrorf(codeContainerNotFound, "container %s not found", containerID)
+	}
+	return &details[0], nil
+}
+
+// RunConfig reconstructs the run request that would recreate the container.
+// Values the container merely inherited from its image (env, labels, command)
+// are left out so a newer image's defaults take effect.
+func (dm *DockerManager) RunConfig(containerID string) (*ContainerCreateRequest, error) {
+	info, err := dm.inspectContainer(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var image imageConfig
+	if output, err := dm.executeSSHCommand("docker image inspect --format '{{json .}}' " + shellQuote(info.Image)); err == nil {
+		json.Unmarshal([]byte(strings.TrimSpace(output)), &image)
+	} else {
+		dm.logger.Error("failed to inspect container image", "image", info.Image, "error", err)
+	}
+
+	req := &ContainerCreateRequest{
+		Image:  info.Config.Image,
+		Name:   strings.TrimPrefix(info.Name, "/"),
+		Env:    map[string]string{},
+		Labels: map[string]string{},
+	}
+
+	imageEnv := map[string]bool{}
+	for _, env := range image.Config.Env {
+		imageEnv[env] = true
+	}
+	for _, env := range info.Config.Env {
+		if imageEnv[env] {
+			continue
+		}
+		if key, value, ok := strings.Cut(env, "="); ok {
+			req.Env[key] = value
+		}
+	}
+
+	for key, value := range info.Config.Labels {
+		if imageValue, ok := image.Config.Labels[key]; ok && imageValue == value {
+			continue
+		}
+		// Compose bookkeeping labels would make docker compose adopt the copy.
+		if strings.HasPrefix(key, "com.docker.compose.") {
+			continue
+		}
+		req.Labels[key] = value
+	}
+
+	for _, containerPort := range sortedMetricKeys(info.HostConfig.PortBindings) {
+		for _, binding := range info.HostConfig.PortBindings[containerPort] {
+			mapping := binding.HostPort + ":" + strings.TrimSuffix(containerPort, "/tcp")
+			if binding.HostIP != "" && binding.HostIP != "0.0.0.0" && binding.HostIP != "::" {
+				mapping = binding.HostIP + ":" + mapping
+			}
+			req.Ports = append(req.Ports, mapping)
+		}
+	}
+
+	req.Volumes = append(req.Volumes, info.HostConfig.Binds...)
+	for _, mount := range info.Mounts {
+		// Named volumes not declared via -v (e.g. created through --mount);
+		// anonymous image volumes are recreated by docker itself.
+		if mount.Type != "volume" || len(mount.Name) == 64 || containsBindTarget(info.HostConfig.Binds, mount.Destination) {
+			continue
+		}
+		volume := mount.Name + ":" + mount.Destination
+		if !mount.RW {
+			volume += ":ro"
+		}
+		req.Volumes = append(req.Volumes, volume)
+	}
+
+	if policy := info.HostConfig.RestartPolicy; policy.Name != "" && policy.Name != "no" {
+		req.RestartPolicy = policy.Name
+		if policy.Name == "on-failure" && policy.MaximumRetryCount > 0 {
+			req.RestartPolicy = fmt.Sprintf("on-failure:%d", policy.MaximumRetryCount)
+		}
+	}
+
+	if mode := info.HostConfig.NetworkMode; mode != "" && mode != "default" && mode != "bridge" {
+		req.Network = mode
+	}
+
+	if !equalStrings(info.Config.Entrypoint, image.Config.Entrypoint) {
+		req.Entrypoint = info.Config.Entrypoint
+	}
+	if !equalStrings(info.Config.Cmd, image.Config.Cmd) || req.Entrypoint != nil {
+		req.Command = info.Config.Cmd
+	}
+	req.Resources = resourceLimitsOf(info).Request()
+
+	return req, nil
+}
+
+func containsBindTarget(binds []string, destination string) bool {
+	for _, bind := range binds {
+		parts := strings.Split(bind, ":")
+		if len(parts) >= 2 && parts[1] == destination {
+			return true
+		}
+	}
+	return false
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type RedeployResult struct {
+	OldID        string `json:"old_id"`
+	NewID        string `json:"new_id"`
+	Image        string `json:"image"`
+	ImageUpdated bool   `json:"image_updated"`
+}
+
+// RedeployContainer pulls the container's image and recreates it with the
+// same configuration. The old container is renamed and stopped rather than
+// removed until the replacement is running, so a failed run can be rolled back.
+// Protected containers are only recreated with override.
+func (dm *DockerManager) RedeployContainer(containerID string, override bool) (*RedeployResult, error) {
+	if err := dm.checkProtected(containerID, "redeploy", override); err != nil {
+		return nil, err
+	}
+	info, err := dm.inspectContainer(containerID)
+	if err != nil {
+		return nil, err
+	}
+	req, err := dm.RunConfig(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := dm.executeSSHCommand("docker pull " + shellQuote(req.Image)); err != nil {
+		return nil, fmt.Errorf("failed to pull %s: %v", req.Image, err)
+	}
+	newImage, err := dm.executeSSHCommand("docker image inspect --format '{{.Id}}' " + shellQuote(req.Image))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RedeployResult{
+		OldID:        info.ID,
+		Image:        req.Image,
+		ImageUpdated: strings.TrimSpace(newImage) != info.Image,
+	}
+	if err := dm.replaceContainer(info.ID, req); err != nil {
+		return nil, err
+	}
+	result.NewID, err = dm.executeSSHCommand("docker inspect --format '{{.Id}}' " + shellQuote(req.Name))
+	result.NewID = strings.TrimSpace(result.NewID)
+	return result, err
+}
+
+// replaceContainer swaps the container for a new one built from req,
+// restoring the original if the new one cannot be started.
+func (dm *DockerManager) replaceContainer(oldID string, req *ContainerCreateRequest) error {
+	backup := fmt.Sprintf("%s-replaced-%d", req.Name, time.Now().Unix())
+	if _, err := dm.executeSSHCommand("docker rename " + shellQuote(oldID) + " " + shellQuote(backup)); err != nil {
+		return err
+	}
+	if _, err := dm.executeSSHCommand("docker stop " + shellQuote(oldID)); err != nil {
+		dm.executeSSHCommand("docker rename " + shellQuote(oldID) + " " + shellQuote(req.Name))
+		return err
+	}
+
+	if _, err := dm.CreateContainer(*req); err != nil {
+		dm.logger.Error("replacement failed, restoring original container", "name", req.Name, "error", err)
+		dm.executeSSHCommand("docker rm -f " + shellQuote(req.Name))
+		dm.executeSSHCommand("docker rename " + shellQuote(oldID) + " " + shellQuote(req.Name))
+		dm.executeSSHCommand("docker start " + shellQuote(oldID))
+		return fmt.Errorf("failed to recreate container, original restored: %w", err)
+	}
+
+	if _, err := dm.executeSSHCommand("docker rm " + shellQuote(oldID)); err != nil {
+		dm.logger.Error("failed to remove replaced container", "container", backup, "error", err)
+	}
+	return nil
+}
+
+func containerRedeployHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	containerID := mux.Vars(r)["id"]
+	override := overrideRequested(r)
+	if asyncRequested(r) {
+		job, err := submitJob(r, manager, "container.redeploy", containerID, false, func(ctx context.Context, run *jobRun) (interface{}, error) {
+			result, err := manager.RedeployContainer(containerID, override)
+			if err != nil {
+				return nil, err
+			}
+			return result, nil
+		})
+		writeJobResponse(w, job, err)
+		return
+	}
+
+	result, err := manager.RedeployContainer(containerID, override)
+	recordAudit(r, manager.config.ID(), "container.redeploy", containerID, err)
+	if err != nil {
+		manager.logger.Error("redeploy failed", "container", containerID, "error", err)
+		response := failure(err)
+		response["protected"] = isProtectedError(err)
+		writeJSON(w, response)
+		return
+	}
+
+	manager.logger.Info("container redeployed", "container", containerID, "new_id", result.NewID, "image_updated", result.ImageUpdated)
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"result":  result,
+		"message": "Container redeployed successfully",
+	})
+}
+
+type ContainerCloneRequest struct {
+	Name string `json:"name"`
+	// Ports replaces the original port mappings when set; an empty list
+	// publishes nothing, which avoids clashing with the original.
+	Ports []string `json:"ports"`
+}
+
+func (dm *DockerManager) CloneContainer(containerID string, clone ContainerCloneRequest) (string, error) {
+	if clone.Name == "" {
+		return "", requestErrorf(codeInvalidRequest, "name is required")
+	}
+	req, err := dm.RunConfig(containerID)
+	if err != nil {
+		return "", err
+	}
+	req.Name = clone.Name
+	if clone.Ports != nil {
+		req.Ports = clone.Ports
+	}
+	return dm.CreateContainer(*req)
+}
+
+func containerCloneHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	var req ContainerCloneRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, codeInvalidRequest, "Invalid JSON format")
+		return
+	}
+
+	containerID := mux.Vars(r)["id"]
+	id, err := manager.CloneContainer(containerID, req)
+	recordAudit(r, manager.config.ID(), "container.clone", containerID, err)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	manager.logger.Info("container cloned", "container", containerID, "clone", req.Name, "id", id)
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"id":      id,
+		"message": "Container cloned successfully",
+	})
+}
+
+type FilesystemDiff struct {
+	Added   []string `json:"added"`
+	Changed []string `json:"changed"`
+	Deleted []string `json:"deleted"`
+}
+
+func (dm *DockerManager) ContainerDiff(containerID string) (*FilesystemDiff, error) {
+	output, err := dm.executeSSHCommand("docker diff " + shellQuote(containerID))
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &FilesystemDiff{Added: []string{}, Changed: []string{}, Deleted: []string{}}
+	for _, line := range strings.Split(output, "\n") {
+		kind, path, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+		switch kind {
+		case "A":
+			diff.Added = append(diff.Added, path)
+		case "C":
+			diff.Changed = append(diff.Changed, path)
+		case "D":
+			diff.Deleted = append(diff.Deleted, path)
+		}
+	}
+	return diff, nil
+}
+
+func containerDiffHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	diff, err := manager.ContainerDiff(mux.Vars(r)["id"])
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"added":   diff.Added,
+		"changed": diff.Changed,
+		"deleted": diff.Deleted,
+		"count":   len(diff.Added) + len(diff.Changed) + len(diff.Deleted),
+	})
+}
+
+func (dm *DockerManager) InspectContainer(containerID string) (map[string]interface{}, error) {
+	output, err := dm.executeSSHCommand("docker inspect --type container " + shellQuote(containerID))
+	if err != nil {
+		return nil, err
+	}
+
+	var details []map[string]interface{}
+	if err := json.Unmarshal([]byte(output), &details); err != nil {
+		return nil, fmt.Errorf("failed to parse container inspect output: %v", err)
+	}
+	if len(details) == 0 {
+		return nil, requestErrorf(codeContainerNotFound, "container %s not found", containerID)
+	}
+	return details[0], nil
+}
+
+func containerInspectHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	details, err := manager.InspectContainer(mux.Vars(r)["id"])
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success":   true,
+		"container": details,
+	})
+}
+
+// containerDetail is what the container list needs from inspect.
+type containerDetail struct {
+	Labels       map[string]string
+	RestartCount int
+	OOMKilled    bool
+}
+
+// containerDetails reads the full label sets, restart counts and OOM flags
+// with a single inspect, since the Labels column of docker ps joins labels
+// with commas that values may contain. Details are best-effort and missing
+// from the result on failure.
+func (dm *DockerManager) containerDetails(containers []Container) map[string]containerDetail {
+	details := map[string]containerDetail{}
+	if len(containers) == 0 {
+		return details
+	}
+
+	args := []string{"docker", "inspect", "--type", "container", "--format", "'{{.Id}}|{{.RestartCount}}|{{.State.OOMKilled}}|{{json .Config.Labels}}'"}
+	for _, container := range containers {
+		args = append(args, shellQuote(container.ID))
+	}
+	output, err := dm.executeSSHCommand(strings.Join(args, " "))
+	if err != nil {
+		dm.logger.Error("failed to inspect containers", "error", err)
+		return details
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "|", 4)
+		if len(parts) != 4 {
+			continue
+		}
+		var detail containerDetail
+		if json.Unmarshal([]byte(parts[3]), &detail.Labels) != nil || detail.Labels == nil {
+			continue
+		}
+		detail.RestartCount, _ = strconv.Atoi(parts[1])
+		detail.OOMKilled = parts[2] == "true"
+		for _, container := range containers {
+			if strings.HasPrefix(parts[0], container.ID) {
+				details[container.ID] = detail
+			}
+		}
+	}
+	return details
+}
+
+// parseContainerSize parses docker ps sizes like "2.5kB (virtual 187MB)".
+func parseContainerSize(value string) (int64, int64) {
+	rw, virtual, _ := strings.Cut(value, "(virtual ")
+	return parseHumanSize(rw), parseHumanSize(strings.TrimSuffix(virtual, ")"))
+}
+
+// bulkConcurrency bounds how many commands a bulk action runs at once, below
+// sshd's default limit of ten sessions per connection.
+const bulkConcurrency = 8
+
+type ContainerBulkRequest struct {
+	Action     string   `json:"action"`
+	Containers []string `json:"containers"`
+}
+
+type ContainerBulkResult struct {
+	Container string `json:"container"`
+	Success   bool   `json:"success"`
+	Error     string `json:"error,omitempty"`
+	Protected bool   `json:"protected,omitempty"`
+	// Command is what runs, or would run on a dry run, for this container.
+	Command string `json:"command,omitempty"`
+}
+
+// BulkContainerAction runs action on every container concurrently. One
+// container failing, or refusing because it is protected, does not stop the
+// others; results keep the request order. A dry run only checks that each
+// container exists and may be acted on.
+func (dm *DockerManager) BulkContainerAction(action string, containers []string, override, dryRun bool) ([]ContainerBulkResult, error) {
+	if _, ok := containerActionCommands[action]; !ok {
+		return nil, requestErrorf(codeInvalidRequest, "unknown action %q: use start, stop, restart or remove", action)
+	}
+
+	results := make([]ContainerBulkResult, len(containers))
+	sem := make(chan struct{}, bulkConcurrency)
+	var wg sync.WaitGroup
+	for i, container := range containers {
+		wg.Add(1)
+		go func(i int, container string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			results[i] = ContainerBulkResult{Container: container, Success: true, Command: containerActionCommand(action, container)}
+			err := dm.checkProtected(container, action, override)
+			if err == nil && dryRun {
+				_, err = dm.containerResource(container)
+			} else if err == nil {
+				_, err = dm.executeSSHCommand(results[i].Command)
+			}
+			if err != nil {
+				results[i].Success = false
+				results[i].Error = err.Error()
+				results[i].Protected = isProtectedError(err)
+				if results[i].Protected || dryRun {
+					results[i].Command = ""
+				}
+			}
+		}(i, container)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+func containerBulkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	var req ContainerBulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, codeInvalidRequest, "Invalid JSON format")
+		return
+	}
+	var containers []string
+	seen := map[string]bool{}
+	for _, container := range req.Containers {
+		container = strings.TrimSpace(container)
+		if container != "" && !seen[container] {
+			seen[container] = true
+			containers = append(containers, container)
+		}
+	}
+	if len(containers) == 0 {
+		writeError(w, codeInvalidRequest, "containers is required")
+		return
+	}
+
+	dryRun := dryRunRequested(r)
+	if req.Action == "remove" && !dryRun && !confirmOperation(w, r, manager, requestOperation(r, req.Action, strings.Join(containers, ",")), func() (string, map[string]interface{}, error) {
+		return fmt.Sprintf("Remove %d containers: %s", len(containers), strings.Join(containers, ", ")), nil, nil
+	}) {
+		return
+	}
+	results, err := manager.BulkContainerAction(req.Action, containers, overrideRequested(r), dryRun)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	if dryRun {
+		commands := []string{}
+		for _, result := range results {
+			if result.Success {
+				commands = append(commands, result.Command)
+			}
+		}
+		writeDryRun(w, commands, map[string]interface{}{
+			"action":  req.Action,
+			"results": results,
+		})
+		return
+	}
+
+	failed := 0
+	for _, result := range results {
+		var resultErr error
+		if !result.Success {
+			failed++
+			resultErr = errors.New(result.Error)
+		}
+		recordAudit(r, manager.config.ID(), "container."+req.Action, result.Container, resultErr)
+	}
+	manager.logger.Info("bulk container action", "action", req.Action, "containers", len(results), "failed", failed)
+	writeJSON(w, map[string]interface{}{
+		"success":   failed == 0,
+		"action":    req.Action,
+		"results":   results,
+		"succeeded": len(results) - failed,
+		"failed":    failed,
+	})
+}
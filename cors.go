@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSPolicy controls which other origins may call the API from a browser.
+// No allowed origins, the default, leaves the API same-origin only.
+type CORSPolicy struct {
+	Origins     []string
+	Methods     string
+	Headers     string
+	Exposed     string
+	Credentials bool
+	MaxAge      time.Duration
+}
+
+var corsPolicy = loadCORSPolicy()
+
+func envList(name, fallback string) []string {
+	value := setting(name)
+	if value == "" {
+		value = fallback
+	}
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func loadCORSPolicy() *CORSPolicy {
+	return &CORSPolicy{
+		Origins:     envList("CORS_ALLOWED_ORIGINS", ""),
+		Methods:     strings.Join(envList("CORS_ALLOWED_METHODS", "GET, POST, OPTIONS"), ", "),
+		Headers:     strings.Join(envList("CORS_ALLOWED_HEADERS", "Content-Type, Authorization, "+csrfHeader+", "+confirmTokenHeader+", X-Request-ID, "+serverHeader), ", "),
+		Exposed:     strings.Join(envList("CORS_EXPOSED_HEADERS", "X-Request-ID"), ", "),
+		Credentials: setting("CORS_ALLOW_CREDENTIALS") == "true",
+		MaxAge:      envDuration("CORS_MAX_AGE", 10*time.Minute),
+	}
+}
+
+// allowed reports whether origin may make requests. "*" allows any origin,
+// but never together with credentials.
+func (p *CORSPolicy) allowed(origin string) bool {
+	for _, allowed := range p.Origins {
+		if allowed == "*" && !p.Credentials {
+			return true
+		}
+		if strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOrigin is the WebSocket upgrader's origin check: same-origin, as by
+// default, or an allowed CORS origin.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return corsPolicy.allowed(origin)
+}
+
+// corsMiddleware adds the CORS headers for allowed origins and answers
+// preflight requests itself, before authentication.
+func corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !corsPolicy.allowed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := w.Header()
+		header.Add("Vary", "Origin")
+		header.Set("Access-Control-Allow-Origin", origin)
+		if corsPolicy.Credentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			header.Set("Access-Control-Allow-Methods", corsPolicy.Methods)
+			header.Set("Access-Control-Allow-Headers", corsPolicy.Headers)
+			header.Set("Access-Control-Max-Age", strconv.Itoa(int(corsPolicy.MaxAge/time.Second)))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if corsPolicy.Exposed != "" {
+			header.Set("Access-Control-Expose-Headers", corsPolicy.Exposed)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
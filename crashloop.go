@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// A container that died crashLoopRestarts times within crashLoopWindow
+	// is crash-looping, whatever restarted it: docker's restart policy,
+	// auto-heal or someone at the console.
+	crashLoopWindow   = envDuration("CRASH_LOOP_WINDOW", 10*time.Minute)
+	crashLoopRestarts = crashLoopRestartsSetting()
+)
+
+func crashLoopRestartsSetting() int {
+	if n, err := strconv.Atoi(setting("CRASH_LOOP_RESTARTS")); err == nil && n > 0 {
+		return n
+	}
+	return 3
+}
+
+// crashLooping says whether a container is crash-looping: docker is waiting
+// to restart it again, or it died too often lately. Deaths are only known
+// while the event collector runs.
+func crashLooping(c *Container) bool {
+	return c.State == "restarting" || c.RecentRestarts >= crashLoopRestarts
+}
+
+var (
+	crashLoopsMu sync.Mutex
+	// crashLoops are the containers a crash_loop notification was sent for,
+	// until a death finds them below the threshold again.
+	crashLoops = map[string]bool{}
+)
+
+// checkCrashLoop notifies once when a death makes a container crash-looping.
+func checkCrashLoop(dm *DockerManager, event *DockerEvent) {
+	server := dm.config.ID()
+	deaths := deathsSince(server, time.Now().Add(-crashLoopWindow))[event.Name]
+
+	crashLoopsMu.Lock()
+	key := server + "/" + event.Name
+	looping := deaths >= crashLoopRestarts
+	notified := crashLoops[key]
+	crashLoops[key] = looping
+	crashLoopsMu.Unlock()
+	if !looping || notified {
+		return
+	}
+
+	notify(dm, &Notification{
+		ID:          newRequestID(),
+		Event:       "crash_loop",
+		Severity:    notificationSeverity("crash_loop"),
+		Server:      server,
+		Container:   event.Name,
+		ContainerID: event.ID,
+		Image:       event.Image,
+		ExitCode:    event.Attributes["exitCode"],
+		Time:        event.Time,
+		Message:     fmt.Sprintf("Container %s is crash-looping: it died %d times in %s, last with exit code %s", event.Name, deaths, crashLoopWindow, event.Attributes["exitCode"]),
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// 2024-05-01 is a Wednesday.
+	from := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		expr string
+		want string
+	}{
+		{"* * * * *", "2024-05-01T10:31:00Z"},
+		{"0 3 * * *", "2024-05-02T03:00:00Z"},
+		{"@daily", "2024-05-02T00:00:00Z"},
+		{"@hourly", "2024-05-01T11:00:00Z"},
+		{"@weekly", "2024-05-05T00:00:00Z"},
+		{"@monthly", "2024-06-01T00:00:00Z"},
+		{"*/15 * * * *", "2024-05-01T10:45:00Z"},
+		{"10-20/5 * * * *", "2024-05-01T11:10:00Z"},
+		{"30 10 * * *", "2024-05-02T10:30:00Z"},
+		{"0 9 * * mon-fri", "2024-05-02T09:00:00Z"},
+		{"0 0 * * 7", "2024-05-05T00:00:00Z"},
+		{"0 0 1 jan *", "2025-01-01T00:00:00Z"},
+		{"0,45 10,12 * * *", "2024-05-01T10:45:00Z"},
+		// Both day fields restricted: either may match.
+		{"0 0 15 * fri", "2024-05-03T00:00:00Z"},
+		{"0 0 29 2 *", "2028-02-29T00:00:00Z"},
+		{"0 0 30 2 *", ""},
+	} {
+		schedule, err := parseCron(tc.expr)
+		if err != nil {
+			t.Errorf("parseCron(%q): %v", tc.expr, err)
+			continue
+		}
+		next := schedule.next(from)
+		got := ""
+		if !next.IsZero() {
+			got = next.Format(time.RFC3339)
+		}
+		if got != tc.want {
+			t.Errorf("next of %q = %q, want %q", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestParseCronRejects(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"* * * foo *",
+		"@every 5m",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) was accepted", expr)
+		} else if code := errorCodeOf(err); code != codeInvalidRequest {
+			t.Errorf("parseCron(%q): code %q, want %q", expr, code, codeInvalidRequest)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	diskCheckInterval   = envDuration("DISK_CHECK_INTERVAL", 5*time.Minute)
+	diskWarnPercent     = envPercent("DISK_WARN_PERCENT", 80)
+	diskCriticalPercent = envPercent("DISK_CRITICAL_PERCENT", 90)
+	// diskCheckPaths are checked besides the Docker root directory.
+	diskCheckPaths = envList("DISK_CHECK_PATHS", "/")
+)
+
+func envPercent(name string, fallback float64) float64 {
+	value := setting(name)
+	if value == "" {
+		return fallback
+	}
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil || percent <= 0 || percent > 100 {
+		slog.Error("invalid percent setting", "name", name, "value", value, "fallback", fallback)
+		return fallback
+	}
+	return percent
+}
+
+// DiskCheck is the usage of one filesystem, with the checked paths on it.
+// Docker is set for the one holding the Docker root directory, which is
+// what fills up with images, volumes and container logs.
+type DiskCheck struct {
+	Filesystem        string   `json:"filesystem"`
+	Mountpoint        string   `json:"mountpoint"`
+	Paths             []string `json:"paths"`
+	Docker            bool     `json:"docker"`
+	Size              int64    `json:"size"`
+	Used              int64    `json:"used"`
+	Available         int64    `json:"available"`
+	UsedPercent       float64  `json:"used_percent"`
+	InodesUsedPercent float64  `json:"inodes_used_percent"`
+	Level             string   `json:"level"`
+}
+
+type DiskReport struct {
+	Server        string      `json:"server"`
+	DockerRootDir string      `json:"docker_root_dir"`
+	Time          time.Time   `json:"time"`
+	Disks         []DiskCheck `json:"disks"`
+	Error         string      `json:"error,omitempty"`
+}
+
+var (
+	diskMu      sync.Mutex
+	diskReports = map[string]*DiskReport{}
+)
+
+// diskCheckCommand runs df for space and inodes on every path separately,
+// so a missing path does not hide the others.
+func diskCheckCommand() string {
+	paths := []string{`"$root"`}
+	for _, path := range diskCheckPaths {
+		paths = append(paths, shellQuote(path))
+	}
+	return `root=$(docker info --format '{{.DockerRootDir}}' 2>/dev/null); root=${root:-/var/lib/docker}; ` +
+		`for p in ` + strings.Join(paths, " ") + `; do echo "== $p"; ` +
+		`df -P -k "$p" 2>/dev/null | tail -n 1; df -P -i "$p" 2>/dev/null | tail -n 1; done`
+}
+
+func diskLevel(percent float64) string {
+	switch {
+	case percent >= diskCriticalPercent:
+		return "critical"
+	case percent >= diskWarnPercent:
+		return "warning"
+	}
+	return "ok"
+}
+
+// CheckDisks reports the filesystems holding the Docker root directory and
+// diskCheckPaths, each once however many of the paths are on it.
+func (dm *DockerManager) CheckDisks() (*DiskReport, error) {
+	output, err := dm.executeSSHCommand(diskCheckCommand())
+	if err != nil {
+		return nil, fmt.Errorf("Disk check failed: %w", err)
+	}
+
+	report := &DiskReport{Server: dm.config.ID(), Time: time.Now().UTC(), Disks: []DiskCheck{}}
+	index := map[string]int{}
+	var path string
+	var lines int
+	var disk *DiskCheck
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "== ") {
+			path, lines, disk = strings.TrimPrefix(line, "== "), 0, nil
+			if report.DockerRootDir == "" {
+				report.DockerRootDir = path
+			}
+			continue
+		}
+		fields := strings.Fields(line)
+		if path == "" || len(fields) < 6 {
+			continue
+		}
+		lines++
+		switch lines {
+		case 1:
+			mountpoint := fields[5]
+			i, seen := index[mountpoint]
+			if !seen {
+				size, _ := strconv.ParseInt(fields[1], 10, 64)
+				used, _ := strconv.ParseInt(fields[2], 10, 64)
+				available, _ := strconv.ParseInt(fields[3], 10, 64)
+				percent, _ := strconv.ParseFloat(strings.TrimSuffix(fields[4], "%"), 64)
+				report.Disks = append(report.Disks, DiskCheck{
+					Filesystem:  fields[0],
+					Mountpoint:  mountpoint,
+					Paths:       []string{},
+					Size:        size * 1024,
+					Used:        used * 1024,
+					Available:   available * 1024,
+					UsedPercent: percent,
+				})
+				i = len(report.Disks) - 1
+				index[mountpoint] = i
+			}
+			disk = &report.Disks[i]
+			disk.Paths = append(disk.Paths, path)
+			if path == report.DockerRootDir {
+				disk.Docker = true
+			}
+		case 2:
+			// Some filesystems, e.g. btrfs, report "-" for inodes.
+			if percent, err := strconv.ParseFloat(strings.TrimSuffix(fields[4], "%"), 64); err == nil && disk != nil {
+				disk.InodesUsedPercent = percent
+			}
+		}
+	}
+	for i := range report.Disks {
+		disk := &report.Disks[i]
+		disk.Level = diskLevel(max(disk.UsedPercent, disk.InodesUsedPercent))
+	}
+	return report, nil
+}
+
+func lastDiskReport(server string) *DiskReport {
+	diskMu.Lock()
+	defer diskMu.Unlock()
+	return diskReports[server]
+}
+
+// checkDisks runs a check and notifies about every filesystem whose level
+// changed since the last one: a warning or critical "disk" notification as
+// it fills, a "resolved" one when it is back under diskWarnPercent.
+func checkDisks(dm *DockerManager) *DiskReport {
+	server := dm.config.ID()
+	report, err := dm.CheckDisks()
+	if err != nil {
+		dm.logger.Error("disk check failed", "error", err)
+		report = &DiskReport{Server: server, Time: time.Now().UTC(), Disks: []DiskCheck{}, Error: err.Error()}
+	}
+
+	diskMu.Lock()
+	previous := diskReports[server]
+	diskReports[server] = report
+	diskMu.Unlock()
+	if err != nil {
+		return report
+	}
+
+	levels := map[string]string{}
+	if previous != nil {
+		for _, disk := range previous.Disks {
+			levels[disk.Mountpoint] = disk.Level
+		}
+	}
+	for _, disk := range report.Disks {
+		before := levels[disk.Mountpoint]
+		if before == "" {
+			before = "ok"
+		}
+		if disk.Level == before {
+			continue
+		}
+		notify(dm, diskNotification(server, &disk))
+	}
+	return report
+}
+
+func diskNotification(server string, disk *DiskCheck) *Notification {
+	name := disk.Mountpoint
+	if disk.Docker {
+		name += " (Docker data)"
+	}
+	n := &Notification{
+		ID:       newRequestID(),
+		Event:    "disk",
+		Severity: "info",
+		Server:   server,
+		Time:     time.Now().UTC(),
+	}
+	switch disk.Level {
+	case "ok":
+		n.Event = "resolved"
+		n.Message = fmt.Sprintf("Resolved: filesystem %s is %.0f%% full", name, disk.UsedPercent)
+		return n
+	case "critical":
+		n.Severity = "critical"
+	}
+	n.Message = fmt.Sprintf("Filesystem %s is %.0f%% full, %s free", name, disk.UsedPercent, formatSize(disk.Available))
+	if disk.InodesUsedPercent >= diskWarnPercent {
+		n.Message += fmt.Sprintf(", %.0f%% of inodes used", disk.InodesUsedPercent)
+	}
+	return n
+}
+
+// startDiskMonitor checks the server's disks every diskCheckInterval.
+func startDiskMonitor(dm *DockerManager) {
+	if diskCheckInterval <= 0 {
+		return
+	}
+
+	server := dm.config.ID()
+	startBackgroundTask("disk:"+server, func(ctx context.Context) {
+		dm.logger.Info("disk monitor started", "interval", diskCheckInterval)
+		ticker := time.NewTicker(diskCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			checkDisks(dm)
+			select {
+			case <-ctx.Done():
+				dm.logger.Info("disk monitor stopped")
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// diskHandler returns the last disk check of the server, running one when
+// there is none yet or ?refresh=true is given.
+func diskHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := serverManager(w, r)
+	if !ok {
+		return
+	}
+
+	report := lastDiskReport(manager.config.ID())
+	if report == nil || r.URL.Query().Get("refresh") == "true" {
+		report = checkDisks(manager)
+	}
+	if report.Error != "" {
+		writeError(w, codeInternal, report.Error)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"success":          true,
+		"report":           report,
+		"warn_percent":     diskWarnPercent,
+		"critical_percent": diskCriticalPercent,
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// DryRunResource is something a destructive action would touch.
+type DryRunResource struct {
+	Type string `json:"type"`
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+// dryRunRequested reports whether the client only wants to know what an
+// action would do. Dry runs execute nothing and are not audited.
+func dryRunRequested(r *http.Request) bool {
+	return r.URL.Query().Get("dry_run") == "true"
+}
+
+// writeDryRun answers a dry run with the commands that would have run on the
+// host and any details of what they affect.
+func writeDryRun(w http.ResponseWriter, commands []string, fields map[string]interface{}) {
+	payload := map[string]interface{}{
+		"success":  true,
+		"dry_run":  true,
+		"commands": commands,
+	}
+	for key, value := range fields {
+		payload[key] = value
+	}
+	writeJSON(w, payload)
+}
+
+// containerResource resolves a container the way docker would, so a dry run
+// fails for containers the real action could not find.
+func (dm *DockerManager) containerResource(containerID string) (DryRunResource, error) {
+	info, err := dm.inspectContainer(containerID)
+	if err != nil {
+		return DryRunResource{}, err
+	}
+	return DryRunResource{Type: "container", ID: info.ID, Name: strings.TrimPrefix(info.Name, "/")}, nil
+}
+
+func containerActionDryRun(w http.ResponseWriter, r *http.Request, manager *DockerManager, containerID, action string, protectErr error) {
+	if protectErr != nil {
+		writeJSON(w, map[string]interface{}{
+			"success":   false,
+			"dry_run":   true,
+			"error":     protectErr.Error(),
+			"protected": true,
+			"code":      errorCodeOf(protectErr),
+		})
+		return
+	}
+
+	var command string
+	switch action {
+	case "kill":
+		var err error
+		if command, err = killCommand(containerID, r.URL.Query().Get("signal")); err != nil {
+			writeFailure(w, err)
+			return
+		}
+	default:
+		if _, ok := containerActionCommands[action]; !ok {
+			writeError(w, codeInvalidRequest, "Unknown action: "+action)
+			return
+		}
+		command = containerActionCommand(action, containerID)
+	}
+
+	resource, err := manager.containerResource(containerID)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	writeDryRun(w, []string{command}, map[string]interface{}{
+		"resources": []DryRunResource{resource},
+	})
+}
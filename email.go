@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	smtpHost     = envString("SMTP_HOST", "")
+	smtpPort     = envString("SMTP_PORT", "587")
+	smtpUsername = envString("SMTP_USERNAME", "")
+	smtpPassword = envString("SMTP_PASSWORD", "")
+	smtpFrom     = envString("SMTP_FROM", "rdm@localhost")
+	// smtpTLS is starttls, tls (implicit, usually port 465) or none.
+	smtpTLS = envString("SMTP_TLS", "starttls")
+
+	notifyDigestInterval = envDuration("NOTIFY_DIGEST_INTERVAL", time.Hour)
+)
+
+func validEmails(addresses []string) error {
+	for _, address := range addresses {
+		if _, err := mail.ParseAddress(address); err != nil {
+			return requestErrorf(codeInvalidRequest, "invalid email address %q", address)
+		}
+	}
+	return nil
+}
+
+// recipients are the notifier's own addresses and those of every server
+// group the server is in.
+func (n *Notifier) recipients() []string {
+	to := append([]string{}, n.Recipients...)
+	for _, group := range loadServerGroups() {
+		if !containsFold(group.Servers, n.Server) {
+			continue
+		}
+		for _, address := range group.Emails {
+			if !containsFold(to, address) {
+				to = append(to, address)
+			}
+		}
+	}
+	return to
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+type emailSink struct {
+	notifier *Notifier
+}
+
+func (s *emailSink) Send(ctx context.Context, n *Notification) error {
+	subject, err := s.notifier.text(n)
+	if err != nil {
+		return permanentError{err}
+	}
+	return sendMail(ctx, s.notifier.recipients(), subject, notificationDetails(n))
+}
+
+func notificationDetails(n *Notification) string {
+	lines := []string{n.Message, "", "Server: " + n.Server}
+	if n.Container != "" {
+		lines = append(lines, "Container: "+n.Container+" ("+shortID(n.ContainerID)+")", "Image: "+n.Image)
+	}
+	if n.ExitCode != "" {
+		lines = append(lines, "Exit code: "+n.ExitCode)
+	}
+	lines = append(lines, "Time: "+n.Time.Format(time.RFC1123Z))
+	return strings.Join(lines, "\r\n")
+}
+
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// sendMail sends a plain text message through the configured SMTP server.
+// Rejected recipients and messages are permanent failures.
+func sendMail(ctx context.Context, to []string, subject, body string) error {
+	if smtpHost == "" {
+		return permanentError{errors.New("SMTP_HOST is not set")}
+	}
+	if len(to) == 0 {
+		return permanentError{errors.New("no recipients")}
+	}
+
+	addr := net.JoinHostPort(smtpHost, smtpPort)
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	var err error
+	if smtpTLS == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: smtpHost})
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	client, err := smtp.NewClient(conn, smtpHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if smtpTLS == "starttls" {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return permanentError{fmt.Errorf("%s does not support STARTTLS; set SMTP_TLS=none to send unencrypted", smtpHost)}
+		}
+		if err := client.StartTLS(&tls.Config{ServerName: smtpHost}); err != nil {
+			return err
+		}
+	}
+	if smtpUsername != "" {
+		if err := client.Auth(smtp.PlainAuth("", smtpUsername, smtpPassword, smtpHost)); err != nil {
+			return smtpError(err)
+		}
+	}
+	if err := client.Mail(smtpFrom); err != nil {
+		return smtpError(err)
+	}
+	for _, address := range to {
+		if err := client.Rcpt(address); err != nil {
+			return smtpError(err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return smtpError(err)
+	}
+	// Header values must not break the header; templates may produce
+	// anything.
+	subject = strings.Join(strings.Fields(subject), " ")
+	header := []string{
+		"From: " + smtpFrom,
+		"To: " + strings.Join(to, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: 8bit",
+	}
+	if _, err := fmt.Fprintf(w, "%s\r\n\r\n%s\r\n", strings.Join(header, "\r\n"), body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return smtpError(err)
+	}
+	return client.Quit()
+}
+
+// smtpError treats 5xx replies as permanent.
+func smtpError(err error) error {
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code >= 500 {
+		return permanentError{err}
+	}
+	return err
+}
+
+type notificationDigest struct {
+	notifier      Notifier
+	notifications []Notification
+}
+
+var (
+	digestsMu sync.Mutex
+	digests   = map[string]*notificationDigest{}
+)
+
+func queueDigest(notifier *Notifier, n *Notification) {
+	digestsMu.Lock()
+	defer digestsMu.Unlock()
+	digest, ok := digests[notifier.ID]
+	if !ok {
+		digest = &notificationDigest{notifier: *notifier}
+		digests[notifier.ID] = digest
+	}
+	digest.notifications = append(digest.notifications, *n)
+}
+
+// flushDigests sends every queued digest as one message per notifier.
+// Digests are kept in memory, so a restart loses what was queued.
+func flushDigests() {
+	digestsMu.Lock()
+	pending := digests
+	digests = map[string]*notificationDigest{}
+	digestsMu.Unlock()
+
+	ids := make([]string, 0, len(pending))
+	for id := range pending {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		digest := pending[id]
+		go retryDelivery(slog.Default(), &digest.notifier, "digest", func(ctx context.Context) error {
+			return digest.send(ctx)
+		})
+	}
+}
+
+func (d *notificationDigest) send(ctx context.Context) error {
+	sort.SliceStable(d.notifications, func(i, j int) bool {
+		return d.notifications[i].Time.Before(d.notifications[j].Time)
+	})
+	lines := []string{}
+	for _, n := range d.notifications {
+		text, err := d.notifier.text(&n)
+		if err != nil {
+			text = n.Message
+		}
+		lines = append(lines, n.Time.Format("2006-01-02 15:04:05")+"  "+text)
+	}
+	subject := fmt.Sprintf("[%s] %d notifications", d.notifier.Server, len(d.notifications))
+	return sendMail(ctx, d.notifier.recipients(), subject, strings.Join(lines, "\r\n"))
+}
+
+func startNotificationDigests() {
+	if notifyDigestInterval <= 0 {
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(notifyDigestInterval)
+			flushDigests()
+		}
+	}()
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Error codes of the failures clients commonly need to tell apart. They are
+// returned as "code" next to "error", and as the code of the versioned API's
+// error.
+const (
+	codeSSHAuthFailed       = "SSH_AUTH_FAILED"
+	codeSSHConnectionFailed = "SSH_CONNECTION_FAILED"
+	codeDockerNotInstalled  = "DOCKER_NOT_INSTALLED"
+	codeContainerNotFound   = "CONTAINER_NOT_FOUND"
+	codePermissionDenied    = "PERMISSION_DENIED"
+	codeTimeout             = "TIMEOUT"
+)
+
+// Codes the versioned API derives from the status of failures without one
+// of the codes above.
+const (
+	codeInvalidRequest       = "INVALID_REQUEST"
+	codeUnauthenticated      = "UNAUTHENTICATED"
+	codeForbidden            = "FORBIDDEN"
+	codeNotFound             = "NOT_FOUND"
+	codeMethodNotAllowed     = "METHOD_NOT_ALLOWED"
+	codeConflict             = "CONFLICT"
+	codeConfirmationRequired = "CONFIRMATION_REQUIRED"
+	codeRateLimited          = "RATE_LIMITED"
+	codeUnavailable          = "UNAVAILABLE"
+	codeInternal             = "INTERNAL"
+)
+
+var codeStatuses = map[string]int{
+	codeSSHAuthFailed:       http.StatusBadGateway,
+	codeSSHConnectionFailed: http.StatusBadGateway,
+	codeDockerNotInstalled:  http.StatusServiceUnavailable,
+	codeContainerNotFound:   http.StatusNotFound,
+	codePermissionDenied:    http.StatusForbidden,
+	codeTimeout:             http.StatusGatewayTimeout,
+
+	codeInvalidRequest:       http.StatusBadRequest,
+	codeUnauthenticated:      http.StatusUnauthorized,
+	codeForbidden:            http.StatusForbidden,
+	codeNotFound:             http.StatusNotFound,
+	codeMethodNotAllowed:     http.StatusMethodNotAllowed,
+	codeConflict:             http.StatusConflict,
+	codeConfirmationRequired: http.StatusPreconditionRequired,
+	codeRateLimited:          http.StatusTooManyRequests,
+	codeUnavailable:          http.StatusServiceUnavailable,
+	codeInternal:             http.StatusInternalServerError,
+}
+
+// CommandError is a failed SSH connection or remote command. Code is one of
+// the codes above, or blank for failures without one.
+type CommandError struct {
+	Code    string
+	Message string
+	Err     error
+}
+
+func (e *CommandError) Error() string {
+	return e.Message
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+func (e *CommandError) ErrorCode() string {
+	return e.Code
+}
+
+// RequestError is a request refused before anything ran, e.g. one with an
+// invalid field or naming an object that does not exist.
+type RequestError struct {
+	Code    string
+	Message string
+}
+
+func (e *RequestError) Error() string {
+	return e.Message
+}
+
+func (e *RequestError) ErrorCode() string {
+	return e.Code
+}
+
+func requestErrorf(code, format string, args ...interface{}) error {
+	return &RequestError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// errorCodeOf returns the first code in err's chain, if any.
+func errorCodeOf(err error) string {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if coded, ok := err.(interface{ ErrorCode() string }); ok && coded.ErrorCode() != "" {
+			return coded.ErrorCode()
+		}
+	}
+	return ""
+}
+
+func timeoutError(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// dialErrorCode tells a refused login apart from an unreachable host.
+func dialErrorCode(err error) string {
+	switch {
+	case timeoutError(err):
+		return codeTimeout
+	case strings.Contains(err.Error(), "unable to authenticate"):
+		return codeSSHAuthFailed
+	}
+	return codeSSHConnectionFailed
+}
+
+// commandErrorCode classifies a command that exited with err from what it
+// wrote to stderr. Only the lines docker reports errors on count, so
+// warnings printed next to an unrelated failure do not decide its code.
+// Docker is only missing when the shell says so: docker run and exec also
+// exit with 127 when the container's program is not found.
+func commandErrorCode(err error, stderr string) string {
+	if timeoutError(err) {
+		return codeTimeout
+	}
+	for _, line := range strings.Split(strings.ToLower(stderr), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "warning") {
+			continue
+		}
+		switch {
+		case strings.Contains(line, "docker: command not found"), strings.Contains(line, "docker: not found"):
+			return codeDockerNotInstalled
+		case strings.Contains(line, "no such container"):
+			return codeContainerNotFound
+		case strings.Contains(line, "no such"):
+			return codeNotFound
+		case strings.Contains(line, "permission denied"):
+			return codePermissionDenied
+		case strings.Contains(line, "timed out"), strings.Contains(line, "timeout exceeded"):
+			return codeTimeout
+		}
+	}
+	return ""
+}
+
+// commandFailed describes a command that exited with err. Secrets are
+// redacted from the command and from stderr, which may echo it back.
+func commandFailed(command string, err error, stderr string) error {
+	message := fmt.Sprintf("command '%s' failed: %v", redactCommand(command), err)
+	if stderr != "" {
+		message += ", stderr: " + redactCommand(stderr)
+	}
+	return &CommandError{Code: commandErrorCode(err, stderr), Message: message, Err: err}
+}
+
+// lookupFailed is the error of looking up an object that failed with err.
+// Failures the code of the connection or docker itself explains keep it;
+// any other means the object is missing, reported with code.
+func lookupFailed(err error, code, message string) error {
+	if errorCode := errorCodeOf(err); errorCode != "" {
+		code = errorCode
+	}
+	return &CommandError{Code: code, Message: message, Err: err}
+}
+
+// failure is the response for err, with its code when it has one.
+func failure(err error) map[string]interface{} {
+	payload := map[string]interface{}{
+		"success": false,
+		"error":   err.Error(),
+	}
+	if code := errorCodeOf(err); code != "" {
+		payload["code"] = code
+	}
+	return payload
+}
+
+// writeFailure is writeError for err.
+func writeFailure(w http.ResponseWriter, err error) {
+	writeJSON(w, failure(err))
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCommandErrorCode(t *testing.T) {
+	exit127 := errors.New("Process exited with status 127")
+	for _, tc := range []struct {
+		name   string
+		stderr string
+		want   string
+	}{
+		{"bash without docker", "bash: line 1: docker: command not found", codeDockerNotInstalled},
+		{"sh without docker", "sh: 1: docker: not found", codeDockerNotInstalled},
+		{"missing container program", `docker: Error response from daemon: failed to create task for container: OCI runtime create failed: runc create failed: unable to start container process: exec: "nope": executable file not found in $PATH: unknown.`, ""},
+		{"missing exec program", `OCI runtime exec failed: exec failed: unable to start container process: exec: "nope": executable file not found in $PATH: unknown`, ""},
+		{"no stderr", "", ""},
+		{"warning before error", "WARNING: docker: not found in cache\nError response from daemon: No such container: web", codeContainerNotFound},
+		{"missing image", "Error response from daemon: No such image: nope:latest", codeNotFound},
+	} {
+		if got := commandErrorCode(exit127, tc.stderr); got != tc.want {
+			t.Errorf("%s: code %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestLookupFailedDockerMissing(t *testing.T) {
+	// which docker fails without a code when docker is missing.
+	err := lookupFailed(commandFailed("which docker", errors.New("Process exited with status 1"), ""), codeDockerNotInstalled, "Docker is not installed")
+	if code := errorCodeOf(err); code != codeDockerNotInstalled {
+		t.Errorf("which docker failure: code %q, want %q", code, codeDockerNotInstalled)
+	}
+
+	dialErr := &CommandError{Code: codeSSHConnectionFailed, Message: "SSH connection failed"}
+	if code := errorCodeOf(lookupFailed(dialErr, codeDockerNotInstalled, "Docker is not installed")); code != codeSSHConnectionFailed {
+		t.Errorf("connection failure: code %q, want %q", code, codeSSHConnectionFailed)
+	}
+}
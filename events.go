@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type DockerEvent struct {
+	Type       string            `json:"type"`
+	Action     string            `json:"action"`
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Image      string            `json:"image"`
+	Detail     string            `json:"detail,omitempty"`
+	Time       time.Time         `json:"time"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+func parseDockerEvent(line string) (*DockerEvent, error) {
+	var raw struct {
+		Type   string
+		Action string
+		Actor  struct {
+			ID         string
+			Attributes map[string]string
+		}
+		TimeNano int64 `json:"timeNano"`
+	}
+	if err := json.Unmarshal([]byte(line), &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse docker event: %v", err)
+	}
+
+	event := &DockerEvent{
+		Type:       raw.Type,
+		Action:     raw.Action,
+		ID:         raw.Actor.ID,
+		Name:       raw.Actor.Attributes["name"],
+		Image:      raw.Actor.Attributes["image"],
+		Time:       time.Unix(0, raw.TimeNano).UTC(),
+		Attributes: raw.Actor.Attributes,
+	}
+	// Exec and health events carry a suffix ("exec_start: sh",
+	// "health_status: healthy"); keep the action itself stable and the
+	// suffix in Detail.
+	if i := strings.Index(event.Action, ":"); i >= 0 {
+		event.Detail = strings.TrimSpace(event.Action[i+1:])
+		event.Action = strings.TrimSpace(event.Action[:i])
+	}
+	return event, nil
+}
+
+// WatchEvents follows `docker events` on the remote host until fn returns
+// an error or the command ends. filters are passed through as --filter values;
+// a non-zero since replays events from that point first.
+func (dm *DockerManager) WatchEvents(ctx context.Context, since time.Time, filters []string, fn func(*DockerEvent) error) error {
+	command := "docker events --format '{{json .}}'"
+	if !since.IsZero() {
+		command += fmt.Sprintf(" --since %d", since.Unix())
+	}
+	for _, filter := range filters {
+		command += " --filter " + shellQuote(filter)
+	}
+
+	return dm.streamSSHLines(ctx, command, func(line string) error {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return nil
+		}
+		event, err := parseDockerEvent(line)
+		if err != nil {
+			dm.logger.Error("skipping docker event", "error", err)
+			return nil
+		}
+		return fn(event)
+	})
+}
+
+func writeSSE(w http.ResponseWriter, event, id string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	if event != "" {
+		fmt.Fprintf(w, "event: %s\n", event)
+	}
+	if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+func startSSE(w http.ResponseWriter) bool {
+	if _, ok := w.(http.Flusher); !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return false
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	return true
+}
+
+func eventsStreamHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+	if !startSSE(w) {
+		return
+	}
+
+	manager.logger.Info("streaming docker events")
+	err := manager.WatchEvents(r.Context(), time.Time{}, r.URL.Query()["filter"], func(event *DockerEvent) error {
+		return writeSSE(w, "docker", "", event)
+	})
+	if err != nil && r.Context().Err() == nil {
+		manager.logger.Error("docker events stream failed", "error", err)
+		writeSSE(w, "error", "", map[string]string{"error": err.Error()})
+	}
+	manager.logger.Info("docker events stream closed")
+}
+
+const eventsCollection = "events"
+
+const eventRetention = 30 * 24 * time.Hour
+
+type StoredEvent struct {
+	Server string `json:"server"`
+	DockerEvent
+}
+
+// startEventCollector records the server's docker events into the store,
+// replacing any collector already running for the same server.
+func startEventCollector(dm *DockerManager) {
+	if store == nil {
+		return
+	}
+
+	server := dm.config.ID()
+	startBackgroundTask("events:"+server, func(ctx context.Context) {
+		dm.logger.Info("event collector started")
+		last := time.Now()
+		for {
+			err := dm.WatchEvents(ctx, last, nil, func(event *DockerEvent) error {
+				// Reconnecting with --since replays the boundary second.
+				if !event.Time.After(last) {
+					return nil
+				}
+				last = event.Time
+				if event.Type == "container" {
+					containerCache.invalidate(server)
+					recordUptimeEvent(server, event)
+					recordAutoHealEvent(server, event)
+				}
+				if event.Type == "container" && event.Action == "die" {
+					recordContainerDeath(server, event.Name, event.Time)
+					checkCrashLoop(dm, event)
+				}
+				notifyDockerEvent(dm, event)
+				return store.Append(eventsCollection, StoredEvent{Server: server, DockerEvent: *event})
+			})
+			if ctx.Err() != nil {
+				dm.logger.Info("event collector stopped")
+				return
+			}
+			dm.logger.Error("event collector disconnected", "error", err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(10 * time.Second):
+			}
+		}
+	})
+}
+
+func pruneEventHistory() {
+	cutoff := time.Now().Add(-eventRetention)
+	err := store.Compact(eventsCollection, func(data []byte) bool {
+		var event StoredEvent
+		return json.Unmarshal(data, &event) == nil && event.Time.After(cutoff)
+	})
+	if err != nil {
+		slog.Error("failed to prune event history", "error", err)
+	}
+}
+
+func startEventHistoryPruner() {
+	go func() {
+		for {
+			pruneEventHistory()
+			time.Sleep(24 * time.Hour)
+		}
+	}()
+}
+
+// parseSince accepts an RFC3339 timestamp or a duration relative to now.
+func parseSince(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, requestErrorf(codeInvalidRequest, "invalid time %q: use RFC3339 or a duration like 24h", value)
+	}
+	return time.Now().Add(-d), nil
+}
+
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		writeError(w, codeUnavailable, "Event history is not available")
+		return
+	}
+
+	server, ok := historyServer(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	container := query.Get("container")
+	eventType := query.Get("type")
+	action := query.Get("action")
+
+	since, err := parseSince(query.Get("since"))
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	limit := 100
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			writeError(w, codeInvalidRequest, "Invalid limit: "+value)
+			return
+		}
+	}
+
+	events := []StoredEvent{}
+	err = store.Scan(eventsCollection, func(data []byte) error {
+		var event StoredEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil
+		}
+		if event.Server != server {
+			return nil
+		}
+		if container != "" && event.Name != container && !strings.HasPrefix(event.ID, container) {
+			return nil
+		}
+		if eventType != "" && event.Type != eventType {
+			return nil
+		}
+		if action != "" && event.Action != action {
+			return nil
+		}
+		if event.Time.Before(since) {
+			return nil
+		}
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		writeFailure(w, fmt.Errorf("Failed to read event history: %w", err))
+		return
+	}
+
+	// Newest first, capped at limit.
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	if len(events) > limit {
+		events = events[:limit]
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"events":  events,
+		"count":   len(events),
+	})
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/ssh"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	maxExecOutput  = 1 << 20
+	maxExecTimeout = 5 * time.Minute
+)
+
+// readOnlyCommands are programs that only inspect the container. Shells and
+// anything that can launch another program (env, find -exec) are absent.
+var readOnlyCommands = []string{
+	"cat", "df", "du", "echo", "grep", "head", "hostname", "id", "ls",
+	"printenv", "ps", "pwd", "stat", "tail", "uname", "uptime", "wc", "which", "whoami",
+}
+
+// execAllowlist comes from EXEC_ALLOWLIST, a comma separated list of program
+// names; "readonly" stands for readOnlyCommands. Empty allows everything.
+var execAllowlist = parseExecAllowlist(setting("EXEC_ALLOWLIST"))
+
+func parseExecAllowlist(value string) map[string]bool {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+	allowed := map[string]bool{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "readonly" {
+			for _, command := range readOnlyCommands {
+				allowed[command] = true
+			}
+		} else if name != "" {
+			allowed[name] = true
+		}
+	}
+	return allowed
+}
+
+type ExecRequest struct {
+	Command  []string          `json:"command"`
+	User     string            `json:"user"`
+	WorkDir  string            `json:"workdir"`
+	Env      map[string]string `json:"env"`
+	Timeout  string            `json:"timeout"`
+	ReadOnly bool              `json:"read_only"`
+}
+
+type ExecResult struct {
+	Stdout    string        `json:"stdout"`
+	Stderr    string        `json:"stderr"`
+	ExitCode  int           `json:"exit_code"`
+	Duration  time.Duration `json:"-"`
+	Truncated bool          `json:"truncated,omitempty"`
+}
+
+func (req *ExecRequest) Validate() error {
+	if len(req.Command) == 0 || req.Command[0] == "" {
+		return requestErrorf(codeInvalidRequest, "command is required")
+	}
+	// The guards below go by the program's name, so it must be looked up
+	// on the container's PATH: a path could be any uploaded file named like
+	// an allowed command.
+	program := req.Command[0]
+	if (req.ReadOnly || execAllowlist != nil) && strings.Contains(program, "/") {
+		return requestErrorf(codeInvalidRequest, "%s: give the program name, not a path", program)
+	}
+	if req.ReadOnly {
+		if !slices.Contains(readOnlyCommands, program) {
+			return requestErrorf(codeForbidden, "%s is not a read-only command", program)
+		}
+	}
+	if execAllowlist != nil && !execAllowlist[program] {
+		return requestErrorf(codeForbidden, "%s is not in the exec allowlist", program)
+	}
+	for key := range req.Env {
+		if key == "" || strings.Contains(key, "=") {
+			return requestErrorf(codeInvalidRequest, "invalid environment variable name %q", key)
+		}
+	}
+	return nil
+}
+
+// limitedBuffer keeps the first maxExecOutput bytes and drops the rest.
+type limitedBuffer struct {
+	bytes.Buffer
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := maxExecOutput - b.Len(); len(p) > room {
+		b.truncated = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// runSSHCommand runs command and reports stdout, stderr and the exit code
+// separately. Unlike executeSSHCommand a non-zero exit is not an error.
+func (dm *DockerManager) runSSHCommand(ctx context.Context, command string) (result *ExecResult, err error) {
+	_, span := startSpan(ctx, "ssh "+commandCategory(command), spanKindClient,
+		"server", dm.config.ID(),
+		"ssh.command.category", commandCategory(command),
+	)
+	defer func(start time.Time) {
+		span.End(err)
+		observeSSHCommand(dm.config.ID(), command, start, err)
+	}(time.Now())
+
+	client, err := dm.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, &CommandError{Code: codeSSHConnectionFailed, Message: fmt.Sprintf("SSH session creation failed: %v", err), Err: err}
+	}
+	defer session.Close()
+
+	var stdout, stderr limitedBuffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			client.Close()
+		case <-done:
+		}
+	}()
+
+	start := time.Now()
+	runErr := session.Run(command)
+	result = &ExecResult{
+		Stdout:    stdout.String(),
+		Stderr:    stderr.String(),
+		Duration:  time.Since(start),
+		Truncated: stdout.truncated || stderr.truncated,
+	}
+
+	var exitErr *ssh.ExitError
+	switch {
+	case runErr == nil:
+	case ctx.Err() != nil:
+		return result, ctx.Err()
+	case errors.As(runErr, &exitErr):
+		result.ExitCode = exitErr.ExitStatus()
+	default:
+		return result, commandFailed(command, runErr, result.Stderr)
+	}
+	return result, nil
+}
+
+func (dm *DockerManager) ExecContainer(ctx context.Context, containerID string, req ExecRequest) (*ExecResult, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	timeout := 30 * time.Second
+	if req.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(req.Timeout); err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", req.Timeout)
+		}
+	}
+	if timeout > maxExecTimeout {
+		timeout = maxExecTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	args := []string{"docker", "exec"}
+	if req.User != "" {
+		args = append(args, "--user", shellQuote(req.User))
+	}
+	if req.WorkDir != "" {
+		args = append(args, "--workdir", shellQuote(req.WorkDir))
+	}
+	for _, key := range sortedKeys(req.Env) {
+		args = append(args, "-e", shellQuote(key+"="+req.Env[key]))
+	}
+	args = append(args, shellQuote(containerID))
+	for _, arg := range req.Command {
+		args = append(args, shellQuote(arg))
+	}
+
+	result, err := dm.runSSHCommand(ctx, strings.Join(args, " "))
+	if errors.Is(err, context.DeadlineExceeded) {
+		return result, fmt.Errorf("command timed out after %s", timeout)
+	}
+	return result, err
+}
+
+func containerExecHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	var req ExecRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, codeInvalidRequest, "Invalid JSON format")
+		return
+	}
+
+	containerID := mux.Vars(r)["id"]
+	result, err := manager.ExecContainer(r.Context(), containerID, req)
+	recordAudit(r, manager.config.ID(), "container.exec", containerID, err)
+	if err != nil {
+		manager.logger.Error("exec failed", "container", containerID, "command", redactCommand(strings.Join(req.Command, " ")), "error", err)
+		writeFailure(w, err)
+		return
+	}
+
+	manager.logger.Info("exec completed", "container", containerID, "command", redactCommand(strings.Join(req.Command, " ")), "exit_code", result.ExitCode)
+	writeJSON(w, map[string]interface{}{
+		"success":     true,
+		"stdout":      result.Stdout,
+		"stderr":      result.Stderr,
+		"exit_code":   result.ExitCode,
+		"duration_ms": result.Duration.Milliseconds(),
+		"truncated":   result.Truncated,
+	})
+}
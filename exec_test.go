@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestValidateRejectsProgramPaths(t *testing.T) {
+	defer func(allowlist map[string]bool) { execAllowlist = allowlist }(execAllowlist)
+
+	execAllowlist = nil
+	for _, command := range []string{"/tmp/x/cat", "./cat", "bin/cat"} {
+		req := ExecRequest{Command: []string{command, "/etc/hostname"}, ReadOnly: true}
+		if err := req.Validate(); err == nil {
+			t.Errorf("read-only %s was allowed", command)
+		}
+	}
+
+	execAllowlist = parseExecAllowlist("cat")
+	if err := (&ExecRequest{Command: []string{"/tmp/x/cat"}}).Validate(); err == nil {
+		t.Error("/tmp/x/cat passed an allowlist of cat")
+	}
+	if err := (&ExecRequest{Command: []string{"cat", "/etc/hostname"}}).Validate(); err != nil {
+		t.Errorf("cat with a path argument was rejected: %v", err)
+	}
+
+	execAllowlist = nil
+	if err := (&ExecRequest{Command: []string{"/usr/local/bin/tool"}}).Validate(); err != nil {
+		t.Errorf("unrestricted exec of a path was rejected: %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// composeQuote renders a YAML double-quoted scalar, escaping "$" so compose
+// does not treat it as variable interpolation.
+func composeQuote(s string) string {
+	return strconv.Quote(strings.ReplaceAll(s, "$", "$$"))
+}
+
+func composeList(b *strings.Builder, indent, key string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "%s%s:\n", indent, key)
+	for _, value := range values {
+		fmt.Fprintf(b, "%s  - %s\n", indent, composeQuote(value))
+	}
+}
+
+func composeMap(b *strings.Builder, indent, key string, values map[string]string) {
+	if len(values) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "%s%s:\n", indent, key)
+	for _, k := range sortedKeys(values) {
+		fmt.Fprintf(b, "%s  %s: %s\n", indent, strconv.Quote(k), composeQuote(values[k]))
+	}
+}
+
+// ComposeFile renders the request as a docker-compose file with a single
+// service. Named volumes and user-defined networks are declared external
+// since they already exist on the server.
+func (req *ContainerCreateRequest) ComposeFile() string {
+	service := req.Name
+	if service == "" {
+		service = "app"
+	}
+
+	var b strings.Builder
+	b.WriteString("services:\n")
+	fmt.Fprintf(&b, "  %s:\n", strconv.Quote(service))
+	fmt.Fprintf(&b, "    image: %s\n", composeQuote(req.Image))
+	if req.Name != "" {
+		fmt.Fprintf(&b, "    container_name: %s\n", composeQuote(req.Name))
+	}
+	if req.RestartPolicy != "" {
+		fmt.Fprintf(&b, "    restart: %s\n", composeQuote(req.RestartPolicy))
+	}
+	composeList(&b, "    ", "entrypoint", req.Entrypoint)
+	composeList(&b, "    ", "command", req.Command)
+	composeList(&b, "    ", "ports", req.Ports)
+	composeMap(&b, "    ", "environment", req.Env)
+	composeList(&b, "    ", "volumes", req.Volumes)
+	composeMap(&b, "    ", "labels", req.Labels)
+	if limits := req.Resources; limits != nil {
+		for _, limit := range []struct{ key, value string }{
+			{"cpus", limits.CPUs},
+			{"cpu_shares", limits.CPUShares},
+			{"mem_limit", limits.Memory},
+			{"mem_reservation", limits.MemoryReservation},
+			{"memswap_limit", limits.MemorySwap},
+			{"pids_limit", limits.PidsLimit},
+		} {
+			if limit.value != "" {
+				fmt.Fprintf(&b, "    %s: %s\n", limit.key, composeQuote(limit.value))
+			}
+		}
+	}
+
+	userNetwork := false
+	switch {
+	case req.Network == "":
+	case req.Network == "host" || req.Network == "none" || strings.HasPrefix(req.Network, "container:"):
+		fmt.Fprintf(&b, "    network_mode: %s\n", composeQuote(req.Network))
+	default:
+		userNetwork = true
+		composeList(&b, "    ", "networks", []string{req.Network})
+	}
+
+	var named []string
+	for _, volume := range req.Volumes {
+		parts := strings.Split(volume, ":")
+		if len(parts) > 1 && parts[0] != "" && !strings.ContainsAny(parts[0][:1], "/.~") {
+			named = append(named, parts[0])
+		}
+	}
+	if len(named) > 0 {
+		b.WriteString("\nvolumes:\n")
+		for _, name := range named {
+			fmt.Fprintf(&b, "  %s:\n    external: true\n", strconv.Quote(name))
+		}
+	}
+	if userNetwork {
+		fmt.Fprintf(&b, "\nnetworks:\n  %s:\n    external: true\n", strconv.Quote(req.Network))
+	}
+	return b.String()
+}
+
+func containerExportHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	containerID := mux.Vars(r)["id"]
+	req, err := manager.RunConfig(containerID)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "run":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, req.RunCommand())
+	case "compose":
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", req.Name+"-compose.yml"))
+		fmt.Fprint(w, req.ComposeFile())
+	case "":
+		writeJSON(w, map[string]interface{}{
+			"success": true,
+			"config":  req,
+			"run":     req.RunCommand(),
+			"compose": req.ComposeFile(),
+		})
+	default:
+		writeError(w, codeInvalidRequest, "Invalid format: use run or compose")
+	}
+}
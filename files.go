@@ -0,0 +1,362 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// containerPath checks that p is an absolute path inside the container.
+func containerPath(p string) (string, error) {
+	if !strings.HasPrefix(p, "/") {
+		return "", requestErrorf(codeInvalidRequest, "path must be absolute: %q", p)
+	}
+	return path.Clean(p), nil
+}
+
+// CopyFromContainer streams a tar archive of path in the container, as
+// produced by `docker cp container:path -`.
+func (dm *DockerManager) CopyFromContainer(ctx context.Context, containerID, p string, w io.Writer) error {
+	command := fmt.Sprintf("docker cp %s -", shellQuote(containerID+":"+p))
+	return dm.streamSSHCommand(ctx, command, nil, w)
+}
+
+// downloadWriter sends the download headers with the first byte of output,
+// so a failure before any data (e.g. a missing path) can still be reported
+// as a normal JSON error.
+type downloadWriter struct {
+	w        http.ResponseWriter
+	filename string
+	started  bool
+}
+
+func (d *downloadWriter) Write(p []byte) (int, error) {
+	if !d.started {
+		d.started = true
+		d.w.Header().Set("Content-Type", "application/x-tar")
+		d.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", d.filename))
+	}
+	return d.w.Write(p)
+}
+
+func containerFileDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	containerID := mux.Vars(r)["id"]
+	p, err := containerPath(r.URL.Query().Get("path"))
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	name := path.Base(p)
+	if name == "/" {
+		name = "root"
+	}
+	download := &downloadWriter{w: w, filename: name + ".tar"}
+
+	manager.logger.Info("downloading from container", "container", containerID, "path", p)
+	if err := manager.CopyFromContainer(r.Context(), containerID, p, download); err != nil {
+		manager.logger.Error("container download failed", "container", containerID, "path", p, "error", err)
+		if !download.started {
+			writeFailure(w, err)
+		}
+		return
+	}
+	if !download.started {
+		writeError(w, codeNotFound, "Nothing to download at "+p)
+	}
+}
+
+// containerPathExists reports whether p exists in the container. It reads at
+// most one byte of `docker cp` output, which works even in images without a
+// shell or coreutils.
+func (dm *DockerManager) containerPathExists(containerID, p string) (bool, error) {
+	output, err := dm.executeSSHCommand(fmt.Sprintf("docker cp %s - 2>/dev/null | head -c 1 | wc -c", shellQuote(containerID+":"+p)))
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(output) != "0", nil
+}
+
+// CopyToContainer extracts the tar archive into dir in the container.
+func (dm *DockerManager) CopyToContainer(ctx context.Context, containerID, dir string, archive io.Reader) error {
+	command := fmt.Sprintf("docker cp - %s", shellQuote(containerID+":"+dir))
+	return dm.streamSSHCommand(ctx, command, archive, io.Discard)
+}
+
+// tarFile wraps a single file into a tar stream for docker cp. The content is
+// spooled to disk first because tar headers need the size up front.
+func tarFile(name string, content io.Reader) (io.ReadCloser, error) {
+	spool, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(spool.Name())
+
+	size, err := io.Copy(spool, content)
+	if err != nil {
+		spool.Close()
+		return nil, err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		spool.Close()
+		return nil, err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		defer spool.Close()
+		tw := tar.NewWriter(writer)
+		err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    size,
+			ModTime: time.Now(),
+		})
+		if err == nil {
+			_, err = io.Copy(tw, spool)
+		}
+		if err == nil {
+			err = tw.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+	return reader, nil
+}
+
+func containerFileUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	containerID := mux.Vars(r)["id"]
+	dir, err := containerPath(query.Get("path"))
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	overwrite := query.Get("overwrite") == "true"
+
+	upload, err := uploadReader(r)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	var archive io.Reader
+	target := dir
+	if query.Get("extract") == "true" {
+		// Any file in the archive may replace an existing one, so archives
+		// are only extracted once the caller has accepted that.
+		if !overwrite {
+			writeJSON(w, map[string]interface{}{
+				"success": false,
+				"confirm": true,
+				"code":    codeConflict,
+				"error":   "Extracting an archive may overwrite files in " + dir + "; repeat with overwrite=true to continue",
+			})
+			return
+		}
+		archive = upload
+	} else {
+		name := query.Get("name")
+		if part, ok := upload.(*multipart.Part); ok && name == "" {
+			name = part.FileName()
+		}
+		name = path.Base(name)
+		if name == "" || name == "." || name == "/" {
+			writeError(w, codeInvalidRequest, "File name is required")
+			return
+		}
+		target = path.Join(dir, name)
+
+		if !overwrite {
+			exists, err := manager.containerPathExists(containerID, target)
+			if err != nil {
+				writeFailure(w, err)
+				return
+			}
+			if exists {
+				writeJSON(w, map[string]interface{}{
+					"success": false,
+					"confirm": true,
+					"code":    codeConflict,
+					"error":   target + " already exists; repeat with overwrite=true to replace it",
+				})
+				return
+			}
+		}
+
+		wrapped, err := tarFile(name, upload)
+		if err != nil {
+			writeFailure(w, fmt.Errorf("Failed to read upload: %w", err))
+			return
+		}
+		defer wrapped.Close()
+		archive = wrapped
+	}
+
+	manager.logger.Info("uploading to container", "container", containerID, "path", target)
+	err = manager.CopyToContainer(r.Context(), containerID, dir, archive)
+	recordAudit(r, manager.config.ID(), "container.upload", containerID+":"+target, err)
+	if err != nil {
+		manager.logger.Error("container upload failed", "container", containerID, "path", target, "error", err)
+		writeFailure(w, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"path":    target,
+		"message": "Uploaded to " + target,
+	})
+}
+
+type FileEntry struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Mode       string `json:"mode"`
+	Size       int64  `json:"size"`
+	Owner      string `json:"owner"`
+	Group      string `json:"group"`
+	Modified   string `json:"modified"`
+	LinkTarget string `json:"link_target,omitempty"`
+}
+
+// lsLinePattern matches `ls -la` lines from both GNU ls with
+// --time-style=full-iso and busybox; either way the timestamp is three fields.
+var lsLinePattern = regexp.MustCompile(`^([-dlcbps][-rwxsStT]{9}\S*)\s+\d+\s+(\S+)\s+(\S+)\s+(\d+)\s+(\S+\s+\S+\s+\S+)\s(.+)$`)
+
+// tarLinePattern matches `tar -tv` lines, e.g.
+// "drwxr-xr-x root/root 0 2024-01-01 12:00 etc/nginx/".
+var tarLinePattern = regexp.MustCompile(`^([-dlcbps][-rwxsStT]{9})\s+(\S+?)/(\S+)\s+(\d+)\s+(\S+\s+\S+)\s(.+)$`)
+
+func fileType(mode string) string {
+	switch mode[0] {
+	case 'd':
+		return "dir"
+	case 'l':
+		return "link"
+	case '-':
+		return "file"
+	}
+	return "other"
+}
+
+func parseFileEntry(match []string, modified string) FileEntry {
+	size, _ := strconv.ParseInt(match[4], 10, 64)
+	entry := FileEntry{
+		Name:     match[6],
+		Type:     fileType(match[1]),
+		Mode:     match[1],
+		Size:     size,
+		Owner:    match[2],
+		Group:    match[3],
+		Modified: modified,
+	}
+	if name, target, ok := strings.Cut(entry.Name, " -> "); ok && entry.Type == "link" {
+		entry.Name, entry.LinkTarget = name, target
+	}
+	return entry
+}
+
+// ListContainerFiles lists the directory dir inside the container. It uses ls
+// through docker exec and falls back to streaming the directory as a tar
+// archive for images without ls, which reads the whole tree.
+func (dm *DockerManager) ListContainerFiles(containerID, dir string) ([]FileEntry, error) {
+	entries := []FileEntry{}
+	output, err := dm.executeSSHCommand(fmt.Sprintf("docker exec %s ls -la --time-style=full-iso %s 2>/dev/null || docker exec %s ls -la %s",
+		shellQuote(containerID), shellQuote(dir), shellQuote(containerID), shellQuote(dir)))
+	if err == nil {
+		for _, line := range strings.Split(output, "\n") {
+			match := lsLinePattern.FindStringSubmatch(strings.TrimRight(line, "\r"))
+			if match == nil || match[6] == "." || match[6] == ".." {
+				continue
+			}
+			entries = append(entries, parseFileEntry(match, match[5]))
+		}
+		return entries, nil
+	}
+	dm.logger.Debug("ls failed in container, listing through docker cp", "container", containerID, "error", err)
+
+	output, err = dm.executeSSHCommand(fmt.Sprintf("docker cp %s - | tar -tvf -", shellQuote(containerID+":"+dir)))
+	if err != nil {
+		return nil, err
+	}
+	// docker cp puts everything under the directory's own name; only its
+	// direct children are listed.
+	base := path.Base(dir)
+	for _, line := range strings.Split(output, "\n") {
+		match := tarLinePattern.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		name := strings.TrimSuffix(match[6], "/")
+		if link, _, ok := strings.Cut(name, " -> "); ok {
+			name = link
+		}
+		rel := strings.TrimPrefix(name, base+"/")
+		if rel == name || rel == "" || strings.Contains(rel, "/") {
+			continue
+		}
+		match[6] = strings.Replace(strings.TrimSuffix(match[6], "/"), base+"/", "", 1)
+		entries = append(entries, parseFileEntry(match, match[5]))
+	}
+	return entries, nil
+}
+
+func containerFilesHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	dir := r.URL.Query().Get("path")
+	if dir == "" {
+		dir = "/"
+	}
+	dir, err := containerPath(dir)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	entries, err := manager.ListContainerFiles(mux.Vars(r)["id"], dir)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if (entries[i].Type == "dir") != (entries[j].Type == "dir") {
+			return entries[i].Type == "dir"
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"path":    dir,
+		"entries": entries,
+		"count":   len(entries),
+	})
+}
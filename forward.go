@@ -0,0 +1,483 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logForwardersCollection = "log_forwarders"
+	forwardBatchSize        = 500
+	forwardBufferSize       = 10000
+	forwardRefreshInterval  = 30 * time.Second
+	forwardMaxBackoff       = time.Minute
+)
+
+var lokiLabelPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// LogForwarder ships the followed logs of one container, or of every running
+// container on the server when Container is empty, to an external system:
+// Loki, a syslog receiver or the Elasticsearch bulk API.
+type LogForwarder struct {
+	ID        string            `json:"id"`
+	Server    string            `json:"server"`
+	Container string            `json:"container,omitempty"`
+	Type      string            `json:"type"`
+	URL       string            `json:"url"`
+	Tenant    string            `json:"tenant,omitempty"`
+	Index     string            `json:"index,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// ForwarderStatus reports delivery progress since the forwarder last started.
+type ForwarderStatus struct {
+	Running     bool      `json:"running"`
+	Following   int       `json:"following"`
+	Buffered    int       `json:"buffered"`
+	Blocked     bool      `json:"blocked"`
+	Sent        int64     `json:"sent"`
+	Dropped     int64     `json:"dropped"`
+	Retries     int64     `json:"retries"`
+	LastSentAt  time.Time `json:"last_sent_at"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at"`
+}
+
+type forwarderState struct {
+	mu     sync.Mutex
+	status ForwarderStatus
+	lines  chan forwardedLog
+}
+
+var (
+	forwarderStatesMu sync.Mutex
+	forwarderStates   = map[string]*forwarderState{}
+)
+
+func (s *forwarderState) update(fn func(status *ForwarderStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.status)
+}
+
+func (s *forwarderState) snapshot() ForwarderStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.status
+	status.Buffered = len(s.lines)
+	return status
+}
+
+// forwardedLog is a log line together with the labels it is shipped with.
+type forwardedLog struct {
+	ContainerLog
+	Image string
+}
+
+type logSink interface {
+	Send(ctx context.Context, batch []forwardedLog) error
+}
+
+func (f *LogForwarder) Validate() error {
+	if f.Type == "" {
+		f.Type = "loki"
+	}
+	var schemes []string
+	switch f.Type {
+	case "loki", "elasticsearch":
+		schemes = []string{"http", "https"}
+	case "syslog":
+		schemes = []string{"udp", "tcp"}
+	default:
+		return requestErrorf(codeInvalidRequest, "unsupported forwarder type %q", f.Type)
+	}
+	u, err := url.Parse(f.URL)
+	if err != nil || u.Host == "" || (u.Scheme != schemes[0] && u.Scheme != schemes[1]) {
+		return requestErrorf(codeInvalidRequest, "invalid url %q: %s forwarders take a %s:// or %s:// URL", f.URL, f.Type, schemes[0], schemes[1])
+	}
+	for name := range f.Labels {
+		if !lokiLabelPattern.MatchString(name) {
+			return requestErrorf(codeInvalidRequest, "invalid label name %q", name)
+		}
+	}
+	return nil
+}
+
+func (f *LogForwarder) sink() logSink {
+	switch f.Type {
+	case "syslog":
+		return &syslogSink{forwarder: f}
+	case "elasticsearch":
+		return &elasticsearchSink{forwarder: f}
+	}
+	return &lokiSink{forwarder: f}
+}
+
+// forwardTargets lists the running containers the forwarder covers.
+func (dm *DockerManager) forwardTargets(f *LogForwarder) ([]Container, error) {
+	containers, err := dm.GetContainers(false, "status=running")
+	if err != nil {
+		return nil, err
+	}
+	if f.Container == "" {
+		return containers, nil
+	}
+	for _, container := range containers {
+		if container.Name == f.Container || strings.HasPrefix(container.ID, f.Container) {
+			return []Container{container}, nil
+		}
+	}
+	return nil, nil
+}
+
+// followForwarded follows one container, starting after since or with new
+// lines only when since is zero, and returns the time of the last line sent.
+// When the buffer is full the follower waits rather than dropping lines; the
+// unread output backs up on the server, where docker keeps it on disk anyway.
+func (dm *DockerManager) followForwarded(ctx context.Context, state *forwarderState, container Container, since time.Time) time.Time {
+	opts := LogOptions{Tail: "0"}
+	if !since.IsZero() {
+		opts = LogOptions{Since: since.Format(time.RFC3339Nano)}
+	}
+	err := dm.FollowContainerLogs(ctx, container.Name, opts, func(entry ContainerLog) error {
+		// --since is inclusive, so the last line before a reconnect comes
+		// round again.
+		if !since.IsZero() && !entry.Timestamp.After(since) {
+			return nil
+		}
+		entry.Container = container.Name
+		line := forwardedLog{ContainerLog: entry, Image: container.Image}
+		select {
+		case state.lines <- line:
+		default:
+			state.update(func(status *ForwarderStatus) { status.Blocked = true })
+			select {
+			case state.lines <- line:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if !entry.Timestamp.IsZero() {
+			since = entry.Timestamp
+		}
+		return nil
+	})
+	if err != nil && ctx.Err() == nil {
+		dm.logger.Error("forwarded log stream failed", "container", container.Name, "error", err)
+	}
+	return since
+}
+
+// ship sends lines to the sink in batches, once a second or whenever a batch
+// fills up. Failed batches are retried with backoff until they are delivered,
+// unless the receiver rejected them outright.
+func (dm *DockerManager) ship(ctx context.Context, f *LogForwarder, state *forwarderState) {
+	sink := f.sink()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var batch []forwardedLog
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		backoff := time.Second
+		for {
+			err := sink.Send(ctx, batch)
+			if err == nil {
+				state.update(func(status *ForwarderStatus) {
+					status.Sent += int64(len(batch))
+					status.LastSentAt = time.Now().UTC()
+					status.Blocked = len(state.lines) == cap(state.lines)
+				})
+				break
+			}
+
+			var permanent permanentError
+			rejected := errors.As(err, &permanent)
+			state.update(func(status *ForwarderStatus) {
+				status.LastError = err.Error()
+				status.LastErrorAt = time.Now().UTC()
+				if rejected || ctx.Err() != nil {
+					status.Dropped += int64(len(batch))
+				} else {
+					status.Retries++
+				}
+			})
+			if rejected || ctx.Err() != nil {
+				dm.logger.Error("dropping log batch", "forwarder", f.ID, "lines", len(batch), "error", err)
+				break
+			}
+			dm.logger.Warn("log delivery failed, retrying", "forwarder", f.ID, "lines", len(batch), "retry_in", backoff, "error", err)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+			}
+			backoff = min(backoff*2, forwardMaxBackoff)
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case line := <-state.lines:
+			batch = append(batch, line)
+			if len(batch) >= forwardBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// runLogForwarder keeps a follower running for every container the forwarder
+// covers. Containers are re-listed periodically, so ones that start later are
+// picked up and ones that restart resume where they left off.
+func (dm *DockerManager) runLogForwarder(ctx context.Context, f *LogForwarder, state *forwarderState) {
+	go dm.ship(ctx, f, state)
+
+	type ended struct {
+		name string
+		last time.Time
+	}
+	done := make(chan ended)
+	following := map[string]bool{}
+	resume := map[string]time.Time{}
+
+	ticker := time.NewTicker(forwardRefreshInterval)
+	defer ticker.Stop()
+	for {
+		targets, err := dm.forwardTargets(f)
+		if err != nil {
+			dm.logger.Error("failed to list containers to forward", "forwarder", f.ID, "error", err)
+		}
+		for _, container := range targets {
+			if following[container.Name] {
+				continue
+			}
+			following[container.Name] = true
+			go func(container Container, since time.Time) {
+				last := dm.followForwarded(ctx, state, container, since)
+				select {
+				case done <- ended{container.Name, last}:
+				case <-ctx.Done():
+				}
+			}(container, resume[container.Name])
+		}
+		state.update(func(status *ForwarderStatus) { status.Following = len(following) })
+
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				state.update(func(status *ForwarderStatus) {
+					status.Running = false
+					status.Following = 0
+				})
+				dm.logger.Info("log forwarder stopped", "forwarder", f.ID)
+				return
+			case e := <-done:
+				delete(following, e.name)
+				resume[e.name] = e.last
+				state.update(func(status *ForwarderStatus) { status.Following = len(following) })
+			case <-ticker.C:
+				break wait
+			}
+		}
+	}
+}
+
+func startLogForwarder(dm *DockerManager, f *LogForwarder) {
+	state := &forwarderState{
+		status: ForwarderStatus{Running: true},
+		lines:  make(chan forwardedLog, forwardBufferSize),
+	}
+	forwarderStatesMu.Lock()
+	forwarderStates[f.ID] = state
+	forwarderStatesMu.Unlock()
+
+	startBackgroundTask("logforward:"+f.ID, func(ctx context.Context) {
+		dm.logger.Info("log forwarder started", "forwarder", f.ID, "type", f.Type, "container", f.Container)
+		dm.runLogForwarder(ctx, f, state)
+	})
+}
+
+func stopLogForwarder(id string) {
+	stopBackgroundTask("logforward:" + id)
+	forwarderStatesMu.Lock()
+	delete(forwarderStates, id)
+	forwarderStatesMu.Unlock()
+}
+
+func logForwarders(server string) ([]LogForwarder, error) {
+	docs, err := store.List(logForwardersCollection)
+	if err != nil {
+		return nil, err
+	}
+	forwarders := []LogForwarder{}
+	for _, key := range sortedMetricKeys(docs) {
+		var f LogForwarder
+		if json.Unmarshal(docs[key], &f) == nil && f.Server == server {
+			forwarders = append(forwarders, f)
+		}
+	}
+	return forwarders, nil
+}
+
+// startLogForwarders resumes the server's configured forwarders.
+func startLogForwarders(dm *DockerManager) {
+	if store == nil {
+		return
+	}
+	forwarders, err := logForwarders(dm.config.ID())
+	if err != nil {
+		dm.logger.Error("failed to read log forwarders", "error", err)
+		return
+	}
+	for i := range forwarders {
+		startLogForwarder(dm, &forwarders[i])
+	}
+}
+
+func logForwardersHandler(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		writeError(w, codeUnavailable, "Log forwarding is not available")
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		forwarders, err := logForwarders(manager.config.ID())
+		if err != nil {
+			writeFailure(w, fmt.Errorf("Failed to read log forwarders: %w", err))
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"success":    true,
+			"forwarders": forwarders,
+			"count":      len(forwarders),
+		})
+	case "POST":
+		var f LogForwarder
+		if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+			writeError(w, codeInvalidRequest, "Invalid JSON format")
+			return
+		}
+		if err := f.Validate(); err != nil {
+			writeFailure(w, err)
+			return
+		}
+		f.ID = newRequestID()
+		f.Server = manager.config.ID()
+		f.CreatedAt = time.Now().UTC()
+
+		err := store.Put(logForwardersCollection, f.ID, f)
+		recordAudit(r, manager.config.ID(), "forwarder.create", f.ID, err)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		startLogForwarder(manager, &f)
+		writeJSON(w, map[string]interface{}{
+			"success":   true,
+			"forwarder": f,
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func logForwarderRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if store == nil {
+		writeError(w, codeUnavailable, "Log forwarding is not available")
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	var f LogForwarder
+	found, err := store.Get(logForwardersCollection, id, &f)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	if !found || f.Server != manager.config.ID() {
+		writeError(w, codeNotFound, "Log forwarder not found: "+id)
+		return
+	}
+
+	stopLogForwarder(id)
+	err = store.Delete(logForwardersCollection, id)
+	recordAudit(r, manager.config.ID(), "forwarder.remove", id, err)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"message": "Log forwarder removed",
+	})
+}
+
+func logForwarderStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		writeError(w, codeUnavailable, "Log forwarding is not available")
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	var f LogForwarder
+	found, err := store.Get(logForwardersCollection, id, &f)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	if !found || f.Server != manager.config.ID() {
+		writeError(w, codeNotFound, "Log forwarder not found: "+id)
+		return
+	}
+
+	status := ForwarderStatus{}
+	forwarderStatesMu.Lock()
+	state, ok := forwarderStates[id]
+	forwarderStatesMu.Unlock()
+	if ok {
+		status = state.snapshot()
+	}
+	writeJSON(w, map[string]interface{}{
+		"success":   true,
+		"forwarder": f,
+		"status":    status,
+	})
+}
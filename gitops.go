@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	gitStacksCollection      = "git_stacks"
+	gitDeploymentsCollection = "git_deployments"
+)
+
+var gitOpsInterval = envDuration("GITOPS_INTERVAL", 5*time.Minute)
+
+var (
+	gitRepoPattern   = regexp.MustCompile(`^(https?://|ssh://|git@)\S+$`)
+	gitBranchPattern = regexp.MustCompile(`^[A-Za-z0-9._][A-Za-z0-9._/-]*$`)
+)
+
+// gitEnv keeps git from waiting for a password or a host key confirmation
+// that nobody can answer.
+const gitEnv = "GIT_TERMINAL_PROMPT=0 GIT_SSH_COMMAND='ssh -o BatchMode=yes' "
+
+// GitStack is a compose project deployed from the compose file in Path of a
+// Git repository. The repository is cloned on the remote host.
+type GitStack struct {
+	Server     string    `json:"server"`
+	Project    string    `json:"project"`
+	Repo       string    `json:"repo"`
+	Branch     string    `json:"branch"`
+	Path       string    `json:"path"`
+	AutoDeploy bool      `json:"auto_deploy"`
+	LastCommit string    `json:"last_commit,omitempty"`
+	DeployedAt time.Time `json:"deployed_at"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type GitDeployment struct {
+	Time    time.Time `json:"time"`
+	Server  string    `json:"server"`
+	Project string    `json:"project"`
+	Commit  string    `json:"commit,omitempty"`
+	Trigger string    `json:"trigger"`
+	Result  string    `json:"result"`
+	Error   string    `json:"error,omitempty"`
+	Output  string    `json:"output,omitempty"`
+}
+
+func (s *GitStack) Validate() error {
+	if err := validComposeProject(s.Project); err != nil {
+		return err
+	}
+	if !gitRepoPattern.MatchString(s.Repo) {
+		return requestErrorf(codeInvalidRequest, "invalid repo %q: use an https://, ssh:// or git@ URL", s.Repo)
+	}
+	if s.Branch == "" {
+		s.Branch = "main"
+	}
+	if !gitBranchPattern.MatchString(s.Branch) {
+		return requestErrorf(codeInvalidRequest, "invalid branch %q", s.Branch)
+	}
+	s.Path = path.Clean("/" + s.Path)[1:]
+	if s.Path == "" {
+		s.Path = "."
+	}
+	return nil
+}
+
+func (s *GitStack) key() string {
+	return s.Server + "/" + s.Project
+}
+
+func (s *GitStack) checkoutDir() string {
+	return composeProjectDir(s.Project) + "/repo"
+}
+
+func (s *GitStack) location() composeLocation {
+	return composeLocation{Dir: path.Join(s.checkoutDir(), s.Path)}
+}
+
+// gitDeployLocks serializes deployments of the same stack, which can be
+// started by the poller and by hand at the same time.
+var gitDeployLocks sync.Map
+
+// RemoteCommit asks the remote for the branch head without fetching.
+func (dm *DockerManager) RemoteCommit(stack *GitStack) (string, error) {
+	output, err := dm.executeSSHCommand(gitEnv + "git ls-remote " + shellQuote(stack.Repo) + " " + shellQuote("refs/heads/"+stack.Branch))
+	if err != nil {
+		return "", err
+	}
+	commit, _, _ := strings.Cut(strings.TrimSpace(output), "\t")
+	if commit == "" {
+		return "", requestErrorf(codeNotFound, "branch %s not found in %s", stack.Branch, stack.Repo)
+	}
+	return commit, nil
+}
+
+// syncGitStack clones the repository or resets the existing checkout to the
+// branch head, and returns the checked out commit.
+func (dm *DockerManager) syncGitStack(stack *GitStack) (string, error) {
+	dir := shellQuote(stack.checkoutDir())
+	branch := shellQuote(stack.Branch)
+	command := fmt.Sprintf("if [ -d %s/.git ]; then %sgit -C %s fetch --quiet origin %s && git -C %s reset --hard --quiet FETCH_HEAD; else mkdir -p %s && %sgit clone --quiet --branch %s --single-branch %s %s; fi && git -C %s rev-parse HEAD",
+		dir, gitEnv, dir, branch, dir, shellQuote(composeProjectDir(stack.Project)), gitEnv, branch, shellQuote(stack.Repo), dir, dir)
+	output, err := dm.executeSSHCommand(command)
+	if err != nil {
+		return "", fmt.Errorf("git sync failed: %w", err)
+	}
+	return strings.TrimSpace(output), nil
+}
+
+// DeployGitStack updates the checkout, brings the stack up and records the
+// deployment in the history.
+func (dm *DockerManager) DeployGitStack(ctx context.Context, stack *GitStack, trigger string) GitDeployment {
+	lock, _ := gitDeployLocks.LoadOrStore(stack.key(), &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	deployment := GitDeployment{
+		Time:    time.Now().UTC(),
+		Server:  stack.Server,
+		Project: stack.Project,
+		Trigger: trigger,
+		Result:  "success",
+	}
+
+	var output []string
+	commit, err := dm.syncGitStack(stack)
+	if err == nil {
+		deployment.Commit = commit
+		err = dm.runCompose(ctx, stack.location(), stack.Project, func(line string) error {
+			output = append(output, line)
+			return nil
+		}, composeActions["up"]...)
+	}
+	deployment.Output = strings.Join(output, "\n")
+	if err != nil {
+		deployment.Result = "failure"
+		deployment.Error = err.Error()
+		dm.logger.Error("git stack deployment failed", "project", stack.Project, "trigger", trigger, "error", err)
+	} else {
+		stack.LastCommit = commit
+		stack.DeployedAt = deployment.Time
+		if err := store.Put(gitStacksCollection, stack.key(), stack); err != nil {
+			dm.logger.Error("failed to save git stack", "project", stack.Project, "error", err)
+		}
+		dm.logger.Info("git stack deployed", "project", stack.Project, "commit", commit, "trigger", trigger)
+	}
+
+	if err := store.Append(gitDeploymentsCollection, deployment); err != nil {
+		dm.logger.Error("failed to record deployment", "error", err)
+	}
+	return deployment
+}
+
+func gitStacks(server string) ([]GitStack, error) {
+	docs, err := store.List(gitStacksCollection)
+	if err != nil {
+		return nil, err
+	}
+	stacks := []GitStack{}
+	for _, key := range sortedMetricKeys(docs) {
+		var stack GitStack
+		if json.Unmarshal(docs[key], &stack) == nil && stack.Server == server {
+			stacks = append(stacks, stack)
+		}
+	}
+	return stacks, nil
+}
+
+// startGitOpsPoller redeploys auto-deploy stacks whose branch has moved,
+// checking every gitOpsInterval.
+func startGitOpsPoller(dm *DockerManager) {
+	if store == nil || gitOpsInterval <= 0 {
+		return
+	}
+
+	startBackgroundTask("gitops:"+dm.config.ID(), func(ctx context.Context) {
+		dm.logger.Info("gitops poller started", "interval", gitOpsInterval)
+		ticker := time.NewTicker(gitOpsInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				dm.logger.Info("gitops poller stopped")
+				return
+			case <-ticker.C:
+			}
+
+			stacks, err := gitStacks(dm.config.ID())
+			if err != nil {
+				dm.logger.Error("failed to read git stacks", "error", err)
+				continue
+			}
+			for i := range stacks {
+				stack := &stacks[i]
+				if !stack.AutoDeploy {
+					continue
+				}
+				commit, err := dm.RemoteCommit(stack)
+				if err != nil {
+					dm.logger.Error("git poll failed", "project", stack.Project, "error", err)
+					continue
+				}
+				if commit != stack.LastCommit {
+					dm.DeployGitStack(ctx, stack, "poll")
+				}
+			}
+		}
+	})
+}
+
+func gitOpsHandler(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		writeError(w, codeUnavailable, "GitOps is not available")
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		stacks, err := gitStacks(manager.config.ID())
+		if err != nil {
+			writeFailure(w, fmt.Errorf("Failed to read git stacks: %w", err))
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"success":  true,
+			"stacks":   stacks,
+			"count":    len(stacks),
+			"interval": gitOpsInterval.String(),
+		})
+	case "POST":
+		var stack GitStack
+		if err := json.NewDecoder(r.Body).Decode(&stack); err != nil {
+			writeError(w, codeInvalidRequest, "Invalid JSON format")
+			return
+		}
+		if err := stack.Validate(); err != nil {
+			writeFailure(w, err)
+			return
+		}
+		stack.Server = manager.config.ID()
+		stack.LastCommit = ""
+		stack.DeployedAt = time.Time{}
+		stack.CreatedAt = time.Now().UTC()
+
+		err := store.Put(gitStacksCollection, stack.key(), stack)
+		recordAudit(r, manager.config.ID(), "gitops.register", stack.Project, err)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"success": true,
+			"stack":   stack,
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func loadGitStack(w http.ResponseWriter, r *http.Request, manager *DockerManager) (*GitStack, bool) {
+	project := mux.Vars(r)["project"]
+	var stack GitStack
+	found, err := store.Get(gitStacksCollection, manager.config.ID()+"/"+project, &stack)
+	if err != nil {
+		writeFailure(w, err)
+		return nil, false
+	}
+	if !found {
+		writeError(w, codeNotFound, "Git stack not found: "+project)
+		return nil, false
+	}
+	return &stack, true
+}
+
+func gitOpsDeployHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if store == nil {
+		writeError(w, codeUnavailable, "GitOps is not available")
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+	stack, ok := loadGitStack(w, r, manager)
+	if !ok {
+		return
+	}
+
+	deployment := manager.DeployGitStack(r.Context(), stack, "manual")
+	var err error
+	if deployment.Error != "" {
+		err = fmt.Errorf("%s", deployment.Error)
+	}
+	recordAudit(r, manager.config.ID(), "gitops.deploy", stack.Project, err)
+	response := map[string]interface{}{
+		"success":    err == nil,
+		"deployment": deployment,
+	}
+	if err != nil {
+		response["error"] = deployment.Error
+	}
+	writeJSON(w, response)
+}
+
+func gitOpsHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		writeError(w, codeUnavailable, "GitOps is not available")
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+	project := mux.Vars(r)["project"]
+
+	history := []GitDeployment{}
+	err := store.Scan(gitDeploymentsCollection, func(data []byte) error {
+		var deployment GitDeployment
+		if json.Unmarshal(data, &deployment) == nil && deployment.Server == manager.config.ID() && deployment.Project == project {
+			history = append(history, deployment)
+		}
+		return nil
+	})
+	if err != nil {
+		writeFailure(w, fmt.Errorf("Failed to read deployment history: %w", err))
+		return
+	}
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+	if len(history) > 100 {
+		history = history[:100]
+	}
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"project": project,
+		"history": history,
+	})
+}
+
+func gitOpsRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if store == nil {
+		writeError(w, codeUnavailable, "GitOps is not available")
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+	stack, ok := loadGitStack(w, r, manager)
+	if !ok {
+		return
+	}
+
+	// The running stack and its checkout are left alone; only the
+	// registration goes.
+	err := store.Delete(gitStacksCollection, stack.key())
+	recordAudit(r, manager.config.ID(), "gitops.remove", stack.Project, err)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"message": "Git stack " + stack.Project + " unregistered",
+	})
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type HealthCheckResult struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	ExitCode int       `json:"exit_code"`
+	Output   string    `json:"output"`
+}
+
+type ContainerHealth struct {
+	Status        string              `json:"status"`
+	FailingStreak int                 `json:"failing_streak"`
+	Log           []HealthCheckResult `json:"log"`
+}
+
+// parseHealth extracts the health state docker appends to the ps status,
+// e.g. "Up 3 minutes (healthy)" or "Up 5 seconds (health: starting)". It is
+// empty for containers without a healthcheck.
+func parseHealth(status string) string {
+	switch {
+	case strings.Contains(status, "(healthy)"):
+		return "healthy"
+	case strings.Contains(status, "(unhealthy)"):
+		return "unhealthy"
+	case strings.Contains(status, "(health: starting)"):
+		return "starting"
+	}
+	return ""
+}
+
+func (dm *DockerManager) GetContainerHealth(containerID string) (*ContainerHealth, error) {
+	output, err := dm.executeSSHCommand("docker inspect --format '{{json .State.Health}}' " + shellQuote(containerID))
+	if err != nil {
+		return nil, err
+	}
+	output = strings.TrimSpace(output)
+	if output == "" || output == "null" {
+		return nil, fmt.Errorf("container %s has no healthcheck", containerID)
+	}
+
+	var raw struct {
+		Status        string
+		FailingStreak int
+		Log           []struct {
+			Start    time.Time
+			End      time.Time
+			ExitCode int
+			Output   string
+		}
+	}
+	if err := json.Unmarshal([]byte(output), &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse health output: %v", err)
+	}
+
+	health := &ContainerHealth{
+		Status:        raw.Status,
+		FailingStreak: raw.FailingStreak,
+		Log:           []HealthCheckResult{},
+	}
+	for _, entry := range raw.Log {
+		health.Log = append(health.Log, HealthCheckResult{
+			Start:    entry.Start,
+			End:      entry.End,
+			ExitCode: entry.ExitCode,
+			Output:   strings.TrimSpace(entry.Output),
+		})
+	}
+	return health, nil
+}
+
+func containerHealthHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	health, err := manager.GetContainerHealth(mux.Vars(r)["id"])
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"health":  health,
+	})
+}
+
+type ContainerState struct {
+	Status    string    `json:"status"`
+	Health    string    `json:"health,omitempty"`
+	ExitCode  int       `json:"exit_code"`
+	StartedAt time.Time `json:"started_at"`
+	Restarts  int       `json:"restarts"`
+}
+
+// stableAfter is how long a container without a healthcheck has to stay
+// running, without restarting, to count as up.
+const stableAfter = 5 * time.Second
+
+func (dm *DockerManager) GetContainerState(containerID string) (*ContainerState, error) {
+	output, err := dm.executeSSHCommand("docker inspect --format '{{.State.Status}}|{{if .State.Health}}{{.State.Health.Status}}{{end}}|{{.State.ExitCode}}|{{.State.StartedAt}}|{{.RestartCount}}' " + shellQuote(containerID))
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(strings.TrimSpace(output), "|")
+	if len(parts) < 5 {
+		return nil, fmt.Errorf("unexpected inspect output: %s", output)
+	}
+	state := &ContainerState{Status: parts[0], Health: parts[1]}
+	fmt.Sscan(parts[2], &state.ExitCode)
+	fmt.Sscan(parts[4], &state.Restarts)
+	state.StartedAt, _ = time.Parse(time.RFC3339Nano, parts[3])
+	return state, nil
+}
+
+// WaitForHealthy polls the container until it reports healthy or, without a
+// healthcheck, has been running stably for stableAfter. The last observed
+// state is returned together with an error if it never got there.
+func (dm *DockerManager) WaitForHealthy(ctx context.Context, containerID string, timeout time.Duration) (*ContainerState, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var state *ContainerState
+	var runningSince time.Time
+	for {
+		current, err := dm.GetContainerState(containerID)
+		if err != nil {
+			return state, err
+		}
+		if state == nil || current.StartedAt != state.StartedAt || current.Status != "running" {
+			runningSince = time.Now()
+		}
+		state = current
+
+		switch {
+		case state.Health == "healthy":
+			return state, nil
+		case state.Health == "unhealthy":
+			return state, fmt.Errorf("container is unhealthy")
+		case state.Status == "exited" || state.Status == "dead":
+			return state, fmt.Errorf("container exited with code %d", state.ExitCode)
+		case state.Health == "" && state.Status == "running" && time.Since(runningSince) >= stableAfter:
+			return state, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return state, &CommandError{Code: codeTimeout, Message: fmt.Sprintf("timed out after %s waiting for container (status %s)", timeout, state.Status)}
+		case <-time.After(time.Second):
+		}
+	}
+}
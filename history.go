@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// metricsCollection is the log of metrics samples. It lives in the Store
+// with every other history rather than in SQLite: samples are only appended,
+// read by time range and pruned by age, which keeps one storage format in
+// DATA_DIR and the build free of a database driver.
+const metricsCollection = "metrics"
+
+type MetricSample struct {
+	Server      string    `json:"server"`
+	ContainerID string    `json:"container_id"`
+	Name        string    `json:"name"`
+	Time        time.Time `json:"time"`
+	CPUPercent  float64   `json:"cpu_percent"`
+	MemoryUsage int64     `json:"memory_usage"`
+	MemoryLimit int64     `json:"memory_limit"`
+}
+
+type MetricPoint struct {
+	Time           time.Time `json:"time"`
+	CPUPercent     float64   `json:"cpu_percent"`
+	CPUPercentMax  float64   `json:"cpu_percent_max"`
+	MemoryUsage    int64     `json:"memory_usage"`
+	MemoryUsageMax int64     `json:"memory_usage_max"`
+	MemoryLimit    int64     `json:"memory_limit"`
+	Samples        int       `json:"samples"`
+}
+
+var (
+	metricsInterval  = envDuration("METRICS_INTERVAL", time.Minute)
+	metricsRetention = envDuration("METRICS_RETENTION", 7*24*time.Hour)
+)
+
+// startMetricsSampler records CPU and memory of every running container on
+// the server each metricsInterval, tracks their uptime and evaluates the
+// alert rules.
+func startMetricsSampler(dm *DockerManager) {
+	if store == nil || metricsInterval <= 0 {
+		return
+	}
+
+	server := dm.config.ID()
+	startBackgroundTask("metrics:"+server, func(ctx context.Context) {
+		dm.logger.Info("metrics sampler started", "interval", metricsInterval)
+		ticker := time.NewTicker(metricsInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				dm.logger.Info("metrics sampler stopped")
+				return
+			case <-ticker.C:
+			}
+
+			trackUptime(dm)
+			all, err := dm.GetAllContainerStats()
+			if err != nil {
+				dm.logger.Error("metrics sampling failed", "error", err)
+				continue
+			}
+			now := time.Now().UTC()
+			for _, stats := range all {
+				sample := MetricSample{
+					Server:      server,
+					ContainerID: stats.ID,
+					Name:        stats.Name,
+					Time:        now,
+					CPUPercent:  stats.CPUPercent,
+					MemoryUsage: stats.MemoryUsage,
+					MemoryLimit: stats.MemoryLimit,
+				}
+				if err := store.Append(metricsCollection, sample); err != nil {
+					dm.logger.Error("failed to store metrics sample", "error", err)
+				}
+			}
+			evaluateAlertRules(dm, all)
+		}
+	})
+}
+
+func startMetricsHistoryPruner() {
+	go func() {
+		for {
+			cutoff := time.Now().Add(-metricsRetention)
+			err := store.Compact(metricsCollection, func(data []byte) bool {
+				var sample MetricSample
+				return json.Unmarshal(data, &sample) == nil && sample.Time.After(cutoff)
+			})
+			if err != nil {
+				slog.Error("failed to prune metrics history", "error", err)
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+// downsample averages samples into buckets of width step. Maxima are kept
+// alongside the averages so short spikes survive coarse steps.
+func downsample(samples []MetricSample, from time.Time, step time.Duration) []MetricPoint {
+	points := []MetricPoint{}
+	index := map[int64]int{}
+	for _, sample := range samples {
+		bucket := int64(sample.Time.Sub(from) / step)
+		i, ok := index[bucket]
+		if !ok {
+			i = len(points)
+			index[bucket] = i
+			points = append(points, MetricPoint{Time: from.Add(time.Duration(bucket) * step)})
+		}
+
+		point := &points[i]
+		point.CPUPercent += sample.CPUPercent
+		point.MemoryUsage += sample.MemoryUsage
+		if sample.CPUPercent > point.CPUPercentMax {
+			point.CPUPercentMax = sample.CPUPercent
+		}
+		if sample.MemoryUsage > point.MemoryUsageMax {
+			point.MemoryUsageMax = sample.MemoryUsage
+		}
+		point.MemoryLimit = sample.MemoryLimit
+		point.Samples++
+	}
+
+	for i := range points {
+		points[i].CPUPercent /= float64(points[i].Samples)
+		points[i].MemoryUsage /= int64(points[i].Samples)
+	}
+	return points
+}
+
+func containerMetricsHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		writeError(w, codeUnavailable, "Metrics history is not available")
+		return
+	}
+
+	server, ok := historyServer(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	container := mux.Vars(r)["id"]
+
+	from, err := parseSince(query.Get("from"))
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	if from.IsZero() {
+		from = time.Now().Add(-time.Hour)
+	}
+	to := time.Now()
+	if value := query.Get("to"); value != "" {
+		if to, err = parseSince(value); err != nil {
+			writeFailure(w, err)
+			return
+		}
+	}
+	if !to.After(from) {
+		writeError(w, codeInvalidRequest, "'to' must be after 'from'")
+		return
+	}
+
+	step := metricsInterval
+	if value := query.Get("step"); value != "" {
+		if step, err = time.ParseDuration(value); err != nil || step <= 0 {
+			writeError(w, codeInvalidRequest, fmt.Sprintf("Invalid step %q", value))
+			return
+		}
+	} else if span := to.Sub(from); span/step > 500 {
+		// Keep default responses chart-sized.
+		step = (span / 500).Round(time.Second)
+	}
+
+	samples := []MetricSample{}
+	err = store.Scan(metricsCollection, func(data []byte) error {
+		var sample MetricSample
+		if err := json.Unmarshal(data, &sample); err != nil {
+			return nil
+		}
+		if sample.Server != server {
+			return nil
+		}
+		if sample.Name != container && !strings.HasPrefix(sample.ContainerID, container) {
+			return nil
+		}
+		if sample.Time.Before(from) || sample.Time.After(to) {
+			return nil
+		}
+		samples = append(samples, sample)
+		return nil
+	})
+	if err != nil {
+		writeFailure(w, fmt.Errorf("Failed to read metrics history: %w", err))
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"from":    from.UTC(),
+		"to":      to.UTC(),
+		"step":    step.String(),
+		"points":  downsample(samples, from, step),
+	})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type MemoryUsage struct {
+	TotalMB     int64 `json:"total_mb"`
+	UsedMB      int64 `json:"used_mb"`
+	FreeMB      int64 `json:"free_mb"`
+	AvailableMB int64 `json:"available_mb"`
+	SwapTotalMB int64 `json:"swap_total_mb"`
+	SwapUsedMB  int64 `json:"swap_used_mb"`
+}
+
+type DiskUsageEntry struct {
+	Filesystem  string  `json:"filesystem"`
+	Mountpoint  string  `json:"mountpoint"`
+	Size        int64   `json:"size"`
+	Used        int64   `json:"used"`
+	Available   int64   `json:"available"`
+	UsedPercent float64 `json:"used_percent"`
+}
+
+type HostMetrics struct {
+	UptimeSeconds float64          `json:"uptime_seconds"`
+	Load1         float64          `json:"load1"`
+	Load5         float64          `json:"load5"`
+	Load15        float64          `json:"load15"`
+	CPUPercent    float64          `json:"cpu_percent"`
+	Memory        MemoryUsage      `json:"memory"`
+	Disks         []DiskUsageEntry `json:"disks"`
+}
+
+// hostMetricsCommand gathers everything in one round trip. /proc/stat is
+// sampled twice so CPU usage reflects the last second, not time since boot.
+const hostMetricsCommand = `cat /proc/uptime; echo ---; cat /proc/loadavg; echo ---; free -m; echo ---; df -P -k; echo ---; head -1 /proc/stat; sleep 1; head -1 /proc/stat`
+
+var pseudoFilesystems = map[string]bool{
+	"tmpfs": true, "devtmpfs": true, "overlay": true, "shm": true, "udev": true, "none": true,
+}
+
+func (dm *DockerManager) GetHostMetrics() (*HostMetrics, error) {
+	output, err := dm.executeSSHCommand(hostMetricsCommand)
+	if err != nil {
+		return nil, fmt.Errorf("Host metrics command failed: %w", err)
+	}
+
+	sections := strings.Split(output, "---\n")
+	if len(sections) < 5 {
+		return nil, fmt.Errorf("unexpected host metrics output")
+	}
+
+	metrics := &HostMetrics{Disks: []DiskUsageEntry{}}
+
+	if fields := strings.Fields(sections[0]); len(fields) > 0 {
+		metrics.UptimeSeconds, _ = strconv.ParseFloat(fields[0], 64)
+	}
+
+	if fields := strings.Fields(sections[1]); len(fields) >= 3 {
+		metrics.Load1, _ = strconv.ParseFloat(fields[0], 64)
+		metrics.Load5, _ = strconv.ParseFloat(fields[1], 64)
+		metrics.Load15, _ = strconv.ParseFloat(fields[2], 64)
+	}
+
+	for _, line := range strings.Split(sections[2], "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		values := make([]int64, len(fields)-1)
+		for i, field := range fields[1:] {
+			values[i], _ = strconv.ParseInt(field, 10, 64)
+		}
+		switch fields[0] {
+		case "Mem:":
+			metrics.Memory.TotalMB = values[0]
+			metrics.Memory.UsedMB = values[1]
+			metrics.Memory.FreeMB = values[2]
+			if len(values) >= 6 {
+				metrics.Memory.AvailableMB = values[5]
+			}
+		case "Swap:":
+			metrics.Memory.SwapTotalMB = values[0]
+			metrics.Memory.SwapUsedMB = values[1]
+		}
+	}
+
+	for _, line := range strings.Split(sections[3], "\n")[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 6 || pseudoFilesystems[fields[0]] {
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[1], 10, 64)
+		used, _ := strconv.ParseInt(fields[2], 10, 64)
+		available, _ := strconv.ParseInt(fields[3], 10, 64)
+		percent, _ := strconv.ParseFloat(strings.TrimSuffix(fields[4], "%"), 64)
+		metrics.Disks = append(metrics.Disks, DiskUsageEntry{
+			Filesystem:  fields[0],
+			Mountpoint:  fields[5],
+			Size:        size * 1024,
+			Used:        used * 1024,
+			Available:   available * 1024,
+			UsedPercent: percent,
+		})
+	}
+
+	cpuLines := strings.Split(strings.TrimSpace(sections[4]), "\n")
+	if len(cpuLines) == 2 {
+		idle1, total1 := parseCPUStat(cpuLines[0])
+		idle2, total2 := parseCPUStat(cpuLines[1])
+		if total2 > total1 {
+			metrics.CPUPercent = 100 * (1 - float64(idle2-idle1)/float64(total2-total1))
+		}
+	}
+
+	return metrics, nil
+}
+
+// parseCPUStat returns idle (idle + iowait) and total jiffies of a
+// "cpu ..." line from /proc/stat.
+func parseCPUStat(line string) (idle, total int64) {
+	fields := strings.Fields(line)
+	for i, field := range fields[1:] {
+		value, _ := strconv.ParseInt(field, 10, 64)
+		total += value
+		if i == 3 || i == 4 {
+			idle += value
+		}
+	}
+	return idle, total
+}
+
+func hostMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := serverManager(w, r)
+	if !ok {
+		return
+	}
+
+	metrics, err := manager.GetHostMetrics()
+	if err != nil {
+		manager.logger.Error("failed to get host metrics", "error", err)
+		writeFailure(w, err)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"metrics": metrics,
+	})
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// livePollInterval re-lists the containers of a watched server even without
+// docker events, in case the event stream missed something or is
+// reconnecting.
+var livePollInterval = envDuration("LIVE_POLL_INTERVAL", 30*time.Second)
+
+const (
+	// liveHistorySize is how many past changes are kept for subscribers
+	// resuming with the ID of the last one they saw.
+	liveHistorySize = 256
+	// liveIdleTimeout keeps a server watched for a while after its last
+	// subscriber leaves, so a reconnecting client can resume.
+	liveIdleTimeout = time.Minute
+)
+
+// ContainerChange is one message of the live container feed: an updated
+// container, or the ID of one that is gone.
+type ContainerChange struct {
+	Seq       uint64     `json:"seq"`
+	Type      string     `json:"type"`
+	Server    string     `json:"server"`
+	Time      time.Time  `json:"time"`
+	Container *Container `json:"container,omitempty"`
+	ID        string     `json:"id,omitempty"`
+}
+
+// containerHub keeps the last known container list of a server and pushes
+// every change to its subscribers. It only watches the server while someone
+// is subscribed.
+type containerHub struct {
+	mu          sync.Mutex
+	server      string
+	ready       bool
+	containers  []Container
+	seq         uint64
+	subscribers map[chan ContainerChange]struct{}
+
+	// Changes are only kept while the server is watched; epoch changes
+	// each time watching starts, so older event IDs can't be resumed.
+	watching bool
+	epoch    string
+	history  []ContainerChange
+	idle     *time.Timer
+}
+
+// hubSubscription is where a subscriber starts: a snapshot of the
+// containers, or when resuming the changes it missed, followed by Changes.
+// Changes is closed if the subscriber falls too far behind.
+type hubSubscription struct {
+	Changes  chan ContainerChange
+	Snapshot []Container
+	Replay   []ContainerChange
+	Epoch    string
+	Seq      uint64
+}
+
+var (
+	hubsMu sync.Mutex
+	hubs   = map[string]*containerHub{}
+)
+
+func containerHubFor(server string) *containerHub {
+	hubsMu.Lock()
+	defer hubsMu.Unlock()
+	hub, ok := hubs[server]
+	if !ok {
+		hub = &containerHub{
+			server:      server,
+			subscribers: map[chan ContainerChange]struct{}{},
+		}
+		hubs[server] = hub
+	}
+	return hub
+}
+
+func (h *containerHub) taskKey() string {
+	return "live:" + h.server
+}
+
+// subscribe starts a subscription from lastEventID, as made by
+// liveEventID, or from a snapshot if it is empty or too old to resume.
+func (h *containerHub) subscribe(dm *DockerManager, lastEventID string) (*hubSubscription, error) {
+	h.mu.Lock()
+	if h.idle != nil {
+		h.idle.Stop()
+		h.idle = nil
+	}
+	// Nothing kept the list current while the server was not watched.
+	stale := !h.watching
+	h.mu.Unlock()
+	if stale {
+		if err := h.refresh(dm); err != nil {
+			return nil, err
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.watching {
+		h.watching = true
+		h.epoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+		h.history = nil
+		// The request's manager logs with its request ID; the feed
+		// outlives it.
+		if registered, ok := registry.Get(h.server); ok {
+			dm = registered
+		}
+		startBackgroundTask(h.taskKey(), func(ctx context.Context) { h.run(ctx, dm) })
+	}
+
+	sub := &hubSubscription{
+		Changes: make(chan ContainerChange, 64),
+		Epoch:   h.epoch,
+		Seq:     h.seq,
+	}
+	h.subscribers[sub.Changes] = struct{}{}
+	if replay, ok := h.changesSince(lastEventID); ok {
+		sub.Replay = replay
+	} else {
+		sub.Snapshot = h.snapshot()
+	}
+	return sub, nil
+}
+
+// changesSince returns the kept changes after lastEventID, and false if
+// some of them are no longer kept.
+func (h *containerHub) changesSince(lastEventID string) ([]ContainerChange, bool) {
+	epoch, value, ok := strings.Cut(lastEventID, "-")
+	if !ok || epoch != h.epoch {
+		return nil, false
+	}
+	seq, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || seq > h.seq {
+		return nil, false
+	}
+	oldest := h.seq + 1
+	if len(h.history) > 0 {
+		oldest = h.history[0].Seq
+	}
+	if seq+1 < oldest {
+		return nil, false
+	}
+	replay := []ContainerChange{}
+	for _, change := range h.history {
+		if change.Seq > seq {
+			replay = append(replay, change)
+		}
+	}
+	return replay, true
+}
+
+func (h *containerHub) unsubscribe(changes chan ContainerChange) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[changes]; ok {
+		delete(h.subscribers, changes)
+		close(changes)
+	}
+	if len(h.subscribers) == 0 && h.watching && h.idle == nil {
+		h.idle = time.AfterFunc(liveIdleTimeout, h.stopIfIdle)
+	}
+}
+
+func (h *containerHub) stopIfIdle() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.subscribers) == 0 {
+		stopBackgroundTask(h.taskKey())
+		h.watching = false
+		h.idle = nil
+	}
+}
+
+// liveEventID identifies a change for resuming after it.
+func liveEventID(epoch string, seq uint64) string {
+	return epoch + "-" + strconv.FormatUint(seq, 10)
+}
+
+func (h *containerHub) snapshot() []Container {
+	return append([]Container{}, h.containers...)
+}
+
+func (h *containerHub) publish(change ContainerChange) {
+	h.seq++
+	change.Seq = h.seq
+	change.Server = h.server
+	change.Time = time.Now().UTC()
+	h.history = append(h.history, change)
+	if len(h.history) > liveHistorySize {
+		h.history = h.history[len(h.history)-liveHistorySize:]
+	}
+	for subscriber := range h.subscribers {
+		select {
+		case subscriber <- change:
+		default:
+			// A slow client reconnects and starts over from a snapshot
+			// rather than holding up everybody else.
+			delete(h.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
+
+// containerChanged ignores the status text or age alone changing, which for
+// a running container ("Up 5 minutes") they do on every listing.
+func containerChanged(old, current Container) bool {
+	old.Status, current.Status = "", ""
+	old.Age, current.Age = "", ""
+	return !reflect.DeepEqual(old, current)
+}
+
+// refresh lists the containers and publishes how they differ from the last
+// listing.
+func (h *containerHub) refresh(dm *DockerManager) error {
+	containers, err := dm.GetContainers(false)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.ready {
+		previous := make(map[string]Container, len(h.containers))
+		for _, c := range h.containers {
+			previous[c.ID] = c
+		}
+		for _, c := range containers {
+			old, ok := previous[c.ID]
+			delete(previous, c.ID)
+			if !ok || containerChanged(old, c) {
+				c := c
+				h.publish(ContainerChange{Type: "update", Container: &c})
+			}
+		}
+		for id := range previous {
+			h.publish(ContainerChange{Type: "remove", ID: id})
+		}
+	}
+	h.containers = containers
+	h.ready = true
+	return nil
+}
+
+// run re-lists the containers shortly after docker reports a container
+// event, and every livePollInterval regardless.
+func (h *containerHub) run(ctx context.Context, dm *DockerManager) {
+	dm.logger.Info("live container feed started")
+	changed := make(chan struct{}, 1)
+	go func() {
+		for {
+			err := dm.WatchEvents(ctx, time.Time{}, []string{"type=container"}, func(*DockerEvent) error {
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+				return nil
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			dm.logger.Error("live container feed lost docker events", "error", err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(10 * time.Second):
+			}
+		}
+	}()
+
+	var tick <-chan time.Time
+	if livePollInterval > 0 {
+		ticker := time.NewTicker(livePollInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			dm.logger.Info("live container feed stopped")
+			return
+		case <-changed:
+			// Events arrive in bursts (e.g. kill, die, stop); list once.
+			select {
+			case <-ctx.Done():
+				continue
+			case <-time.After(500 * time.Millisecond):
+			}
+			select {
+			case <-changed:
+			default:
+			}
+		case <-tick:
+		}
+		if err := h.refresh(dm); err != nil {
+			dm.logger.Error("live container refresh failed", "error", err)
+		}
+	}
+}
+
+// restartContainerHub points a watched server's feed at a new
+// configuration.
+func restartContainerHub(dm *DockerManager) {
+	h := containerHubFor(dm.config.ID())
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.watching {
+		startBackgroundTask(h.taskKey(), func(ctx context.Context) { h.run(ctx, dm) })
+	}
+}
+
+// matchesLabels reports whether c has every label filter, each a key or
+// key=value as for docker ps --filter label=.
+func matchesLabels(c Container, filters []string) bool {
+	for _, filter := range filters {
+		key, value, hasValue := strings.Cut(filter, "=")
+		actual, ok := c.Labels[key]
+		if !ok || (hasValue && actual != value) {
+			return false
+		}
+	}
+	return true
+}
+
+func withLabels(containers []Container, filters []string) []Container {
+	matching := []Container{}
+	for _, c := range containers {
+		if matchesLabels(c, filters) {
+			matching = append(matching, c)
+		}
+	}
+	return matching
+}
+
+func containersLiveHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		manager.logger.Error("WebSocket upgrade failed", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	hub := containerHubFor(manager.config.ID())
+	sub, err := hub.subscribe(manager, "")
+	if err != nil {
+		manager.logger.Error("live container feed failed", "error", err)
+		conn.WriteJSON(map[string]string{"error": err.Error()})
+		return
+	}
+	defer hub.unsubscribe(sub.Changes)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	// As with stats, reading only detects when the client goes away.
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	labels := r.URL.Query()["label"]
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	err = conn.WriteJSON(map[string]interface{}{
+		"type":       "snapshot",
+		"seq":        sub.Seq,
+		"server":     hub.server,
+		"containers": withLabels(sub.Snapshot, labels),
+	})
+	if err != nil {
+		return
+	}
+
+	manager.logger.Info("streaming live container state")
+	for {
+		select {
+		case <-ctx.Done():
+			manager.logger.Info("live container stream closed")
+			return
+		case change, ok := <-sub.Changes:
+			if !ok {
+				manager.logger.Info("live container stream fell behind")
+				return
+			}
+			if change.Container != nil && !matchesLabels(*change.Container, labels) {
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteJSON(change); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// serverContainersStreamHandler is the live container feed as Server-Sent
+// Events, for clients that can't use WebSockets. Every event has an ID; a
+// client reconnecting with Last-Event-ID gets the changes it missed, or a
+// new snapshot when they are no longer kept.
+func serverContainersStreamHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := serverManager(w, r)
+	if !ok {
+		return
+	}
+
+	hub := containerHubFor(manager.config.ID())
+	sub, err := hub.subscribe(manager, r.Header.Get("Last-Event-ID"))
+	if err != nil {
+		manager.logger.Error("live container feed failed", "error", err)
+		writeFailure(w, err)
+		return
+	}
+	defer hub.unsubscribe(sub.Changes)
+	if !startSSE(w) {
+		return
+	}
+
+	labels := r.URL.Query()["label"]
+	send := func(change ContainerChange) error {
+		if change.Container != nil && !matchesLabels(*change.Container, labels) {
+			return nil
+		}
+		return writeSSE(w, change.Type, liveEventID(sub.Epoch, change.Seq), change)
+	}
+
+	if sub.Snapshot != nil {
+		err = writeSSE(w, "snapshot", liveEventID(sub.Epoch, sub.Seq), map[string]interface{}{
+			"seq":        sub.Seq,
+			"server":     hub.server,
+			"containers": withLabels(sub.Snapshot, labels),
+		})
+		if err != nil {
+			return
+		}
+	}
+	for _, change := range sub.Replay {
+		if err := send(change); err != nil {
+			return
+		}
+	}
+
+	manager.logger.Info("streaming live container state", "resumed", sub.Snapshot == nil)
+	for {
+		select {
+		case <-r.Context().Done():
+			manager.logger.Info("live container stream closed")
+			return
+		case change, ok := <-sub.Changes:
+			if !ok {
+				manager.logger.Info("live container stream fell behind")
+				return
+			}
+			if err := send(change); err != nil {
+				return
+			}
+		}
+	}
+}
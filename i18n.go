@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Language is one the web interface and the API messages can be read in.
+type Language struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+// languages are the supported ones; English is the source text, every other
+// language has a pack at web/locales/<code>.json mapping it to translations.
+var languages = []Language{
+	{Code: "en", Name: "English"},
+	{Code: "az", Name: "Azərbaycan dili"},
+}
+
+const (
+	languageKey    contextKey = "language"
+	languageCookie            = "rdm_lang"
+)
+
+var (
+	defaultLanguage = envLanguage("DEFAULT_LANGUAGE", "en")
+	languagePacks   = loadLanguagePacks()
+)
+
+func supportedLanguage(code string) bool {
+	for _, language := range languages {
+		if language.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+func envLanguage(name, fallback string) string {
+	code := strings.ToLower(setting(name))
+	if code == "" {
+		return fallback
+	}
+	if !supportedLanguage(code) {
+		slog.Error("unsupported language setting", "name", name, "value", code, "fallback", fallback)
+		return fallback
+	}
+	return code
+}
+
+func loadLanguagePacks() map[string]map[string]string {
+	packs := map[string]map[string]string{}
+	for _, language := range languages[1:] {
+		data, err := webFS.ReadFile("web/locales/" + language.Code + ".json")
+		if err != nil {
+			panic(err)
+		}
+		pack := map[string]string{}
+		if err := json.Unmarshal(data, &pack); err != nil {
+			panic("web/locales/" + language.Code + ".json: " + err.Error())
+		}
+		packs[language.Code] = pack
+	}
+	return packs
+}
+
+// translate returns message in language. Messages with a detail appended,
+// e.g. "Failed to save protection: disk full", have the part before the
+// first ": " translated and keep the detail as it is. Anything without a
+// translation stays English.
+func translate(language, message string) string {
+	pack := languagePacks[language]
+	if pack == nil || message == "" {
+		return message
+	}
+	if translated, ok := pack[message]; ok {
+		return translated
+	}
+	if i := strings.Index(message, ": "); i > 0 {
+		if translated, ok := pack[message[:i]]; ok {
+			return translated + message[i:]
+		}
+	}
+	return message
+}
+
+// translator binds the "t" template function to a language.
+func translator(language string) template.FuncMap {
+	return template.FuncMap{
+		"t": func(message string) string { return translate(language, message) },
+	}
+}
+
+// acceptLanguage picks the supported language the Accept-Language header
+// prefers most, or "" when it names none of them.
+func acceptLanguage(header string) string {
+	type choice struct {
+		code    string
+		quality float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		code, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if q, err := strconv.ParseFloat(value, 64); err == nil {
+				quality = q
+			}
+		}
+		if supportedLanguage(code) && quality > 0 {
+			choices = append(choices, choice{code, quality})
+		}
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].quality > choices[j].quality })
+	if len(choices) == 0 {
+		return ""
+	}
+	return choices[0].code
+}
+
+// requestLanguage is the language chosen in the web interface, which is
+// kept in a cookie and restored from the user's preference at login, else
+// the one the client asks for, else defaultLanguage.
+func requestLanguage(r *http.Request) string {
+	if cookie, err := r.Cookie(languageCookie); err == nil && supportedLanguage(cookie.Value) {
+		return cookie.Value
+	}
+	if code := acceptLanguage(r.Header.Get("Accept-Language")); code != "" {
+		return code
+	}
+	return defaultLanguage
+}
+
+func languageFrom(ctx context.Context) string {
+	if language, ok := ctx.Value(languageKey).(string); ok {
+		return language
+	}
+	return defaultLanguage
+}
+
+func setLanguageCookie(w http.ResponseWriter, r *http.Request, language string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     languageCookie,
+		Value:    language,
+		Path:     basePath + "/",
+		Expires:  time.Now().Add(365 * 24 * time.Hour),
+		Secure:   secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// localeWriter carries the request's language to writeJSON, which has only
+// the response writer to go by.
+type localeWriter struct {
+	http.ResponseWriter
+	language string
+}
+
+func (lw *localeWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
+
+func (lw *localeWriter) Flush() {
+	if flusher, ok := lw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (lw *localeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := lw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+// localeMiddleware picks the language of every request and answers in it.
+func localeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		language := requestLanguage(r)
+		w.Header().Set("Content-Language", language)
+		w.Header().Add("Vary", "Accept-Language")
+		r = r.WithContext(context.WithValue(r.Context(), languageKey, language))
+		next.ServeHTTP(&localeWriter{ResponseWriter: w, language: language}, r)
+	})
+}
+
+// localize translates the "error" and "message" of a JSON response into the
+// language of its request.
+func localize(w http.ResponseWriter, payload map[string]interface{}) {
+	var language string
+	for writer := w; writer != nil; {
+		if current, ok := writer.(*localeWriter); ok {
+			language = current.language
+			break
+		}
+		unwrapper, ok := writer.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			break
+		}
+		writer = unwrapper.Unwrap()
+	}
+	if language == "" {
+		return
+	}
+	for _, key := range []string{"error", "message"} {
+		if message, ok := payload[key].(string); ok {
+			payload[key] = translate(language, message)
+		}
+	}
+}
+
+type LanguageRequest struct {
+	Language string `json:"language"`
+}
+
+// languageHandler lists the languages or, on POST, switches the web
+// interface to one; a logged-in user keeps it as their preference.
+func languageHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, map[string]interface{}{
+			"success":   true,
+			"language":  languageFrom(r.Context()),
+			"default":   defaultLanguage,
+			"languages": languages,
+		})
+		return
+	case "POST":
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req LanguageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, codeInvalidRequest, "Invalid JSON format")
+		return
+	}
+	req.Language = strings.ToLower(req.Language)
+	if !supportedLanguage(req.Language) {
+		writeError(w, codeInvalidRequest, "Unknown language: "+req.Language)
+		return
+	}
+
+	if user := userFrom(r.Context()); user != nil && user.Source != ldapSource {
+		user.Language = req.Language
+		if err := saveUser(user); err != nil {
+			writeFailure(w, fmt.Errorf("Failed to save language: %w", err))
+			return
+		}
+	}
+	setLanguageCookie(w, r, req.Language)
+	writeJSON(w, map[string]interface{}{
+		"success":  true,
+		"language": req.Language,
+	})
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func (dm *DockerManager) SaveImage(ctx context.Context, image string, w io.Writer) error {
+	return dm.streamSSHCommand(ctx, "docker save "+shellQuote(image), nil, w)
+}
+
+func (dm *DockerManager) LoadImage(ctx context.Context, archive io.Reader) (string, error) {
+	var output strings.Builder
+	if err := dm.streamSSHCommand(ctx, "docker load", archive, &output); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(output.String()), nil
+}
+
+type Image struct {
+	ID         string `json:"id"`
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+	Created    string `json:"created"`
+	Size       string `json:"size"`
+}
+
+func (dm *DockerManager) ListImages() ([]Image, error) {
+	output, err := dm.executeSSHCommand("docker images --format '{{json .}}'")
+	if err != nil {
+		return nil, err
+	}
+
+	images := []Image{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		var raw struct {
+			ID         string
+			Repository string
+			Tag        string
+			CreatedAt  string
+			Size       string
+		}
+		if err := json.Unmarshal([]byte(line), &raw); err != nil {
+			return nil, fmt.Errorf("failed to parse image line: %v", err)
+		}
+		images = append(images, Image{
+			ID:         raw.ID,
+			Repository: raw.Repository,
+			Tag:        raw.Tag,
+			Created:    raw.CreatedAt,
+			Size:       raw.Size,
+		})
+	}
+	return images, nil
+}
+
+func imagesHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	images, err := manager.ListImages()
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"images":  images,
+		"count":   len(images),
+	})
+}
+
+func imageSaveHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	image := mux.Vars(r)["id"]
+	if _, err := manager.executeSSHCommand("docker image inspect --format '{{.Id}}' " + shellQuote(image)); err != nil {
+		writeFailure(w, lookupFailed(err, "", "Image not found: "+image))
+		return
+	}
+
+	filename := strings.NewReplacer("/", "_", ":", "_").Replace(image) + ".tar"
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	manager.logger.Info("exporting image", "image", image)
+	if err := manager.SaveImage(r.Context(), image, w); err != nil {
+		// Headers are already sent, so the client only sees a truncated archive.
+		manager.logger.Error("image export failed", "image", image, "error", err)
+		return
+	}
+	manager.logger.Info("image exported", "image", image)
+}
+
+func imageLoadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	archive, err := uploadReader(r)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	manager.logger.Info("loading image archive")
+	output, err := manager.LoadImage(r.Context(), archive)
+	recordAudit(r, manager.config.ID(), "image.load", output, err)
+	if err != nil {
+		manager.logger.Error("image load failed", "error", err)
+		writeFailure(w, err)
+		return
+	}
+
+	manager.logger.Info("image archive loaded", "output", output)
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"message": output,
+	})
+}
+
+type ImageLayer struct {
+	ID        string `json:"id"`
+	CreatedBy string `json:"created_by"`
+	Created   string `json:"created"`
+	Size      int64  `json:"size"`
+	Comment   string `json:"comment"`
+}
+
+func (dm *DockerManager) InspectImage(image string) (map[string]interface{}, error) {
+	output, err := dm.executeSSHCommand("docker image inspect " + shellQuote(image))
+	if err != nil {
+		return nil, err
+	}
+
+	var details []map[string]interface{}
+	if err := json.Unmarshal([]byte(output), &details); err != nil {
+		return nil, fmt.Errorf("failed to parse image inspect output: %v", err)
+	}
+	if len(details) == 0 {
+		return nil, requestErrorf(codeNotFound, "image %s not found", image)
+	}
+	return details[0], nil
+}
+
+func (dm *DockerManager) ImageHistory(image string) ([]ImageLayer, error) {
+	output, err := dm.executeSSHCommand("docker history --no-trunc --human=false --format '{{json .}}' " + shellQuote(image))
+	if err != nil {
+		return nil, err
+	}
+
+	layers := []ImageLayer{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		var raw struct {
+			ID        string
+			CreatedBy string
+			CreatedAt string
+			Size      string
+			Comment   string
+		}
+		if err := json.Unmarshal([]byte(line), &raw); err != nil {
+			return nil, fmt.Errorf("failed to parse history line: %v", err)
+		}
+
+		size, _ := strconv.ParseInt(raw.Size, 10, 64)
+		layers = append(layers, ImageLayer{
+			ID:        raw.ID,
+			CreatedBy: raw.CreatedBy,
+			Created:   raw.CreatedAt,
+			Size:      size,
+			Comment:   raw.Comment,
+		})
+	}
+	return layers, nil
+}
+
+func imageInspectHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	details, err := manager.InspectImage(mux.Vars(r)["id"])
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"image":   details,
+	})
+}
+
+func imageHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	layers, err := manager.ImageHistory(mux.Vars(r)["id"])
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	var total int64
+	for _, layer := range layers {
+		total += layer.Size
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"success":    true,
+		"layers":     layers,
+		"count":      len(layers),
+		"total_size": total,
+	})
+}
+
+var (
+	buildStepPattern       = regexp.MustCompile(`^#\d+ \[[^\]]*?(\d+)/(\d+)\]`)
+	legacyBuildStepPattern = regexp.MustCompile(`^Step (\d+)/(\d+) :`)
+)
+
+// PullImage pulls image, logging docker's progress lines and estimating
+// progress from the layers that have finished.
+func (dm *DockerManager) PullImage(ctx context.Context, image string, run *jobRun) error {
+	layers := map[string]bool{}
+	return dm.streamSSHLines(ctx, "docker pull "+shellQuote(image)+" 2>&1", func(line string) error {
+		run.Log(line)
+		layer, status, ok := strings.Cut(line, ": ")
+		if !ok || strings.Contains(layer, " ") {
+			return nil
+		}
+		switch status {
+		case "Pulling fs layer", "Waiting":
+			if _, seen := layers[layer]; !seen {
+				layers[layer] = false
+			}
+		case "Pull complete", "Already exists":
+			layers[layer] = true
+		default:
+			return nil
+		}
+		done := 0
+		for _, complete := range layers {
+			if complete {
+				done++
+			}
+		}
+		run.SetProgress(done * 100 / len(layers))
+		return nil
+	})
+}
+
+type ImageBuildRequest struct {
+	Tag string `json:"tag"`
+	// Context is a directory on the host or a Git URL.
+	Context    string            `json:"context"`
+	Dockerfile string            `json:"dockerfile"`
+	BuildArgs  map[string]string `json:"build_args"`
+	NoCache    bool              `json:"no_cache"`
+	Pull       bool              `json:"pull"`
+}
+
+// BuildImage runs docker build on the host, logging its output and taking
+// progress from the step counter.
+func (dm *DockerManager) BuildImage(ctx context.Context, req ImageBuildRequest, run *jobRun) error {
+	if req.Tag == "" || req.Context == "" {
+		return requestErrorf(codeInvalidRequest, "tag and context are required")
+	}
+
+	args := []string{"docker", "build", "-t", shellQuote(req.Tag)}
+	if req.Dockerfile != "" {
+		args = append(args, "-f", shellQuote(req.Dockerfile))
+	}
+	keys := make([]string, 0, len(req.BuildArgs))
+	for key := range req.BuildArgs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "--build-arg", shellQuote(key+"="+req.BuildArgs[key]))
+	}
+	if req.NoCache {
+		args = append(args, "--no-cache")
+	}
+	if req.Pull {
+		args = append(args, "--pull")
+	}
+	args = append(args, shellQuote(req.Context))
+
+	// Without a terminal BuildKit prints plain progress, which like the
+	// legacy builder's output goes to stderr.
+	command := strings.Join(args, " ") + " 2>&1"
+	return dm.streamSSHLines(ctx, command, func(line string) error {
+		run.Log(line)
+		match := buildStepPattern.FindStringSubmatch(line)
+		if match == nil {
+			match = legacyBuildStepPattern.FindStringSubmatch(line)
+		}
+		if match != nil {
+			step, _ := strconv.Atoi(match[1])
+			total, _ := strconv.Atoi(match[2])
+			if total > 0 {
+				run.SetProgress((step - 1) * 100 / total)
+			}
+		}
+		return nil
+	})
+}
+
+func imagePullHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Image string `json:"image"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, codeInvalidRequest, "Invalid JSON format")
+		return
+	}
+	if req.Image == "" {
+		writeError(w, codeInvalidRequest, "image is required")
+		return
+	}
+
+	job, err := submitJob(r, manager, "image.pull", req.Image, true, func(ctx context.Context, run *jobRun) (interface{}, error) {
+		return nil, manager.PullImage(ctx, req.Image, run)
+	})
+	writeJobResponse(w, job, err)
+}
+
+func imageBuildHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	var req ImageBuildRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, codeInvalidRequest, "Invalid JSON format")
+		return
+	}
+	if req.Tag == "" || req.Context == "" {
+		writeError(w, codeInvalidRequest, "tag and context are required")
+		return
+	}
+
+	job, err := submitJob(r, manager, "image.build", req.Tag, true, func(ctx context.Context, run *jobRun) (interface{}, error) {
+		return nil, manager.BuildImage(ctx, req, run)
+	})
+	writeJobResponse(w, job, err)
+}
@@ -0,0 +1,526 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	jobsCollection = "jobs"
+	jobQueueSize   = 1000
+	maxJobOutput   = 1000
+)
+
+// Job is a long-running operation that runs in the background. Queued and
+// running jobs are kept in memory; finished ones are written to the jobs log.
+type Job struct {
+	ID     string `json:"id"`
+	Server string `json:"server"`
+	Type   string `json:"type"`
+	Target string `json:"target,omitempty"`
+	// State is queued, running, succeeded, failed or cancelled.
+	State string `json:"state"`
+	// Cancellable jobs stop when cancelled while running. The others do
+	// work that cannot be interrupted and are only cancelled while queued.
+	Cancellable bool `json:"cancellable"`
+	// Progress is a percentage, or -1 while it is unknown.
+	Progress   int         `json:"progress"`
+	Output     []string    `json:"output"`
+	Error      string      `json:"error,omitempty"`
+	Result     interface{} `json:"result,omitempty"`
+	CreatedAt  time.Time   `json:"created_at"`
+	StartedAt  time.Time   `json:"started_at"`
+	FinishedAt time.Time   `json:"finished_at"`
+}
+
+// jobFunc does the work of a job, reporting through the jobRun.
+type jobFunc func(ctx context.Context, run *jobRun) (interface{}, error)
+
+type jobRun struct {
+	mu     sync.Mutex
+	job    Job
+	fn     jobFunc
+	dm     *DockerManager
+	cancel context.CancelFunc
+	// finish records the audit entry once the outcome is known.
+	finish func(err error)
+	// lines counts every output line, including those trimmed from Output.
+	lines int
+	// changed is closed and replaced whenever the job changes, waking
+	// streams that follow it.
+	changed chan struct{}
+}
+
+var (
+	jobsMu     sync.Mutex
+	activeJobs = map[string]*jobRun{}
+	jobQueue   = make(chan *jobRun, jobQueueSize)
+	jobsOnce   sync.Once
+)
+
+// jobWorkers is how many jobs run at once; the rest wait in the queue.
+func jobWorkers() int {
+	if n, err := strconv.Atoi(setting("JOB_WORKERS")); err == nil && n > 0 {
+		return n
+	}
+	return 4
+}
+
+// notify wakes the job's followers; callers hold run.mu.
+func (run *jobRun) notify() {
+	close(run.changed)
+	run.changed = make(chan struct{})
+}
+
+func (run *jobRun) Log(line string) error {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	run.job.Output = append(run.job.Output, line)
+	if len(run.job.Output) > maxJobOutput {
+		run.job.Output = run.job.Output[len(run.job.Output)-maxJobOutput:]
+	}
+	run.lines++
+	run.notify()
+	return nil
+}
+
+func (run *jobRun) SetProgress(percent int) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	percent = min(max(percent, 0), 100)
+	if percent != run.job.Progress {
+		run.job.Progress = percent
+		run.notify()
+	}
+}
+
+// since returns the output lines after the first pos, numbered from 1, the
+// job without its output and a channel closed on the next change. Lines
+// already trimmed from the output are skipped.
+func (run *jobRun) since(pos int) ([]string, int, Job, <-chan struct{}) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	first := run.lines - len(run.job.Output)
+	pos = max(pos, first)
+	lines := append([]string{}, run.job.Output[pos-first:]...)
+	job := run.job
+	job.Output = nil
+	return lines, pos, job, run.changed
+}
+
+func (run *jobRun) snapshot() Job {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	job := run.job
+	job.Output = append([]string{}, run.job.Output...)
+	return job
+}
+
+// submitJob queues fn and returns the job right away. The outcome is
+// audited as action on target when the job ends. Only a cancellable fn
+// stops when its context is cancelled.
+func submitJob(r *http.Request, dm *DockerManager, action, target string, cancellable bool, fn jobFunc) (Job, error) {
+	jobsOnce.Do(func() {
+		for i := 0; i < jobWorkers(); i++ {
+			go jobWorker()
+		}
+	})
+
+	run := &jobRun{
+		job: Job{
+			ID:          newRequestID(),
+			Server:      dm.config.ID(),
+			Type:        action,
+			Target:      target,
+			State:       "queued",
+			Cancellable: cancellable,
+			Progress:    -1,
+			Output:      []string{},
+			CreatedAt:   time.Now().UTC(),
+		},
+		fn:      fn,
+		dm:      dm,
+		changed: make(chan struct{}),
+		finish: func(err error) {
+			recordAudit(r, dm.config.ID(), action, target, err)
+		},
+	}
+
+	jobsMu.Lock()
+	activeJobs[run.job.ID] = run
+	jobsMu.Unlock()
+
+	select {
+	case jobQueue <- run:
+	default:
+		jobsMu.Lock()
+		delete(activeJobs, run.job.ID)
+		jobsMu.Unlock()
+		return Job{}, fmt.Errorf("job queue is full, try again later")
+	}
+	dm.logger.Info("job queued", "job", run.job.ID, "type", action, "target", target)
+	return run.snapshot(), nil
+}
+
+func jobWorker() {
+	for run := range jobQueue {
+		ctx, cancel := context.WithCancel(context.Background())
+		run.mu.Lock()
+		cancelled := run.job.State == "cancelled"
+		run.cancel = cancel
+		run.job.State = "running"
+		run.job.StartedAt = time.Now().UTC()
+		run.notify()
+		run.mu.Unlock()
+
+		var result interface{}
+		var err error
+		if cancelled {
+			err = context.Canceled
+		} else {
+			result, err = run.fn(ctx, run)
+			cancelled = err != nil && ctx.Err() != nil
+		}
+		cancel()
+		finishJob(run, result, err, cancelled)
+	}
+}
+
+func finishJob(run *jobRun, result interface{}, err error, cancelled bool) {
+	run.mu.Lock()
+	run.job.Result = result
+	run.job.FinishedAt = time.Now().UTC()
+	switch {
+	case cancelled:
+		run.job.State = "cancelled"
+		run.job.Error = "cancelled"
+	case err != nil:
+		run.job.State = "failed"
+		run.job.Error = err.Error()
+	default:
+		run.job.State = "succeeded"
+		run.job.Progress = 100
+	}
+	run.notify()
+	run.mu.Unlock()
+
+	job := run.snapshot()
+	if err != nil {
+		run.dm.logger.Error("job failed", "job", job.ID, "type", job.Type, "target", job.Target, "error", job.Error)
+	} else {
+		run.dm.logger.Info("job finished", "job", job.ID, "type", job.Type, "target", job.Target)
+	}
+	run.finish(err)
+
+	if store != nil {
+		if err := store.Append(jobsCollection, job); err != nil {
+			slog.Error("failed to record job", "job", job.ID, "error", err)
+		}
+	}
+	jobsMu.Lock()
+	delete(activeJobs, job.ID)
+	jobsMu.Unlock()
+}
+
+// cancelJob cancels a running job's context, which stops streamed remote
+// commands, or keeps a queued job from starting. Running jobs that are not
+// cancellable are refused rather than reported cancelled while their work
+// goes on.
+func cancelJob(id string) error {
+	jobsMu.Lock()
+	run, ok := activeJobs[id]
+	jobsMu.Unlock()
+	if !ok {
+		return requestErrorf(codeConflict, "Job %s is not queued or running", id)
+	}
+
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	switch {
+	case run.cancel == nil:
+		run.job.State = "cancelled"
+	case !run.job.Cancellable:
+		return requestErrorf(codeConflict, "Job %s is running and cannot be cancelled", id)
+	default:
+		run.cancel()
+	}
+	return nil
+}
+
+// activeJobIDs returns the queued and running jobs.
+func activeJobIDs() []string {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	ids := make([]string, 0, len(activeJobs))
+	for id := range activeJobs {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func jobFinished(state string) bool {
+	return state != "queued" && state != "running"
+}
+
+func findJob(id string) (*Job, error) {
+	jobsMu.Lock()
+	run, ok := activeJobs[id]
+	jobsMu.Unlock()
+	if ok {
+		job := run.snapshot()
+		return &job, nil
+	}
+	if store == nil {
+		return nil, nil
+	}
+
+	var found *Job
+	err := store.Scan(jobsCollection, func(data []byte) error {
+		var job Job
+		if !bytes.Contains(data, []byte(id)) || json.Unmarshal(data, &job) != nil || job.ID != id {
+			return nil
+		}
+		found = &job
+		return errJobFound
+	})
+	if err == errJobFound {
+		err = nil
+	}
+	return found, err
+}
+
+// errJobFound stops the scan of the jobs log at the job looked for.
+var errJobFound = errors.New("job found")
+
+// pruneJobHistory drops finished jobs older than JOB_RETENTION.
+func pruneJobHistory() {
+	cutoff := time.Now().Add(-envDuration("JOB_RETENTION", 7*24*time.Hour))
+	err := store.Compact(jobsCollection, func(data []byte) bool {
+		var job Job
+		return json.Unmarshal(data, &job) == nil && job.FinishedAt.After(cutoff)
+	})
+	if err != nil {
+		slog.Error("failed to prune job history", "error", err)
+	}
+}
+
+func startJobHistoryPruner() {
+	go func() {
+		for {
+			pruneJobHistory()
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+// listJobs returns the server's active jobs followed by finished ones, newest
+// first, without their output.
+func listJobs(server string, limit int) ([]Job, error) {
+	jobs := []Job{}
+	jobsMu.Lock()
+	for _, run := range activeJobs {
+		if job := run.snapshot(); job.Server == server {
+			jobs = append(jobs, job)
+		}
+	}
+	jobsMu.Unlock()
+
+	var history []Job
+	if store != nil {
+		err := store.Scan(jobsCollection, func(data []byte) error {
+			var job Job
+			if json.Unmarshal(data, &job) == nil && job.Server == server {
+				history = append(history, job)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		jobs = append(jobs, history[i])
+	}
+
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+	if len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+	for i := range jobs {
+		jobs[i].Output = nil
+	}
+	return jobs, nil
+}
+
+// asyncRequested reports whether the client asked for a job instead of
+// waiting for the result.
+func asyncRequested(r *http.Request) bool {
+	return r.URL.Query().Get("async") == "true"
+}
+
+// writeJobResponse answers a request that started a job.
+func writeJobResponse(w http.ResponseWriter, job Job, err error) {
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"job_id":  job.ID,
+		"job":     job,
+	})
+}
+
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	limit := 100
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+		limit = n
+	}
+	jobs, err := listJobs(manager.config.ID(), limit)
+	if err != nil {
+		writeFailure(w, fmt.Errorf("Failed to read jobs: %w", err))
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"jobs":    jobs,
+		"count":   len(jobs),
+	})
+}
+
+// serverJob finds the job with the route's id on the server the request
+// acts on; jobs of other servers are reported as not found.
+func serverJob(w http.ResponseWriter, r *http.Request) (*Job, bool) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	id := mux.Vars(r)["id"]
+	job, err := findJob(id)
+	if err != nil {
+		writeFailure(w, fmt.Errorf("Failed to read jobs: %w", err))
+		return nil, false
+	}
+	if job == nil || job.Server != manager.config.ID() {
+		writeError(w, codeNotFound, "Job not found: "+id)
+		return nil, false
+	}
+	return job, true
+}
+
+func jobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := serverJob(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"job":     job,
+	})
+}
+
+func jobCancelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	job, ok := serverJob(w, r)
+	if !ok {
+		return
+	}
+	id := job.ID
+	if err := cancelJob(id); err != nil {
+		writeFailure(w, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"message": "Job " + id + " cancelled",
+	})
+}
+
+// jobStreamHandler follows a job as Server-Sent Events: an output event per
+// line (with the line number as id), progress events when the state or
+// percentage changes and a done event with the final job. Output produced
+// before the client connected is replayed first.
+func jobStreamHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := serverJob(w, r)
+	if !ok {
+		return
+	}
+	jobsMu.Lock()
+	run, active := activeJobs[job.ID]
+	jobsMu.Unlock()
+
+	if !active {
+		// The job may have finished since it was looked up.
+		id := job.ID
+		job, err := findJob(id)
+		if err != nil {
+			writeFailure(w, fmt.Errorf("Failed to read jobs: %w", err))
+			return
+		}
+		if job == nil {
+			writeError(w, codeNotFound, "Job not found: "+id)
+			return
+		}
+		if !startSSE(w) {
+			return
+		}
+		for i, line := range job.Output {
+			writeSSE(w, "output", strconv.Itoa(i+1), map[string]string{"line": line})
+		}
+		job.Output = nil
+		writeSSE(w, "done", "", job)
+		return
+	}
+
+	if !startSSE(w) {
+		return
+	}
+	pos := 0
+	lastState, lastProgress := "", -2
+	for {
+		lines, first, job, changed := run.since(pos)
+		for i, line := range lines {
+			if err := writeSSE(w, "output", strconv.Itoa(first+i+1), map[string]string{"line": line}); err != nil {
+				return
+			}
+		}
+		pos = first + len(lines)
+
+		if jobFinished(job.State) {
+			writeSSE(w, "done", "", job)
+			return
+		}
+		if job.State != lastState || job.Progress != lastProgress {
+			lastState, lastProgress = job.State, job.Progress
+			if err := writeSSE(w, "progress", "", map[string]interface{}{"state": job.State, "progress": job.Progress}); err != nil {
+				return
+			}
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		}
+	}
+}
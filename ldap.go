@@ -0,0 +1,539 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// ldapSource marks users that log in against the directory. Their
+	// record in the users collection only carries the role from the last
+	// login.
+	ldapSource  = "ldap"
+	ldapTimeout = 10 * time.Second
+	// startTLSOID is the LDAP StartTLS extended operation (RFC 4511).
+	startTLSOID = "1.3.6.1.4.1.1466.20037"
+)
+
+// LDAPConfig configures the LDAP / Active Directory backend. Filters take
+// {username}, and the group filter also {dn}, the user's entry.
+type LDAPConfig struct {
+	URL           string
+	StartTLS      bool
+	SkipVerify    bool
+	BindDN        string
+	BindPassword  string
+	BaseDN        string
+	UserFilter    string
+	GroupBaseDN   string
+	GroupFilter   string
+	AdminGroup    string
+	RequiredGroup string
+}
+
+var ldapConfig = loadLDAPConfig()
+
+// loadLDAPConfig reads LDAP_* settings; it returns nil unless LDAP_URL is
+// set, leaving local users as the only backend.
+func loadLDAPConfig() *LDAPConfig {
+	if setting("LDAP_URL") == "" {
+		return nil
+	}
+	config := &LDAPConfig{
+		URL:           setting("LDAP_URL"),
+		StartTLS:      setting("LDAP_START_TLS") == "true",
+		SkipVerify:    setting("LDAP_TLS_SKIP_VERIFY") == "true",
+		BindDN:        setting("LDAP_BIND_DN"),
+		BindPassword:  setting("LDAP_BIND_PASSWORD"),
+		BaseDN:        setting("LDAP_BASE_DN"),
+		UserFilter:    setting("LDAP_USER_FILTER"),
+		GroupBaseDN:   setting("LDAP_GROUP_BASE_DN"),
+		GroupFilter:   setting("LDAP_GROUP_FILTER"),
+		AdminGroup:    setting("LDAP_ADMIN_GROUP"),
+		RequiredGroup: setting("LDAP_REQUIRED_GROUP"),
+	}
+	if config.UserFilter == "" {
+		config.UserFilter = "(uid={username})"
+	}
+	if config.GroupBaseDN == "" {
+		config.GroupBaseDN = config.BaseDN
+	}
+	if config.GroupFilter == "" {
+		config.GroupFilter = "(|(member={dn})(uniqueMember={dn})(memberUid={username}))"
+	}
+	return config
+}
+
+// ldapAuthenticate looks the user up with the service account, checks the
+// password by binding as them and maps their groups to a role. The local
+// record is created or updated so sessions, tokens and the audit log work
+// as for local users.
+func ldapAuthenticate(config *LDAPConfig, username, password string) (*User, error) {
+	// An empty password would be an unauthenticated bind, which servers
+	// accept for any DN.
+	if password == "" || !usernamePattern.MatchString(username) {
+		return nil, nil
+	}
+
+	conn, err := dialLDAP(config)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if config.BindDN != "" {
+		if err := conn.bind(config.BindDN, config.BindPassword); err != nil {
+			return nil, fmt.Errorf("service account bind failed: %v", err)
+		}
+	}
+
+	filter := expandLDAPFilter(config.UserFilter, username, "")
+	entries, err := conn.search(config.BaseDN, filter, []string{"memberOf"}, 2)
+	if err != nil {
+		return nil, fmt.Errorf("user search failed: %v", err)
+	}
+	if len(entries) != 1 {
+		return nil, nil
+	}
+	entry := entries[0]
+
+	groups := entry.attributes["memberof"]
+	if config.GroupFilter != "" && config.GroupBaseDN != "" {
+		filter := expandLDAPFilter(config.GroupFilter, username, entry.dn)
+		found, err := conn.search(config.GroupBaseDN, filter, []string{"1.1"}, 0)
+		if err != nil {
+			return nil, fmt.Errorf("group search failed: %v", err)
+		}
+		for _, group := range found {
+			groups = append(groups, group.dn)
+		}
+	}
+
+	if err := conn.bind(entry.dn, password); err != nil {
+		if err == errLDAPInvalidCredentials {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	role := "user"
+	if config.AdminGroup != "" && inLDAPGroup(groups, config.AdminGroup) {
+		role = "admin"
+	} else if config.RequiredGroup != "" && !inLDAPGroup(groups, config.RequiredGroup) {
+		return nil, nil
+	}
+
+	user, err := loadUser(username)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		user = &User{Username: username, CreatedAt: time.Now().UTC()}
+	}
+	user.Source = ldapSource
+	user.Role = role
+	user.PasswordHash = ""
+	return user, saveUser(user)
+}
+
+func inLDAPGroup(groups []string, group string) bool {
+	for _, g := range groups {
+		if strings.EqualFold(strings.ReplaceAll(g, ", ", ","), strings.ReplaceAll(strings.TrimSpace(group), ", ", ",")) {
+			return true
+		}
+	}
+	return false
+}
+
+func expandLDAPFilter(filter, username, dn string) string {
+	return strings.NewReplacer("{username}", escapeLDAPFilter(username), "{dn}", escapeLDAPFilter(dn)).Replace(filter)
+}
+
+// escapeLDAPFilter escapes a value for use in a search filter (RFC 4515).
+func escapeLDAPFilter(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '\\', '*', '(', ')', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+var errLDAPInvalidCredentials = fmt.Errorf("invalid credentials")
+
+// ldapConn is a minimal LDAPv3 client: simple bind, search and StartTLS,
+// which is all logging in needs.
+type ldapConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	nextID int
+}
+
+type ldapEntry struct {
+	dn string
+	// attributes are keyed by lower case name.
+	attributes map[string][]string
+}
+
+func dialLDAP(config *LDAPConfig) (*ldapConn, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP_URL: %v", err)
+	}
+	tlsConfig := &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: config.SkipVerify}
+	host := u.Host
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		conn, err = net.DialTimeout("tcp", host, ldapTimeout)
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}, "tcp", host, tlsConfig)
+	default:
+		return nil, fmt.Errorf("invalid LDAP_URL scheme %q: use ldap or ldaps", u.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP server: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(ldapTimeout))
+
+	c := &ldapConn{conn: conn, reader: bufio.NewReader(conn)}
+	if config.StartTLS && u.Scheme == "ldap" {
+		if err := c.startTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *ldapConn) Close() error {
+	c.nextID++
+	c.conn.Write(berTLV(0x30, berInt(0x02, c.nextID), berTLV(0x42)))
+	return c.conn.Close()
+}
+
+// request sends one operation and returns the responses up to and including
+// the one tagged done.
+func (c *ldapConn) request(op []byte, done byte) ([]berElement, error) {
+	c.nextID++
+	if _, err := c.conn.Write(berTLV(0x30, berInt(0x02, c.nextID), op)); err != nil {
+		return nil, err
+	}
+	var responses []berElement
+	for {
+		msg, err := readBER(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		parts, err := parseBER(msg.data)
+		if err != nil || len(parts) < 2 {
+			return nil, fmt.Errorf("malformed LDAP response")
+		}
+		responses = append(responses, parts[1])
+		if parts[1].tag == done {
+			return responses, nil
+		}
+	}
+}
+
+// ldapResult turns an LDAPResult into an error unless it reports success.
+func ldapResult(op berElement) error {
+	parts, err := parseBER(op.data)
+	if err != nil || len(parts) < 3 {
+		return fmt.Errorf("malformed LDAP result")
+	}
+	code := berToInt(parts[0].data)
+	switch code {
+	case 0:
+		return nil
+	case 49:
+		return errLDAPInvalidCredentials
+	}
+	if message := string(parts[2].data); message != "" {
+		return fmt.Errorf("LDAP error %d: %s", code, message)
+	}
+	return fmt.Errorf("LDAP error %d", code)
+}
+
+func (c *ldapConn) bind(dn, password string) error {
+	op := berTLV(0x60, berInt(0x02, 3), berTLV(0x04, []byte(dn)), berTLV(0x80, []byte(password)))
+	responses, err := c.request(op, 0x61)
+	if err != nil {
+		return err
+	}
+	return ldapResult(responses[len(responses)-1])
+}
+
+func (c *ldapConn) startTLS(config *tls.Config) error {
+	responses, err := c.request(berTLV(0x77, berTLV(0x80, []byte(startTLSOID))), 0x78)
+	if err != nil {
+		return err
+	}
+	if err := ldapResult(responses[len(responses)-1]); err != nil {
+		return fmt.Errorf("StartTLS failed: %v", err)
+	}
+	tlsConn := tls.Client(c.conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		return fmt.Errorf("StartTLS failed: %v", err)
+	}
+	c.conn = tlsConn
+	c.reader = bufio.NewReader(tlsConn)
+	return nil
+}
+
+// search runs a subtree search. sizeLimit 0 means the server's limit.
+func (c *ldapConn) search(baseDN, filter string, attributes []string, sizeLimit int) ([]ldapEntry, error) {
+	encoded, rest, err := encodeLDAPFilter(filter)
+	if err == nil && rest != "" {
+		err = fmt.Errorf("unexpected %q after filter", rest)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %s: %v", filter, err)
+	}
+	var attrs [][]byte
+	for _, attr := range attributes {
+		attrs = append(attrs, berTLV(0x04, []byte(attr)))
+	}
+	op := berTLV(0x63,
+		berTLV(0x04, []byte(baseDN)),
+		berInt(0x0a, 2), // wholeSubtree
+		berInt(0x0a, 0), // neverDerefAliases
+		berInt(0x02, sizeLimit),
+		berInt(0x02, int(ldapTimeout/time.Second)),
+		berTLV(0x01, []byte{0}),
+		encoded,
+		berTLV(0x30, attrs...),
+	)
+	responses, err := c.request(op, 0x65)
+	if err != nil {
+		return nil, err
+	}
+	if err := ldapResult(responses[len(responses)-1]); err != nil {
+		return nil, err
+	}
+
+	var entries []ldapEntry
+	for _, response := range responses {
+		if response.tag != 0x64 {
+			continue
+		}
+		parts, err := parseBER(response.data)
+		if err != nil || len(parts) < 2 {
+			return nil, fmt.Errorf("malformed search entry")
+		}
+		entry := ldapEntry{dn: string(parts[0].data), attributes: map[string][]string{}}
+		attrs, _ := parseBER(parts[1].data)
+		for _, attr := range attrs {
+			pair, err := parseBER(attr.data)
+			if err != nil || len(pair) < 2 {
+				continue
+			}
+			name := strings.ToLower(string(pair[0].data))
+			values, _ := parseBER(pair[1].data)
+			for _, value := range values {
+				entry.attributes[name] = append(entry.attributes[name], string(value.data))
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// encodeLDAPFilter encodes the string form of a filter (RFC 4515): &, |, !,
+// equality, presence and substrings. It returns what follows the filter.
+func encodeLDAPFilter(filter string) ([]byte, string, error) {
+	if !strings.HasPrefix(filter, "(") {
+		return nil, "", fmt.Errorf("expected (")
+	}
+	filter = filter[1:]
+	if filter == "" {
+		return nil, "", fmt.Errorf("unexpected end")
+	}
+
+	switch filter[0] {
+	case '&', '|', '!':
+		tag := map[byte]byte{'&': 0xa0, '|': 0xa1, '!': 0xa2}[filter[0]]
+		rest := filter[1:]
+		var parts [][]byte
+		for strings.HasPrefix(rest, "(") {
+			part, next, err := encodeLDAPFilter(rest)
+			if err != nil {
+				return nil, "", err
+			}
+			parts = append(parts, part)
+			rest = next
+		}
+		if !strings.HasPrefix(rest, ")") || len(parts) == 0 || (tag == 0xa2 && len(parts) != 1) {
+			return nil, "", fmt.Errorf("malformed %c filter", filter[0])
+		}
+		return berTLV(tag, parts...), rest[1:], nil
+	}
+
+	end := strings.IndexByte(filter, ')')
+	if end < 0 {
+		return nil, "", fmt.Errorf("expected )")
+	}
+	item, rest := filter[:end], filter[end+1:]
+	attr, value, ok := strings.Cut(item, "=")
+	if !ok || attr == "" {
+		return nil, "", fmt.Errorf("malformed item %q", item)
+	}
+	if strings.ContainsAny(attr[len(attr)-1:], "~<>:") {
+		return nil, "", fmt.Errorf("unsupported match in %q", item)
+	}
+
+	if value == "*" {
+		return berTLV(0x87, []byte(attr)), rest, nil
+	}
+	if !strings.Contains(value, "*") {
+		unescaped, err := unescapeLDAPFilter(value)
+		if err != nil {
+			return nil, "", err
+		}
+		return berTLV(0xa3, berTLV(0x04, []byte(attr)), berTLV(0x04, unescaped)), rest, nil
+	}
+
+	pieces := strings.Split(value, "*")
+	var substrings [][]byte
+	for i, piece := range pieces {
+		if piece == "" {
+			continue
+		}
+		unescaped, err := unescapeLDAPFilter(piece)
+		if err != nil {
+			return nil, "", err
+		}
+		tag := byte(0x81) // any
+		switch i {
+		case 0:
+			tag = 0x80 // initial
+		case len(pieces) - 1:
+			tag = 0x82 // final
+		}
+		substrings = append(substrings, berTLV(tag, unescaped))
+	}
+	return berTLV(0xa4, berTLV(0x04, []byte(attr)), berTLV(0x30, substrings...)), rest, nil
+}
+
+func unescapeLDAPFilter(value string) ([]byte, error) {
+	var out []byte
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			out = append(out, value[i])
+			continue
+		}
+		if i+3 > len(value) {
+			return nil, fmt.Errorf("malformed escape in %q", value)
+		}
+		b, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return nil, fmt.Errorf("malformed escape in %q", value)
+		}
+		out = append(out, b...)
+		i += 2
+	}
+	return out, nil
+}
+
+// berElement is one BER tag-length-value; data is the raw content.
+type berElement struct {
+	tag  byte
+	data []byte
+}
+
+func berTLV(tag byte, content ...[]byte) []byte {
+	var data []byte
+	for _, c := range content {
+		data = append(data, c...)
+	}
+	out := []byte{tag}
+	if n := len(data); n < 0x80 {
+		out = append(out, byte(n))
+	} else {
+		var length []byte
+		for ; n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		out = append(out, 0x80|byte(len(length)))
+		out = append(out, length...)
+	}
+	return append(out, data...)
+}
+
+func berInt(tag byte, n int) []byte {
+	var data []byte
+	for {
+		data = append([]byte{byte(n)}, data...)
+		n >>= 8
+		if n == 0 && data[0]&0x80 == 0 {
+			break
+		}
+	}
+	return berTLV(tag, data)
+}
+
+func berToInt(data []byte) int {
+	n := 0
+	for _, b := range data {
+		n = n<<8 | int(b)
+	}
+	return n
+}
+
+func readBER(r *bufio.Reader) (berElement, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return berElement{}, err
+	}
+	length := int(header[1])
+	if length&0x80 != 0 {
+		size := length & 0x7f
+		if size == 0 || size > 4 {
+			return berElement{}, fmt.Errorf("unsupported BER length")
+		}
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return berElement{}, err
+		}
+		length = berToInt(buf)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return berElement{}, err
+	}
+	return berElement{tag: header[0], data: data}, nil
+}
+
+// parseBER splits the content of a constructed element into its children.
+func parseBER(data []byte) ([]berElement, error) {
+	var elements []berElement
+	r := bufio.NewReader(bytes.NewReader(data))
+	for {
+		element, err := readBER(r)
+		if err == io.EOF {
+			return elements, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestEscapeLDAPFilter(t *testing.T) {
+	for _, tc := range []struct {
+		value, want string
+	}{
+		{"bob", "bob"},
+		{"bob*", `bob\2a`},
+		{"*)(uid=*))(|(uid=*", `\2a\29\28uid=\2a\29\29\28|\28uid=\2a`},
+		{`domain\bob`, `domain\5cbob`},
+		{"nul\x00byte", `nul\00byte`},
+		{"cn=Bob Smith,ou=people,dc=example,dc=com", "cn=Bob Smith,ou=people,dc=example,dc=com"},
+		{"", ""},
+	} {
+		if got := escapeLDAPFilter(tc.value); got != tc.want {
+			t.Errorf("escapeLDAPFilter(%q) = %q, want %q", tc.value, got, tc.want)
+		}
+	}
+}
+
+func TestEncodeLDAPFilter(t *testing.T) {
+	for _, tc := range []struct {
+		filter string
+		want   string
+		rest   string
+	}{
+		{"(uid=bob)", "a30a04037569640403626f62", ""},
+		{"(objectClass=*)", "870b6f626a656374436c617373", ""},
+		{"(&(uid=bob)(objectClass=*))", "a019" + "a30a04037569640403626f62" + "870b6f626a656374436c617373", ""},
+		{"(|(uid=bob)(uid=bob))", "a118" + "a30a04037569640403626f62" + "a30a04037569640403626f62", ""},
+		{"(!(uid=bob))", "a20c" + "a30a04037569640403626f62", ""},
+		{"(cn=a*b*c)", "a40f0402636e3009800161810162820163", ""},
+		{"(cn=*b)", "a4090402636e3003820162", ""},
+		{`(cn=a\2ab)`, "a3090402636e0403612a62", ""},
+		{"(uid=bob)(uid=eve)", "a30a04037569640403626f62", "(uid=eve)"},
+	} {
+		got, rest, err := encodeLDAPFilter(tc.filter)
+		if err != nil {
+			t.Errorf("encodeLDAPFilter(%q): %v", tc.filter, err)
+			continue
+		}
+		if hex.EncodeToString(got) != tc.want || rest != tc.rest {
+			t.Errorf("encodeLDAPFilter(%q) = %x, %q, want %s, %q", tc.filter, got, rest, tc.want, tc.rest)
+		}
+	}
+}
+
+func TestEncodeLDAPFilterRejects(t *testing.T) {
+	for _, filter := range []string{
+		"uid=bob",
+		"(",
+		"(uid=bob",
+		"(uid)",
+		"(=bob)",
+		"(uid>=5)",
+		"(uid~=bob)",
+		"(&)",
+		"(!(a=b)(c=d))",
+		`(cn=\zz)`,
+		`(cn=a\2)`,
+	} {
+		if _, _, err := encodeLDAPFilter(filter); err == nil {
+			t.Errorf("encodeLDAPFilter(%q) was accepted", filter)
+		}
+	}
+}
+
+func TestEscapedValueRoundTrips(t *testing.T) {
+	value := `a*(b)\c` + "\x00"
+	got, _, err := encodeLDAPFilter("(uid=" + escapeLDAPFilter(value) + ")")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := berTLV(0xa3, berTLV(0x04, []byte("uid")), berTLV(0x04, []byte(value)))
+	if !bytes.Equal(got, want) {
+		t.Errorf("escaped %q encodes to %x, want an equality match %x", value, got, want)
+	}
+}
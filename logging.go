@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type contextKey string
+
+const requestIDKey contextKey = "request_id"
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// sensitiveKeys are attribute names whose values never reach the log output.
+var sensitiveKeys = map[string]bool{
+	"password":      true,
+	"passphrase":    true,
+	"secret":        true,
+	"token":         true,
+	"authorization": true,
+	"private_key":   true,
+	"cookie":        true,
+}
+
+// commandSecrets match the parts of a remote command that may be secret:
+// container environment values, KEY=value pairs with a secret-looking key,
+// passwords passed to docker login and credentials in URLs.
+var commandSecrets = []*regexp.Regexp{
+	regexp.MustCompile(`((?:^|\s)(?:-e|--env)\s+'[A-Za-z_][A-Za-z0-9_]*=)[^']*`),
+	regexp.MustCompile(`((?:^|\s)(?:-e|--env)(?:\s+|=)[A-Za-z_][A-Za-z0-9_]*=)[^\s']*`),
+	regexp.MustCompile(`(?i)(\b[A-Z0-9_]*(?:PASSWORD|PASSWD|PASSPHRASE|SECRET|TOKEN|API_?KEY|ACCESS_KEY|PRIVATE_KEY|CREDENTIALS?)[A-Z0-9_]*=)(?:'[^']*'|"[^"]*"|[^\s'"]*)`),
+	regexp.MustCompile(`((?:^|\s)--password(?:\s+|=))(?:'[^']*'|\S+)`),
+	regexp.MustCompile(`(docker login\b[^|;&]*?\s-p(?:\s+|=))(?:'[^']*'|\S+)`),
+	regexp.MustCompile(`(://[^/\s:@]+:)[^/\s@]+(@)`),
+	regexp.MustCompile(`(?i)(authorization:\s*(?:bearer|basic)\s+)[^\s'"]+`),
+}
+
+// redactCommand is command with its secrets, see commandSecrets, replaced
+// by [REDACTED], for logs and error messages.
+func redactCommand(command string) string {
+	for _, pattern := range commandSecrets {
+		command = pattern.ReplaceAllString(command, "${1}[REDACTED]$2")
+	}
+	return command
+}
+
+// setupLogging installs the default slog logger. LOG_LEVEL selects
+// debug/info/warn/error and LOG_FORMAT=json switches to JSON lines; debug
+// forces the debug level, which logs every SSH command.
+func setupLogging(debug bool) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(setting("LOG_LEVEL"))); err != nil {
+		level = slog.LevelInfo
+	}
+	if debug {
+		level = slog.LevelDebug
+	}
+
+	opts := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if sensitiveKeys[strings.ToLower(attr.Key)] {
+				return slog.String(attr.Key, "[REDACTED]")
+			}
+			return attr
+		},
+	}
+
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if strings.EqualFold(setting("LOG_FORMAT"), "json") {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+func newRequestID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// loggerFrom returns the default logger tagged with the request ID carried
+// by ctx, if any.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if id := requestIDFrom(ctx); id != "" {
+		return slog.Default().With("request_id", id)
+	}
+	return slog.Default()
+}
+
+// requestIDMiddleware accepts a sane incoming X-Request-ID or generates one,
+// echoes it back and stores it in the request context.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+func loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		loggerFrom(r.Context()).Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"duration", time.Since(start),
+			"remote", r.RemoteAddr,
+		)
+	})
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogOptions map onto the docker logs flags of the same name. Since and
+// Until accept anything docker does: RFC3339 timestamps, Unix timestamps or
+// relative durations such as "10m". Grep filters lines on the server before
+// they are transferred. ANSI is "strip" or "html" to remove or translate
+// escape sequences in messages; anything else passes them through.
+type LogOptions struct {
+	Tail       string
+	Since      string
+	Until      string
+	Grep       string
+	Regex      bool
+	IgnoreCase bool
+	ANSI       string
+}
+
+var logTimePattern = regexp.MustCompile(`^[0-9A-Za-z:.+-]+$`)
+
+// logOptionsFromQuery reads tail, since and until; defaultTail applies when
+// none of them is given. A search covers the whole log unless bounded.
+func logOptionsFromQuery(query url.Values, defaultTail string) (LogOptions, error) {
+	opts := LogOptions{
+		Tail:  query.Get("tail"),
+		Since: query.Get("since"),
+		Until: query.Get("until"),
+		Grep:  query.Get("grep"),
+		Regex: query.Get("regex") == "true",
+		// Matches are case-insensitive unless asked otherwise.
+		IgnoreCase: query.Get("ignore_case") != "false",
+		ANSI:       query.Get("ansi"),
+	}
+	if opts.ANSI != "" && opts.ANSI != "keep" && opts.ANSI != "strip" && opts.ANSI != "html" {
+		return opts, requestErrorf(codeInvalidRequest, "invalid ansi %q: use keep, strip or html", opts.ANSI)
+	}
+	if opts.Grep != "" {
+		defaultTail = "all"
+	}
+	if opts.Tail == "" && opts.Since == "" && opts.Until == "" {
+		opts.Tail = defaultTail
+	}
+	if opts.Tail != "" && opts.Tail != "all" {
+		if n, err := strconv.Atoi(opts.Tail); err != nil || n < 0 {
+			return opts, requestErrorf(codeInvalidRequest, "invalid tail %q", opts.Tail)
+		}
+	}
+	for name, value := range map[string]string{"since": opts.Since, "until": opts.Until} {
+		if value != "" && !logTimePattern.MatchString(value) {
+			return opts, requestErrorf(codeInvalidRequest, "invalid %s %q", name, value)
+		}
+	}
+	return opts, nil
+}
+
+func (opts LogOptions) args() string {
+	var args []string
+	if opts.Tail != "" {
+		args = append(args, "--tail", shellQuote(opts.Tail))
+	}
+	if opts.Since != "" {
+		args = append(args, "--since", shellQuote(opts.Since))
+	}
+	if opts.Until != "" {
+		args = append(args, "--until", shellQuote(opts.Until))
+	}
+	return strings.Join(args, " ")
+}
+
+// pipeline returns the shell pipeline that reads the container's plain logs,
+// including the grep filter if one is set. grep exits 1 when nothing
+// matched, which is not a failure here.
+func (opts LogOptions) pipeline(containerID string, follow bool) string {
+	command := "docker logs "
+	if follow {
+		command += "-f "
+	}
+	command += opts.args() + " " + shellQuote(containerID) + " 2>&1"
+	if opts.Grep == "" {
+		return command
+	}
+
+	flags := "-F"
+	if opts.Regex {
+		flags = "-E"
+	}
+	if opts.IgnoreCase {
+		flags += " -i"
+	}
+	if follow {
+		flags += " --line-buffered"
+	}
+	return fmt.Sprintf("%s | { grep %s -e %s; [ $? -le 1 ]; }", command, flags, shellQuote(opts.Grep))
+}
+
+// ContainerLog is one line of `docker logs -t` output.
+type ContainerLog struct {
+	Container string    `json:"container,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Stream    string    `json:"stream"`
+	Message   string    `json:"message"`
+}
+
+// logTagScript prefixes every line with its stream name (the awk variable s)
+// and applies the grep filter from $LOG_GREP to the message, leaving out the
+// timestamp docker puts in front. fflush keeps followed output line by line.
+func (opts LogOptions) logTagScript() string {
+	match := "1"
+	if opts.Grep != "" {
+		msg, pattern := "msg", `ENVIRON["LOG_GREP"]`
+		if opts.IgnoreCase {
+			msg, pattern = "tolower(msg)", "tolower("+pattern+")"
+		}
+		if opts.Regex {
+			match = msg + " ~ " + pattern
+		} else {
+			match = "index(" + msg + ", " + pattern + ")"
+		}
+	}
+	return `{ msg = substr($0, index($0, " ") + 1) } ` + match + ` { print s " " $0; fflush() }`
+}
+
+// structuredPipeline runs docker logs -t with stdout and stderr each passed
+// through their own tagging awk, so both streams arrive on one channel (and
+// through a PTY when following) but stay distinguishable.
+func (opts LogOptions) structuredPipeline(containerID string, follow bool) string {
+	command := "docker logs -t "
+	if follow {
+		command += "-f "
+	}
+	command += opts.args() + " " + shellQuote(containerID)
+	script := shellQuote(opts.logTagScript())
+	return fmt.Sprintf("export LOG_GREP=%s; { { %s 2>&1 1>&3 | awk -v s=stderr %s 1>&4; } 3>&1 | awk -v s=stdout %s; } 4>&1",
+		shellQuote(opts.Grep), command, script, script)
+}
+
+func (opts LogOptions) render(message string) string {
+	switch opts.ANSI {
+	case "strip":
+		return stripANSI(message)
+	case "html":
+		return ansiToHTML(message)
+	}
+	return message
+}
+
+func parseContainerLog(line string) (ContainerLog, bool) {
+	stream, rest, ok := strings.Cut(strings.TrimSuffix(line, "\r"), " ")
+	if !ok || (stream != "stdout" && stream != "stderr") {
+		return ContainerLog{}, false
+	}
+	entry := ContainerLog{Stream: stream, Message: rest}
+	if timestamp, message, ok := strings.Cut(rest, " "); ok {
+		if t, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+			entry.Timestamp, entry.Message = t, message
+		}
+	}
+	return entry, true
+}
+
+func (dm *DockerManager) LogContainers(containerID string, opts LogOptions) ([]ContainerLog, error) {
+	// Errors from docker logs itself would come out as stderr lines.
+	if _, err := dm.executeSSHCommand("docker inspect --format '{{.Id}}' " + shellQuote(containerID)); err != nil {
+		return nil, lookupFailed(err, codeContainerNotFound, fmt.Sprintf("container %s not found", containerID))
+	}
+	entries, err := dm.readContainerLogs(containerID, opts)
+	if err != nil {
+		return nil, err
+	}
+	sortContainerLogs(entries)
+	return entries, nil
+}
+
+func (dm *DockerManager) readContainerLogs(containerID string, opts LogOptions) ([]ContainerLog, error) {
+	output, err := dm.executeSSHCommand(opts.structuredPipeline(containerID, false))
+	if err != nil {
+		return nil, err
+	}
+	entries := []ContainerLog{}
+	for _, line := range strings.Split(output, "\n") {
+		if entry, ok := parseContainerLog(line); ok {
+			entry.Message = opts.render(entry.Message)
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// sortContainerLogs orders entries by time. Streams and containers are read
+// independently, so their lines arrive in no particular order.
+func sortContainerLogs(entries []ContainerLog) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp.Before(entries[j].Timestamp)
+	})
+}
+
+// FollowContainerLogs streams the container's output, starting with the
+// lines selected by opts, until fn fails or ctx is cancelled.
+func (dm *DockerManager) FollowContainerLogs(ctx context.Context, containerID string, opts LogOptions, fn func(ContainerLog) error) error {
+	return dm.followSSHLines(ctx, opts.structuredPipeline(containerID, true), func(line string) error {
+		if entry, ok := parseContainerLog(line); ok {
+			entry.Message = opts.render(entry.Message)
+			return fn(entry)
+		}
+		return nil
+	})
+}
+
+// maxLogContainers bounds the SSH sessions a single aggregated request opens.
+const maxLogContainers = 20
+
+// logContainerNames resolves the given containers, or those of a compose
+// project, to their names.
+func (dm *DockerManager) logContainerNames(containers []string, project string) ([]string, error) {
+	if project != "" {
+		list, err := dm.GetContainers(false, "label=com.docker.compose.project="+project)
+		if err != nil {
+			return nil, err
+		}
+		for _, container := range list {
+			containers = append(containers, container.Name)
+		}
+		if len(list) == 0 {
+			return nil, fmt.Errorf("no containers found for project %s", project)
+		}
+	}
+	if len(containers) == 0 {
+		return nil, requestErrorf(codeInvalidRequest, "containers or project is required")
+	}
+	if len(containers) > maxLogContainers {
+		return nil, fmt.Errorf("at most %d containers can be followed at once", maxLogContainers)
+	}
+
+	quoted := make([]string, len(containers))
+	for i, container := range containers {
+		quoted[i] = shellQuote(container)
+	}
+	output, err := dm.executeSSHCommand("docker inspect --format '{{.Name}}' " + strings.Join(quoted, " "))
+	if err != nil {
+		return nil, lookupFailed(err, codeContainerNotFound, fmt.Sprintf("container not found: %v", err))
+	}
+	names := []string{}
+	for _, name := range strings.Split(strings.TrimSpace(output), "\n") {
+		names = append(names, strings.TrimPrefix(strings.TrimSpace(name), "/"))
+	}
+	return names, nil
+}
+
+// AggregateContainerLogs reads the logs of all containers at once and merges
+// them by time, like docker compose logs.
+func (dm *DockerManager) AggregateContainerLogs(containers []string, opts LogOptions) ([]ContainerLog, error) {
+	type result struct {
+		entries []ContainerLog
+		err     error
+	}
+	results := make([]result, len(containers))
+	var wg sync.WaitGroup
+	for i, container := range containers {
+		wg.Add(1)
+		go func(i int, container string) {
+			defer wg.Done()
+			entries, err := dm.readContainerLogs(container, opts)
+			for j := range entries {
+				entries[j].Container = container
+			}
+			results[i] = result{entries, err}
+		}(i, container)
+	}
+	wg.Wait()
+
+	entries := []ContainerLog{}
+	for i, result := range results {
+		if result.err != nil {
+			return nil, fmt.Errorf("%s: %v", containers[i], result.err)
+		}
+		entries = append(entries, result.entries...)
+	}
+	sortContainerLogs(entries)
+	return entries, nil
+}
+
+// FollowAggregateLogs follows all containers at once and passes their lines
+// to fn as they arrive. A container whose stream ends is logged and dropped;
+// the call returns once none are left, fn fails or ctx is cancelled.
+func (dm *DockerManager) FollowAggregateLogs(ctx context.Context, containers []string, opts LogOptions, fn func(ContainerLog) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	lines := make(chan ContainerLog)
+	var wg sync.WaitGroup
+	for _, container := range containers {
+		wg.Add(1)
+		go func(container string) {
+			defer wg.Done()
+			err := dm.FollowContainerLogs(ctx, container, opts, func(entry ContainerLog) error {
+				entry.Container = container
+				select {
+				case lines <- entry:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			})
+			if err != nil && ctx.Err() == nil {
+				dm.logger.Error("log stream failed", "container", container, "error", err)
+			}
+		}(container)
+	}
+	go func() {
+		wg.Wait()
+		close(lines)
+	}()
+
+	for entry := range lines {
+		if err := fn(entry); err != nil {
+			cancel()
+			for range lines {
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// aggregateLogsHandler serves the logs of several containers, given as
+// ?containers=a,b or ?project= for a compose project, interleaved in one
+// response or stream. Each entry carries its container's name.
+func aggregateLogsHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+
+	opts, err := logOptionsFromQuery(query, "20")
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	var containers []string
+	for _, container := range strings.Split(query.Get("containers"), ",") {
+		if container = strings.TrimSpace(container); container != "" {
+			containers = append(containers, container)
+		}
+	}
+	containers, err = manager.logContainerNames(containers, query.Get("project"))
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	if query.Get("follow") != "true" {
+		entries, err := manager.AggregateContainerLogs(containers, opts)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		var logs strings.Builder
+		for _, entry := range entries {
+			logs.WriteString(entry.Container + " | " + entry.Message + "\n")
+		}
+		response := map[string]interface{}{
+			"success":    true,
+			"containers": containers,
+			"logs":       logs.String(),
+			"entries":    entries,
+		}
+		if opts.Grep != "" {
+			response["matches"] = len(entries)
+		}
+		writeJSON(w, response)
+		return
+	}
+
+	if !startSSE(w) {
+		return
+	}
+	manager.logger.Info("following aggregated logs", "containers", containers)
+	err = manager.FollowAggregateLogs(r.Context(), containers, opts, func(entry ContainerLog) error {
+		return writeSSE(w, "log", "", entry)
+	})
+	if err != nil && r.Context().Err() == nil {
+		writeSSE(w, "error", "", map[string]string{"error": err.Error()})
+	}
+	manager.logger.Info("aggregated log stream closed", "containers", containers)
+}
+
+func logsHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+	containerID := mux.Vars(r)["id"]
+
+	opts, err := logOptionsFromQuery(r.URL.Query(), "20")
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	if r.URL.Query().Get("follow") != "true" {
+		entries, err := manager.LogContainers(containerID, opts)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		var logs strings.Builder
+		for _, entry := range entries {
+			logs.WriteString(entry.Message + "\n")
+		}
+		response := map[string]interface{}{
+			"success": true,
+			"logs":    logs.String(),
+			"entries": entries,
+		}
+		if opts.Grep != "" {
+			response["matches"] = len(entries)
+		}
+		writeJSON(w, response)
+		return
+	}
+
+	if !startSSE(w) {
+		return
+	}
+	manager.logger.Info("following container logs", "container", containerID)
+	err = manager.FollowContainerLogs(r.Context(), containerID, opts, func(entry ContainerLog) error {
+		return writeSSE(w, "log", "", entry)
+	})
+	if err != nil && r.Context().Err() == nil {
+		manager.logger.Error("log stream failed", "container", containerID, "error", err)
+		writeSSE(w, "error", "", map[string]string{"error": err.Error()})
+	}
+	manager.logger.Info("log stream closed", "container", containerID)
+}
+
+// DownloadContainerLogs writes the selected logs gzip-compressed to w. The
+// compression happens on the server so only the compressed stream crosses
+// the SSH connection, which is also why escapes are stripped there by sed.
+func (dm *DockerManager) DownloadContainerLogs(ctx context.Context, containerID string, opts LogOptions, w io.Writer) error {
+	command := opts.pipeline(containerID, false)
+	if opts.ANSI == "strip" {
+		command += ` | sed "s/$(printf '\033')\[[0-?]*[ -/]*[@-~]//g"`
+	}
+	command += " | gzip -c"
+	return dm.streamSSHCommand(ctx, command, nil, w)
+}
+
+func logsDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+	containerID := mux.Vars(r)["id"]
+
+	opts, err := logOptionsFromQuery(r.URL.Query(), "all")
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	if opts.ANSI == "html" {
+		writeError(w, codeInvalidRequest, "ansi=html is not supported for downloads")
+		return
+	}
+
+	// The pipeline's status is gzip's, so a missing container has to be
+	// caught up front.
+	name, err := manager.executeSSHCommand("docker inspect --format '{{.Name}}' " + shellQuote(containerID))
+	if err != nil {
+		writeFailure(w, lookupFailed(err, codeContainerNotFound, "Container not found: "+containerID))
+		return
+	}
+
+	filename := fmt.Sprintf("%s-%s.log.gz", strings.TrimPrefix(strings.TrimSpace(name), "/"), time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	manager.logger.Info("downloading container logs", "container", containerID)
+	if err := manager.DownloadContainerLogs(r.Context(), containerID, opts, w); err != nil {
+		manager.logger.Error("log download failed", "container", containerID, "error", err)
+	}
+}
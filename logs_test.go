@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseContainerLog(t *testing.T) {
+	stamp := time.Date(2024, 5, 1, 14, 0, 0, 123456789, time.UTC)
+	for _, tc := range []struct {
+		line string
+		want ContainerLog
+		ok   bool
+	}{
+		{"stdout 2024-05-01T14:00:00.123456789Z GET / 200", ContainerLog{Stream: "stdout", Timestamp: stamp, Message: "GET / 200"}, true},
+		{"stderr 2024-05-01T14:00:00.123456789Z panic: boom\r", ContainerLog{Stream: "stderr", Timestamp: stamp, Message: "panic: boom"}, true},
+		{"stdout 2024-05-01T14:00:00.123456789Z ", ContainerLog{Stream: "stdout", Timestamp: stamp}, true},
+		{"stdout not-a-time message", ContainerLog{Stream: "stdout", Message: "not-a-time message"}, true},
+		{"stdout untimestamped", ContainerLog{Stream: "stdout", Message: "untimestamped"}, true},
+		{"stdin 2024-05-01T14:00:00Z typed", ContainerLog{}, false},
+		{"Error response from daemon: No such container: web", ContainerLog{}, false},
+		{"stdout", ContainerLog{}, false},
+		{"", ContainerLog{}, false},
+	} {
+		got, ok := parseContainerLog(tc.line)
+		if ok != tc.ok || got.Stream != tc.want.Stream || got.Message != tc.want.Message || !got.Timestamp.Equal(tc.want.Timestamp) {
+			t.Errorf("parseContainerLog(%q) = %+v, %v, want %+v, %v", tc.line, got, ok, tc.want, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// permanentError marks a delivery failure that retrying will not fix, such as
+// a request the receiver rejected as invalid.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+
+// checkSinkResponse treats throttling and server errors as temporary.
+func checkSinkResponse(resp *http.Response, name string) error {
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	err := fmt.Errorf("%s returned %s", name, resp.Status)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return err
+	}
+	return permanentError{err}
+}
+
+// defaultSinkPath completes a base URL to the given endpoint; URLs that
+// already have a path are used as they are.
+func defaultSinkPath(rawURL, endpoint string) string {
+	if u, err := url.Parse(rawURL); err == nil && strings.Trim(u.Path, "/") == "" {
+		u.Path = endpoint
+		return u.String()
+	}
+	return rawURL
+}
+
+func postSink(ctx context.Context, target, contentType string, body []byte, header map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", target, bytes.NewReader(body))
+	if err != nil {
+		return nil, permanentError{err}
+	}
+	req.Header.Set("Content-Type", contentType)
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	return client.Do(req)
+}
+
+func lineTime(line forwardedLog) time.Time {
+	if line.Timestamp.IsZero() {
+		return time.Now()
+	}
+	return line.Timestamp
+}
+
+type lokiSink struct {
+	forwarder *LogForwarder
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func (s *lokiSink) Send(ctx context.Context, batch []forwardedLog) error {
+	streams := map[string]*lokiStream{}
+	var order []string
+	for _, line := range batch {
+		key := line.Container + "|" + line.Image + "|" + line.Stream
+		stream, ok := streams[key]
+		if !ok {
+			labels := map[string]string{}
+			for name, value := range s.forwarder.Labels {
+				labels[name] = value
+			}
+			labels["server"] = s.forwarder.Server
+			labels["container"] = line.Container
+			labels["image"] = line.Image
+			labels["stream"] = line.Stream
+			stream = &lokiStream{Stream: labels}
+			streams[key] = stream
+			order = append(order, key)
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(lineTime(line).UnixNano(), 10), line.Message})
+	}
+
+	payload := map[string][]*lokiStream{"streams": {}}
+	for _, key := range order {
+		payload["streams"] = append(payload["streams"], streams[key])
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return permanentError{err}
+	}
+
+	header := map[string]string{}
+	if s.forwarder.Tenant != "" {
+		header["X-Scope-OrgID"] = s.forwarder.Tenant
+	}
+	resp, err := postSink(ctx, defaultSinkPath(s.forwarder.URL, "/loki/api/v1/push"), "application/json", body, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkSinkResponse(resp, "loki")
+}
+
+// syslogSink sends RFC 5424 messages over UDP, or over TCP with octet
+// counting framing (RFC 6587). stderr lines are sent with error severity.
+type syslogSink struct {
+	forwarder *LogForwarder
+}
+
+const syslogFacilityUser = 1
+
+// syslogEscaper escapes structured data parameter values.
+var syslogEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+func (s *syslogSink) structuredData() string {
+	if len(s.forwarder.Labels) == 0 {
+		return "-"
+	}
+	params := []string{"rdm@32473"}
+	for _, name := range sortedKeys(s.forwarder.Labels) {
+		params = append(params, name+`="`+syslogEscaper.Replace(s.forwarder.Labels[name])+`"`)
+	}
+	return "[" + strings.Join(params, " ") + "]"
+}
+
+func (s *syslogSink) Send(ctx context.Context, batch []forwardedLog) error {
+	u, err := url.Parse(s.forwarder.URL)
+	if err != nil {
+		return permanentError{err}
+	}
+	var dialer net.Dialer
+	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	conn, err := dialer.DialContext(dialCtx, u.Scheme, u.Host)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	hostname := s.forwarder.Server
+	if host, _, err := net.SplitHostPort(hostname); err == nil {
+		hostname = host
+	}
+	data := s.structuredData()
+
+	var buf bytes.Buffer
+	for _, line := range batch {
+		severity := 6
+		if line.Stream == "stderr" {
+			severity = 3
+		}
+		msg := fmt.Sprintf("<%d>1 %s %s %s - - %s %s", syslogFacilityUser*8+severity,
+			lineTime(line).UTC().Format("2006-01-02T15:04:05.000000Z07:00"), hostname, line.Container, data, line.Message)
+		if u.Scheme == "udp" {
+			if _, err := conn.Write([]byte(msg)); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Fprintf(&buf, "%d %s", len(msg), msg)
+	}
+	if buf.Len() > 0 {
+		_, err = conn.Write(buf.Bytes())
+	}
+	return err
+}
+
+// elasticsearchSink indexes one document per line through the bulk API.
+type elasticsearchSink struct {
+	forwarder *LogForwarder
+}
+
+func (s *elasticsearchSink) Send(ctx context.Context, batch []forwardedLog) error {
+	index := s.forwarder.Index
+	if index == "" {
+		index = "rdm-logs"
+	}
+	action, _ := json.Marshal(map[string]interface{}{"index": map[string]string{"_index": index}})
+
+	var body bytes.Buffer
+	for _, line := range batch {
+		doc, err := json.Marshal(map[string]interface{}{
+			"@timestamp": lineTime(line).UTC().Format(time.RFC3339Nano),
+			"message":    line.Message,
+			"stream":     line.Stream,
+			"container":  line.Container,
+			"image":      line.Image,
+			"server":     s.forwarder.Server,
+			"labels":     s.forwarder.Labels,
+		})
+		if err != nil {
+			return permanentError{err}
+		}
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(doc)
+		body.WriteByte('\n')
+	}
+
+	resp, err := postSink(ctx, defaultSinkPath(s.forwarder.URL, "/_bulk"), "application/x-ndjson", body.Bytes(), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkSinkResponse(resp, "elasticsearch"); err != nil {
+		return err
+	}
+
+	// The bulk API reports rejected documents in a successful response.
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int `json:"status"`
+			Error  struct {
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || !result.Errors {
+		return nil
+	}
+	rejected, reason := 0, ""
+	for _, item := range result.Items {
+		for _, status := range item {
+			if status.Status >= 300 {
+				rejected++
+				if reason == "" {
+					reason = status.Error.Reason
+				}
+			}
+		}
+	}
+	return permanentError{fmt.Errorf("elasticsearch rejected %d of %d documents: %s", rejected, len(batch), reason)}
+}
@@ -1,15 +1,22 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"github.com/gorilla/mux"
 	"golang.org/x/crypto/ssh"
-	"html/template"
-	"log"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -21,40 +28,123 @@ type ServerConfig struct {
 	Password string `json:"password"`
 }
 
+// ID identifies the server in /api/servers/{sid} routes.
+func (c *ServerConfig) ID() string {
+	return c.Host + ":" + c.Port
+}
+
+// MarshalJSON and LogValue leave the password out, so that a config sent
+// in a response or logged never carries it.
+func (c ServerConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{"host": c.Host, "port": c.Port, "username": c.Username})
+}
+
+func (c ServerConfig) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("host", c.Host), slog.String("port", c.Port), slog.String("username", c.Username))
+}
+
 type Container struct {
-	ID      string `json:"id"`
-	Name    string `json:"name"`
-	Image   string `json:"image"`
-	Status  string `json:"status"`
-	State   string `json:"state"`
-	Created string `json:"created"`
-	Ports   string `json:"ports"`
+	ID             string            `json:"id"`
+	Name           string            `json:"name"`
+	Image          string            `json:"image"`
+	Status         string            `json:"status"`
+	State          string            `json:"state"`
+	Health         string            `json:"health"`
+	Created        time.Time         `json:"created"`
+	Age            string            `json:"age"`
+	Ports          []PortMapping     `json:"ports"`
+	PortsDisplay   string            `json:"ports_display"`
+	Labels         map[string]string `json:"labels"`
+	Protected      bool              `json:"protected"`
+	RestartCount   int               `json:"restart_count"`
+	RecentRestarts int               `json:"recent_restarts"`
+	OOMKilled      bool              `json:"oom_killed"`
+	CrashLooping   bool              `json:"crash_looping"`
+	SizeRw         int64             `json:"size_rw,omitempty"`
+	SizeVirtual    int64             `json:"size_virtual,omitempty"`
+	SizeDisplay    string            `json:"size_display,omitempty"`
 }
 
 type DockerManager struct {
 	config *ServerConfig
+	logger *slog.Logger
+	ctx    context.Context
+}
+
+func newDockerManager(config *ServerConfig) *DockerManager {
+	return &DockerManager{
+		config: config,
+		logger: slog.Default().With("server", config.ID()),
+		ctx:    context.Background(),
+	}
 }
 
-func (dm *DockerManager) executeSSHCommand(command string) (string, error) {
+// withRequest returns a copy of the manager whose logs carry the request ID
+// and whose SSH commands are traced as children of the request's span.
+func (dm *DockerManager) withRequest(r *http.Request) *DockerManager {
+	scoped := *dm
+	scoped.ctx = r.Context()
+	if id := requestIDFrom(r.Context()); id != "" {
+		scoped.logger = dm.logger.With("request_id", id)
+	}
+	return &scoped
+}
+
+// sshTimeout bounds connecting to a server; sshDefaultPort is used when a
+// server is configured without a port.
+var (
+	sshTimeout     = envDuration("SSH_TIMEOUT", 30*time.Second)
+	sshDefaultPort = envString("SSH_DEFAULT_PORT", "22")
+)
+
+func (dm *DockerManager) dial() (*ssh.Client, error) {
 	config := &ssh.ClientConfig{
 		User: dm.config.Username,
 		Auth: []ssh.AuthMethod{
 			ssh.Password(dm.config.Password),
 		},
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         30 * time.Second,
+		Timeout:         sshTimeout,
 	}
 
 	address := dm.config.Host + ":" + dm.config.Port
 	client, err := ssh.Dial("tcp", address, config)
 	if err != nil {
-		return "", fmt.Errorf("SSH connection to %s failed: %v", address, err)
+		return nil, &CommandError{Code: dialErrorCode(err), Message: fmt.Sprintf("SSH connection to %s failed: %v", address, err), Err: err}
+	}
+	trackSSHClient(client)
+	return client, nil
+}
+
+func (dm *DockerManager) executeSSHCommand(command string) (output string, err error) {
+	_, span := startSpan(dm.ctx, "ssh "+commandCategory(command), spanKindClient,
+		"server", dm.config.ID(),
+		"ssh.command.category", commandCategory(command),
+	)
+	defer func(start time.Time) {
+		span.End(err)
+		observeSSHCommand(dm.config.ID(), command, start, err)
+		if changesContainers(command) {
+			containerCache.invalidate(dm.config.ID())
+		}
+		if err != nil {
+			dm.logger.Debug("ssh command failed", "category", commandCategory(command), "command", redactCommand(command),
+				"duration", time.Since(start), "code", errorCodeOf(err), "error", err)
+		} else {
+			dm.logger.Debug("ssh command", "category", commandCategory(command), "command", redactCommand(command),
+				"duration", time.Since(start), "output_bytes", len(output))
+		}
+	}(time.Now())
+
+	client, err := dm.dial()
+	if err != nil {
+		return "", err
 	}
 	defer client.Close()
 
 	session, err := client.NewSession()
 	if err != nil {
-		return "", fmt.Errorf("SSH session creation failed: %v", err)
+		return "", &CommandError{Code: codeSSHConnectionFailed, Message: fmt.Sprintf("SSH session creation failed: %v", err), Err: err}
 	}
 	defer session.Close()
 
@@ -64,40 +154,157 @@ func (dm *DockerManager) executeSSHCommand(command string) (string, error) {
 	session.Stderr = &stderr
 
 	if err := session.Run(command); err != nil {
-		errorOutput := stderr.String()
-		if errorOutput != "" {
-			return "", fmt.Errorf("command '%s' failed: %v, stderr: %s", command, err, errorOutput)
+		return "", commandFailed(command, err, stderr.String())
+	}
+
+	return stdout.String(), nil
+}
+
+// streamSSHCommand runs command with stdin and stdout wired straight to the
+// SSH session, so large transfers are never held in memory. The connection is
+// torn down as soon as ctx is cancelled.
+func (dm *DockerManager) streamSSHCommand(ctx context.Context, command string, stdin io.Reader, stdout io.Writer) error {
+	return dm.streamSSH(ctx, command, stdin, stdout, false)
+}
+
+func (dm *DockerManager) streamSSH(ctx context.Context, command string, stdin io.Reader, stdout io.Writer, pty bool) (err error) {
+	_, span := startSpan(ctx, "ssh stream "+commandCategory(command), spanKindClient,
+		"server", dm.config.ID(),
+		"ssh.command.category", commandCategory(command),
+	)
+	defer func() {
+		span.End(err)
+		if changesContainers(command) {
+			containerCache.invalidate(dm.config.ID())
 		}
-		return "", fmt.Errorf("command '%s' failed: %v", command, err)
+	}()
+
+	client, err := dm.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return &CommandError{Code: codeSSHConnectionFailed, Message: fmt.Sprintf("SSH session creation failed: %v", err), Err: err}
+	}
+	defer session.Close()
+
+	if pty {
+		// Output post-processing would turn every "\n" into "\r\n".
+		if err := session.RequestPty("dumb", 24, 200, ssh.TerminalModes{ssh.ECHO: 0, ssh.OPOST: 0}); err != nil {
+			return fmt.Errorf("failed to allocate terminal: %v", err)
+		}
+	}
+
+	var stderr bytes.Buffer
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = &stderr
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			client.Close()
+		case <-done:
+		}
+	}()
+
+	if err := session.Run(command); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return commandFailed(command, err, stderr.String())
 	}
+	return nil
+}
+
+// streamSSHLines runs command like streamSSHCommand and calls fn for every
+// line of its output as soon as it arrives. Returning an error from fn stops
+// the command.
+func (dm *DockerManager) streamSSHLines(ctx context.Context, command string, fn func(line string) error) error {
+	return dm.scanSSHLines(ctx, command, false, fn)
+}
 
-	output := stdout.String()
-	return output, nil
+// followSSHLines is streamSSHLines for commands that never end on their own,
+// such as docker logs -f. The session gets a terminal so the remote process
+// receives SIGHUP when the connection is closed instead of lingering until
+// its next write fails.
+func (dm *DockerManager) followSSHLines(ctx context.Context, command string, fn func(line string) error) error {
+	return dm.scanSSHLines(ctx, command, true, fn)
 }
 
-func (dm *DockerManager) GetContainers() ([]Container, error) {
+func (dm *DockerManager) scanSSHLines(ctx context.Context, command string, pty bool, fn func(line string) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	reader, writer := io.Pipe()
+	result := make(chan error, 1)
+	go func() {
+		err := dm.streamSSH(ctx, command, nil, writer, pty)
+		writer.CloseWithError(err)
+		result <- err
+	}()
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if err := fn(scanner.Text()); err != nil {
+			cancel()
+			reader.Close()
+			<-result
+			return err
+		}
+	}
+	reader.Close()
+	return <-result
+}
+
+// shellQuote wraps s in single quotes for safe use in a remote shell command.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// GetContainers lists all containers; filters are passed to docker ps as
+// --filter arguments, e.g. "label=com.docker.compose.project=web". Sizes are
+// opt-in because docker has to walk every writable layer to compute them.
+func (dm *DockerManager) GetContainers(withSize bool, filters ...string) ([]Container, error) {
 	_, err := dm.executeSSHCommand("which docker")
 	if err != nil {
-		return []Container{}, fmt.Errorf("Docker is not installed or not in PATH: %v", err)
+		// which only exits non-zero, so a failure without a code is docker
+		// missing rather than the connection.
+		return []Container{}, lookupFailed(err, codeDockerNotInstalled, fmt.Sprintf("Docker is not installed or not in PATH: %v", err))
 	}
 
-	_, err = dm.executeSSHCommand("docker info")
+	_, err = dm.executeSSHCommand("docker info --format '{{.ServerVersion}}'")
 	if err != nil {
-		return []Container{}, fmt.Errorf("Docker daemon is not running or permission denied: %v", err)
+		return []Container{}, fmt.Errorf("Docker daemon is not running or permission denied: %w", err)
 	}
 
 	output, err := dm.executeSSHCommand("docker ps -a")
 	if err != nil {
-		return []Container{}, fmt.Errorf("Docker ps command failed: %v", err)
+		return []Container{}, fmt.Errorf("Docker ps command failed: %w", err)
 	}
 
 	if strings.TrimSpace(output) == "" {
 		return []Container{}, fmt.Errorf("Docker ps returned empty output")
 	}
 
-	formattedOutput, err := dm.executeSSHCommand("docker ps -a --format '{{.ID}}|{{.Names}}|{{.Image}}|{{.Status}}|{{.CreatedAt}}|{{.Ports}}'")
+	listCommand := "docker ps -a"
+	for _, filter := range filters {
+		listCommand += " --filter " + shellQuote(filter)
+	}
+	format := "{{.ID}}|{{.Names}}|{{.Image}}|{{.Status}}|{{.CreatedAt}}|{{.Ports}}"
+	if withSize {
+		listCommand += " --size"
+		format += "|{{.Size}}"
+	}
+	formattedOutput, err := dm.executeSSHCommand(listCommand + " --format '" + format + "'")
 	if err != nil {
-		return []Container{}, fmt.Errorf("Docker ps formatted command failed: %v", err)
+		return []Container{}, fmt.Errorf("Docker ps formatted command failed: %w", err)
 	}
 
 	lines := strings.Split(formattedOutput, "\n")
@@ -117,267 +324,154 @@ func (dm *DockerManager) GetContainers() ([]Container, error) {
 				Image:   strings.TrimSpace(parts[2]),
 				Status:  strings.TrimSpace(parts[3]),
 				State:   getStateFromStatus(strings.TrimSpace(parts[3])),
-				Created: strings.TrimSpace(parts[4]),
-				Ports:   "",
+				Health:  parseHealth(parts[3]),
+				Created: parseCreatedAt(parts[4]),
+				Ports:   []PortMapping{},
+				Labels:  map[string]string{},
 			}
 			if len(parts) > 5 {
-				container.Ports = strings.TrimSpace(parts[5])
+				container.PortsDisplay = strings.TrimSpace(parts[5])
+				container.Ports = parsePorts(container.PortsDisplay)
+			}
+			if len(parts) > 6 {
+				container.SizeDisplay = strings.TrimSpace(parts[6])
+				container.SizeRw, container.SizeVirtual = parseContainerSize(container.SizeDisplay)
+			}
+			if !container.Created.IsZero() {
+				container.Age = humanAge(time.Since(container.Created))
 			}
 			containers = append(containers, container)
 		}
 	}
 
+	details := dm.containerDetails(containers)
+	protections := storedProtections(dm.config.ID())
+	deaths := deathsSince(dm.config.ID(), time.Now().Add(-crashLoopWindow))
+	for i := range containers {
+		if detail, ok := details[containers[i].ID]; ok {
+			containers[i].Labels = detail.Labels
+			containers[i].RestartCount = detail.RestartCount
+			containers[i].OOMKilled = detail.OOMKilled
+		}
+		_, stored := protections[containers[i].Name]
+		containers[i].Protected = stored || protectLabelSet(containers[i].Labels)
+		containers[i].RecentRestarts = deaths[containers[i].Name]
+		containers[i].CrashLooping = crashLooping(&containers[i])
+	}
+
 	return containers, nil
 }
 
 func getStateFromStatus(status string) string {
+	if strings.HasPrefix(status, "Restarting") {
+		return "restarting"
+	}
 	if strings.Contains(strings.ToLower(status), "up") {
 		return "running"
 	}
 	return "stopped"
 }
 
+// parseCreatedAt parses docker's CreatedAt, e.g. "2024-05-01 14:00:00 +0400
+// +04". It is in the remote host's timezone, which the result keeps; the zone
+// abbreviation is left out since hosts without one repeat the offset.
+func parseCreatedAt(value string) time.Time {
+	fields := strings.Fields(value)
+	if len(fields) < 3 {
+		return time.Time{}
+	}
+	t, err := time.Parse("2006-01-02 15:04:05 -0700", strings.Join(fields[:3], " "))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// humanAge words how long ago something happened the way docker ps does,
+// e.g. "3 days ago".
+func humanAge(d time.Duration) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return "1 " + unit + " ago"
+		}
+		return fmt.Sprintf("%d %ss ago", n, unit)
+	}
+	switch hours := d.Hours(); {
+	case d < time.Minute:
+		return "Less than a minute ago"
+	case d < 2*time.Minute:
+		return "About a minute ago"
+	case d < time.Hour:
+		return plural(int(d.Minutes()), "minute")
+	case d < 2*time.Hour:
+		return "About an hour ago"
+	case hours < 48:
+		return plural(int(hours), "hour")
+	case hours < 24*14:
+		return plural(int(hours/24), "day")
+	case hours < 24*60:
+		return plural(int(hours/24/7), "week")
+	case hours < 24*365*2:
+		return plural(int(hours/24/30), "month")
+	default:
+		return plural(int(hours/24/365), "year")
+	}
+}
+
+// containerActionCommands are the docker commands behind the simple
+// container actions.
+var containerActionCommands = map[string]string{
+	"start":   "docker start",
+	"stop":    "docker stop",
+	"restart": "docker restart",
+	"remove":  "docker rm -f",
+}
+
+func containerActionCommand(action, containerID string) string {
+	return containerActionCommands[action] + " " + shellQuote(containerID)
+}
+
 func (dm *DockerManager) StartContainer(containerID string) error {
-	_, err := dm.executeSSHCommand(fmt.Sprintf("docker start %s", containerID))
+	_, err := dm.executeSSHCommand(containerActionCommand("start", containerID))
 	return err
 }
 
 func (dm *DockerManager) StopContainer(containerID string) error {
-	_, err := dm.executeSSHCommand(fmt.Sprintf("docker stop %s", containerID))
+	_, err := dm.executeSSHCommand(containerActionCommand("stop", containerID))
 	return err
 }
 
 func (dm *DockerManager) RestartContainer(containerID string) error {
-	_, err := dm.executeSSHCommand(fmt.Sprintf("docker restart %s", containerID))
+	_, err := dm.executeSSHCommand(containerActionCommand("restart", containerID))
 	return err
 }
 
-func (dm *DockerManager) RemoveContainer(containerID string) error {
-	_, err := dm.executeSSHCommand(fmt.Sprintf("docker rm -f %s", containerID))
-	return err
-}
+var signalPattern = regexp.MustCompile(`^[A-Za-z0-9+-]+$`)
 
-var dockerManager *DockerManager
-
-const htmlTemplate = `
-<!DOCTYPE html>
-<html>
-<head>
-    <title>Remote Docker Manager</title>
-    <style>
-        body { font-family: Arial, sans-serif; margin: 20px; background-color: #f5f5f5; }
-        .container { max-width: 1200px; margin: 0 auto; background: white; padding: 20px; border-radius: 8px; box-shadow: 0 2px 10px rgba(0,0,0,0.1); }
-        .header { display: flex; justify-content: space-between; align-items: center; margin-bottom: 20px; }
-        .server-info { background: #e3f2fd; padding: 10px; border-radius: 5px; margin-bottom: 20px; }
-        table { width: 100%; border-collapse: collapse; margin-top: 20px; }
-        th, td { border: 1px solid #ddd; padding: 12px; text-align: left; }
-        th { background-color: #2196F3; color: white; }
-        .running { color: #4CAF50; font-weight: bold; }
-        .stopped { color: #f44336; font-weight: bold; }
-        .btn { padding: 8px 16px; margin: 2px; border: none; border-radius: 4px; cursor: pointer; font-size: 12px; }
-        .btn-primary { background: #2196F3; color: white; }
-        .btn-success { background: #4CAF50; color: white; }
-        .btn-warning { background: #FF9800; color: white; }
-        .btn-danger { background: #f44336; color: white; }
-        .btn:hover { opacity: 0.8; }
-        .config-form { background: #f9f9f9; padding: 20px; border-radius: 5px; margin-bottom: 20px; }
-        .form-group { margin-bottom: 15px; }
-        .form-group label { display: block; margin-bottom: 5px; font-weight: bold; }
-        .form-group input { width: 100%; padding: 8px; border: 1px solid #ddd; border-radius: 4px; }
-        .loading { text-align: center; padding: 20px; }
-        .error { color: #f44336; background: #ffebee; padding: 10px; border-radius: 4px; margin: 10px 0; }
-        .success { color: #4CAF50; background: #e8f5e8; padding: 10px; border-radius: 4px; margin: 10px 0; }
-    </style>
-</head>
-<body>
-    <div class="container">
-        <div class="header">
-            <h1>🐳 Remote Docker Manager</h1>
-            <button class="btn btn-primary" onclick="showConfig()">Server Config</button>
-        </div>
-
-        <div id="configSection" class="config-form" style="display: none;">
-            <h3>Server Configuration</h3>
-            <div class="form-group">
-                <label>Host:</label>
-                <input type="text" id="host" placeholder="192.168.1.100" value="{{.Host}}">
-            </div>
-            <div class="form-group">
-                <label>Port:</label>
-                <input type="text" id="port" placeholder="22" value="{{.Port}}">
-            </div>
-            <div class="form-group">
-                <label>Username:</label>
-                <input type="text" id="username" placeholder="root" value="{{.Username}}">
-            </div>
-            <div class="form-group">
-                <label>Password:</label>
-                <input type="password" id="password" placeholder="password">
-            </div>
-            <button class="btn btn-success" onclick="saveConfig()">Connect & Save</button>
-            <button class="btn btn-primary" onclick="hideConfig()">Cancel</button>
-        </div>
-
-        <div class="server-info">
-            <strong>Connected Server:</strong> {{.Host}}:{{.Port}} ({{.Username}})
-            <button class="btn btn-primary" onclick="refreshContainers()" style="float: right;">🔄 Refresh</button>
-        </div>
-
-        <div id="message"></div>
-        <div id="loading" class="loading" style="display: none;">Loading containers...</div>
-        
-        <table id="containersTable">
-            <thead>
-                <tr>
-                    <th>ID</th>
-                    <th>Name</th>
-                    <th>Image</th>
-                    <th>Status</th>
-                    <th>Created</th>
-                    <th>Ports</th>
-                    <th>Actions</th>
-                </tr>
-            </thead>
-            <tbody id="containersBody">
-            </tbody>
-        </table>
-    </div>
-
-    <script>
-        function showConfig() {
-            document.getElementById('configSection').style.display = 'block';
-        }
-
-        function hideConfig() {
-            document.getElementById('configSection').style.display = 'none';
-        }
-
-        function saveConfig() {
-            const config = {
-                host: document.getElementById('host').value,
-                port: document.getElementById('port').value,
-                username: document.getElementById('username').value,
-                password: document.getElementById('password').value
-            };
-
-            fetch('/api/config', {
-                method: 'POST',
-                headers: {'Content-Type': 'application/json'},
-                body: JSON.stringify(config)
-            })
-            .then(response => response.json())
-            .then(data => {
-                if (data.success) {
-                    showMessage('Configuration saved successfully!', 'success');
-                    hideConfig();
-                    refreshContainers();
-                } else {
-                    showMessage('Error: ' + data.error, 'error');
-                }
-            })
-            .catch(err => showMessage('Connection failed: ' + err, 'error'));
-        }
-
-        function refreshContainers() {
-            document.getElementById('loading').style.display = 'block';
-            document.getElementById('containersTable').style.display = 'none';
-
-            fetch('/api/containers')
-            .then(response => response.json())
-            .then(data => {
-                document.getElementById('loading').style.display = 'none';
-                document.getElementById('containersTable').style.display = 'table';
-                
-                if (data.success) {
-                    updateContainersTable(data.containers || []);
-                } else {
-                    showMessage('Error: ' + (data.error || 'Unknown error'), 'error');
-                    updateContainersTable([]);
-                }
-            })
-            .catch(err => {
-                document.getElementById('loading').style.display = 'none';
-                document.getElementById('containersTable').style.display = 'table';
-                showMessage('Failed to fetch containers: ' + err.message, 'error');
-                updateContainersTable([]);
-            });
-        }
-
-        function updateContainersTable(containers) {
-            const tbody = document.getElementById('containersBody');
-            tbody.innerHTML = '';
-
-            if (!containers || !Array.isArray(containers)) {
-                tbody.innerHTML = '<tr><td colspan="7">No containers found</td></tr>';
-                return;
-            }
-
-            containers.forEach(container => {
-                const row = document.createElement('tr');
-                row.innerHTML = 
-                    '<td>' + container.id + '</td>' +
-                    '<td>' + container.name + '</td>' +
-                    '<td>' + container.image + '</td>' +
-                    '<td class="' + container.state + '">' + container.status + '</td>' +
-                    '<td>' + container.created + '</td>' +
-                    '<td>' + container.ports + '</td>' +
-                    '<td>' +
-                        '<button class="btn btn-success" onclick="containerAction(\'' + container.id + '\', \'start\')">▶️ Start</button>' +
-                        '<button class="btn btn-warning" onclick="containerAction(\'' + container.id + '\', \'stop\')">⏸️ Stop</button>' +
-                        '<button class="btn btn-primary" onclick="containerAction(\'' + container.id + '\', \'restart\')">🔄 Restart</button>' +
-                        '<button class="btn btn-danger" onclick="containerAction(\'' + container.id + '\', \'remove\')">🗑️ Remove</button>' +
-                    '</td>';
-                tbody.appendChild(row);
-            });
-        }
-
-        function containerAction(containerID, action) {
-            if (action === 'remove' && !confirm('Are you sure you want to remove this container?')) {
-                return;
-            }
-
-            fetch('/api/container/' + containerID + '/' + action, {
-                method: 'POST'
-            })
-            .then(response => response.json())
-            .then(data => {
-                if (data.success) {
-                    showMessage('Action completed successfully!', 'success');
-                    refreshContainers();
-                } else {
-                    showMessage('Error: ' + data.error, 'error');
-                }
-            })
-            .catch(err => showMessage('Action failed: ' + err, 'error'));
-        }
-
-        function showMessage(message, type) {
-            const messageDiv = document.getElementById('message');
-            messageDiv.innerHTML = '<div class="' + type + '">' + message + '</div>';
-            setTimeout(() => messageDiv.innerHTML = '', 5000);
-        }
-
-        window.onload = function() {
-            refreshContainers();
-        };
-    </script>
-</body>
-</html>
-`
-
-func homeHandler(w http.ResponseWriter, r *http.Request) {
-	tmpl, err := template.New("index").Parse(htmlTemplate)
+// KillContainer sends a signal to the container's main process, e.g. SIGHUP
+// to make nginx reload its configuration without a restart.
+func (dm *DockerManager) KillContainer(containerID, signal string) error {
+	command, err := killCommand(containerID, signal)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return err
 	}
+	_, err = dm.executeSSHCommand(command)
+	return err
+}
 
-	config := &ServerConfig{}
-	if dockerManager != nil && dockerManager.config != nil {
-		config = dockerManager.config
+func killCommand(containerID, signal string) (string, error) {
+	if signal == "" {
+		signal = "SIGKILL"
+	}
+	if !signalPattern.MatchString(signal) {
+		return "", requestErrorf(codeInvalidRequest, "invalid signal %q", signal)
 	}
+	return fmt.Sprintf("docker kill --signal %s %s", shellQuote(strings.ToUpper(signal)), shellQuote(containerID)), nil
+}
 
-	tmpl.Execute(w, config)
+func (dm *DockerManager) RemoveContainer(containerID string) error {
+	_, err := dm.executeSSHCommand(containerActionCommand("remove", containerID))
+	return err
 }
 
 func configHandler(w http.ResponseWriter, r *http.Request) {
@@ -390,56 +484,63 @@ func configHandler(w http.ResponseWriter, r *http.Request) {
 
 	var config ServerConfig
 	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
-		log.Printf("ERROR: Invalid JSON in config: %v", err)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"error":   "Invalid JSON format",
-		})
+		loggerFrom(r.Context()).Error("invalid JSON in config", "error", err)
+		writeError(w, codeInvalidRequest, "Invalid JSON format")
 		return
 	}
 
 	if config.Host == "" || config.Username == "" || config.Password == "" {
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"error":   "Host, username and password are required",
-		})
+		writeError(w, codeInvalidRequest, "Host, username and password are required")
 		return
 	}
 
 	if config.Port == "" {
-		config.Port = "22"
+		config.Port = sshDefaultPort
+	}
+	if user := userFrom(r.Context()); user != nil && !user.CanAccessServer(config.ID()) {
+		writeAuthError(w, http.StatusForbidden, "No access to server "+config.ID())
+		return
 	}
 
-	log.Printf("INFO: Attempting to connect to %s@%s:%s", config.Username, config.Host, config.Port)
-
-	dockerManager = &DockerManager{config: &config}
+	configured := newDockerManager(&config)
+	manager := configured.withRequest(r)
+	manager.logger.Info("connecting to server", "username", config.Username)
 
-	testOutput, err := dockerManager.executeSSHCommand("whoami && echo 'SSH connection successful'")
+	testOutput, err := manager.executeSSHCommand("whoami && echo 'SSH connection successful'")
 	if err != nil {
-		log.Printf("ERROR: SSH test failed: %v", err)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"error":   "SSH connection failed: " + err.Error(),
-		})
+		manager.logger.Error("SSH test failed", "error", err)
+		recordAudit(r, config.ID(), "server.configure", config.ID(), err)
+		writeFailure(w, fmt.Errorf("SSH connection failed: %w", err))
 		return
 	}
 
-	log.Printf("INFO: SSH test successful: %s", testOutput)
+	manager.logger.Info("SSH test successful", "output", strings.TrimSpace(testOutput))
 
-	// Docker mövcudluğunu test et
-	dockerOutput, err := dockerManager.executeSSHCommand("docker --version")
+	// Check that docker is available
+	dockerOutput, err := manager.executeSSHCommand("docker --version")
 	if err != nil {
-		log.Printf("ERROR: Docker test failed: %v", err)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"error":   "Docker is not available: " + err.Error(),
-		})
+		manager.logger.Error("Docker test failed", "error", err)
+		recordAudit(r, config.ID(), "server.configure", config.ID(), err)
+		writeFailure(w, lookupFailed(err, codeUnavailable, "Docker is not available: "+err.Error()))
 		return
 	}
 
-	log.Printf("INFO: Docker test successful: %s", dockerOutput)
-
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	manager.logger.Info("Docker test successful", "version", strings.TrimSpace(dockerOutput))
+
+	recordAudit(r, config.ID(), "server.configure", config.ID(), nil)
+	registry.Register(configured)
+	setSessionServer(r, config.ID())
+	startEventCollector(configured)
+	startMetricsSampler(configured)
+	startDiskMonitor(configured)
+	startAutoHealer(configured)
+	startAutoUpdater(configured)
+	startLogForwarders(configured)
+	startGitOpsPoller(configured)
+	startScheduler(configured)
+	restartContainerHub(configured)
+
+	writeJSON(w, map[string]interface{}{
 		"success": true,
 		"message": "Configuration saved successfully",
 	})
@@ -448,36 +549,72 @@ func configHandler(w http.ResponseWriter, r *http.Request) {
 func containersHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	if dockerManager == nil {
-		log.Println("ERROR: No docker manager configured")
-		json.NewEncoder(w).Encode(map[string]interface{}{
+	current, err := selectedManager(r)
+	if err != nil {
+		message := err.Error()
+		if err == errNoServer {
+			loggerFrom(r.Context()).Error("no docker manager configured")
+			message = "No server configuration found. Please configure server first."
+		}
+		writeJSON(w, map[string]interface{}{
 			"success":    false,
-			"error":      "No server configuration found. Please configure server first.",
+			"error":      message,
+			"code":       errorCodeOf(err),
 			"containers": []Container{},
 		})
 		return
 	}
 
-	log.Printf("INFO: Fetching containers from %s@%s:%s",
-		dockerManager.config.Username, dockerManager.config.Host, dockerManager.config.Port)
-
-	containers, err := dockerManager.GetContainers()
+	query, err := parseContainerQuery(r.URL.Query())
 	if err != nil {
-		log.Printf("ERROR: Failed to get containers: %v", err)
-		json.NewEncoder(w).Encode(map[string]interface{}{
+		writeJSON(w, map[string]interface{}{
 			"success":    false,
 			"error":      err.Error(),
+			"code":       errorCodeOf(err),
 			"containers": []Container{},
 		})
 		return
 	}
 
-	log.Printf("INFO: Successfully fetched %d containers", len(containers))
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	manager := current.withRequest(r)
+	manager.logger.Info("fetching containers")
+
+	var filters []string
+	for _, label := range r.URL.Query()["label"] {
+		filters = append(filters, "label="+label)
+	}
+	containers, cached, err := manager.CachedContainers(r.URL.Query().Get("size") == "true", filters...)
+	if err != nil {
+		manager.logger.Error("failed to get containers", "error", err)
+		response := failure(err)
+		response["containers"] = []Container{}
+		writeJSON(w, response)
+		return
+	}
+
+	manager.logger.Info("fetched containers", "count", len(containers), "cached", cached)
+	page, total := query.Apply(containers)
+	response := map[string]interface{}{
 		"success":    true,
-		"containers": containers,
-		"count":      len(containers),
-	})
+		"containers": page,
+		"count":      len(page),
+		"total":      total,
+	}
+	if query.Limit > 0 {
+		response["page"] = query.Page
+		response["limit"] = query.Limit
+		response["pages"] = (total + query.Limit - 1) / query.Limit
+	}
+
+	body, _ := json.Marshal(response)
+	etag := listETag(body)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Write(append(body, '\n'))
 }
 
 func containerActionHandler(w http.ResponseWriter, r *http.Request) {
@@ -486,75 +623,577 @@ func containerActionHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if dockerManager == nil {
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"error":   "No server configuration found",
-		})
+	current, err := selectedManager(r)
+	if err != nil {
+		writeFailure(w, err)
 		return
 	}
 
+	manager := current.withRequest(r)
 	vars := mux.Vars(r)
 	containerID := vars["id"]
 	action := vars["action"]
 
-	var err error
-	switch action {
-	case "start":
-		err = dockerManager.StartContainer(containerID)
-	case "stop":
-		err = dockerManager.StopContainer(containerID)
-	case "restart":
-		err = dockerManager.RestartContainer(containerID)
-	case "remove":
-		err = dockerManager.RemoveContainer(containerID)
+	err = manager.checkProtected(containerID, action, overrideRequested(r))
+	if dryRunRequested(r) {
+		containerActionDryRun(w, r, manager, containerID, action, err)
+		return
+	}
+	if err == nil && action == "remove" && !confirmOperation(w, r, manager, requestOperation(r), func() (string, map[string]interface{}, error) {
+		resource, err := manager.containerResource(containerID)
+		if err != nil {
+			return "", nil, err
+		}
+		return "Remove container " + resource.Name, map[string]interface{}{"resources": []DryRunResource{resource}}, nil
+	}) {
+		return
+	}
+	switch {
+	case err != nil:
+	case action == "start":
+		err = manager.StartContainer(containerID)
+	case action == "stop":
+		err = manager.StopContainer(containerID)
+	case action == "restart":
+		err = manager.RestartContainer(containerID)
+	case action == "remove":
+		err = manager.RemoveContainer(containerID)
+	case action == "kill":
+		err = manager.KillContainer(containerID, r.URL.Query().Get("signal"))
 	default:
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"error":   "Unknown action: " + action,
-		})
+		writeError(w, codeInvalidRequest, "Unknown action: "+action)
 		return
 	}
 
+	// ?wait=true blocks until the container is healthy (or stably running)
+	// so automation learns whether the service actually came up.
+	var state *ContainerState
+	if err == nil && (action == "start" || action == "restart") && r.URL.Query().Get("wait") == "true" {
+		timeout := 60 * time.Second
+		if value := r.URL.Query().Get("timeout"); value != "" {
+			if timeout, err = time.ParseDuration(value); err != nil {
+				writeError(w, codeInvalidRequest, "Invalid timeout: "+value)
+				return
+			}
+		}
+		state, err = manager.WaitForHealthy(r.Context(), containerID, timeout)
+	}
+
+	recordAudit(r, manager.config.ID(), "container."+action, containerID, err)
 	if err != nil {
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"error":   err.Error(),
-		})
+		manager.logger.Error("container action failed", "container", containerID, "action", action, "error", err)
+		response := failure(err)
+		response["state"] = state
+		response["protected"] = isProtectedError(err)
+		writeJSON(w, response)
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	manager.logger.Info("container action completed", "container", containerID, "action", action)
+	writeJSON(w, map[string]interface{}{
 		"success": true,
 		"message": "Action completed successfully",
+		"state":   state,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, payload map[string]interface{}) {
+	localize(w, payload)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(payload)
+}
+
+func writeError(w http.ResponseWriter, code, message string) {
+	writeJSON(w, map[string]interface{}{
+		"success": false,
+		"error":   message,
+		"code":    code,
+	})
+}
+
+// uploadReader returns the uploaded payload of r without buffering it: either
+// the "file" part of a multipart form or the raw request body.
+func uploadReader(r *http.Request) (io.Reader, error) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return r.Body, nil
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, requestErrorf(codeInvalidRequest, "Invalid multipart upload: %v", err)
+	}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, fmt.Errorf("Missing 'file' field in upload")
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+	}
+}
+
+func envString(name, fallback string) string {
+	if value := setting(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value := setting(name)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Error("invalid duration setting", "name", name, "value", value, "fallback", fallback)
+		return fallback
+	}
+	return d
+}
+
+func requireManager(w http.ResponseWriter, r *http.Request) (*DockerManager, bool) {
+	selected, err := selectedManager(r)
+	if err != nil {
+		writeFailure(w, err)
+		return nil, false
+	}
+	return selected.withRequest(r), true
+}
+
+// serverManager resolves the {sid} route variable to a configured server.
+// "current" refers to the server the request acts on, see selectedManager.
+func serverManager(w http.ResponseWriter, r *http.Request) (*DockerManager, bool) {
+	sid := mux.Vars(r)["sid"]
+	if sid == "current" {
+		return requireManager(w, r)
+	}
+	registered, ok := registry.Get(sid)
+	if !ok {
+		writeError(w, codeNotFound, "Unknown server: "+sid)
+		return nil, false
+	}
+	return registered.withRequest(r), true
+}
+
+// accessibleManagers returns a manager for every configured server the
+// user may access, scoped to the request.
+func accessibleManagers(r *http.Request) []*DockerManager {
+	managers := []*DockerManager{}
+	user := userFrom(r.Context())
+	for _, dm := range registry.All() {
+		if user == nil || user.CanAccessServer(dm.config.ID()) {
+			managers = append(managers, dm.withRequest(r))
+		}
+	}
+	return managers
+}
+
+// serverSelectHandler makes the session work on another configured server.
+func serverSelectHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	manager, ok := serverManager(w, r)
+	if !ok {
+		return
+	}
+	if !setSessionServer(r, manager.config.ID()) {
+		writeError(w, codeInvalidRequest, "Invalid request: selecting a server needs a login session; send the "+serverHeader+" header instead")
+		return
+	}
+	manager.logger.Info("server selected")
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"server":  manager.config.ID(),
+	})
+}
+
+// serversHandler lists the configured servers the user may access.
+func serversHandler(w http.ResponseWriter, r *http.Request) {
+	servers := []map[string]interface{}{}
+	current, _ := selectedManager(r)
+	for _, manager := range accessibleManagers(r) {
+		config := manager.config
+		servers = append(servers, map[string]interface{}{
+			"id":       config.ID(),
+			"host":     config.Host,
+			"port":     config.Port,
+			"username": config.Username,
+			"current":  current != nil && config.ID() == current.config.ID(),
+		})
+	}
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"servers": servers,
+		"count":   len(servers),
 	})
 }
 
 func main() {
+	flag.String("config", configFilePath(), "YAML configuration file; flags and environment variables override it")
+	listenAddr := flag.String("listen", setting("LISTEN_ADDR"), "address to listen on, e.g. 127.0.0.1 (default all interfaces)")
+	portFlag := flag.String("port", setting("PORT"), "port to listen on (default 8080)")
+	dataDirFlag := flag.String("data-dir", envString("DATA_DIR", "data"), "directory for the data store")
+	tlsCert := flag.String("tls-cert", setting("TLS_CERT"), "TLS certificate file (PEM); serves HTTPS together with --tls-key")
+	tlsKey := flag.String("tls-key", setting("TLS_KEY"), "TLS private key file (PEM)")
+	acmeDomains := flag.String("acme-domain", setting("ACME_DOMAINS"), "comma-separated domains to obtain certificates for via ACME/Let's Encrypt")
+	acmeEmail := flag.String("acme-email", setting("ACME_EMAIL"), "contact email for the ACME account")
+	unixSocket := flag.String("unix-socket", setting("UNIX_SOCKET"), "listen on this Unix socket path instead of a TCP port")
+	basePathFlag := flag.String("base-path", setting("BASE_PATH"), "URL prefix to serve the app under, e.g. /docker")
+	socketMode := flag.String("unix-socket-mode", setting("UNIX_SOCKET_MODE"), "permissions of the Unix socket, in octal (default 0660)")
+	debug := flag.Bool("debug", setting("DEBUG") == "true", "log every SSH command, with secrets redacted")
+	flag.Parse()
+	basePath = normalizeBasePath(*basePathFlag)
+
+	setupLogging(*debug)
+	if err := configError(); err != nil {
+		slog.Error("failed to load config file", "error", err)
+		os.Exit(1)
+	}
+	if configPath != "" {
+		slog.Info("loaded config file", "path", configPath)
+	}
+	setupTracing()
+
 	r := mux.NewRouter()
 
-	r.HandleFunc("/", homeHandler)
+	for _, page := range pages {
+		path := "/" + page.Path
+		if page.Path == "./" {
+			path = "/"
+		}
+		r.HandleFunc(path, pageHandler(page.Name))
+	}
+	r.PathPrefix("/static/").Handler(staticHandler())
+	r.HandleFunc("/login", loginPageHandler)
+	r.HandleFunc("/api/auth/login", loginHandler)
+	r.HandleFunc("/api/auth/logout", logoutHandler)
+	r.HandleFunc("/api/auth/me", currentUserHandler)
+	r.HandleFunc("/api/auth/password", passwordHandler)
+	r.HandleFunc("/api/auth/language", languageHandler)
+	r.HandleFunc("/api/users", usersHandler)
+	r.HandleFunc("/api/users/{name}/remove", userRemoveHandler)
+	r.HandleFunc("/api/users/{name}/servers", userServersHandler)
+	r.HandleFunc("/api/server-groups", serverGroupsHandler)
+	r.HandleFunc("/api/server-groups/{name}/remove", serverGroupRemoveHandler)
+	r.HandleFunc("/api/tokens", apiTokensHandler)
+	r.HandleFunc("/api/tokens/{id}/revoke", apiTokenRevokeHandler)
 	r.HandleFunc("/health", healthHandler)
+	r.HandleFunc("/metrics", prometheusHandler)
 	r.HandleFunc("/api/config", configHandler)
 	r.HandleFunc("/api/containers", containersHandler)
+	r.HandleFunc("/api/containers/bulk", containerBulkHandler)
+	r.HandleFunc("/api/containers/live", containersLiveHandler)
 	r.HandleFunc("/api/container/{id}/{action}", containerActionHandler)
-
+	r.HandleFunc("/api/containers/{id}/redeploy", containerRedeployHandler)
+	r.HandleFunc("/api/containers/{id}/clone", containerCloneHandler)
+	r.HandleFunc("/api/containers/{id}/export", containerExportHandler)
+	r.HandleFunc("/api/containers/{id}/resources", containerResourcesHandler)
+	r.HandleFunc("/api/containers/{id}/restart-policy", containerRestartPolicyHandler)
+	r.HandleFunc("/api/containers/{id}/protection", containerProtectionHandler)
+	r.HandleFunc("/api/containers/{id}/autoheal", containerAutoHealHandler)
+	r.HandleFunc("/api/containers/{id}/health", containerHealthHandler)
+	r.HandleFunc("/api/containers/{id}/diff", containerDiffHandler)
+	r.HandleFunc("/api/containers/{id}/inspect", containerInspectHandler)
+	r.HandleFunc("/api/containers/{id}/exec", containerExecHandler)
+	r.HandleFunc("/api/containers/{id}/attach/ws", containerAttachHandler)
+	r.HandleFunc("/api/containers/{id}/files", containerFilesHandler)
+	r.HandleFunc("/api/containers/{id}/files/download", containerFileDownloadHandler)
+	r.HandleFunc("/api/containers/{id}/files/upload", containerFileUploadHandler)
+	r.HandleFunc("/api/containers/{id}/stats", containerStatsHandler)
+	r.HandleFunc("/api/containers/{id}/stats/ws", containerStatsStreamHandler)
+	r.HandleFunc("/api/containers/{id}/metrics", containerMetricsHistoryHandler)
+	r.HandleFunc("/api/containers/{id}/network/{net}/{action}", containerNetworkHandler)
+	r.HandleFunc("/api/audit", auditHandler)
+	r.HandleFunc("/api/audit/export", auditExportHandler)
+	r.HandleFunc("/api/updates", updatesHandler)
+	r.HandleFunc("/api/updates/check", updatesCheckHandler)
+	r.HandleFunc("/api/updates/{name}/{action}", updateActionHandler)
+	r.HandleFunc("/api/gitops", gitOpsHandler)
+	r.HandleFunc("/api/gitops/{project}/deploy", gitOpsDeployHandler)
+	r.HandleFunc("/api/gitops/{project}/history", gitOpsHistoryHandler)
+	r.HandleFunc("/api/gitops/{project}/remove", gitOpsRemoveHandler)
+	r.HandleFunc("/api/jobs", jobsHandler)
+	r.HandleFunc("/api/jobs/{id}", jobHandler)
+	r.HandleFunc("/api/jobs/{id}/stream", jobStreamHandler)
+	r.HandleFunc("/api/jobs/{id}/cancel", jobCancelHandler)
+	r.HandleFunc("/api/schedules", schedulesHandler)
+	r.HandleFunc("/api/schedules/{id}/history", scheduleHistoryHandler)
+	r.HandleFunc("/api/schedules/{id}/backups", scheduleBackupsHandler)
+	r.HandleFunc("/api/schedules/{id}/{action}", scheduleActionHandler)
+	r.HandleFunc("/api/webhooks", webhooksHandler)
+	r.HandleFunc("/api/webhooks/{id}/trigger", webhookTriggerHandler)
+	r.HandleFunc("/api/webhooks/{id}/remove", webhookRemoveHandler)
+	r.HandleFunc("/api/templates", templatesHandler)
+	r.HandleFunc("/api/compose", composeProjectsHandler)
+	r.HandleFunc("/api/compose/{project}/deploy", composeDeployHandler)
+	r.HandleFunc("/api/compose/{project}/ps", composePsHandler)
+	r.HandleFunc("/api/compose/{project}/logs", composeLogsHandler)
+	r.HandleFunc("/api/compose/{project}/{action}", composeActionHandler)
+	r.HandleFunc("/api/services", servicesHandler)
+	r.HandleFunc("/api/services/{name}/tasks", serviceTasksHandler)
+	r.HandleFunc("/api/services/{name}/scale", serviceScaleHandler)
+	r.HandleFunc("/api/services/{name}/{action}", serviceActionHandler)
+	r.HandleFunc("/api/nodes", nodesHandler)
+	r.HandleFunc("/api/nodes/{id}/{action}", nodeActionHandler)
+	r.HandleFunc("/api/forwarders", logForwardersHandler)
+	r.HandleFunc("/api/forwarders/{id}/status", logForwarderStatusHandler)
+	r.HandleFunc("/api/forwarders/{id}/remove", logForwarderRemoveHandler)
+	r.HandleFunc("/api/notifications", notifiersHandler)
+	r.HandleFunc("/api/notifications/{id}/test", notifierTestHandler)
+	r.HandleFunc("/api/notifications/{id}/remove", notifierRemoveHandler)
+	r.HandleFunc("/api/alert-rules", alertRulesHandler)
+	r.HandleFunc("/api/alert-rules/{id}/remove", alertRuleRemoveHandler)
+	r.HandleFunc("/api/alerts", alertsHandler)
+	r.HandleFunc("/api/autoheal", autoHealLogHandler)
+	r.HandleFunc("/api/uptime", uptimeHandler)
+	r.HandleFunc("/api/uptime/{name}", uptimeTimelineHandler)
+	r.HandleFunc("/api/logs", aggregateLogsHandler)
+	r.HandleFunc("/api/logs/{id}", logsHandler)
+	r.HandleFunc("/api/logs/{id}/download", logsDownloadHandler)
+	r.HandleFunc("/api/events", eventsHandler)
+	r.HandleFunc("/api/events/stream", eventsStreamHandler)
+	r.HandleFunc("/api/servers", serversHandler)
+	r.HandleFunc("/api/search", searchHandler)
+	r.HandleFunc("/api/servers/{sid}/containers", serverContainersHandler)
+	r.HandleFunc("/api/servers/{sid}/containers/stream", serverContainersStreamHandler)
+	r.HandleFunc("/api/servers/{sid}/templates/{name}/deploy", templateDeployHandler)
+	r.HandleFunc("/api/servers/{sid}/disk-usage", diskUsageHandler)
+	r.HandleFunc("/api/servers/{sid}/info", serverInfoHandler)
+	r.HandleFunc("/api/servers/{sid}/select", serverSelectHandler)
+	r.HandleFunc("/api/servers/{sid}/host-metrics", hostMetricsHandler)
+	r.HandleFunc("/api/servers/{sid}/disk-space", diskHandler)
+	r.HandleFunc("/api/images", imagesHandler)
+	r.HandleFunc("/api/images/load", imageLoadHandler)
+	r.HandleFunc("/api/images/pull", imagePullHandler)
+	r.HandleFunc("/api/images/build", imageBuildHandler)
+	r.HandleFunc("/api/volumes", volumesHandler)
+	r.HandleFunc("/api/volumes/prune", volumePruneHandler)
+	r.HandleFunc("/api/prune/{target}", pruneHandler)
+	r.HandleFunc("/api/volumes/{name}/inspect", volumeInspectHandler)
+	r.HandleFunc("/api/volumes/{name}/remove", volumeRemoveHandler)
+	r.HandleFunc("/api/volumes/{name}/backup", volumeBackupHandler)
+	r.HandleFunc("/api/volumes/{name}/restore", volumeRestoreHandler)
+	r.HandleFunc("/api/images/{id:.+}/save", imageSaveHandler)
+	r.HandleFunc("/api/images/{id:.+}/inspect", imageInspectHandler)
+	r.HandleFunc("/api/images/{id:.+}/history", imageHistoryHandler)
+
+	r.Use(shutdownMiddleware)
+	r.Use(requestIDMiddleware)
 	r.Use(loggingMiddleware)
-
-	port := ":8080"
-	if envPort := os.Getenv("PORT"); envPort != "" {
-		port = ":" + envPort
+	r.Use(metricsMiddleware)
+	r.Use(tracingMiddleware)
+	r.Use(corsMiddleware)
+	r.Use(localeMiddleware)
+	r.Use(authMiddleware)
+	r.Use(csrfMiddleware)
+	r.Use(serverAccessMiddleware)
+
+	dataDir := *dataDirFlag
+	var err error
+	if store, err = OpenStore(dataDir); err != nil {
+		slog.Error("failed to open data store", "error", err)
+		os.Exit(1)
+	}
+	if authEnabled {
+		if err := ensureAdminUser(); err != nil {
+			slog.Error("failed to set up users", "error", err)
+			os.Exit(1)
+		}
+	}
+	startEventHistoryPruner()
+	startMetricsHistoryPruner()
+	startUptimeHistoryPruner()
+	startAutoHealLogPruner()
+	startJobHistoryPruner()
+	startTelegramBots()
+	startNotificationDigests()
+
+	port := "8080"
+	if *portFlag != "" {
+		port = *portFlag
+	}
+	options := &ServeOptions{
+		Addr:          net.JoinHostPort(*listenAddr, port),
+		TLSCert:       *tlsCert,
+		TLSKey:        *tlsKey,
+		ACMEEmail:     *acmeEmail,
+		ACMECacheDir:  filepath.Join(dataDir, "acme"),
+		ACMEDirectory: setting("ACME_DIRECTORY_URL"),
+		ACMEHTTPAddr:  ":80",
+	}
+	if *acmeDomains != "" {
+		options.ACMEDomains = strings.Split(strings.ReplaceAll(*acmeDomains, " ", ""), ",")
+		// Browsers reach a public domain on the default HTTPS port.
+		if *portFlag == "" {
+			options.Addr = net.JoinHostPort(*listenAddr, "443")
+		}
+	}
+	if envHTTPPort := setting("ACME_HTTP_PORT"); envHTTPPort != "" {
+		options.ACMEHTTPAddr = ":" + envHTTPPort
+	}
+	if *unixSocket != "" {
+		options.Socket = *unixSocket
+		trustProxyHeaders = true
+		options.SocketMode = 0660
+		if *socketMode != "" {
+			mode, err := strconv.ParseUint(*socketMode, 8, 32)
+			if err != nil {
+				slog.Error("invalid unix socket mode", "mode", *socketMode)
+				os.Exit(1)
+			}
+			options.SocketMode = os.FileMode(mode)
+		}
+	}
+	scheme := "http"
+	if options.TLS() {
+		scheme = "https"
 	}
 
-	fmt.Printf("🚀 Remote Docker Manager starting on http://localhost%s\n", port)
+	if options.Socket != "" {
+		fmt.Printf("🚀 Remote Docker Manager starting on %s over unix:%s\n", scheme, options.Socket)
+	} else {
+		host := *listenAddr
+		if host == "" {
+			host = "localhost"
+		}
+		_, port, _ := net.SplitHostPort(options.Addr)
+		fmt.Printf("🚀 Remote Docker Manager starting on %s://%s%s/\n", scheme, net.JoinHostPort(host, port), basePath)
+	}
 	fmt.Println("📋 Available endpoints:")
 	fmt.Println("   GET  /           - Web interface")
+	fmt.Println("   GET  /images, /volumes, /stacks, /services, /settings - Other pages")
+	fmt.Println("   *    /api/v1/... - Every /api route with a response envelope and HTTP status codes")
 	fmt.Println("   GET  /health     - Health check")
+	fmt.Println("   GET  /login      - Login page")
+	fmt.Println("   POST /api/auth/login - Log in and start a session")
+	fmt.Println("   POST /api/auth/logout - End the session")
+	fmt.Println("   GET  /api/auth/me - Current user")
+	fmt.Println("   POST /api/auth/password - Change own password")
+	fmt.Println("   POST /api/auth/language - Switch the interface language")
+	fmt.Println("   GET  /api/users - List users (admin)")
+	fmt.Println("   POST /api/users - Create a user (admin)")
+	fmt.Println("   POST /api/users/{name}/remove - Remove a user (admin)")
+	fmt.Println("   POST /api/users/{name}/servers - Restrict a user to servers (admin)")
+	fmt.Println("   GET  /api/server-groups - List server groups (admin)")
+	fmt.Println("   POST /api/server-groups - Save a server group (admin)")
+	fmt.Println("   POST /api/server-groups/{name}/remove - Remove a server group (admin)")
+	fmt.Println("   GET  /api/tokens - List own API tokens")
+	fmt.Println("   POST /api/tokens - Create an API token")
+	fmt.Println("   POST /api/tokens/{id}/revoke - Revoke an API token")
+	fmt.Println("   GET  /metrics    - Prometheus metrics")
 	fmt.Println("   POST /api/config - Server configuration")
 	fmt.Println("   GET  /api/containers - List containers")
+	fmt.Println("   GET  /api/containers/live - Live container state changes (WebSocket)")
 	fmt.Println("   POST /api/container/{id}/{action} - Container actions")
-
-	log.Fatal(http.ListenAndServe(port, r))
+	fmt.Println("   POST /api/containers/bulk - Start, stop, restart or remove several containers")
+	fmt.Println("   POST /api/containers/{id}/redeploy - Pull image and recreate container")
+	fmt.Println("   POST /api/containers/{id}/clone - Copy container configuration to a new container")
+	fmt.Println("   GET  /api/containers/{id}/export - Container as docker run command or compose file")
+	fmt.Println("   GET  /api/containers/{id}/resources - Current CPU and memory limits")
+	fmt.Println("   POST /api/containers/{id}/resources - Update limits of a running container")
+	fmt.Println("   GET  /api/containers/{id}/restart-policy - Current restart policy")
+	fmt.Println("   POST /api/containers/{id}/restart-policy - Change restart policy")
+	fmt.Println("   GET  /api/containers/{id}/protection - Whether stop and remove are refused")
+	fmt.Println("   POST /api/containers/{id}/protection - Protect or unprotect a container")
+	fmt.Println("   GET  /api/containers/{id}/autoheal - Auto-heal policy and attempts of a container")
+	fmt.Println("   POST /api/containers/{id}/autoheal - Enable or disable auto-heal for a container")
+	fmt.Println("   GET  /api/containers/{id}/health - Healthcheck status and recent output")
+	fmt.Println("   GET  /api/containers/{id}/diff - Filesystem changes in the writable layer")
+	fmt.Println("   GET  /api/containers/{id}/inspect - Full docker inspect output")
+	fmt.Println("   POST /api/containers/{id}/exec - Run a one-shot command in a container")
+	fmt.Println("   GET  /api/containers/{id}/attach/ws - Attach to the main process (WebSocket)")
+	fmt.Println("   GET  /api/containers/{id}/files - List a directory inside a container")
+	fmt.Println("   GET  /api/containers/{id}/files/download - Download a file or directory as tar")
+	fmt.Println("   POST /api/containers/{id}/files/upload - Upload a file or archive into a container")
+	fmt.Println("   GET  /api/containers/{id}/stats - Container resource usage")
+	fmt.Println("   GET  /api/containers/{id}/stats/ws - Live container stats (WebSocket)")
+	fmt.Println("   GET  /api/containers/{id}/metrics - Historical CPU/memory")
+	fmt.Println("   POST /api/containers/{id}/network/{net}/{action} - Connect/disconnect network")
+	fmt.Println("   GET  /api/audit - Audit log")
+	fmt.Println("   GET  /api/audit/export - Audit log export (CSV/JSON)")
+	fmt.Println("   GET  /api/updates - Pending image updates and update history")
+	fmt.Println("   POST /api/updates/check - Check opted-in containers for newer images")
+	fmt.Println("   POST /api/updates/{name}/{approve|dismiss} - Resolve a pending update")
+	fmt.Println("   GET  /api/gitops - Stacks deployed from Git (POST to register one)")
+	fmt.Println("   POST /api/gitops/{project}/deploy - Pull and deploy a Git stack")
+	fmt.Println("   GET  /api/gitops/{project}/history - Deployment history of a Git stack")
+	fmt.Println("   POST /api/gitops/{project}/remove - Unregister a Git stack")
+	fmt.Println("   GET  /api/jobs - Background jobs of the current server")
+	fmt.Println("   GET  /api/jobs/{id} - State, progress and output of a job")
+	fmt.Println("   GET  /api/jobs/{id}/stream - Live job output and progress (SSE)")
+	fmt.Println("   POST /api/jobs/{id}/cancel - Cancel a queued or running job")
+	fmt.Println("   GET  /api/schedules - Scheduled tasks of the current server (POST to add one)")
+	fmt.Println("   GET  /api/schedules/{id}/history - Runs of a scheduled task")
+	fmt.Println("   GET  /api/schedules/{id}/backups - Backup sets kept by a backup schedule")
+	fmt.Println("   POST /api/schedules/{id}/{enable|disable|run|remove} - Manage a scheduled task")
+	fmt.Println("   GET  /api/webhooks - Deploy webhooks of the current server (POST to create one)")
+	fmt.Println("   POST /api/webhooks/{id}/trigger - Pull and redeploy the webhook's target (for CI)")
+	fmt.Println("   POST /api/webhooks/{id}/remove - Remove a deploy webhook")
+	fmt.Println("   GET  /api/templates - App template catalog")
+	fmt.Println("   GET  /api/compose - List compose projects")
+	fmt.Println("   POST /api/compose/{project}/deploy - Upload a compose file and run docker compose up -d")
+	fmt.Println("   GET  /api/compose/{project}/ps - Project containers grouped by service")
+	fmt.Println("   GET  /api/compose/{project}/logs - Project logs (?service=, ?follow=true streams via SSE)")
+	fmt.Println("   POST /api/compose/{project}/{up|down|restart|pull} - Run a project-level action")
+	fmt.Println("   GET  /api/services - Swarm services with replicas and update status")
+	fmt.Println("   GET  /api/services/{name}/tasks - Tasks of a swarm service")
+	fmt.Println("   POST /api/services/{name}/scale - Scale a swarm service")
+	fmt.Println("   POST /api/services/{name}/{restart|update|rollback} - Run a service action")
+	fmt.Println("   GET  /api/nodes - Swarm nodes with role, status and availability")
+	fmt.Println("   POST /api/nodes/{id}/{drain|activate|pause} - Change node availability")
+	fmt.Println("   GET  /api/forwarders - Log forwarders of the current server (POST to add one)")
+	fmt.Println("   GET  /api/forwarders/{id}/status - Delivery status of a log forwarder")
+	fmt.Println("   POST /api/forwarders/{id}/remove - Stop and remove a log forwarder")
+	fmt.Println("   GET  /api/notifications - Notifiers of the current server (POST to add one)")
+	fmt.Println("   POST /api/notifications/{id}/test - Send a test notification")
+	fmt.Println("   POST /api/notifications/{id}/remove - Remove a notifier")
+	fmt.Println("   GET  /api/alert-rules - Alert rules of the current server (POST to add one)")
+	fmt.Println("   POST /api/alert-rules/{id}/remove - Remove an alert rule")
+	fmt.Println("   GET  /api/alerts - Pending and firing alerts of the current server")
+	fmt.Println("   GET  /api/autoheal - Auto-heal actions taken on the current server")
+	fmt.Println("   GET  /api/uptime - Availability of containers over 24h, 7d and 30d")
+	fmt.Println("   GET  /api/uptime/{name} - Up and down timeline of a container")
+	fmt.Println("   GET  /api/logs - Interleaved logs of several containers or a compose project")
+	fmt.Println("   GET  /api/logs/{id} - Container logs (?follow=true streams via SSE)")
+	fmt.Println("   GET  /api/logs/{id}/download - Full container logs as gzip")
+	fmt.Println("   GET  /api/events - Event history")
+	fmt.Println("   GET  /api/events/stream - Live docker events (SSE)")
+	fmt.Println("   POST /api/servers/{sid}/containers - Create container")
+	fmt.Println("   GET  /api/servers/{sid}/containers/stream - Live container state changes (SSE)")
+	fmt.Println("   POST /api/servers/{sid}/templates/{name}/deploy - Deploy an app template")
+	fmt.Println("   GET  /api/servers/{sid}/disk-usage - Docker disk usage")
+	fmt.Println("   GET  /api/servers - List configured servers")
+	fmt.Println("   GET  /api/search?q= - Find containers on every server")
+	fmt.Println("   GET  /api/servers/{sid}/info - Docker and host info")
+	fmt.Println("   POST /api/servers/{sid}/select - Work on this server in the current session")
+	fmt.Println("   GET  /api/servers/{sid}/host-metrics - Host CPU, memory, disk and load")
+	fmt.Println("   GET  /api/servers/{sid}/disk-space - Last disk space check of the Docker root and other paths")
+	fmt.Println("   GET  /api/images - List images")
+	fmt.Println("   POST /api/images/load - Load image archive")
+	fmt.Println("   POST /api/images/pull - Pull an image in a background job")
+	fmt.Println("   POST /api/images/build - Build an image on the host in a background job")
+	fmt.Println("   GET  /api/images/{id}/save - Export image archive")
+	fmt.Println("   GET  /api/images/{id}/inspect - Image details")
+	fmt.Println("   GET  /api/images/{id}/history - Image layer history")
+	fmt.Println("   GET  /api/volumes - List volumes")
+	fmt.Println("   POST /api/volumes - Create volume")
+	fmt.Println("   GET  /api/volumes/{name}/inspect - Volume details")
+	fmt.Println("   POST /api/volumes/{name}/remove - Remove volume")
+	fmt.Println("   GET  /api/volumes/{name}/backup - Download volume backup")
+	fmt.Println("   POST /api/volumes/{name}/restore - Restore volume from backup")
+	fmt.Println("   POST /api/volumes/prune - Remove unused volumes")
+	fmt.Println("   GET  /api/prune/{target} - Preview a container, image, volume, network or system prune")
+	fmt.Println("   POST /api/prune/{target} - Prune after confirmation")
+
+	if err := serve(options, withBasePath(withAPIVersions(r))); err != nil {
+		slog.Error("server stopped", "error", err)
+		os.Exit(1)
+	}
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
@@ -566,11 +1205,3 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 		"version":   "1.0.0",
 	})
 }
-
-func loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		next.ServeHTTP(w, r)
-		log.Printf("%s %s %s", r.Method, r.RequestURI, time.Since(start))
-	})
-}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCreatedAt(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		want  string
+	}{
+		{"2024-05-01 14:00:00 +0400 +04", "2024-05-01T14:00:00+04:00"},
+		{"2024-05-01 14:00:00 +0000 UTC", "2024-05-01T14:00:00Z"},
+		{"2024-12-31 23:59:59 -0500 EST", "2024-12-31T23:59:59-05:00"},
+		{"2024-05-01 14:00:00 +0200", "2024-05-01T14:00:00+02:00"},
+		{"2024-05-01 14:00:00", ""},
+		{"yesterday at noon UTC", ""},
+		{"", ""},
+	} {
+		got := parseCreatedAt(tc.value)
+		if tc.want == "" {
+			if !got.IsZero() {
+				t.Errorf("parseCreatedAt(%q) = %v, want the zero time", tc.value, got)
+			}
+			continue
+		}
+		if got.Format(time.RFC3339) != tc.want {
+			t.Errorf("parseCreatedAt(%q) = %s, want %s", tc.value, got.Format(time.RFC3339), tc.want)
+		}
+	}
+}
+
+func TestHumanAge(t *testing.T) {
+	day := 24 * time.Hour
+	for _, tc := range []struct {
+		age  time.Duration
+		want string
+	}{
+		{0, "Less than a minute ago"},
+		{59 * time.Second, "Less than a minute ago"},
+		{time.Minute, "About a minute ago"},
+		{2 * time.Minute, "2 minutes ago"},
+		{59 * time.Minute, "59 minutes ago"},
+		{time.Hour, "About an hour ago"},
+		{2 * time.Hour, "2 hours ago"},
+		{47 * time.Hour, "47 hours ago"},
+		{2 * day, "2 days ago"},
+		{13 * day, "13 days ago"},
+		{14 * day, "2 weeks ago"},
+		{59 * day, "8 weeks ago"},
+		{60 * day, "2 months ago"},
+		{729 * day, "24 months ago"},
+		{730 * day, "2 years ago"},
+	} {
+		if got := humanAge(tc.age); got != tc.want {
+			t.Errorf("humanAge(%v) = %q, want %q", tc.age, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// managerRegistry holds a DockerManager for every configured server, keyed
+// by server ID. It is safe for concurrent use; managers themselves are never
+// changed once registered, only replaced.
+type managerRegistry struct {
+	mu       sync.RWMutex
+	managers map[string]*DockerManager
+}
+
+var registry = &managerRegistry{managers: map[string]*DockerManager{}}
+
+// Register adds dm, replacing any manager of the same server.
+func (m *managerRegistry) Register(dm *DockerManager) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.managers[dm.config.ID()] = dm
+}
+
+func (m *managerRegistry) Get(id string) (*DockerManager, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	dm, ok := m.managers[id]
+	return dm, ok
+}
+
+// All returns every manager, ordered by server ID.
+func (m *managerRegistry) All() []*DockerManager {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	list := make([]*DockerManager, 0, len(m.managers))
+	for _, dm := range m.managers {
+		list = append(list, dm)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].config.ID() < list[j].config.ID() })
+	return list
+}
+
+// serverHeader names the server a request acts on, for clients without a
+// session such as scripts with an API token.
+const serverHeader = "X-Server-ID"
+
+var (
+	errNoServer       = &RequestError{Code: codeConflict, Message: "No server configuration found"}
+	errServerRequired = &RequestError{Code: codeInvalidRequest, Message: "Several servers are configured; select one with the " + serverHeader + " header or /api/servers/{id}/select"}
+)
+
+// selectedManager returns the manager of the server a request acts on: the
+// one named by the X-Server-ID header, else the one its session works on,
+// else the only configured server. With several servers a request has to
+// name one, so users working on different servers through the same
+// instance never act on each other's by accident.
+func selectedManager(r *http.Request) (*DockerManager, error) {
+	if id := r.Header.Get(serverHeader); id != "" {
+		if dm, ok := registry.Get(id); ok {
+			return dm, nil
+		}
+		return nil, requestErrorf(codeNotFound, "Unknown server: %s", id)
+	}
+	if id := sessionServerFrom(r.Context()); id != "" {
+		if dm, ok := registry.Get(id); ok {
+			return dm, nil
+		}
+	}
+	switch all := registry.All(); len(all) {
+	case 0:
+		return nil, errNoServer
+	case 1:
+		return all[0], nil
+	}
+	return nil, errServerRequired
+}
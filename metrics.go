@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A deliberately small Prometheus text-format registry: the exporter only
+// needs labelled counters, gauges and histograms.
+
+var durationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type metricFamily struct {
+	help       string
+	kind       string
+	values     map[string]float64
+	histograms map[string]*histogram
+}
+
+type metricsRegistry struct {
+	mu       sync.Mutex
+	families map[string]*metricFamily
+}
+
+var promMetrics = &metricsRegistry{families: map[string]*metricFamily{}}
+
+func (m *metricsRegistry) family(name, kind, help string) *metricFamily {
+	f, ok := m.families[name]
+	if !ok {
+		f = &metricFamily{help: help, kind: kind, values: map[string]float64{}, histograms: map[string]*histogram{}}
+		m.families[name] = f
+	}
+	return f
+}
+
+func (m *metricsRegistry) Inc(name, help string, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.family(name, "counter", help).values[formatLabels(labels)]++
+}
+
+func (m *metricsRegistry) Set(name, help string, value float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.family(name, "gauge", help).values[formatLabels(labels)] = value
+}
+
+func (m *metricsRegistry) Observe(name, help string, value float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f := m.family(name, "histogram", help)
+	key := formatLabels(labels)
+	h, ok := f.histograms[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(durationBuckets))}
+		f.histograms[key] = h
+	}
+	for i, bound := range durationBuckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+func formatLabels(labels []string) string {
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func withLabel(labels, extra string) string {
+	if labels == "" {
+		return extra
+	}
+	return labels + "," + extra
+}
+
+func writeSample(w *bufio.Writer, name, labels string, value float64) {
+	if labels != "" {
+		fmt.Fprintf(w, "%s{%s} %s\n", name, labels, strconv.FormatFloat(value, 'g', -1, 64))
+	} else {
+		fmt.Fprintf(w, "%s %s\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+	}
+}
+
+func (m *metricsRegistry) WriteTo(w *bufio.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := m.families[name]
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, f.help, name, f.kind)
+
+		if f.kind == "histogram" {
+			keys := sortedMetricKeys(f.histograms)
+			for _, key := range keys {
+				h := f.histograms[key]
+				for i, bound := range durationBuckets {
+					writeSample(w, name+"_bucket", withLabel(key, fmt.Sprintf(`le="%g"`, bound)), float64(h.counts[i]))
+				}
+				writeSample(w, name+"_bucket", withLabel(key, `le="+Inf"`), float64(h.count))
+				writeSample(w, name+"_sum", key, h.sum)
+				writeSample(w, name+"_count", key, float64(h.count))
+			}
+			continue
+		}
+
+		for _, key := range sortedMetricKeys(f.values) {
+			writeSample(w, name, key, f.values[key])
+		}
+	}
+}
+
+func sortedMetricKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// commandCategory reduces a remote command to its first two words
+// ("docker ps", "docker stats") to keep label cardinality bounded.
+func commandCategory(command string) string {
+	fields := strings.Fields(command)
+	if len(fields) > 2 {
+		fields = fields[:2]
+	}
+	return strings.Join(fields, " ")
+}
+
+func observeSSHCommand(server, command string, start time.Time, err error) {
+	category := commandCategory(command)
+	promMetrics.Observe("rdm_ssh_command_duration_seconds", "Duration of remote SSH commands.",
+		time.Since(start).Seconds(), "server", server, "command", category)
+	if err != nil {
+		promMetrics.Inc("rdm_ssh_command_errors_total", "Remote SSH commands that failed.",
+			"server", server, "command", category)
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		route := "unmatched"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		promMetrics.Inc("rdm_http_requests_total", "HTTP requests handled.",
+			"method", r.Method, "route", route, "status", strconv.Itoa(recorder.status))
+		promMetrics.Observe("rdm_http_request_duration_seconds", "Duration of HTTP requests.",
+			time.Since(start).Seconds(), "method", r.Method, "route", route)
+	})
+}
+
+// writeServerMetrics scrapes a server's gauges into scrape, labelled with
+// the server so the gauges of every server can share a family.
+func writeServerMetrics(scrape *metricsRegistry, manager *DockerManager) {
+	server := manager.config.ID()
+	const upHelp = "Whether the last scrape of the server succeeded."
+
+	output, err := manager.executeSSHCommand("docker ps -a --format '{{.State}}'")
+	if err != nil {
+		manager.logger.Error("metrics scrape failed", "error", err)
+		scrape.Set("rdm_server_up", upHelp, 0, "server", server)
+		return
+	}
+	scrape.Set("rdm_server_up", upHelp, 1, "server", server)
+
+	counts := map[string]float64{"running": 0, "exited": 0}
+	for _, state := range strings.Fields(output) {
+		counts[state]++
+	}
+	for state, count := range counts {
+		scrape.Set("rdm_containers", "Containers by state.", count, "server", server, "state", state)
+	}
+
+	if report := lastDiskReport(server); report != nil {
+		for _, disk := range report.Disks {
+			scrape.Set("rdm_host_disk_used_percent", "Host filesystem usage in percent at the last disk check.",
+				disk.UsedPercent, "server", server, "mountpoint", disk.Mountpoint)
+			scrape.Set("rdm_host_disk_available_bytes", "Host filesystem free space at the last disk check.",
+				float64(disk.Available), "server", server, "mountpoint", disk.Mountpoint)
+		}
+	}
+
+	if setting("METRICS_CONTAINER_GAUGES") != "true" {
+		return
+	}
+	all, err := manager.GetAllContainerStats()
+	if err != nil {
+		manager.logger.Error("container stats scrape failed", "error", err)
+		return
+	}
+	for _, stats := range all {
+		scrape.Set("rdm_container_cpu_percent", "Container CPU usage in percent.",
+			stats.CPUPercent, "server", server, "container", stats.Name)
+		scrape.Set("rdm_container_memory_bytes", "Container memory usage in bytes.",
+			float64(stats.MemoryUsage), "server", server, "container", stats.Name)
+		scrape.Set("rdm_container_memory_limit_bytes", "Container memory limit in bytes.",
+			float64(stats.MemoryLimit), "server", server, "container", stats.Name)
+	}
+}
+
+// prometheusHandler exports the process metrics and the gauges of every
+// server the caller may access, scraped concurrently.
+func prometheusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	scrape := &metricsRegistry{families: map[string]*metricFamily{}}
+	var wg sync.WaitGroup
+	for _, manager := range accessibleManagers(r) {
+		wg.Add(1)
+		go func(manager *DockerManager) {
+			defer wg.Done()
+			writeServerMetrics(scrape, manager)
+		}(manager)
+	}
+	wg.Wait()
+
+	promMetrics.WriteTo(out)
+	scrape.WriteTo(out)
+}
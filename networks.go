@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type NetworkConnectOptions struct {
+	Aliases []string `json:"aliases"`
+	IPv4    string   `json:"ipv4"`
+	IPv6    string   `json:"ipv6"`
+	Force   bool     `json:"force"`
+}
+
+func (dm *DockerManager) ConnectNetwork(containerID, network string, opts NetworkConnectOptions) error {
+	args := []string{"docker", "network", "connect"}
+	for _, alias := range opts.Aliases {
+		args = append(args, "--alias", shellQuote(alias))
+	}
+	if opts.IPv4 != "" {
+		args = append(args, "--ip", shellQuote(opts.IPv4))
+	}
+	if opts.IPv6 != "" {
+		args = append(args, "--ip6", shellQuote(opts.IPv6))
+	}
+	args = append(args, shellQuote(network), shellQuote(containerID))
+
+	_, err := dm.executeSSHCommand(strings.Join(args, " "))
+	return err
+}
+
+func (dm *DockerManager) DisconnectNetwork(containerID, network string, force bool) error {
+	command := "docker network disconnect "
+	if force {
+		command += "--force "
+	}
+	_, err := dm.executeSSHCommand(command + shellQuote(network) + " " + shellQuote(containerID))
+	return err
+}
+
+func containerNetworkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	var opts NetworkConnectOptions
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil && err != io.EOF {
+		writeError(w, codeInvalidRequest, "Invalid JSON format")
+		return
+	}
+
+	vars := mux.Vars(r)
+	containerID := vars["id"]
+	network := vars["net"]
+	action := vars["action"]
+
+	var err error
+	switch action {
+	case "connect":
+		err = manager.ConnectNetwork(containerID, network, opts)
+	case "disconnect":
+		err = manager.DisconnectNetwork(containerID, network, opts.Force)
+	default:
+		writeError(w, codeInvalidRequest, "Unknown action: "+action)
+		return
+	}
+
+	recordAudit(r, manager.config.ID(), "network."+action, containerID+" "+network, err)
+	if err != nil {
+		manager.logger.Error("network action failed", "container", containerID, "network", network, "action", action, "error", err)
+		writeFailure(w, err)
+		return
+	}
+
+	manager.logger.Info("network action completed", "container", containerID, "network", network, "action", action)
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("Container %sed network %s", action, network),
+	})
+}
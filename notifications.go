@@ -0,0 +1,568 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	notifiersCollection = "notifiers"
+	notifyAttempts      = 5
+)
+
+// notificationEvents are the events a notifier can subscribe to: container
+// events, alert rules firing and resolving, host disks filling up and
+// auto-heal restarts.
+var notificationEvents = []string{"died", "unhealthy", "removed", "oom_killed", "crash_loop", "alert", "disk", "resolved", "autoheal"}
+
+// criticalEvents are never held back for a digest.
+var criticalEvents = []string{"died", "unhealthy", "oom_killed", "crash_loop"}
+
+// Notification is what notifiers are told about a container: it died, its
+// health check started failing, it was OOM killed, it is crash-looping or it
+// was removed. ExitCode is set for died and crash_loop, Rule for alerts.
+type Notification struct {
+	ID          string    `json:"id"`
+	Event       string    `json:"event"`
+	Severity    string    `json:"severity"`
+	Server      string    `json:"server"`
+	Container   string    `json:"container"`
+	ContainerID string    `json:"container_id"`
+	Image       string    `json:"image"`
+	ExitCode    string    `json:"exit_code,omitempty"`
+	Rule        string    `json:"rule,omitempty"`
+	Message     string    `json:"message"`
+	Time        time.Time `json:"time"`
+}
+
+// defaultNotificationTemplate is the text of chat notifications without a
+// template of their own.
+const defaultNotificationTemplate = "[{{.Server}}] {{.Message}}"
+
+// Notifier delivers the notifications of a server's containers to an outside
+// system. Webhook notifiers POST each one as JSON, signed with the secret,
+// which is only returned when the notifier is created. Slack notifiers post
+// to an incoming webhook URL, or with a bot token to Channel, or to the one
+// Channels routes the event to; Telegram notifiers send with a bot token to
+// the chat IDs in the same fields. Templates word the text per event. With
+// Commands a Telegram bot also takes commands from AllowedChats, by default
+// the chats it notifies. Email notifiers mail Recipients and the emails of
+// the server's groups; with Digest, notifications that are not critical are
+// sent together every NOTIFY_DIGEST_INTERVAL.
+type Notifier struct {
+	ID           string            `json:"id"`
+	Server       string            `json:"server"`
+	Type         string            `json:"type"`
+	URL          string            `json:"url,omitempty"`
+	Secret       string            `json:"secret,omitempty"`
+	Token        string            `json:"token,omitempty"`
+	Channel      string            `json:"channel,omitempty"`
+	Channels     map[string]string `json:"channels,omitempty"`
+	Templates    map[string]string `json:"templates,omitempty"`
+	Events       []string          `json:"events"`
+	Commands     bool              `json:"commands,omitempty"`
+	AllowedChats []string          `json:"allowed_chats,omitempty"`
+	Recipients   []string          `json:"recipients,omitempty"`
+	Digest       bool              `json:"digest,omitempty"`
+	CreatedAt    time.Time         `json:"created_at"`
+}
+
+// NotifierStatus reports deliveries since the process started.
+type NotifierStatus struct {
+	Sent        int64     `json:"sent"`
+	Failed      int64     `json:"failed"`
+	Retries     int64     `json:"retries"`
+	LastSentAt  time.Time `json:"last_sent_at"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at"`
+}
+
+var (
+	notifierStatusesMu sync.Mutex
+	notifierStatuses   = map[string]*NotifierStatus{}
+)
+
+func updateNotifierStatus(id string, fn func(status *NotifierStatus)) {
+	notifierStatusesMu.Lock()
+	defer notifierStatusesMu.Unlock()
+	status, ok := notifierStatuses[id]
+	if !ok {
+		status = &NotifierStatus{}
+		notifierStatuses[id] = status
+	}
+	fn(status)
+}
+
+func notifierStatus(id string) NotifierStatus {
+	notifierStatusesMu.Lock()
+	defer notifierStatusesMu.Unlock()
+	if status, ok := notifierStatuses[id]; ok {
+		return *status
+	}
+	return NotifierStatus{}
+}
+
+type notificationSink interface {
+	Send(ctx context.Context, n *Notification) error
+}
+
+func validNotificationEvent(event string) error {
+	if !containsString(notificationEvents, event) {
+		return requestErrorf(codeInvalidRequest, "invalid event %q: use died, unhealthy, removed, oom_killed, crash_loop, alert, disk, resolved or autoheal", event)
+	}
+	return nil
+}
+
+func (n *Notifier) Validate() error {
+	if n.Type == "" {
+		n.Type = "webhook"
+	}
+	switch n.Type {
+	case "webhook":
+		if err := validNotifierURL(n.URL, n.Type); err != nil {
+			return err
+		}
+	case "slack":
+		if n.Token == "" {
+			if err := validNotifierURL(n.URL, n.Type); err != nil {
+				return requestErrorf(codeInvalidRequest, "%v, or a bot token", err)
+			}
+		} else if n.URL != "" {
+			return requestErrorf(codeInvalidRequest, "slack notifiers take a url or a token, not both")
+		}
+	case "telegram":
+		if n.Token == "" {
+			return requestErrorf(codeInvalidRequest, "token is required for telegram notifiers")
+		}
+	case "email":
+		if smtpHost == "" {
+			return requestErrorf(codeInvalidRequest, "email notifiers need SMTP_HOST to be set")
+		}
+		if err := validEmails(n.Recipients); err != nil {
+			return err
+		}
+		if len(n.recipients()) == 0 {
+			return requestErrorf(codeInvalidRequest, "recipients is required unless a server group of %s has emails", n.Server)
+		}
+	default:
+		return requestErrorf(codeInvalidRequest, "unsupported notifier type %q", n.Type)
+	}
+
+	if len(n.Events) == 0 {
+		n.Events = notificationEvents
+	}
+	for _, event := range n.Events {
+		if err := validNotificationEvent(event); err != nil {
+			return err
+		}
+		if n.Token != "" && n.channel(event) == "" {
+			return requestErrorf(codeInvalidRequest, "channel is required for %s notifications", event)
+		}
+	}
+	for event := range n.Channels {
+		if err := validNotificationEvent(event); err != nil {
+			return err
+		}
+	}
+	for event, text := range n.Templates {
+		if err := validNotificationEvent(event); err != nil {
+			return err
+		}
+		if _, err := template.New(event).Parse(text); err != nil {
+			return requestErrorf(codeInvalidRequest, "invalid template for %s: %v", event, err)
+		}
+	}
+	if n.Type != "telegram" && (n.Commands || len(n.AllowedChats) > 0) {
+		return requestErrorf(codeInvalidRequest, "only telegram notifiers take commands")
+	}
+	if n.Type != "email" && (n.Digest || len(n.Recipients) > 0) {
+		return requestErrorf(codeInvalidRequest, "only email notifiers take recipients and digests")
+	}
+	return nil
+}
+
+func validNotifierURL(rawURL, kind string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return requestErrorf(codeInvalidRequest, "invalid url %q: %s notifiers take an http:// or https:// URL", rawURL, kind)
+	}
+	return nil
+}
+
+func (n *Notifier) sink() notificationSink {
+	switch n.Type {
+	case "slack":
+		return &slackSink{notifier: n}
+	case "telegram":
+		return &telegramSink{notifier: n}
+	case "email":
+		return &emailSink{notifier: n}
+	}
+	return &webhookSink{notifier: n}
+}
+
+// channel is where notifications of event go; empty for the channel of an
+// incoming webhook.
+func (n *Notifier) channel(event string) string {
+	if channel := n.Channels[event]; channel != "" {
+		return channel
+	}
+	return n.Channel
+}
+
+// text words a notification with the template for its event.
+func (n *Notifier) text(notification *Notification) (string, error) {
+	text, ok := n.Templates[notification.Event]
+	if !ok {
+		text = defaultNotificationTemplate
+	}
+	tmpl, err := template.New(notification.Event).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, notification); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// redacted hides what lets others post as the notifier: its secret, its
+// token and the path of a Slack webhook URL.
+func (n Notifier) redacted() Notifier {
+	n.Secret = ""
+	n.Token = ""
+	if n.Type == "slack" && n.URL != "" {
+		if u, err := url.Parse(n.URL); err == nil {
+			n.URL = u.Scheme + "://" + u.Host + "/…"
+		}
+	}
+	return n
+}
+
+// webhookSink signs the body with HMAC-SHA256 of the secret, so receivers can
+// check that a notification came from this manager.
+type webhookSink struct {
+	notifier *Notifier
+}
+
+func signNotification(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *webhookSink) Send(ctx context.Context, n *Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return permanentError{err}
+	}
+	resp, err := postSink(ctx, s.notifier.URL, "application/json", body, map[string]string{
+		"X-RDM-Event":     n.Event,
+		"X-RDM-Delivery":  n.ID,
+		"X-RDM-Signature": signNotification(s.notifier.Secret, body),
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkSinkResponse(resp, "webhook")
+}
+
+// deliver sends n, or queues it for the notifier's digest.
+func deliver(dm *DockerManager, notifier *Notifier, n *Notification) {
+	if notifier.Digest && notifyDigestInterval > 0 && n.Severity != "critical" {
+		queueDigest(notifier, n)
+		return
+	}
+	sink := notifier.sink()
+	retryDelivery(dm.logger, notifier, n.Event, func(ctx context.Context) error {
+		return sink.Send(ctx, n)
+	})
+}
+
+// retryDelivery runs send, retrying with backoff unless the receiver rejects
+// what was sent, and keeps the notifier's status.
+func retryDelivery(logger *slog.Logger, notifier *Notifier, event string, send func(ctx context.Context) error) {
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err := send(context.Background())
+		if err == nil {
+			updateNotifierStatus(notifier.ID, func(status *NotifierStatus) {
+				status.Sent++
+				status.LastSentAt = time.Now().UTC()
+			})
+			return
+		}
+
+		var permanent permanentError
+		failed := errors.As(err, &permanent) || attempt == notifyAttempts
+		updateNotifierStatus(notifier.ID, func(status *NotifierStatus) {
+			status.LastError = err.Error()
+			status.LastErrorAt = time.Now().UTC()
+			if failed {
+				status.Failed++
+			} else {
+				status.Retries++
+			}
+		})
+		if failed {
+			logger.Error("dropping notification", "notifier", notifier.ID, "event", event, "error", err)
+			return
+		}
+		logger.Warn("notification failed, retrying", "notifier", notifier.ID, "event", event, "retry_in", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, forwardMaxBackoff)
+	}
+}
+
+func notifiers(server string) ([]Notifier, error) {
+	docs, err := store.List(notifiersCollection)
+	if err != nil {
+		return nil, err
+	}
+	list := []Notifier{}
+	for _, key := range sortedMetricKeys(docs) {
+		var n Notifier
+		if json.Unmarshal(docs[key], &n) == nil && n.Server == server {
+			list = append(list, n)
+		}
+	}
+	return list, nil
+}
+
+// containerNotification turns the docker events notifiers care about into a
+// notification, or returns nil.
+func containerNotification(server string, event *DockerEvent) *Notification {
+	if event.Type != "container" {
+		return nil
+	}
+	n := &Notification{
+		ID:          newRequestID(),
+		Server:      server,
+		Container:   event.Name,
+		ContainerID: event.ID,
+		Image:       event.Image,
+		Time:        event.Time,
+	}
+	switch {
+	case event.Action == "die":
+		n.Event = "died"
+		n.ExitCode = event.Attributes["exitCode"]
+		n.Message = fmt.Sprintf("Container %s died with exit code %s", n.Container, n.ExitCode)
+	case event.Action == "health_status" && event.Detail == "unhealthy":
+		n.Event = "unhealthy"
+		n.Message = "Container " + n.Container + " is unhealthy"
+	case event.Action == "oom":
+		n.Event = "oom_killed"
+		n.Message = "Container " + n.Container + " ran out of memory and was killed"
+	case event.Action == "destroy":
+		n.Event = "removed"
+		n.Message = "Container " + n.Container + " was removed"
+	default:
+		return nil
+	}
+	n.Severity = notificationSeverity(n.Event)
+	return n
+}
+
+func notificationSeverity(event string) string {
+	if containsString(criticalEvents, event) {
+		return "critical"
+	}
+	return "info"
+}
+
+// notifyDockerEvent notifies about event if notifiers care about it.
+func notifyDockerEvent(dm *DockerManager, event *DockerEvent) {
+	if n := containerNotification(dm.config.ID(), event); n != nil {
+		notify(dm, n)
+	}
+}
+
+// notify hands n to every notifier of the server subscribed to its event.
+// Deliveries run in the background so a slow receiver does not hold up the
+// event collector or the sampler.
+func notify(dm *DockerManager, n *Notification) {
+	list, err := notifiers(n.Server)
+	if err != nil {
+		dm.logger.Error("failed to read notifiers", "error", err)
+		return
+	}
+	for i := range list {
+		if containsString(list[i].Events, n.Event) {
+			go deliver(dm, &list[i], n)
+		}
+	}
+}
+
+func notifiersHandler(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		writeError(w, codeUnavailable, "Notifications are not available")
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		list, err := notifiers(manager.config.ID())
+		if err != nil {
+			writeFailure(w, fmt.Errorf("Failed to read notifiers: %w", err))
+			return
+		}
+		type notifierWithStatus struct {
+			Notifier
+			Status NotifierStatus `json:"status"`
+		}
+		result := []notifierWithStatus{}
+		for _, n := range list {
+			result = append(result, notifierWithStatus{n.redacted(), notifierStatus(n.ID)})
+		}
+		writeJSON(w, map[string]interface{}{
+			"success":   true,
+			"notifiers": result,
+			"count":     len(result),
+		})
+	case "POST":
+		var n Notifier
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			writeError(w, codeInvalidRequest, "Invalid JSON format")
+			return
+		}
+		n.Server = manager.config.ID()
+		if err := n.Validate(); err != nil {
+			writeFailure(w, err)
+			return
+		}
+		n.ID = newRequestID()
+		if n.Type == "webhook" && n.Secret == "" {
+			n.Secret = newRequestID() + newRequestID()
+		}
+		n.CreatedAt = time.Now().UTC()
+
+		err := store.Put(notifiersCollection, n.ID, n)
+		recordAudit(r, manager.config.ID(), "notifier.create", n.Type+":"+n.ID, err)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		if n.Commands {
+			startTelegramBots()
+		}
+		writeJSON(w, map[string]interface{}{
+			"success":  true,
+			"notifier": n,
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// notifierFor loads the notifier of the route, answering for the caller
+// when it does not belong to the current server.
+func notifierFor(w http.ResponseWriter, manager *DockerManager, id string) (*Notifier, bool) {
+	var n Notifier
+	found, err := store.Get(notifiersCollection, id, &n)
+	if err != nil {
+		writeFailure(w, err)
+		return nil, false
+	}
+	if !found || n.Server != manager.config.ID() {
+		writeError(w, codeNotFound, "Notifier not found: "+id)
+		return nil, false
+	}
+	return &n, true
+}
+
+// notifierTestHandler sends a single test notification and reports whether
+// it was accepted, without retrying.
+func notifierTestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if store == nil {
+		writeError(w, codeUnavailable, "Notifications are not available")
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+	n, ok := notifierFor(w, manager, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	notification := &Notification{
+		ID:       newRequestID(),
+		Event:    "test",
+		Severity: "info",
+		Server:   n.Server,
+		Message:  "Test notification from Remote Docker Manager",
+		Time:     time.Now().UTC(),
+	}
+	if err := n.sink().Send(r.Context(), notification); err != nil {
+		writeFailure(w, fmt.Errorf("Test notification failed: %w", err))
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"message": "Test notification delivered",
+	})
+}
+
+func notifierRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if store == nil {
+		writeError(w, codeUnavailable, "Notifications are not available")
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+	n, ok := notifierFor(w, manager, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	err := store.Delete(notifiersCollection, n.ID)
+	recordAudit(r, manager.config.ID(), "notifier.remove", n.Type+":"+n.ID, err)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	notifierStatusesMu.Lock()
+	delete(notifierStatuses, n.ID)
+	notifierStatusesMu.Unlock()
+	if n.Commands {
+		startTelegramBots()
+	}
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"message": "Notifier " + n.ID + " removed",
+	})
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	serverGroupsCollection = "server_groups"
+	// serverGroupPrefix marks a group name in a user's server list.
+	serverGroupPrefix = "group:"
+)
+
+var serverGroupPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// ServerGroup names a set of servers, by ID, to grant access to at once.
+// Emails are also mailed by the email notifiers of its servers.
+type ServerGroup struct {
+	Name    string   `json:"name"`
+	Servers []string `json:"servers"`
+	Emails  []string `json:"emails,omitempty"`
+}
+
+// ServerAccessRequest sets which servers a user may reach. Unrestricted
+// users reach every server; restricted ones only the listed server IDs and
+// "group:<name>" entries.
+type ServerAccessRequest struct {
+	Restricted bool     `json:"restricted"`
+	Servers    []string `json:"servers"`
+}
+
+func loadServerGroups() map[string]ServerGroup {
+	groups := map[string]ServerGroup{}
+	if store == nil {
+		return groups
+	}
+	docs, err := store.List(serverGroupsCollection)
+	if err != nil {
+		return groups
+	}
+	for _, data := range docs {
+		var group ServerGroup
+		if json.Unmarshal(data, &group) == nil {
+			groups[strings.ToLower(group.Name)] = group
+		}
+	}
+	return groups
+}
+
+// CanAccessServer reports whether the user may work on the server. Admins
+// always may.
+func (u *User) CanAccessServer(serverID string) bool {
+	if u.IsAdmin() || !u.Restricted {
+		return true
+	}
+	var groups map[string]ServerGroup
+	for _, entry := range u.Servers {
+		name, isGroup := strings.CutPrefix(entry, serverGroupPrefix)
+		if !isGroup {
+			if strings.EqualFold(entry, serverID) {
+				return true
+			}
+			continue
+		}
+		if groups == nil {
+			groups = loadServerGroups()
+		}
+		for _, server := range groups[strings.ToLower(name)].Servers {
+			if strings.EqualFold(server, serverID) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// accountPath reports whether a route is about accounts or the app itself
+// rather than a server, so server access does not apply. Routes spanning
+// servers check access to each one themselves.
+func accountPath(path string) bool {
+	switch path {
+	case "/", "/login", "/health", "/metrics", "/api/config", "/api/audit", "/api/audit/export",
+		"/api/servers", "/api/search":
+		return true
+	}
+	for _, prefix := range []string{"/api/auth/", "/api/users", "/api/tokens", "/api/server-groups"} {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// serverAccessMiddleware refuses every server route of a server the user has
+// not been granted: the {sid} of the route, otherwise the server the request
+// acts on.
+func serverAccessMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := userFrom(r.Context())
+		if user == nil || accountPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		serverID := mux.Vars(r)["sid"]
+		if serverID == "" || serverID == "current" {
+			current, err := selectedManager(r)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			serverID = current.config.ID()
+		}
+		if !user.CanAccessServer(serverID) {
+			writeAuthError(w, http.StatusForbidden, "No access to server "+serverID)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// historyServer is the server whose records a history query reads: the
+// ?server= one, which the user must have access to, else the one the
+// request acts on.
+func historyServer(w http.ResponseWriter, r *http.Request) (string, bool) {
+	server := r.URL.Query().Get("server")
+	if server == "" {
+		manager, ok := requireManager(w, r)
+		if !ok {
+			return "", false
+		}
+		return manager.config.ID(), true
+	}
+	if user := userFrom(r.Context()); user != nil && !user.CanAccessServer(server) {
+		writeAuthError(w, http.StatusForbidden, "No access to server "+server)
+		return "", false
+	}
+	return server, true
+}
+
+func serverGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		groups := loadServerGroups()
+		list := []ServerGroup{}
+		for _, group := range groups {
+			list = append(list, group)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+		writeJSON(w, map[string]interface{}{
+			"success": true,
+			"groups":  list,
+			"count":   len(list),
+		})
+	case "POST":
+		var group ServerGroup
+		if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+			writeError(w, codeInvalidRequest, "Invalid JSON format")
+			return
+		}
+		var err error
+		if !serverGroupPattern.MatchString(group.Name) {
+			err = requestErrorf(codeInvalidRequest, "invalid group name %q", group.Name)
+		} else if emailErr := validEmails(group.Emails); emailErr != nil {
+			err = emailErr
+		} else if store == nil {
+			err = requestErrorf(codeUnavailable, "server groups are not available")
+		} else {
+			if group.Servers == nil {
+				group.Servers = []string{}
+			}
+			err = store.Put(serverGroupsCollection, strings.ToLower(group.Name), group)
+		}
+		recordAudit(r, "", "server_group.save", group.Name, err)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"success": true,
+			"group":   group,
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func serverGroupRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	_, found := loadServerGroups()[strings.ToLower(name)]
+	var err error
+	if !found {
+		err = requestErrorf(codeNotFound, "server group %s not found", name)
+	} else {
+		err = store.Delete(serverGroupsCollection, strings.ToLower(name))
+	}
+	recordAudit(r, "", "server_group.remove", name, err)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"message": "Server group " + name + " removed",
+	})
+}
+
+func userServersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	var req ServerAccessRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, codeInvalidRequest, "Invalid JSON format")
+		return
+	}
+
+	username := mux.Vars(r)["name"]
+	user, err := loadUser(username)
+	if err == nil && user == nil {
+		err = requestErrorf(codeNotFound, "user %s not found", username)
+	}
+	if err == nil {
+		user.Restricted = req.Restricted
+		user.Servers = nil
+		if req.Restricted {
+			user.Servers = req.Servers
+		}
+		err = saveUser(user)
+	}
+	recordAudit(r, "", "user.servers", username, err)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success":    true,
+		"username":   user.Username,
+		"restricted": user.Restricted,
+		"servers":    user.Servers,
+	})
+}
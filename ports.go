@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+type PortMapping struct {
+	HostIP        string `json:"host_ip,omitempty"`
+	HostPort      int    `json:"host_port,omitempty"`
+	ContainerPort int    `json:"container_port"`
+	Protocol      string `json:"protocol"`
+}
+
+// parsePortRange accepts "80" or "8000-8002".
+func parsePortRange(value string) (int, int, bool) {
+	low, high, isRange := strings.Cut(value, "-")
+	start, err := strconv.Atoi(low)
+	if err != nil {
+		return 0, 0, false
+	}
+	if !isRange {
+		return start, start, true
+	}
+	end, err := strconv.Atoi(high)
+	if err != nil || end < start {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// parsePorts parses the Ports column of docker ps, e.g.
+// "0.0.0.0:8080->80/tcp, :::8080->80/tcp, 443/tcp". Published ranges such
+// as "0.0.0.0:8000-8001->8000-8001/tcp" are expanded to one entry per port.
+func parsePorts(value string) []PortMapping {
+	mappings := []PortMapping{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		host, target, published := strings.Cut(item, "->")
+		if !published {
+			target, host = host, ""
+		}
+		portSpec, protocol, ok := strings.Cut(target, "/")
+		if !ok {
+			protocol = "tcp"
+		}
+		containerStart, containerEnd, ok := parsePortRange(portSpec)
+		if !ok {
+			continue
+		}
+
+		if !published {
+			for port := containerStart; port <= containerEnd; port++ {
+				mappings = append(mappings, PortMapping{ContainerPort: port, Protocol: protocol})
+			}
+			continue
+		}
+
+		// The host side is "IP:PORT"; IPv6 addresses contain colons
+		// themselves ("::" or "[::1]"), so split on the last one.
+		i := strings.LastIndex(host, ":")
+		if i < 0 {
+			continue
+		}
+		hostIP := strings.Trim(host[:i], "[]")
+		hostStart, _, ok := parsePortRange(host[i+1:])
+		if !ok {
+			continue
+		}
+		for offset := 0; offset <= containerEnd-containerStart; offset++ {
+			mappings = append(mappings, PortMapping{
+				HostIP:        hostIP,
+				HostPort:      hostStart + offset,
+				ContainerPort: containerStart + offset,
+				Protocol:      protocol,
+			})
+		}
+	}
+	return mappings
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePorts(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		want  []PortMapping
+	}{
+		{"", []PortMapping{}},
+		{"80/tcp", []PortMapping{{ContainerPort: 80, Protocol: "tcp"}}},
+		{"53/udp", []PortMapping{{ContainerPort: 53, Protocol: "udp"}}},
+		{"0.0.0.0:8080->80/tcp, :::8080->80/tcp, 443/tcp", []PortMapping{
+			{HostIP: "0.0.0.0", HostPort: 8080, ContainerPort: 80, Protocol: "tcp"},
+			{HostIP: "::", HostPort: 8080, ContainerPort: 80, Protocol: "tcp"},
+			{ContainerPort: 443, Protocol: "tcp"},
+		}},
+		{"[::1]:9000->9000/tcp", []PortMapping{{HostIP: "::1", HostPort: 9000, ContainerPort: 9000, Protocol: "tcp"}}},
+		{"0.0.0.0:8000-8001->8000-8001/tcp", []PortMapping{
+			{HostIP: "0.0.0.0", HostPort: 8000, ContainerPort: 8000, Protocol: "tcp"},
+			{HostIP: "0.0.0.0", HostPort: 8001, ContainerPort: 8001, Protocol: "tcp"},
+		}},
+		{"7000-7001/tcp", []PortMapping{{ContainerPort: 7000, Protocol: "tcp"}, {ContainerPort: 7001, Protocol: "tcp"}}},
+		{"127.0.0.1:5432->5432", []PortMapping{{HostIP: "127.0.0.1", HostPort: 5432, ContainerPort: 5432, Protocol: "tcp"}}},
+		{"http/tcp, 9-8/tcp, 8080->80/tcp", []PortMapping{}},
+	} {
+		if got := parsePorts(tc.value); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parsePorts(%q) = %+v, want %+v", tc.value, got, tc.want)
+		}
+	}
+}
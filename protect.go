@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	protectLabel         = "rdm.protect"
+	protectionCollection = "protected_containers"
+)
+
+// protectedActions are the container actions a protected container refuses
+// without ?override=true.
+var protectedActions = map[string]bool{
+	"stop":     true,
+	"remove":   true,
+	"kill":     true,
+	"redeploy": true,
+}
+
+// ContainerProtection is a protection flag stored by container name, so it
+// survives the container being recreated.
+type ContainerProtection struct {
+	Server    string    `json:"server"`
+	Container string    `json:"container"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ProtectionStatus says whether a container is protected and by what: the
+// rdm.protect label, a stored flag or both.
+type ProtectionStatus struct {
+	Container string `json:"container"`
+	Protected bool   `json:"protected"`
+	Label     bool   `json:"label"`
+	Stored    bool   `json:"stored"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+type ProtectionRequest struct {
+	Protected bool   `json:"protected"`
+	Reason    string `json:"reason"`
+}
+
+type protectedError struct {
+	container string
+	action    string
+}
+
+func (e *protectedError) Error() string {
+	return fmt.Sprintf("container %s is protected; pass override=true to %s it anyway", e.container, e.action)
+}
+
+func (e *protectedError) ErrorCode() string {
+	return codeConflict
+}
+
+func protectLabelSet(labels map[string]string) bool {
+	return labelEnabled(labels[protectLabel])
+}
+
+// labelEnabled reads a boolean rdm.* label.
+func labelEnabled(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "yes", "1":
+		return true
+	}
+	return false
+}
+
+// storedProtections returns the server's stored flags by container name.
+func storedProtections(server string) map[string]ContainerProtection {
+	protections := map[string]ContainerProtection{}
+	if store == nil {
+		return protections
+	}
+	docs, err := store.List(protectionCollection)
+	if err != nil {
+		return protections
+	}
+	for _, data := range docs {
+		var p ContainerProtection
+		if json.Unmarshal(data, &p) == nil && p.Server == server {
+			protections[p.Container] = p
+		}
+	}
+	return protections
+}
+
+func (dm *DockerManager) ContainerProtection(containerID string) (*ProtectionStatus, error) {
+	info, err := dm.inspectContainer(containerID)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimPrefix(info.Name, "/")
+	status := &ProtectionStatus{Container: name, Label: protectLabelSet(info.Config.Labels)}
+	if p, ok := storedProtections(dm.config.ID())[name]; ok {
+		status.Stored = true
+		status.Reason = p.Reason
+	}
+	status.Protected = status.Label || status.Stored
+	return status, nil
+}
+
+// checkProtected refuses action on a protected container unless override is
+// set. A container that cannot be inspected is left for the action itself to
+// report.
+func (dm *DockerManager) checkProtected(containerID, action string, override bool) error {
+	if override || !protectedActions[action] {
+		return nil
+	}
+	status, err := dm.ContainerProtection(containerID)
+	if err != nil || !status.Protected {
+		return nil
+	}
+	return &protectedError{container: status.Container, action: action}
+}
+
+func overrideRequested(r *http.Request) bool {
+	return r.URL.Query().Get("override") == "true"
+}
+
+func isProtectedError(err error) bool {
+	var protected *protectedError
+	return errors.As(err, &protected)
+}
+
+func containerProtectionHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+	containerID := mux.Vars(r)["id"]
+
+	switch r.Method {
+	case "GET":
+		status, err := manager.ContainerProtection(containerID)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"success":    true,
+			"protection": status,
+		})
+	case "POST":
+		if store == nil {
+			writeError(w, codeUnavailable, "Stored protection is not available; use the "+protectLabel+" label")
+			return
+		}
+		var req ProtectionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, codeInvalidRequest, "Invalid JSON format")
+			return
+		}
+		info, err := manager.inspectContainer(containerID)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+
+		name := strings.TrimPrefix(info.Name, "/")
+		key := manager.config.ID() + "/" + name
+		action := "container.unprotect"
+		if req.Protected {
+			action = "container.protect"
+			err = store.Put(protectionCollection, key, ContainerProtection{
+				Server:    manager.config.ID(),
+				Container: name,
+				Reason:    req.Reason,
+				CreatedAt: time.Now().UTC(),
+			})
+		} else {
+			err = store.Delete(protectionCollection, key)
+		}
+		recordAudit(r, manager.config.ID(), action, name, err)
+		if err != nil {
+			writeFailure(w, fmt.Errorf("Failed to save protection: %w", err))
+			return
+		}
+		containerCache.invalidate(manager.config.ID())
+
+		status, err := manager.ContainerProtection(containerID)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		manager.logger.Info("container protection changed", "container", name, "protected", req.Protected)
+		writeJSON(w, map[string]interface{}{
+			"success":    true,
+			"protection": status,
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
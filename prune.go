@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// PruneOptions widens what a prune removes: All includes unused images that
+// are still tagged (and named volumes on volume prune), Volumes adds unused
+// volumes to a system prune.
+type PruneOptions struct {
+	All     bool `json:"all"`
+	Volumes bool `json:"volumes"`
+}
+
+type PruneItem struct {
+	// Type is container, image, volume, network or build_cache.
+	Type string `json:"type"`
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// PrunePreview lists what a prune would remove right now. Reclaimable is an
+// estimate from docker's own size accounting.
+type PrunePreview struct {
+	Target      string       `json:"target"`
+	Options     PruneOptions `json:"options"`
+	Items       []PruneItem  `json:"items"`
+	Count       int          `json:"count"`
+	Reclaimable int64        `json:"reclaimable"`
+}
+
+type PruneResult struct {
+	Output    string `json:"output"`
+	Reclaimed int64  `json:"reclaimed"`
+}
+
+// builtinNetworks are never removed by network prune.
+var builtinNetworks = map[string]bool{
+	"bridge":          true,
+	"host":            true,
+	"none":            true,
+	"ingress":         true,
+	"docker_gwbridge": true,
+}
+
+// prunedContainerStates are the states container prune removes.
+var prunedContainerStates = map[string]bool{
+	"created": true,
+	"exited":  true,
+	"dead":    true,
+}
+
+// anonymousVolumesOnly reports whether the daemon's volume prune leaves named
+// volumes alone unless --all is given, which is the case from API 1.42
+// (Docker 23) on.
+func (dm *DockerManager) anonymousVolumesOnly() bool {
+	output, err := dm.executeSSHCommand("docker version --format '{{.Server.APIVersion}}'")
+	if err != nil {
+		dm.logger.Error("docker version failed", "error", err)
+		return false
+	}
+	major, minor, _ := strings.Cut(strings.TrimSpace(output), ".")
+	x, _ := strconv.Atoi(major)
+	y, _ := strconv.Atoi(minor)
+	return x > 1 || (x == 1 && y >= 42)
+}
+
+func (dm *DockerManager) pruneCommand(target string, opts PruneOptions) (string, error) {
+	command, ok := pruneTargets[target]
+	if !ok || target == "" {
+		return "", requestErrorf(codeInvalidRequest, "invalid prune target %q: use system, container, image, volume or network", target)
+	}
+	switch target {
+	case "image":
+		if opts.All {
+			command += " -a"
+		}
+	case "volume":
+		if opts.All && dm.anonymousVolumesOnly() {
+			command += " --all"
+		}
+	case "system":
+		if opts.All {
+			command += " -a"
+		}
+		if opts.Volumes {
+			command += " --volumes"
+		}
+	}
+	return command, nil
+}
+
+// containerRefs returns the image IDs, volume names and network names used by
+// containers that a prune keeps: all of them, or only running ones when
+// stopped containers are pruned too.
+func (dm *DockerManager) containerRefs(all bool) (map[string]bool, map[string]bool, map[string]bool, error) {
+	list := "docker ps -q --no-trunc"
+	if all {
+		list = "docker ps -aq --no-trunc"
+	}
+	format := `{{.Image}}|{{range .Mounts}}{{if eq .Type "volume"}}{{.Name}} {{end}}{{end}}|{{range $k, $v := .NetworkSettings.Networks}}{{$k}} {{end}}`
+	output, err := dm.executeSSHCommand(list + " | xargs -r docker inspect --format " + shellQuote(format))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	images, volumes, networks := map[string]bool{}, map[string]bool{}, map[string]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.Split(strings.TrimSpace(line), "|")
+		if len(parts) != 3 {
+			continue
+		}
+		images[strings.TrimPrefix(parts[0], "sha256:")] = true
+		for _, name := range strings.Fields(parts[1]) {
+			volumes[name] = true
+		}
+		for _, name := range strings.Fields(parts[2]) {
+			networks[name] = true
+		}
+	}
+	return images, volumes, networks, nil
+}
+
+// imageInUse matches docker df's short image IDs against full ones.
+func imageInUse(id string, images map[string]bool) bool {
+	id = strings.TrimPrefix(id, "sha256:")
+	for full := range images {
+		if strings.HasPrefix(full, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// PrunePreview works out what the prune would remove without touching
+// anything. Pruning system removes stopped containers first, so whatever
+// only they use counts as unused.
+func (dm *DockerManager) PrunePreview(target string, opts PruneOptions) (*PrunePreview, error) {
+	if _, err := dm.pruneCommand(target, PruneOptions{}); err != nil {
+		return nil, err
+	}
+	system := target == "system"
+
+	output, err := dm.executeSSHCommand("docker system df -v --format '{{json .}}'")
+	if err != nil {
+		return nil, fmt.Errorf("Docker system df command failed: %w", err)
+	}
+	var df struct {
+		Images []struct {
+			ID         string
+			Repository string
+			Tag        string
+			Size       string
+			UniqueSize string
+		}
+		Containers []struct {
+			ID    string
+			Names string
+			State string
+			Size  string
+		}
+		Volumes []struct {
+			Name   string
+			Labels string
+			Size   string
+		}
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(output)), &df); err != nil {
+		return nil, fmt.Errorf("failed to parse system df -v output: %v", err)
+	}
+
+	var images, volumes, networks map[string]bool
+	if target != "container" {
+		if images, volumes, networks, err = dm.containerRefs(!system); err != nil {
+			return nil, err
+		}
+	}
+
+	preview := &PrunePreview{Target: target, Options: opts, Items: []PruneItem{}}
+	add := func(item PruneItem) {
+		preview.Items = append(preview.Items, item)
+		preview.Reclaimable += item.Size
+	}
+
+	if system || target == "container" {
+		for _, c := range df.Containers {
+			if prunedContainerStates[c.State] {
+				add(PruneItem{Type: "container", ID: c.ID, Name: c.Names, Size: parseHumanSize(c.Size)})
+			}
+		}
+	}
+
+	if system || target == "image" {
+		for _, image := range df.Images {
+			dangling := image.Repository == "<none>" && image.Tag == "<none>"
+			if (!dangling && !opts.All) || imageInUse(image.ID, images) {
+				continue
+			}
+			name := image.Repository + ":" + image.Tag
+			if dangling {
+				name = image.ID
+			}
+			size := image.UniqueSize
+			if size == "" {
+				size = image.Size
+			}
+			add(PruneItem{Type: "image", ID: image.ID, Name: name, Size: parseHumanSize(size)})
+		}
+	}
+
+	if target == "volume" || (system && opts.Volumes) {
+		anonymousOnly := dm.anonymousVolumesOnly() && (system || !opts.All)
+		for _, volume := range df.Volumes {
+			if volumes[volume.Name] {
+				continue
+			}
+			if anonymousOnly && !strings.Contains(volume.Labels, "com.docker.volume.anonymous") {
+				continue
+			}
+			add(PruneItem{Type: "volume", Name: volume.Name, Size: parseHumanSize(volume.Size)})
+		}
+	}
+
+	if system || target == "network" {
+		output, err := dm.executeSSHCommand("docker network ls --format '{{.ID}}|{{.Name}}'")
+		if err != nil {
+			return nil, fmt.Errorf("Docker network ls command failed: %w", err)
+		}
+		for _, line := range strings.Split(output, "\n") {
+			id, name, ok := strings.Cut(strings.TrimSpace(line), "|")
+			if ok && !builtinNetworks[name] && !networks[name] {
+				add(PruneItem{Type: "network", ID: id, Name: name})
+			}
+		}
+	}
+
+	if system {
+		usage, err := dm.GetDiskUsage()
+		if err != nil {
+			return nil, err
+		}
+		if usage.BuildCache.Reclaimable > 0 {
+			add(PruneItem{Type: "build_cache", Name: "build cache", Size: usage.BuildCache.Reclaimable})
+		}
+	}
+
+	preview.Count = len(preview.Items)
+	return preview, nil
+}
+
+func pruneSummary(preview *PrunePreview) string {
+	return fmt.Sprintf("Prune %s: %d items would be removed, reclaiming about %s", preview.Target, preview.Count, formatSize(preview.Reclaimable))
+}
+
+// formatSize renders bytes the way docker does, in decimal units.
+func formatSize(n int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	value := float64(n)
+	i := 0
+	for value >= 1000 && i < len(units)-1 {
+		value /= 1000
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d%s", n, units[0])
+	}
+	return fmt.Sprintf("%.1f%s", value, units[i])
+}
+
+func (dm *DockerManager) Prune(target string, opts PruneOptions) (*PruneResult, error) {
+	command, err := dm.pruneCommand(target, opts)
+	if err != nil {
+		return nil, err
+	}
+	output, err := dm.executeSSHCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PruneResult{Output: strings.TrimSpace(output)}
+	for _, line := range strings.Split(output, "\n") {
+		if _, size, ok := strings.Cut(line, "Total reclaimed space:"); ok {
+			result.Reclaimed += parseHumanSize(size)
+		}
+	}
+	return result, nil
+}
+
+func pruneOptions(r *http.Request) PruneOptions {
+	query := r.URL.Query()
+	return PruneOptions{
+		All:     query.Get("all") == "true",
+		Volumes: query.Get("volumes") == "true",
+	}
+}
+
+// pruneHandler previews a prune on GET. POST returns the preview with a
+// confirmation token first and prunes once the token is sent back.
+// ?dry_run=true adds the command that would run.
+func pruneHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	target := mux.Vars(r)["target"]
+	opts := pruneOptions(r)
+
+	switch r.Method {
+	case "GET":
+		preview, err := manager.PrunePreview(target, opts)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"success": true,
+			"preview": preview,
+		})
+	case "POST":
+		if dryRunRequested(r) {
+			command, err := manager.pruneCommand(target, opts)
+			if err != nil {
+				writeFailure(w, err)
+				return
+			}
+			preview, err := manager.PrunePreview(target, opts)
+			if err != nil {
+				writeFailure(w, err)
+				return
+			}
+			writeDryRun(w, []string{command}, map[string]interface{}{
+				"preview": preview,
+			})
+			return
+		}
+		if !confirmOperation(w, r, manager, requestOperation(r), func() (string, map[string]interface{}, error) {
+			preview, err := manager.PrunePreview(target, opts)
+			if err != nil {
+				return "", nil, err
+			}
+			return pruneSummary(preview), map[string]interface{}{"preview": preview}, nil
+		}) {
+			return
+		}
+
+		action := target + ".prune"
+		if asyncRequested(r) {
+			if _, err := manager.pruneCommand(target, opts); err != nil {
+				writeFailure(w, err)
+				return
+			}
+			job, err := submitJob(r, manager, action, "", false, func(ctx context.Context, run *jobRun) (interface{}, error) {
+				result, err := manager.Prune(target, opts)
+				if err != nil {
+					return nil, err
+				}
+				if result.Output != "" {
+					run.Log(result.Output)
+				}
+				return result, nil
+			})
+			writeJobResponse(w, job, err)
+			return
+		}
+
+		result, err := manager.Prune(target, opts)
+		recordAudit(r, manager.config.ID(), action, "", err)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		manager.logger.Info("pruned", "target", target, "reclaimed", result.Reclaimed)
+		writeJSON(w, map[string]interface{}{
+			"success":   true,
+			"output":    result.Output,
+			"reclaimed": result.Reclaimed,
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
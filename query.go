@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultPageLimit is the page size when ?page= is given without ?limit=.
+const defaultPageLimit = 50
+
+// ContainerQuery selects, orders and pages a container list on behalf of
+// /api/containers, for hosts with too many containers to send at once.
+type ContainerQuery struct {
+	State string
+	Name  string
+	Image string
+	Sort  string
+	Desc  bool
+	Page  int
+	Limit int
+}
+
+var containerSorts = map[string]func(a, b Container) bool{
+	"name":    func(a, b Container) bool { return a.Name < b.Name },
+	"created": func(a, b Container) bool { return a.Created.Before(b.Created) },
+	// Running containers come first, then by name.
+	"status": func(a, b Container) bool {
+		if a.State != b.State {
+			return a.State == "running"
+		}
+		return a.Name < b.Name
+	},
+}
+
+func parseContainerQuery(values url.Values) (ContainerQuery, error) {
+	q := ContainerQuery{
+		State: values.Get("state"),
+		Name:  strings.ToLower(values.Get("name")),
+		Image: strings.ToLower(values.Get("image")),
+		Sort:  values.Get("sort"),
+	}
+	if q.State != "" && q.State != "running" && q.State != "stopped" && q.State != "restarting" {
+		return q, requestErrorf(codeInvalidRequest, "Invalid state %q: use running, stopped or restarting", q.State)
+	}
+	if _, ok := containerSorts[q.Sort]; q.Sort != "" && !ok {
+		return q, requestErrorf(codeInvalidRequest, "Invalid sort %q: use name, created or status", q.Sort)
+	}
+	switch order := values.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return q, requestErrorf(codeInvalidRequest, "Invalid order %q: use asc or desc", order)
+	}
+
+	var err error
+	if value := values.Get("page"); value != "" {
+		if q.Page, err = strconv.Atoi(value); err != nil || q.Page <= 0 {
+			return q, requestErrorf(codeInvalidRequest, "Invalid page: %s", value)
+		}
+		q.Limit = defaultPageLimit
+	}
+	if value := values.Get("limit"); value != "" {
+		if q.Limit, err = strconv.Atoi(value); err != nil || q.Limit <= 0 {
+			return q, requestErrorf(codeInvalidRequest, "Invalid limit: %s", value)
+		}
+		if q.Page == 0 {
+			q.Page = 1
+		}
+	}
+	return q, nil
+}
+
+func (q ContainerQuery) matches(c Container) bool {
+	return (q.State == "" || c.State == q.State) &&
+		strings.Contains(strings.ToLower(c.Name), q.Name) &&
+		strings.Contains(strings.ToLower(c.Image), q.Image)
+}
+
+// Apply returns the page of matching containers and how many matched in
+// total. Without a sort they stay in docker's order, newest first.
+func (q ContainerQuery) Apply(containers []Container) ([]Container, int) {
+	matching := []Container{}
+	for _, c := range containers {
+		if q.matches(c) {
+			matching = append(matching, c)
+		}
+	}
+
+	if less, ok := containerSorts[q.Sort]; ok {
+		sort.SliceStable(matching, func(i, j int) bool {
+			if q.Desc {
+				return less(matching[j], matching[i])
+			}
+			return less(matching[i], matching[j])
+		})
+	} else if q.Desc {
+		for i, j := 0, len(matching)-1; i < j; i, j = i+1, j-1 {
+			matching[i], matching[j] = matching[j], matching[i]
+		}
+	}
+
+	total := len(matching)
+	if q.Limit == 0 {
+		return matching, total
+	}
+	start := (q.Page - 1) * q.Limit
+	if start >= total {
+		return []Container{}, total
+	}
+	end := start + q.Limit
+	if end > total {
+		end = total
+	}
+	return matching[start:end], total
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ResourceLimits are the container's current limits as reported by inspect.
+// Zero means unlimited; MemorySwap is -1 for unlimited swap.
+type ResourceLimits struct {
+	CPUs              float64 `json:"cpus"`
+	CPUShares         int64   `json:"cpu_shares"`
+	Memory            int64   `json:"memory"`
+	MemoryReservation int64   `json:"memory_reservation"`
+	MemorySwap        int64   `json:"memory_swap"`
+	PidsLimit         int64   `json:"pids_limit"`
+}
+
+// ResourceUpdateRequest takes docker's own notation ("1.5" CPUs, "512m"
+// memory); empty fields are left unchanged.
+type ResourceUpdateRequest struct {
+	CPUs              string `json:"cpus"`
+	CPUShares         string `json:"cpu_shares"`
+	Memory            string `json:"memory"`
+	MemoryReservation string `json:"memory_reservation"`
+	MemorySwap        string `json:"memory_swap"`
+	PidsLimit         string `json:"pids_limit"`
+}
+
+var memoryLimitPattern = regexp.MustCompile(`^(-1|\d+[bkmgBKMG]?)$`)
+
+// Args are the docker update flags of the request.
+func (req *ResourceUpdateRequest) Args() ([]string, error) {
+	args, err := req.flags()
+	if err == nil && len(args) == 0 {
+		err = fmt.Errorf("no limits given")
+	}
+	return args, err
+}
+
+// flags are the limit flags shared by docker update and docker run.
+func (req *ResourceUpdateRequest) flags() ([]string, error) {
+	var args []string
+	if req.CPUs != "" {
+		if cpus, err := strconv.ParseFloat(req.CPUs, 64); err != nil || cpus < 0 {
+			return nil, requestErrorf(codeInvalidRequest, "invalid cpus %q", req.CPUs)
+		}
+		args = append(args, "--cpus", req.CPUs)
+	}
+	if req.CPUShares != "" {
+		if _, err := strconv.ParseUint(req.CPUShares, 10, 64); err != nil {
+			return nil, requestErrorf(codeInvalidRequest, "invalid cpu_shares %q", req.CPUShares)
+		}
+		args = append(args, "--cpu-shares", req.CPUShares)
+	}
+	for _, limit := range []struct{ flag, name, value string }{
+		{"--memory", "memory", req.Memory},
+		{"--memory-reservation", "memory_reservation", req.MemoryReservation},
+		{"--memory-swap", "memory_swap", req.MemorySwap},
+	} {
+		if limit.value == "" {
+			continue
+		}
+		if !memoryLimitPattern.MatchString(limit.value) {
+			return nil, requestErrorf(codeInvalidRequest, "invalid %s %q", limit.name, limit.value)
+		}
+		args = append(args, limit.flag, limit.value)
+	}
+	if req.PidsLimit != "" {
+		if _, err := strconv.ParseInt(req.PidsLimit, 10, 64); err != nil {
+			return nil, requestErrorf(codeInvalidRequest, "invalid pids_limit %q", req.PidsLimit)
+		}
+		args = append(args, "--pids-limit", req.PidsLimit)
+	}
+	return args, nil
+}
+
+func (dm *DockerManager) GetResourceLimits(containerID string) (*ResourceLimits, error) {
+	info, err := dm.inspectContainer(containerID)
+	if err != nil {
+		return nil, err
+	}
+	return resourceLimitsOf(info), nil
+}
+
+func resourceLimitsOf(info *containerInspect) *ResourceLimits {
+	limits := &ResourceLimits{
+		CPUs:              float64(info.HostConfig.NanoCpus) / 1e9,
+		CPUShares:         info.HostConfig.CpuShares,
+		Memory:            info.HostConfig.Memory,
+		MemoryReservation: info.HostConfig.MemoryReservation,
+		MemorySwap:        info.HostConfig.MemorySwap,
+	}
+	// Limits set through --cpu-quota rather than --cpus.
+	if limits.CPUs == 0 && info.HostConfig.CpuQuota > 0 && info.HostConfig.CpuPeriod > 0 {
+		limits.CPUs = float64(info.HostConfig.CpuQuota) / float64(info.HostConfig.CpuPeriod)
+	}
+	if info.HostConfig.PidsLimit != nil && *info.HostConfig.PidsLimit > 0 {
+		limits.PidsLimit = *info.HostConfig.PidsLimit
+	}
+	return limits
+}
+
+// Request renders the limits so that a new container gets the same ones,
+// or nil when the container has none.
+func (l *ResourceLimits) Request() *ResourceUpdateRequest {
+	format := func(value int64) string {
+		if value == 0 {
+			return ""
+		}
+		return strconv.FormatInt(value, 10)
+	}
+	req := &ResourceUpdateRequest{
+		CPUShares:         format(l.CPUShares),
+		Memory:            format(l.Memory),
+		MemoryReservation: format(l.MemoryReservation),
+		MemorySwap:        format(l.MemorySwap),
+		PidsLimit:         format(l.PidsLimit),
+	}
+	if l.CPUs > 0 {
+		req.CPUs = strconv.FormatFloat(l.CPUs, 'f', -1, 64)
+	}
+	if *req == (ResourceUpdateRequest{}) {
+		return nil
+	}
+	return req
+}
+
+func (dm *DockerManager) UpdateResourceLimits(containerID string, req ResourceUpdateRequest) error {
+	args, err := req.Args()
+	if err != nil {
+		return err
+	}
+	_, err = dm.executeSSHCommand("docker update " + strings.Join(args, " ") + " " + shellQuote(containerID))
+	return err
+}
+
+func containerResourcesHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+	containerID := mux.Vars(r)["id"]
+
+	switch r.Method {
+	case "GET":
+	case "POST":
+		var req ResourceUpdateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, codeInvalidRequest, "Invalid JSON format")
+			return
+		}
+		err := manager.UpdateResourceLimits(containerID, req)
+		recordAudit(r, manager.config.ID(), "container.update_resources", containerID, err)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		manager.logger.Info("container limits updated", "container", containerID)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limits, err := manager.GetResourceLimits(containerID)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"limits":  limits,
+	})
+}
+
+type RestartPolicy struct {
+	Name              string `json:"name"`
+	MaximumRetryCount int    `json:"maximum_retry_count"`
+}
+
+// String renders the policy in --restart notation.
+func (p RestartPolicy) String() string {
+	if p.Name == "" {
+		return "no"
+	}
+	if p.Name == "on-failure" && p.MaximumRetryCount > 0 {
+		return fmt.Sprintf("on-failure:%d", p.MaximumRetryCount)
+	}
+	return p.Name
+}
+
+func (dm *DockerManager) GetRestartPolicy(containerID string) (*RestartPolicy, error) {
+	info, err := dm.inspectContainer(containerID)
+	if err != nil {
+		return nil, err
+	}
+	return &RestartPolicy{
+		Name:              info.HostConfig.RestartPolicy.Name,
+		MaximumRetryCount: info.HostConfig.RestartPolicy.MaximumRetryCount,
+	}, nil
+}
+
+func (dm *DockerManager) SetRestartPolicy(containerID, policy string) error {
+	if !restartPolicyPattern.MatchString(policy) {
+		return requestErrorf(codeInvalidRequest, "invalid restart policy %q", policy)
+	}
+	_, err := dm.executeSSHCommand("docker update --restart " + shellQuote(policy) + " " + shellQuote(containerID))
+	return err
+}
+
+func containerRestartPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+	containerID := mux.Vars(r)["id"]
+
+	switch r.Method {
+	case "GET":
+	case "POST":
+		var req struct {
+			Policy string `json:"policy"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, codeInvalidRequest, "Invalid JSON format")
+			return
+		}
+		err := manager.SetRestartPolicy(containerID, req.Policy)
+		recordAudit(r, manager.config.ID(), "container.update_restart_policy", containerID, err)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		manager.logger.Info("restart policy updated", "container", containerID, "policy", req.Policy)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	policy, err := manager.GetRestartPolicy(containerID)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success":        true,
+		"restart_policy": policy,
+		"policy":         policy.String(),
+	})
+}
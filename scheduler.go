@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	schedulesCollection    = "schedules"
+	scheduleRunsCollection = "schedule_runs"
+)
+
+// Schedule runs an action on the server whenever its cron expression
+// matches, in Timezone or the manager's local time.
+type Schedule struct {
+	ID         string      `json:"id"`
+	Server     string      `json:"server"`
+	Name       string      `json:"name"`
+	Cron       string      `json:"cron"`
+	Timezone   string      `json:"timezone,omitempty"`
+	Action     string      `json:"action"`
+	Target     string      `json:"target,omitempty"`
+	Backup     *BackupSpec `json:"backup,omitempty"`
+	Enabled    bool        `json:"enabled"`
+	CreatedAt  time.Time   `json:"created_at"`
+	LastRun    time.Time   `json:"last_run"`
+	LastResult string      `json:"last_result,omitempty"`
+	NextRun    time.Time   `json:"next_run"`
+}
+
+type ScheduleRun struct {
+	Time     time.Time `json:"time"`
+	Server   string    `json:"server"`
+	Schedule string    `json:"schedule"`
+	Action   string    `json:"action"`
+	Target   string    `json:"target,omitempty"`
+	Trigger  string    `json:"trigger"`
+	Result   string    `json:"result"`
+	Error    string    `json:"error,omitempty"`
+	Output   string    `json:"output,omitempty"`
+	Duration string    `json:"duration"`
+}
+
+type scheduleAction struct {
+	// target describes what Target names; empty if the action takes none.
+	target string
+	run    func(ctx context.Context, dm *DockerManager, s *Schedule) (string, error)
+}
+
+var pruneTargets = map[string]string{
+	"":          "docker system prune -f",
+	"system":    "docker system prune -f",
+	"container": "docker container prune -f",
+	"image":     "docker image prune -f",
+	"volume":    "docker volume prune -f",
+	"network":   "docker network prune -f",
+}
+
+// containerCommand runs a container action on the schedule's target.
+// Schedules never override protection.
+func containerCommand(command string) func(ctx context.Context, dm *DockerManager, s *Schedule) (string, error) {
+	return func(ctx context.Context, dm *DockerManager, s *Schedule) (string, error) {
+		if err := dm.checkProtected(s.Target, command, false); err != nil {
+			return "", err
+		}
+		return dm.executeSSHCommand("docker " + command + " " + shellQuote(s.Target))
+	}
+}
+
+var scheduleActions = map[string]scheduleAction{
+	"start":   {target: "container", run: containerCommand("start")},
+	"stop":    {target: "container", run: containerCommand("stop")},
+	"restart": {target: "container", run: containerCommand("restart")},
+	"pull": {target: "image", run: func(ctx context.Context, dm *DockerManager, s *Schedule) (string, error) {
+		return dm.executeSSHCommand("docker pull --quiet " + shellQuote(s.Target))
+	}},
+	"redeploy": {target: "container", run: func(ctx context.Context, dm *DockerManager, s *Schedule) (string, error) {
+		result, err := dm.RedeployContainer(s.Target, false)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("recreated %s as %s (image updated: %t)", s.Target, result.NewID, result.ImageUpdated), nil
+	}},
+	// The prune target picks what to prune; empty is the whole system.
+	"prune": {run: func(ctx context.Context, dm *DockerManager, s *Schedule) (string, error) {
+		command, ok := pruneTargets[s.Target]
+		if !ok {
+			return "", requestErrorf(codeInvalidRequest, "invalid prune target %q", s.Target)
+		}
+		return dm.executeSSHCommand(command)
+	}},
+	"backup": {run: func(ctx context.Context, dm *DockerManager, s *Schedule) (string, error) {
+		return dm.RunBackup(ctx, s)
+	}},
+}
+
+// scheduleLocks keeps a slow run from overlapping with the next one.
+var scheduleLocks sync.Map
+
+func (s *Schedule) Validate() error {
+	if s.Name == "" {
+		return requestErrorf(codeInvalidRequest, "name is required")
+	}
+	action, ok := scheduleActions[s.Action]
+	if !ok {
+		return requestErrorf(codeInvalidRequest, "invalid action %q", s.Action)
+	}
+	if action.target != "" && s.Target == "" {
+		return requestErrorf(codeInvalidRequest, "action %s needs a target %s", s.Action, action.target)
+	}
+	if s.Action == "prune" {
+		if _, ok := pruneTargets[s.Target]; !ok {
+			return requestErrorf(codeInvalidRequest, "invalid prune target %q: use system, container, image, volume or network", s.Target)
+		}
+	}
+	if s.Action == "backup" {
+		if s.Backup == nil {
+			return requestErrorf(codeInvalidRequest, "action backup needs a backup spec")
+		}
+		if err := s.Backup.Validate(); err != nil {
+			return err
+		}
+	}
+	if _, err := parseCron(s.Cron); err != nil {
+		return err
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return requestErrorf(codeInvalidRequest, "invalid timezone %q", s.Timezone)
+	}
+	return nil
+}
+
+func (s *Schedule) location() *time.Location {
+	if loc, err := time.LoadLocation(s.Timezone); err == nil && s.Timezone != "" {
+		return loc
+	}
+	return time.Local
+}
+
+// due reports whether the schedule should run in the minute of now.
+func (s *Schedule) due(now time.Time) bool {
+	cron, err := parseCron(s.Cron)
+	return err == nil && s.Enabled && cron.matches(now.In(s.location()))
+}
+
+func (s *Schedule) updateNextRun(now time.Time) {
+	s.NextRun = time.Time{}
+	if cron, err := parseCron(s.Cron); err == nil && s.Enabled {
+		s.NextRun = cron.next(now.In(s.location())).UTC()
+	}
+}
+
+func schedules(server string) ([]Schedule, error) {
+	docs, err := store.List(schedulesCollection)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	list := []Schedule{}
+	for _, key := range sortedMetricKeys(docs) {
+		var s Schedule
+		if json.Unmarshal(docs[key], &s) == nil && s.Server == server {
+			s.updateNextRun(now)
+			list = append(list, s)
+		}
+	}
+	return list, nil
+}
+
+// RunSchedule runs the schedule's action once and records the run. It is a
+// no-op returning false if the previous run is still going.
+func (dm *DockerManager) RunSchedule(ctx context.Context, s *Schedule, trigger string) (ScheduleRun, bool) {
+	lock, _ := scheduleLocks.LoadOrStore(s.ID, &sync.Mutex{})
+	if !lock.(*sync.Mutex).TryLock() {
+		dm.logger.Info("schedule still running, skipped", "schedule", s.Name)
+		return ScheduleRun{}, false
+	}
+	defer lock.(*sync.Mutex).Unlock()
+
+	run := ScheduleRun{
+		Time:     time.Now().UTC(),
+		Server:   s.Server,
+		Schedule: s.ID,
+		Action:   s.Action,
+		Target:   s.Target,
+		Trigger:  trigger,
+		Result:   "success",
+	}
+	output, err := scheduleActions[s.Action].run(ctx, dm, s)
+	run.Output = strings.TrimSpace(output)
+	run.Duration = time.Since(run.Time).Round(time.Millisecond).String()
+	if err != nil {
+		run.Result = "failure"
+		run.Error = err.Error()
+		dm.logger.Error("scheduled task failed", "schedule", s.Name, "action", s.Action, "target", s.Target, "error", err)
+	} else {
+		dm.logger.Info("scheduled task finished", "schedule", s.Name, "action", s.Action, "target", s.Target, "trigger", trigger)
+	}
+
+	if err := store.Append(scheduleRunsCollection, run); err != nil {
+		dm.logger.Error("failed to record schedule run", "error", err)
+	}
+	// Re-read so a toggle made while the task ran is not overwritten.
+	var current Schedule
+	if found, err := store.Get(schedulesCollection, s.ID, &current); err == nil && found {
+		current.LastRun = run.Time
+		current.LastResult = run.Result
+		if err := store.Put(schedulesCollection, s.ID, current); err != nil {
+			dm.logger.Error("failed to save schedule", "schedule", s.Name, "error", err)
+		}
+	}
+	return run, true
+}
+
+// startScheduler checks the server's schedules at the start of every minute.
+func startScheduler(dm *DockerManager) {
+	if store == nil {
+		return
+	}
+
+	startBackgroundTask("scheduler:"+dm.config.ID(), func(ctx context.Context) {
+		dm.logger.Info("scheduler started")
+		for {
+			now := time.Now()
+			timer := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				dm.logger.Info("scheduler stopped")
+				return
+			case now = <-timer.C:
+			}
+
+			list, err := schedules(dm.config.ID())
+			if err != nil {
+				dm.logger.Error("failed to read schedules", "error", err)
+				continue
+			}
+			for i := range list {
+				if list[i].due(now) {
+					go dm.RunSchedule(ctx, &list[i], "schedule")
+				}
+			}
+		}
+	})
+}
+
+func schedulesHandler(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		writeError(w, codeUnavailable, "Scheduled tasks are not available")
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		list, err := schedules(manager.config.ID())
+		if err != nil {
+			writeFailure(w, fmt.Errorf("Failed to read schedules: %w", err))
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"success":   true,
+			"schedules": list,
+			"count":     len(list),
+		})
+	case "POST":
+		s := Schedule{Enabled: true}
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			writeError(w, codeInvalidRequest, "Invalid JSON format")
+			return
+		}
+		if err := s.Validate(); err != nil {
+			writeFailure(w, err)
+			return
+		}
+		s.ID = newRequestID()
+		s.Server = manager.config.ID()
+		s.CreatedAt = time.Now().UTC()
+		s.LastRun = time.Time{}
+		s.LastResult = ""
+
+		err := store.Put(schedulesCollection, s.ID, s)
+		recordAudit(r, manager.config.ID(), "schedule.create", s.Name, err)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		s.updateNextRun(time.Now())
+		writeJSON(w, map[string]interface{}{
+			"success":  true,
+			"schedule": s,
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func loadSchedule(w http.ResponseWriter, r *http.Request, manager *DockerManager) (*Schedule, bool) {
+	id := mux.Vars(r)["id"]
+	var s Schedule
+	found, err := store.Get(schedulesCollection, id, &s)
+	if err != nil {
+		writeFailure(w, err)
+		return nil, false
+	}
+	if !found || s.Server != manager.config.ID() {
+		writeError(w, codeNotFound, "Schedule not found: "+id)
+		return nil, false
+	}
+	return &s, true
+}
+
+func scheduleHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		writeError(w, codeUnavailable, "Scheduled tasks are not available")
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+	s, ok := loadSchedule(w, r, manager)
+	if !ok {
+		return
+	}
+
+	history := []ScheduleRun{}
+	err := store.Scan(scheduleRunsCollection, func(data []byte) error {
+		var run ScheduleRun
+		if json.Unmarshal(data, &run) == nil && run.Schedule == s.ID {
+			history = append(history, run)
+		}
+		return nil
+	})
+	if err != nil {
+		writeFailure(w, fmt.Errorf("Failed to read schedule history: %w", err))
+		return
+	}
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+	if len(history) > 100 {
+		history = history[:100]
+	}
+	writeJSON(w, map[string]interface{}{
+		"success":  true,
+		"schedule": s,
+		"history":  history,
+	})
+}
+
+func scheduleActionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if store == nil {
+		writeError(w, codeUnavailable, "Scheduled tasks are not available")
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+	s, ok := loadSchedule(w, r, manager)
+	if !ok {
+		return
+	}
+
+	action := mux.Vars(r)["action"]
+	var err error
+	switch action {
+	case "enable", "disable":
+		s.Enabled = action == "enable"
+		err = store.Put(schedulesCollection, s.ID, s)
+	case "remove":
+		err = store.Delete(schedulesCollection, s.ID)
+	case "run":
+		if asyncRequested(r) {
+			job, err := submitJob(r, manager, "schedule.run", s.Name, s.Action == "backup", func(ctx context.Context, job *jobRun) (interface{}, error) {
+				run, started := manager.RunSchedule(ctx, s, "manual")
+				if !started {
+					return nil, fmt.Errorf("schedule %s is already running", s.Name)
+				}
+				if run.Output != "" {
+					job.Log(run.Output)
+				}
+				if run.Error != "" {
+					return run, fmt.Errorf("%s", run.Error)
+				}
+				return run, nil
+			})
+			writeJobResponse(w, job, err)
+			return
+		}
+		run, started := manager.RunSchedule(r.Context(), s, "manual")
+		if !started {
+			writeError(w, codeConflict, "Schedule "+s.Name+" is already running")
+			return
+		}
+		if run.Error != "" {
+			err = fmt.Errorf("%s", run.Error)
+		}
+		recordAudit(r, manager.config.ID(), "schedule.run", s.Name, err)
+		response := map[string]interface{}{
+			"success": err == nil,
+			"run":     run,
+		}
+		if err != nil {
+			response["error"] = run.Error
+		}
+		writeJSON(w, response)
+		return
+	default:
+		writeError(w, codeInvalidRequest, "Unknown action: "+action)
+		return
+	}
+
+	recordAudit(r, manager.config.ID(), "schedule."+action, s.Name, err)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	s.updateNextRun(time.Now())
+	writeJSON(w, map[string]interface{}{
+		"success":  true,
+		"schedule": s,
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SearchMatch is a container found by a fleet search and the server it is
+// on. Matched names the fields that matched, best first.
+type SearchMatch struct {
+	Server    string    `json:"server"`
+	Container Container `json:"container"`
+	Score     int       `json:"score"`
+	Matched   []string  `json:"matched"`
+}
+
+// imageName strips the registry, namespace and tag, so "redis" matches
+// "docker.io/library/redis:7-alpine".
+func imageName(image string) string {
+	image = image[strings.LastIndex(image, "/")+1:]
+	if i := strings.IndexAny(image, ":@"); i >= 0 {
+		image = image[:i]
+	}
+	return image
+}
+
+// termScore rates how well one lower-cased search term matches c, with the
+// field that matched best. Zero means no match.
+func termScore(c Container, term string) (int, string) {
+	name := strings.ToLower(c.Name)
+	image := strings.ToLower(c.Image)
+	switch {
+	case name == term:
+		return 100, "name"
+	case strings.HasPrefix(strings.ToLower(c.ID), term):
+		return 80, "id"
+	case imageName(image) == term:
+		return 70, "image"
+	case strings.HasPrefix(name, term):
+		return 60, "name"
+	case strings.Contains(name, term):
+		return 40, "name"
+	case strings.Contains(image, term):
+		return 30, "image"
+	case strings.Contains(strings.ToLower(c.PortsDisplay), term):
+		return 20, "ports"
+	}
+	for key, value := range c.Labels {
+		if strings.Contains(strings.ToLower(key), term) || strings.Contains(strings.ToLower(value), term) {
+			return 10, "labels"
+		}
+	}
+	return 0, ""
+}
+
+// searchContainers scores c against every term; all of them have to match.
+// Running containers rank above stopped ones with the same score.
+func searchContainers(server string, containers []Container, terms []string) []SearchMatch {
+	matches := []SearchMatch{}
+	for _, c := range containers {
+		match := SearchMatch{Server: server, Container: c}
+		for _, term := range terms {
+			score, field := termScore(c, term)
+			if score == 0 {
+				match.Score = 0
+				break
+			}
+			match.Score += score
+			if !containsString(match.Matched, field) {
+				match.Matched = append(match.Matched, field)
+			}
+		}
+		if match.Score == 0 {
+			continue
+		}
+		if c.State == "running" {
+			match.Score += 5
+		}
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// searchHandler answers "where is redis running?" by listing the containers
+// of every server the user may access at once and ranking the matches.
+// Servers that fail are reported in errors without failing the search.
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	terms := strings.Fields(strings.ToLower(r.URL.Query().Get("q")))
+	if len(terms) == 0 {
+		writeError(w, codeInvalidRequest, "Search query q is required")
+		return
+	}
+
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			writeError(w, codeInvalidRequest, "Invalid limit: "+value)
+			return
+		}
+	}
+
+	managers := accessibleManagers(r)
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		matches = []SearchMatch{}
+		errs    = []map[string]string{}
+	)
+	for _, manager := range managers {
+		wg.Add(1)
+		go func(manager *DockerManager) {
+			defer wg.Done()
+			server := manager.config.ID()
+			containers, _, err := manager.CachedContainers(false)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				manager.logger.Error("search failed", "error", err)
+				errs = append(errs, map[string]string{"server": server, "error": err.Error()})
+				return
+			}
+			matches = append(matches, searchContainers(server, containers, terms)...)
+		}(manager)
+	}
+	wg.Wait()
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		if matches[i].Server != matches[j].Server {
+			return matches[i].Server < matches[j].Server
+		}
+		return matches[i].Container.Name < matches[j].Container.Name
+	})
+	total := len(matches)
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"query":   strings.Join(terms, " "),
+		"matches": matches,
+		"count":   len(matches),
+		"total":   total,
+		"servers": len(managers),
+		"errors":  errs,
+	})
+}
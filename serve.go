@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ServeOptions says how the web server listens. Setting TLSCert and TLSKey
+// serves HTTPS with that certificate; setting ACMEDomains instead obtains
+// and renews certificates from an ACME CA such as Let's Encrypt.
+type ServeOptions struct {
+	Addr    string
+	TLSCert string
+	TLSKey  string
+
+	ACMEDomains  []string
+	ACMEEmail    string
+	ACMECacheDir string
+	// ACMEDirectory is the CA's directory URL; empty means Let's Encrypt.
+	ACMEDirectory string
+	// ACMEHTTPAddr answers HTTP-01 challenges and redirects everything else
+	// to HTTPS. It must be reachable as port 80 of the domains.
+	ACMEHTTPAddr string
+
+	// Socket, when set, is a Unix socket path listened on instead of Addr,
+	// created with SocketMode permissions.
+	Socket     string
+	SocketMode os.FileMode
+}
+
+func (o *ServeOptions) TLS() bool {
+	return o.TLSCert != "" || o.TLSKey != "" || len(o.ACMEDomains) > 0
+}
+
+// certReloader serves a certificate from files and picks up a renewed one,
+// e.g. from certbot, without a restart.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) load() error {
+	info, err := os.Stat(c.certFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert = &cert
+	c.modTime = info.ModTime()
+	return nil
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Checking the file once a minute is enough for renewals.
+	if time.Since(c.checked) > time.Minute {
+		c.checked = time.Now()
+		if info, err := os.Stat(c.certFile); err == nil && !info.ModTime().Equal(c.modTime) {
+			if err := c.load(); err != nil {
+				slog.Warn("failed to reload TLS certificate", "error", err)
+			} else {
+				slog.Info("reloaded TLS certificate", "cert", c.certFile)
+			}
+		}
+	}
+	return c.cert, nil
+}
+
+// listen opens the TCP address or the Unix socket. A socket file left over
+// from an earlier run is replaced.
+func listen(options *ServeOptions) (net.Listener, error) {
+	if options.Socket == "" {
+		return net.Listen("tcp", options.Addr)
+	}
+	if info, err := os.Lstat(options.Socket); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", options.Socket)
+		}
+		os.Remove(options.Socket)
+	}
+	listener, err := net.Listen("unix", options.Socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(options.Socket, options.SocketMode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// serve runs the web server until it fails.
+func serve(options *ServeOptions, handler http.Handler) error {
+	server := &http.Server{
+		Addr:              options.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	if len(options.ACMEDomains) > 0 {
+		if options.TLSCert != "" || options.TLSKey != "" {
+			return fmt.Errorf("--acme-domain cannot be combined with --tls-cert and --tls-key")
+		}
+		if options.Socket != "" {
+			return fmt.Errorf("--acme-domain needs a public port and cannot be combined with --unix-socket")
+		}
+		return serveACME(server, options)
+	}
+
+	listener, err := listen(options)
+	if err != nil {
+		return err
+	}
+	if options.Socket != "" {
+		defer os.Remove(options.Socket)
+	}
+	if !options.TLS() {
+		return runUntilSignal(func() error { return server.Serve(listener) }, server)
+	}
+
+	if options.TLSCert == "" || options.TLSKey == "" {
+		return fmt.Errorf("both --tls-cert and --tls-key are required for TLS")
+	}
+	reloader, err := newCertReloader(options.TLSCert, options.TLSKey)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	server.TLSConfig = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	return runUntilSignal(func() error { return server.ServeTLS(listener, "", "") }, server)
+}
+
+// serveACME serves HTTPS with certificates from the ACME CA. Challenges are
+// answered over HTTP-01 on ACMEHTTPAddr and TLS-ALPN-01 on the HTTPS port.
+func serveACME(server *http.Server, options *ServeOptions) error {
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(options.ACMEDomains...),
+		Email:      options.ACMEEmail,
+		Cache:      autocert.DirCache(options.ACMECacheDir),
+	}
+	if options.ACMEDirectory != "" {
+		manager.Client = &acme.Client{DirectoryURL: options.ACMEDirectory}
+	}
+
+	challenges := &http.Server{
+		Addr:              options.ACMEHTTPAddr,
+		Handler:           manager.HTTPHandler(nil),
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	go func() {
+		if err := challenges.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("acme challenge listener stopped", "addr", options.ACMEHTTPAddr, "error", err)
+		}
+	}()
+
+	server.TLSConfig = manager.TLSConfig()
+	server.TLSConfig.MinVersion = tls.VersionTLS12
+	slog.Info("obtaining certificates via acme", "domains", options.ACMEDomains, "cache", options.ACMECacheDir)
+	return runUntilSignal(func() error { return server.ListenAndServeTLS("", "") }, server, challenges)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"golang.org/x/crypto/ssh"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	shutdownTimeout = envDuration("SHUTDOWN_TIMEOUT", 30*time.Second)
+	// shuttingDown is closed when shutdown begins.
+	shuttingDown = make(chan struct{})
+)
+
+var (
+	sshClientsMu sync.Mutex
+	sshClients   = map[*ssh.Client]struct{}{}
+)
+
+// trackSSHClient remembers an open SSH connection until it is closed, so
+// shutdown can wait for it and close it when the time is up.
+func trackSSHClient(client *ssh.Client) {
+	sshClientsMu.Lock()
+	sshClients[client] = struct{}{}
+	sshClientsMu.Unlock()
+
+	go func() {
+		client.Wait()
+		sshClientsMu.Lock()
+		delete(sshClients, client)
+		sshClientsMu.Unlock()
+	}()
+}
+
+func openSSHClients() int {
+	sshClientsMu.Lock()
+	defer sshClientsMu.Unlock()
+	return len(sshClients)
+}
+
+func closeSSHClients() int {
+	sshClientsMu.Lock()
+	defer sshClientsMu.Unlock()
+	for client := range sshClients {
+		client.Close()
+	}
+	return len(sshClients)
+}
+
+// longLivedRequest reports whether a request streams until the client goes
+// away: server-sent events and WebSockets. Those cannot drain, so they are
+// ended when shutdown begins.
+func longLivedRequest(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream") ||
+		strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// shutdownMiddleware cancels the context of long-lived requests when shutdown
+// begins; other requests keep theirs and are drained.
+func shutdownMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !longLivedRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		go func() {
+			select {
+			case <-shuttingDown:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// runUntilSignal runs fn, which serves until it fails, and shuts the servers
+// down gracefully on SIGINT or SIGTERM: new connections are refused,
+// in-flight requests, jobs and SSH sessions get SHUTDOWN_TIMEOUT to finish
+// and whatever is left is then closed. A second signal exits immediately.
+func runUntilSignal(fn func() error, servers ...*http.Server) error {
+	errs := make(chan error, 1)
+	go func() { errs <- fn() }()
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	var sig os.Signal
+	select {
+	case err := <-errs:
+		return err
+	case sig = <-signals:
+	}
+
+	slog.Info("shutting down", "signal", sig.String(), "timeout", shutdownTimeout)
+	go func() {
+		<-signals
+		slog.Warn("second signal, exiting immediately")
+		os.Exit(1)
+	}()
+	close(shuttingDown)
+	stopBackgroundTasks()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Warn("requests still running at shutdown", "error", err)
+		}
+	}
+
+	// Jobs and WebSocket sessions outlive their requests.
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+drain:
+	for len(activeJobIDs()) > 0 || openSSHClients() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			break drain
+		}
+	}
+	for _, id := range activeJobIDs() {
+		cancelJob(id)
+	}
+	if n := closeSSHClients(); n > 0 {
+		slog.Warn("closed ssh connections still in use", "count", n)
+	}
+	slog.Info("shutdown complete")
+	return nil
+}
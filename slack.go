@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+const slackPostMessageURL = "https://slack.com/api/chat.postMessage"
+
+// slackSink posts notifications to an incoming webhook, or through the Web
+// API with a bot token, which can post to any channel the bot is in.
+type slackSink struct {
+	notifier *Notifier
+}
+
+func (s *slackSink) Send(ctx context.Context, n *Notification) error {
+	text, err := s.notifier.text(n)
+	if err != nil {
+		return permanentError{err}
+	}
+	message := map[string]string{"text": text}
+	if channel := s.notifier.channel(n.Event); channel != "" {
+		message["channel"] = channel
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return permanentError{err}
+	}
+
+	if s.notifier.Token == "" {
+		resp, err := postSink(ctx, s.notifier.URL, "application/json", body, nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		return checkSinkResponse(resp, "slack")
+	}
+
+	resp, err := postSink(ctx, slackPostMessageURL, "application/json; charset=utf-8", body, map[string]string{
+		"Authorization": "Bearer " + s.notifier.Token,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkSinkResponse(resp, "slack"); err != nil {
+		return err
+	}
+	// The Web API answers 200 for most failures and says why in the body.
+	var result struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("slack: invalid response: %v", err)
+	}
+	if !result.OK {
+		err := fmt.Errorf("slack: %s", result.Error)
+		if result.Error == "ratelimited" || result.Error == "internal_error" || result.Error == "service_unavailable" {
+			return err
+		}
+		return permanentError{err}
+	}
+	return nil
+}
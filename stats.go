@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ContainerStats struct {
+	ID            string  `json:"id"`
+	Name          string  `json:"name"`
+	CPUPercent    float64 `json:"cpu_percent"`
+	MemoryUsage   int64   `json:"memory_usage"`
+	MemoryLimit   int64   `json:"memory_limit"`
+	MemoryPercent float64 `json:"memory_percent"`
+	NetworkRx     int64   `json:"network_rx"`
+	NetworkTx     int64   `json:"network_tx"`
+	BlockRead     int64   `json:"block_read"`
+	BlockWrite    int64   `json:"block_write"`
+	PIDs          int     `json:"pids"`
+}
+
+func parseContainerStats(line string) (*ContainerStats, error) {
+	var raw struct {
+		ID       string
+		Name     string
+		CPUPerc  string
+		MemUsage string
+		MemPerc  string
+		NetIO    string
+		BlockIO  string
+		PIDs     string
+	}
+	if err := json.Unmarshal([]byte(line), &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse docker stats output: %v", err)
+	}
+
+	stats := &ContainerStats{
+		ID:            raw.ID,
+		Name:          raw.Name,
+		CPUPercent:    parsePercent(raw.CPUPerc),
+		MemoryPercent: parsePercent(raw.MemPerc),
+	}
+	stats.MemoryUsage, stats.MemoryLimit = parseSizePair(raw.MemUsage)
+	stats.NetworkRx, stats.NetworkTx = parseSizePair(raw.NetIO)
+	stats.BlockRead, stats.BlockWrite = parseSizePair(raw.BlockIO)
+	stats.PIDs, _ = strconv.Atoi(raw.PIDs)
+	return stats, nil
+}
+
+func parsePercent(s string) float64 {
+	value, _ := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	return value
+}
+
+// parseSizePair splits docker's "used / total" columns into byte counts.
+func parseSizePair(s string) (int64, int64) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return parseHumanSize(s), 0
+	}
+	return parseHumanSize(parts[0]), parseHumanSize(parts[1])
+}
+
+func (dm *DockerManager) GetContainerStats(containerID string) (*ContainerStats, error) {
+	output, err := dm.executeSSHCommand("docker stats --no-stream --format '{{json .}}' " + shellQuote(containerID))
+	if err != nil {
+		return nil, err
+	}
+	return parseContainerStats(strings.TrimSpace(output))
+}
+
+func containerStatsHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	stats, err := manager.GetContainerStats(mux.Vars(r)["id"])
+	if err != nil {
+		manager.logger.Error("failed to get container stats", "error", err)
+		writeFailure(w, err)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"stats":   stats,
+	})
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     checkOrigin,
+}
+
+// StreamContainerStats follows `docker stats` for one container and calls fn
+// with every sample until fn fails or ctx is cancelled.
+func (dm *DockerManager) StreamContainerStats(ctx context.Context, containerID string, fn func(*ContainerStats) error) error {
+	command := "docker stats --format '{{json .}}' " + shellQuote(containerID)
+	return dm.streamSSHLines(ctx, command, func(line string) error {
+		// Follow mode prefixes every frame with terminal clear sequences.
+		start := strings.IndexByte(line, '{')
+		if start < 0 {
+			return nil
+		}
+		stats, err := parseContainerStats(line[start:])
+		if err != nil {
+			dm.logger.Error("skipping stats sample", "error", err)
+			return nil
+		}
+		return fn(stats)
+	})
+}
+
+func containerStatsStreamHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		manager.logger.Error("WebSocket upgrade failed", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	// The client never sends anything meaningful; reading detects when it
+	// goes away so the remote docker stats process is torn down.
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	containerID := mux.Vars(r)["id"]
+	manager.logger.Info("streaming container stats", "container", containerID)
+	err = manager.StreamContainerStats(ctx, containerID, func(stats *ContainerStats) error {
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return conn.WriteJSON(stats)
+	})
+	if err != nil && ctx.Err() == nil {
+		manager.logger.Error("stats stream failed", "container", containerID, "error", err)
+		conn.WriteJSON(map[string]string{"error": err.Error()})
+	}
+	manager.logger.Info("stats stream closed", "container", containerID)
+}
+
+func (dm *DockerManager) GetAllContainerStats() ([]ContainerStats, error) {
+	output, err := dm.executeSSHCommand("docker stats --no-stream --format '{{json .}}'")
+	if err != nil {
+		return nil, err
+	}
+
+	all := []ContainerStats{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		stats, err := parseContainerStats(line)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, *stats)
+	}
+	return all, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store is a small embedded document store kept as JSON files in a data
+// directory. Keyed collections live in <name>.json; append-only logs such as
+// event history live in <name>.jsonl.
+type Store struct {
+	dir string
+	mu  sync.Mutex
+}
+
+var store *Store
+
+func OpenStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create data directory %s: %v", dir, err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+func (s *Store) logPath(collection string) string {
+	return filepath.Join(s.dir, collection+".jsonl")
+}
+
+func (s *Store) docPath(collection string) string {
+	return filepath.Join(s.dir, collection+".json")
+}
+
+func (s *Store) Append(collection string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.logPath(collection), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
+
+// Scan calls fn for every record of an append-only collection, oldest first.
+func (s *Store) Scan(collection string, fn func(data []byte) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.Open(s.logPath(collection))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Compact rewrites an append-only collection, keeping only the records for
+// which keep returns true.
+func (s *Store) Compact(collection string, keep func(data []byte) bool) error {
+	var kept bytes.Buffer
+	err := s.Scan(collection, func(data []byte) error {
+		if keep(data) {
+			kept.Write(data)
+			kept.WriteByte('\n')
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeFileAtomic(s.logPath(collection), kept.Bytes())
+}
+
+func (s *Store) readDocs(collection string) (map[string]json.RawMessage, error) {
+	docs := map[string]json.RawMessage{}
+	data, err := os.ReadFile(s.docPath(collection))
+	if os.IsNotExist(err) {
+		return docs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &docs); err != nil {
+		return nil, fmt.Errorf("corrupt collection %s: %v", collection, err)
+	}
+	return docs, nil
+}
+
+func (s *Store) writeDocs(collection string, docs map[string]json.RawMessage) error {
+	data, err := json.MarshalIndent(docs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.docPath(collection), data)
+}
+
+func (s *Store) Put(collection, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	docs, err := s.readDocs(collection)
+	if err != nil {
+		return err
+	}
+	docs[key] = data
+	return s.writeDocs(collection, docs)
+}
+
+func (s *Store) Get(collection, key string, v interface{}) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	docs, err := s.readDocs(collection)
+	if err != nil {
+		return false, err
+	}
+	data, ok := docs[key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+func (s *Store) Delete(collection, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	docs, err := s.readDocs(collection)
+	if err != nil {
+		return err
+	}
+	delete(docs, key)
+	return s.writeDocs(collection, docs)
+}
+
+func (s *Store) List(collection string) (map[string]json.RawMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readDocs(collection)
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
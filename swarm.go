@@ -0,0 +1,514 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ServiceUpdateStatus struct {
+	State       string    `json:"state"`
+	Message     string    `json:"message,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+type SwarmService struct {
+	ID              string               `json:"id"`
+	Name            string               `json:"name"`
+	Mode            string               `json:"mode"`
+	Image           string               `json:"image"`
+	Replicas        string               `json:"replicas"`
+	RunningReplicas int                  `json:"running_replicas"`
+	DesiredReplicas int                  `json:"desired_replicas"`
+	Ports           string               `json:"ports"`
+	UpdateStatus    *ServiceUpdateStatus `json:"update_status,omitempty"`
+}
+
+type ServiceTask struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Image        string `json:"image"`
+	Node         string `json:"node"`
+	DesiredState string `json:"desired_state"`
+	CurrentState string `json:"current_state"`
+	Error        string `json:"error,omitempty"`
+	Ports        string `json:"ports,omitempty"`
+}
+
+// requireSwarmManager fails unless the host is a manager of an active swarm;
+// workers cannot list or change services.
+func (dm *DockerManager) requireSwarmManager() error {
+	output, err := dm.executeSSHCommand("docker info --format '{{.Swarm.LocalNodeState}} {{.Swarm.ControlAvailable}}'")
+	if err != nil {
+		return err
+	}
+	state, control, _ := strings.Cut(strings.TrimSpace(output), " ")
+	if state != "active" {
+		return fmt.Errorf("swarm mode is not active on this server")
+	}
+	if control != "true" {
+		return fmt.Errorf("this server is a swarm worker; services are managed from a manager node")
+	}
+	return nil
+}
+
+// parseReplicas reads "2/3" or "2/3 (max 1 per node)" from docker service ls.
+func parseReplicas(s string) (running, desired int) {
+	field, _, _ := strings.Cut(s, " ")
+	r, d, _ := strings.Cut(field, "/")
+	running, _ = strconv.Atoi(r)
+	desired, _ = strconv.Atoi(d)
+	return running, desired
+}
+
+func (dm *DockerManager) ListServices() ([]SwarmService, error) {
+	if err := dm.requireSwarmManager(); err != nil {
+		return nil, err
+	}
+	output, err := dm.executeSSHCommand("docker service ls --format '{{json .}}'")
+	if err != nil {
+		return nil, err
+	}
+
+	services := []SwarmService{}
+	var ids []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		var raw struct {
+			ID       string
+			Name     string
+			Mode     string
+			Replicas string
+			Image    string
+			Ports    string
+		}
+		if err := json.Unmarshal([]byte(line), &raw); err != nil {
+			return nil, fmt.Errorf("failed to parse service line: %v", err)
+		}
+		running, desired := parseReplicas(raw.Replicas)
+		services = append(services, SwarmService{
+			ID:              raw.ID,
+			Name:            raw.Name,
+			Mode:            raw.Mode,
+			Image:           raw.Image,
+			Replicas:        raw.Replicas,
+			RunningReplicas: running,
+			DesiredReplicas: desired,
+			Ports:           raw.Ports,
+		})
+		ids = append(ids, shellQuote(raw.ID))
+	}
+	if len(ids) == 0 {
+		return services, nil
+	}
+
+	// Update status is only in the full service object; one inspect covers
+	// every service and is best-effort.
+	output, err = dm.executeSSHCommand("docker service inspect " + strings.Join(ids, " "))
+	if err != nil {
+		dm.logger.Error("service inspect failed", "error", err)
+		return services, nil
+	}
+	var details []struct {
+		ID           string
+		UpdateStatus *struct {
+			State       string
+			Message     string
+			StartedAt   time.Time
+			CompletedAt time.Time
+		}
+	}
+	if err := json.Unmarshal([]byte(output), &details); err != nil {
+		dm.logger.Error("failed to parse service inspect output", "error", err)
+		return services, nil
+	}
+	status := map[string]*ServiceUpdateStatus{}
+	for _, d := range details {
+		if d.UpdateStatus != nil {
+			status[d.ID] = &ServiceUpdateStatus{
+				State:       d.UpdateStatus.State,
+				Message:     d.UpdateStatus.Message,
+				StartedAt:   d.UpdateStatus.StartedAt,
+				CompletedAt: d.UpdateStatus.CompletedAt,
+			}
+		}
+	}
+	for i := range services {
+		for id, s := range status {
+			if strings.HasPrefix(id, services[i].ID) {
+				services[i].UpdateStatus = s
+			}
+		}
+	}
+	return services, nil
+}
+
+func (dm *DockerManager) ServiceTasks(service string) ([]ServiceTask, error) {
+	if err := dm.requireSwarmManager(); err != nil {
+		return nil, err
+	}
+	output, err := dm.executeSSHCommand("docker service ps --no-trunc --format '{{json .}}' " + shellQuote(service))
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := []ServiceTask{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		var raw struct {
+			ID           string
+			Name         string
+			Image        string
+			Node         string
+			DesiredState string
+			CurrentState string
+			Error        string
+			Ports        string
+		}
+		if err := json.Unmarshal([]byte(line), &raw); err != nil {
+			return nil, fmt.Errorf("failed to parse task line: %v", err)
+		}
+		tasks = append(tasks, ServiceTask{
+			ID:           raw.ID,
+			Name:         raw.Name,
+			Image:        raw.Image,
+			Node:         raw.Node,
+			DesiredState: raw.DesiredState,
+			CurrentState: raw.CurrentState,
+			Error:        raw.Error,
+			Ports:        raw.Ports,
+		})
+	}
+	return tasks, nil
+}
+
+type SwarmNode struct {
+	ID            string `json:"id"`
+	Hostname      string `json:"hostname"`
+	Role          string `json:"role"`
+	Status        string `json:"status"`
+	Availability  string `json:"availability"`
+	ManagerStatus string `json:"manager_status,omitempty"`
+	EngineVersion string `json:"engine_version"`
+	Self          bool   `json:"self"`
+}
+
+func (dm *DockerManager) ListNodes() ([]SwarmNode, error) {
+	if err := dm.requireSwarmManager(); err != nil {
+		return nil, err
+	}
+	output, err := dm.executeSSHCommand("docker node ls --format '{{json .}}'")
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []SwarmNode{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		var raw struct {
+			ID            string
+			Hostname      string
+			Status        string
+			Availability  string
+			ManagerStatus string
+			EngineVersion string
+			Self          bool
+		}
+		if err := json.Unmarshal([]byte(line), &raw); err != nil {
+			return nil, fmt.Errorf("failed to parse node line: %v", err)
+		}
+		role := "worker"
+		if raw.ManagerStatus != "" {
+			role = "manager"
+		}
+		nodes = append(nodes, SwarmNode{
+			ID:            raw.ID,
+			Hostname:      raw.Hostname,
+			Role:          role,
+			Status:        strings.ToLower(raw.Status),
+			Availability:  strings.ToLower(raw.Availability),
+			ManagerStatus: strings.ToLower(raw.ManagerStatus),
+			EngineVersion: raw.EngineVersion,
+			Self:          raw.Self,
+		})
+	}
+	return nodes, nil
+}
+
+// nodeAvailability maps the node actions to docker node update
+// --availability values.
+var nodeAvailability = map[string]string{
+	"drain":    "drain",
+	"activate": "active",
+	"pause":    "pause",
+}
+
+// SetNodeAvailability drains a node, which moves its tasks to other nodes,
+// pauses it so it takes no new tasks, or makes it active again.
+func (dm *DockerManager) SetNodeAvailability(node, action string) error {
+	availability, ok := nodeAvailability[action]
+	if !ok {
+		return requestErrorf(codeInvalidRequest, "invalid action %q", action)
+	}
+	if err := dm.requireSwarmManager(); err != nil {
+		return err
+	}
+	_, err := dm.executeSSHCommand("docker node update --availability " + availability + " " + shellQuote(node))
+	return err
+}
+
+func (dm *DockerManager) findService(service string) (*SwarmService, error) {
+	services, err := dm.ListServices()
+	if err != nil {
+		return nil, err
+	}
+	for i := range services {
+		if services[i].Name == service || services[i].ID == service {
+			return &services[i], nil
+		}
+	}
+	return nil, requestErrorf(codeNotFound, "service %s not found", service)
+}
+
+// ScaleService sets the desired replica count and returns the service as it
+// was before. The command is detached, so the new tasks may still be
+// starting when it returns.
+func (dm *DockerManager) ScaleService(service string, replicas int) (*SwarmService, error) {
+	if replicas < 0 {
+		return nil, requestErrorf(codeInvalidRequest, "replicas must not be negative")
+	}
+	current, err := dm.findService(service)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(current.Mode, "replicated") {
+		return nil, requestErrorf(codeConflict, "service %s runs in %s mode and cannot be scaled", service, current.Mode)
+	}
+	_, err = dm.executeSSHCommand("docker service scale --detach " + shellQuote(fmt.Sprintf("%s=%d", current.Name, replicas)))
+	return current, err
+}
+
+type ServiceScaleRequest struct {
+	Replicas *int `json:"replicas"`
+}
+
+type ServiceUpdateRequest struct {
+	Image string `json:"image"`
+}
+
+// ServiceAction starts a rolling restart, an update to a new image or a
+// rollback. The commands are detached; progress shows in the service's
+// update status.
+func (dm *DockerManager) ServiceAction(service, action string, req ServiceUpdateRequest) error {
+	if err := dm.requireSwarmManager(); err != nil {
+		return err
+	}
+
+	var command string
+	switch action {
+	case "restart":
+		command = "docker service update --detach --force " + shellQuote(service)
+	case "update":
+		command = "docker service update --detach --with-registry-auth "
+		if req.Image != "" {
+			command += "--image " + shellQuote(req.Image) + " "
+		} else {
+			// Without a new tag, recreate the tasks so they pull the
+			// current digest of the same tag.
+			command += "--force "
+		}
+		command += shellQuote(service)
+	case "rollback":
+		command = "docker service rollback --detach " + shellQuote(service)
+	default:
+		return requestErrorf(codeInvalidRequest, "invalid action %q", action)
+	}
+	_, err := dm.executeSSHCommand(command)
+	return err
+}
+
+func servicesHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	services, err := manager.ListServices()
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success":  true,
+		"services": services,
+		"count":    len(services),
+	})
+}
+
+func serviceTasksHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	service := mux.Vars(r)["name"]
+	tasks, err := manager.ServiceTasks(service)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"service": service,
+		"tasks":   tasks,
+		"count":   len(tasks),
+	})
+}
+
+func serviceActionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	var req ServiceUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, codeInvalidRequest, "Invalid JSON format")
+		return
+	}
+
+	vars := mux.Vars(r)
+	service := vars["name"]
+	action := vars["action"]
+	err := manager.ServiceAction(service, action, req)
+	recordAudit(r, manager.config.ID(), "service."+action, service, err)
+	if err != nil {
+		manager.logger.Error("service action failed", "service", service, "action", action, "error", err)
+		writeFailure(w, err)
+		return
+	}
+
+	manager.logger.Info("service action started", "service", service, "action", action)
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("Service %s %s started", service, action),
+	})
+}
+
+func nodesHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	nodes, err := manager.ListNodes()
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"nodes":   nodes,
+		"count":   len(nodes),
+	})
+}
+
+func nodeActionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	node := vars["id"]
+	action := vars["action"]
+	// Draining or pausing a node moves or holds back the tasks of every
+	// service on it.
+	if (action == "drain" || action == "pause") && !confirmOperation(w, r, manager, requestOperation(r), func() (string, map[string]interface{}, error) {
+		return "Set the availability of node " + node + " to " + nodeAvailability[action], nil, nil
+	}) {
+		return
+	}
+	err := manager.SetNodeAvailability(node, action)
+	recordAudit(r, manager.config.ID(), "node."+action, node, err)
+	if err != nil {
+		manager.logger.Error("node action failed", "node", node, "action", action, "error", err)
+		writeFailure(w, err)
+		return
+	}
+
+	manager.logger.Info("node availability changed", "node", node, "availability", nodeAvailability[action])
+	writeJSON(w, map[string]interface{}{
+		"success":      true,
+		"node":         node,
+		"availability": nodeAvailability[action],
+	})
+}
+
+func serviceScaleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	var req ServiceScaleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, codeInvalidRequest, "Invalid JSON format")
+		return
+	}
+	if req.Replicas == nil {
+		writeError(w, codeInvalidRequest, "replicas is required")
+		return
+	}
+
+	service := mux.Vars(r)["name"]
+	previous, err := manager.ScaleService(service, *req.Replicas)
+	recordAudit(r, manager.config.ID(), "service.scale", fmt.Sprintf("%s=%d", service, *req.Replicas), err)
+	if err != nil {
+		manager.logger.Error("service scale failed", "service", service, "replicas", *req.Replicas, "error", err)
+		writeFailure(w, err)
+		return
+	}
+
+	manager.logger.Info("service scaled", "service", service, "from", previous.DesiredReplicas, "to", *req.Replicas)
+	writeJSON(w, map[string]interface{}{
+		"success":           true,
+		"service":           previous.Name,
+		"running_replicas":  previous.RunningReplicas,
+		"previous_replicas": previous.DesiredReplicas,
+		"desired_replicas":  *req.Replicas,
+	})
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type DiskUsageItem struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	SizeText string `json:"size_text"`
+}
+
+type DiskUsageCategory struct {
+	Total       int             `json:"total"`
+	Active      int             `json:"active"`
+	Size        int64           `json:"size"`
+	Reclaimable int64           `json:"reclaimable"`
+	Items       []DiskUsageItem `json:"items"`
+}
+
+type DiskUsage struct {
+	Images     DiskUsageCategory `json:"images"`
+	Containers DiskUsageCategory `json:"containers"`
+	Volumes    DiskUsageCategory `json:"volumes"`
+	BuildCache DiskUsageCategory `json:"build_cache"`
+	TotalSize  int64             `json:"total_size"`
+}
+
+func (dm *DockerManager) GetDiskUsage() (*DiskUsage, error) {
+	summary, err := dm.executeSSHCommand("docker system df --format '{{json .}}'")
+	if err != nil {
+		return nil, fmt.Errorf("Docker system df command failed: %w", err)
+	}
+
+	usage := &DiskUsage{}
+	categories := map[string]*DiskUsageCategory{
+		"Images":        &usage.Images,
+		"Containers":    &usage.Containers,
+		"Local Volumes": &usage.Volumes,
+		"Build Cache":   &usage.BuildCache,
+	}
+
+	for _, line := range strings.Split(summary, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		var row struct {
+			Type        string
+			TotalCount  string
+			Active      string
+			Size        string
+			Reclaimable string
+		}
+		if err := json.Unmarshal([]byte(line), &row); err != nil {
+			return nil, fmt.Errorf("failed to parse system df output: %v", err)
+		}
+
+		category, ok := categories[row.Type]
+		if !ok {
+			continue
+		}
+		category.Total, _ = strconv.Atoi(row.TotalCount)
+		category.Active, _ = strconv.Atoi(row.Active)
+		category.Size = parseHumanSize(row.Size)
+		category.Reclaimable = parseHumanSize(row.Reclaimable)
+		category.Items = []DiskUsageItem{}
+		usage.TotalSize += category.Size
+	}
+
+	verbose, err := dm.executeSSHCommand("docker system df -v --format '{{json .}}'")
+	if err != nil {
+		// The totals are still useful without the per-item breakdown.
+		dm.logger.Error("docker system df -v failed", "error", err)
+		return usage, nil
+	}
+
+	var details struct {
+		Images []struct {
+			Repository string
+			Tag        string
+			ID         string
+			Size       string
+		}
+		Containers []struct {
+			Names string
+			Size  string
+		}
+		Volumes []struct {
+			Name string
+			Size string
+		}
+		BuildCache []struct {
+			ID   string
+			Size string
+		}
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(verbose)), &details); err != nil {
+		dm.logger.Error("failed to parse system df -v output", "error", err)
+		return usage, nil
+	}
+
+	for _, image := range details.Images {
+		name := image.Repository + ":" + image.Tag
+		if image.Repository == "<none>" {
+			name = image.ID
+		}
+		usage.Images.Items = append(usage.Images.Items, newDiskUsageItem(name, image.Size))
+	}
+	for _, container := range details.Containers {
+		usage.Containers.Items = append(usage.Containers.Items, newDiskUsageItem(container.Names, container.Size))
+	}
+	for _, volume := range details.Volumes {
+		usage.Volumes.Items = append(usage.Volumes.Items, newDiskUsageItem(volume.Name, volume.Size))
+	}
+	for _, cache := range details.BuildCache {
+		usage.BuildCache.Items = append(usage.BuildCache.Items, newDiskUsageItem(cache.ID, cache.Size))
+	}
+
+	return usage, nil
+}
+
+func newDiskUsageItem(name, size string) DiskUsageItem {
+	return DiskUsageItem{Name: name, Size: parseHumanSize(size), SizeText: size}
+}
+
+// parseHumanSize converts docker's size strings ("1.5GB", "12kB (30%)",
+// "0B (virtual 1GB)", "12.3MiB") to bytes, reading only the leading value.
+func parseHumanSize(s string) int64 {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		s = s[:i]
+	}
+
+	units := []struct {
+		suffix     string
+		multiplier float64
+	}{
+		{"PiB", 1 << 50}, {"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+		{"PB", 1e15}, {"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"kB", 1e3}, {"KB", 1e3}, {"B", 1},
+	}
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			value, err := strconv.ParseFloat(strings.TrimSuffix(s, unit.suffix), 64)
+			if err != nil {
+				return 0
+			}
+			return int64(value * unit.multiplier)
+		}
+	}
+	value, _ := strconv.ParseFloat(s, 64)
+	return int64(value)
+}
+
+func diskUsageHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := serverManager(w, r)
+	if !ok {
+		return
+	}
+
+	usage, err := manager.GetDiskUsage()
+	if err != nil {
+		manager.logger.Error("failed to get disk usage", "error", err)
+		writeFailure(w, err)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"usage":   usage,
+	})
+}
+
+type DockerInfo struct {
+	ServerVersion     string `json:"server_version"`
+	APIVersion        string `json:"api_version"`
+	ClientVersion     string `json:"client_version"`
+	Containers        int    `json:"containers"`
+	ContainersRunning int    `json:"containers_running"`
+	ContainersPaused  int    `json:"containers_paused"`
+	ContainersStopped int    `json:"containers_stopped"`
+	Images            int    `json:"images"`
+	StorageDriver     string `json:"storage_driver"`
+	CgroupDriver      string `json:"cgroup_driver"`
+	RootDir           string `json:"root_dir"`
+	SwarmState        string `json:"swarm_state"`
+	SwarmManager      bool   `json:"swarm_manager"`
+}
+
+type HostInfo struct {
+	Hostname        string `json:"hostname"`
+	OperatingSystem string `json:"operating_system"`
+	KernelVersion   string `json:"kernel_version"`
+	Architecture    string `json:"architecture"`
+	CPUs            int    `json:"cpus"`
+	MemoryTotal     int64  `json:"memory_total"`
+}
+
+type ServerInfo struct {
+	Server string     `json:"server"`
+	Docker DockerInfo `json:"docker"`
+	Host   HostInfo   `json:"host"`
+}
+
+const hostInfoCommand = `hostname; uname -r; uname -m; (. /etc/os-release 2>/dev/null && echo "$PRETTY_NAME") || uname -s; nproc; awk '/MemTotal/ {print $2}' /proc/meminfo`
+
+func (dm *DockerManager) GetServerInfo() (*ServerInfo, error) {
+	output, err := dm.executeSSHCommand("docker info --format '{{json .}}'")
+	if err != nil {
+		return nil, fmt.Errorf("Docker daemon is not running or permission denied: %w", err)
+	}
+
+	var info struct {
+		ServerVersion     string
+		Containers        int
+		ContainersRunning int
+		ContainersPaused  int
+		ContainersStopped int
+		Images            int
+		Driver            string
+		CgroupDriver      string
+		DockerRootDir     string
+		OperatingSystem   string
+		KernelVersion     string
+		Architecture      string
+		NCPU              int
+		MemTotal          int64
+		Name              string
+		Swarm             struct {
+			LocalNodeState   string
+			ControlAvailable bool
+		}
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(output)), &info); err != nil {
+		return nil, fmt.Errorf("failed to parse docker info output: %v", err)
+	}
+
+	result := &ServerInfo{
+		Server: dm.config.ID(),
+		Docker: DockerInfo{
+			ServerVersion:     info.ServerVersion,
+			Containers:        info.Containers,
+			ContainersRunning: info.ContainersRunning,
+			ContainersPaused:  info.ContainersPaused,
+			ContainersStopped: info.ContainersStopped,
+			Images:            info.Images,
+			StorageDriver:     info.Driver,
+			CgroupDriver:      info.CgroupDriver,
+			RootDir:           info.DockerRootDir,
+			SwarmState:        info.Swarm.LocalNodeState,
+			SwarmManager:      info.Swarm.ControlAvailable,
+		},
+		Host: HostInfo{
+			Hostname:        info.Name,
+			OperatingSystem: info.OperatingSystem,
+			KernelVersion:   info.KernelVersion,
+			Architecture:    info.Architecture,
+			CPUs:            info.NCPU,
+			MemoryTotal:     info.MemTotal,
+		},
+	}
+
+	if output, err := dm.executeSSHCommand("docker version --format '{{json .}}'"); err == nil {
+		var version struct {
+			Client struct{ Version string }
+			Server struct{ Version, APIVersion string }
+		}
+		if json.Unmarshal([]byte(strings.TrimSpace(output)), &version) == nil {
+			result.Docker.ClientVersion = version.Client.Version
+			result.Docker.APIVersion = version.Server.APIVersion
+		}
+	} else {
+		dm.logger.Error("docker version failed", "error", err)
+	}
+
+	// docker info describes the daemon's view of the machine; the host's own
+	// tools are more accurate inside VMs and rootless setups.
+	if output, err := dm.executeSSHCommand(hostInfoCommand); err == nil {
+		lines := strings.Split(strings.TrimSpace(output), "\n")
+		if len(lines) >= 6 {
+			result.Host.Hostname = strings.TrimSpace(lines[0])
+			result.Host.KernelVersion = strings.TrimSpace(lines[1])
+			result.Host.Architecture = strings.TrimSpace(lines[2])
+			result.Host.OperatingSystem = strings.TrimSpace(lines[3])
+			if cpus, err := strconv.Atoi(strings.TrimSpace(lines[4])); err == nil {
+				result.Host.CPUs = cpus
+			}
+			if memKB, err := strconv.ParseInt(strings.TrimSpace(lines[5]), 10, 64); err == nil {
+				result.Host.MemoryTotal = memKB * 1024
+			}
+		}
+	} else {
+		dm.logger.Error("host info command failed", "error", err)
+	}
+
+	return result, nil
+}
+
+func serverInfoHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := serverManager(w, r)
+	if !ok {
+		return
+	}
+
+	info, err := manager.GetServerInfo()
+	if err != nil {
+		manager.logger.Error("failed to get server info", "error", err)
+		writeFailure(w, err)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"info":    info,
+	})
+}
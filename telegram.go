@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// telegramAPIURL is the Bot API server, which may be a self-hosted one.
+var telegramAPIURL = envString("TELEGRAM_API_URL", "https://api.telegram.org")
+
+const (
+	telegramPollTimeout = 30 * time.Second
+	telegramMaxLines    = 50
+)
+
+type telegramSink struct {
+	notifier *Notifier
+}
+
+func (s *telegramSink) Send(ctx context.Context, n *Notification) error {
+	text, err := s.notifier.text(n)
+	if err != nil {
+		return permanentError{err}
+	}
+	return sendTelegram(ctx, s.notifier.Token, s.notifier.channel(n.Event), text)
+}
+
+func telegramMethodURL(token, method string) string {
+	return strings.TrimRight(telegramAPIURL, "/") + "/bot" + token + "/" + method
+}
+
+// telegramError keeps the bot token, which is part of every API URL, out of
+// errors that end up in logs and notifier status.
+func telegramError(token string, err error) error {
+	return errors.New(strings.ReplaceAll(err.Error(), token, "…"))
+}
+
+type telegramResponse struct {
+	OK          bool            `json:"ok"`
+	Description string          `json:"description"`
+	Result      json.RawMessage `json:"result"`
+}
+
+// checkTelegramResponse reads the result of a Bot API call. Errors come with
+// a description; throttling and server errors are temporary.
+func checkTelegramResponse(resp *http.Response) (json.RawMessage, error) {
+	var result telegramResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		if err := checkSinkResponse(resp, "telegram"); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("telegram: invalid response: %v", err)
+	}
+	if result.OK {
+		return result.Result, nil
+	}
+	err := fmt.Errorf("telegram: %s", result.Description)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return nil, err
+	}
+	return nil, permanentError{err}
+}
+
+func sendTelegram(ctx context.Context, token, chat, text string) error {
+	body, err := json.Marshal(map[string]string{"chat_id": chat, "text": text})
+	if err != nil {
+		return permanentError{err}
+	}
+	resp, err := postSink(ctx, telegramMethodURL(token, "sendMessage"), "application/json", body, nil)
+	if err != nil {
+		return telegramError(token, err)
+	}
+	defer resp.Body.Close()
+	_, err = checkTelegramResponse(resp)
+	return err
+}
+
+type telegramUpdate struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		Text string `json:"text"`
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+	} `json:"message"`
+}
+
+// telegramBot answers the commands sent to one bot on behalf of every
+// Telegram notifier using its token; Telegram allows only one poller per
+// bot, so servers sharing a bot share its poller.
+type telegramBot struct {
+	token     string
+	notifiers []Notifier
+	logger    *slog.Logger
+}
+
+var (
+	telegramBotsMu  sync.Mutex
+	telegramBotKeys = map[string]bool{}
+	// telegramOffsets are the next update of each bot, so a restarted
+	// poller does not answer commands twice.
+	telegramOffsets = map[string]int64{}
+)
+
+func telegramBotKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// startTelegramBots (re)starts a poller for every bot of a notifier with
+// commands enabled and stops the ones no longer used.
+func startTelegramBots() {
+	if store == nil {
+		return
+	}
+	docs, err := store.List(notifiersCollection)
+	if err != nil {
+		slog.Error("failed to read notifiers", "error", err)
+		return
+	}
+	bots := map[string]*telegramBot{}
+	for _, key := range sortedMetricKeys(docs) {
+		var n Notifier
+		if json.Unmarshal(docs[key], &n) != nil || n.Type != "telegram" || !n.Commands {
+			continue
+		}
+		botKey := telegramBotKey(n.Token)
+		if bots[botKey] == nil {
+			bots[botKey] = &telegramBot{token: n.Token, logger: slog.With("bot", botKey)}
+		}
+		bots[botKey].notifiers = append(bots[botKey].notifiers, n)
+	}
+
+	telegramBotsMu.Lock()
+	defer telegramBotsMu.Unlock()
+	for botKey := range telegramBotKeys {
+		if bots[botKey] == nil {
+			stopBackgroundTask("telegram:" + botKey)
+			delete(telegramBotKeys, botKey)
+		}
+	}
+	for botKey, bot := range bots {
+		telegramBotKeys[botKey] = true
+		startBackgroundTask("telegram:"+botKey, bot.run)
+	}
+}
+
+func (b *telegramBot) run(ctx context.Context) {
+	botKey := telegramBotKey(b.token)
+	b.logger.Info("telegram bot started", "notifiers", len(b.notifiers))
+	for {
+		telegramBotsMu.Lock()
+		offset := telegramOffsets[botKey]
+		telegramBotsMu.Unlock()
+
+		updates, err := b.getUpdates(ctx, offset)
+		if ctx.Err() != nil {
+			b.logger.Info("telegram bot stopped")
+			return
+		}
+		if err != nil {
+			b.logger.Error("telegram updates failed", "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(10 * time.Second):
+			}
+			continue
+		}
+		for _, update := range updates {
+			telegramBotsMu.Lock()
+			telegramOffsets[botKey] = update.UpdateID + 1
+			telegramBotsMu.Unlock()
+			if update.Message != nil {
+				b.handle(ctx, strconv.FormatInt(update.Message.Chat.ID, 10), update.Message.Text)
+			}
+		}
+	}
+}
+
+func (b *telegramBot) getUpdates(ctx context.Context, offset int64) ([]telegramUpdate, error) {
+	query := url.Values{
+		"offset":          {strconv.FormatInt(offset, 10)},
+		"timeout":         {strconv.Itoa(int(telegramPollTimeout.Seconds()))},
+		"allowed_updates": {`["message"]`},
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", telegramMethodURL(b.token, "getUpdates")+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, telegramError(b.token, err)
+	}
+	client := &http.Client{Timeout: telegramPollTimeout + 10*time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, telegramError(b.token, err)
+	}
+	defer resp.Body.Close()
+	result, err := checkTelegramResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	var updates []telegramUpdate
+	if err := json.Unmarshal(result, &updates); err != nil {
+		return nil, fmt.Errorf("telegram: invalid updates: %v", err)
+	}
+	return updates, nil
+}
+
+// allowedChats are the chats a notifier takes commands from.
+func (n *Notifier) allowedChats() []string {
+	if len(n.AllowedChats) > 0 {
+		return n.AllowedChats
+	}
+	chats := []string{}
+	if n.Channel != "" {
+		chats = append(chats, n.Channel)
+	}
+	for _, chat := range n.Channels {
+		chats = append(chats, chat)
+	}
+	return chats
+}
+
+// servers lists the servers chat may command, by server ID.
+func (b *telegramBot) servers(chat string) []string {
+	servers := []string{}
+	for _, n := range b.notifiers {
+		if containsString(n.allowedChats(), chat) && !containsString(servers, n.Server) {
+			servers = append(servers, n.Server)
+		}
+	}
+	return servers
+}
+
+// resolveServer picks the server a command acts on: the one named, by ID or
+// host, or the only one the chat may command.
+func resolveServer(servers []string, name string) (string, error) {
+	if name == "" {
+		if len(servers) == 1 {
+			return servers[0], nil
+		}
+		return "", fmt.Errorf("Name the server: %s", strings.Join(servers, ", "))
+	}
+	for _, server := range servers {
+		host, _, _ := strings.Cut(server, ":")
+		if strings.EqualFold(server, name) || strings.EqualFold(host, name) {
+			return server, nil
+		}
+	}
+	return "", fmt.Errorf("Unknown server: %s", name)
+}
+
+var telegramDone = map[string]string{"start": "started", "stop": "stopped", "restart": "restarted"}
+
+const telegramHelp = `Commands:
+/ps [server] - running containers
+/start <container> [server]
+/stop <container> [server]
+/restart <container> [server]`
+
+// handle answers a command. Chats that may not command any server are
+// ignored, so the bot does not reveal what it manages.
+func (b *telegramBot) handle(ctx context.Context, chat, text string) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return
+	}
+	command, _, _ := strings.Cut(strings.TrimPrefix(fields[0], "/"), "@")
+	args := fields[1:]
+
+	servers := b.servers(chat)
+	if len(servers) == 0 {
+		b.logger.Warn("ignoring telegram command from a chat that is not allowed", "chat", chat, "command", command)
+		return
+	}
+
+	reply, err := b.command(command, args, chat, servers)
+	if err != nil {
+		reply = "Error: " + err.Error()
+	}
+	if err := sendTelegram(ctx, b.token, chat, reply); err != nil {
+		b.logger.Error("failed to answer telegram command", "chat", chat, "command", command, "error", err)
+	}
+}
+
+// command runs a command through the same manager methods as the API,
+// including the protection check, and words the reply.
+func (b *telegramBot) command(command string, args []string, chat string, servers []string) (string, error) {
+	switch command {
+	case "ps":
+		if len(args) > 1 {
+			return telegramHelp, nil
+		}
+	case "start", "stop", "restart":
+		if len(args) == 0 || len(args) > 2 {
+			// A bare /start is what Telegram sends when a chat opens the bot.
+			return telegramHelp, nil
+		}
+	default:
+		return telegramHelp, nil
+	}
+
+	serverArg := ""
+	if command == "ps" && len(args) == 1 {
+		serverArg = args[0]
+	} else if command != "ps" && len(args) == 2 {
+		serverArg = args[1]
+	}
+	server, err := resolveServer(servers, serverArg)
+	if err != nil {
+		return "", err
+	}
+	manager, ok := registry.Get(server)
+	if !ok {
+		return "", fmt.Errorf("Server %s is not configured", server)
+	}
+
+	if command == "ps" {
+		containers, _, err := manager.CachedContainers(false, "status=running")
+		if err != nil {
+			return "", err
+		}
+		if len(containers) == 0 {
+			return "No running containers on " + server, nil
+		}
+		lines := []string{fmt.Sprintf("%d running on %s:", len(containers), server)}
+		for i, c := range containers {
+			if i == telegramMaxLines {
+				lines = append(lines, fmt.Sprintf("… and %d more", len(containers)-i))
+				break
+			}
+			lines = append(lines, c.Name+" - "+c.Status)
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+
+	container := args[0]
+	err = manager.checkProtected(container, command, false)
+	if err == nil {
+		switch command {
+		case "start":
+			err = manager.StartContainer(container)
+		case "stop":
+			err = manager.StopContainer(container)
+		case "restart":
+			err = manager.RestartContainer(container)
+		}
+	}
+	writeAudit(manager.logger, AuditEntry{
+		Time:   time.Now().UTC(),
+		Actor:  "telegram chat " + chat,
+		Remote: "telegram",
+		Server: server,
+		Action: "container." + command,
+		Target: container,
+	}, err)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s: %s %s", server, container, telegramDone[command]), nil
+}
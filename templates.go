@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// TemplateParameter is a value asked for at deploy time and substituted for
+// {{name}} in the template. Generate fills an empty value with a random
+// secret, e.g. for passwords.
+type TemplateParameter struct {
+	Name        string `json:"name"`
+	Label       string `json:"label"`
+	Default     string `json:"default,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+	Generate    bool   `json:"generate,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// AppTemplate deploys either a single container or a compose project. The
+// deploy name is available as {{name}} and becomes the container name or the
+// compose project.
+type AppTemplate struct {
+	Name        string                  `json:"name"`
+	Title       string                  `json:"title"`
+	Description string                  `json:"description"`
+	Category    string                  `json:"category"`
+	Parameters  []TemplateParameter     `json:"parameters"`
+	Container   *ContainerCreateRequest `json:"container,omitempty"`
+	Compose     string                  `json:"compose,omitempty"`
+}
+
+var templatePlaceholder = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_]+)\s*\}\}`)
+
+var defaultTemplates = []AppTemplate{
+	{
+		Name:        "postgres",
+		Title:       "PostgreSQL",
+		Description: "PostgreSQL database with a persistent volume",
+		Category:    "database",
+		Parameters: []TemplateParameter{
+			{Name: "version", Label: "Version", Default: "16"},
+			{Name: "port", Label: "Host port", Default: "5432"},
+			{Name: "user", Label: "User", Default: "postgres"},
+			{Name: "password", Label: "Password", Generate: true},
+			{Name: "database", Label: "Database", Default: "app"},
+		},
+		Container: &ContainerCreateRequest{
+			Image: "postgres:{{version}}",
+			Ports: []string{"{{port}}:5432"},
+			Env: map[string]string{
+				"POSTGRES_USER":     "{{user}}",
+				"POSTGRES_PASSWORD": "{{password}}",
+				"POSTGRES_DB":       "{{database}}",
+			},
+			Volumes:       []string{"{{name}}-data:/var/lib/postgresql/data"},
+			RestartPolicy: "unless-stopped",
+		},
+	},
+	{
+		Name:        "mariadb",
+		Title:       "MariaDB",
+		Description: "MariaDB database with a persistent volume",
+		Category:    "database",
+		Parameters: []TemplateParameter{
+			{Name: "version", Label: "Version", Default: "11"},
+			{Name: "port", Label: "Host port", Default: "3306"},
+			{Name: "root_password", Label: "Root password", Generate: true},
+			{Name: "database", Label: "Database", Default: "app"},
+		},
+		Container: &ContainerCreateRequest{
+			Image: "mariadb:{{version}}",
+			Ports: []string{"{{port}}:3306"},
+			Env: map[string]string{
+				"MARIADB_ROOT_PASSWORD": "{{root_password}}",
+				"MARIADB_DATABASE":      "{{database}}",
+			},
+			Volumes:       []string{"{{name}}-data:/var/lib/mysql"},
+			RestartPolicy: "unless-stopped",
+		},
+	},
+	{
+		Name:        "redis",
+		Title:       "Redis",
+		Description: "Redis with append-only persistence",
+		Category:    "database",
+		Parameters: []TemplateParameter{
+			{Name: "version", Label: "Version", Default: "7"},
+			{Name: "port", Label: "Host port", Default: "6379"},
+		},
+		Container: &ContainerCreateRequest{
+			Image:         "redis:{{version}}",
+			Ports:         []string{"{{port}}:6379"},
+			Volumes:       []string{"{{name}}-data:/data"},
+			RestartPolicy: "unless-stopped",
+			Command:       []string{"redis-server", "--appendonly", "yes"},
+		},
+	},
+	{
+		Name:        "nginx",
+		Title:       "nginx",
+		Description: "nginx web server serving a volume",
+		Category:    "web",
+		Parameters: []TemplateParameter{
+			{Name: "version", Label: "Version", Default: "stable"},
+			{Name: "port", Label: "Host port", Default: "80"},
+		},
+		Container: &ContainerCreateRequest{
+			Image:         "nginx:{{version}}",
+			Ports:         []string{"{{port}}:80"},
+			Volumes:       []string{"{{name}}-html:/usr/share/nginx/html:ro"},
+			RestartPolicy: "unless-stopped",
+		},
+	},
+	{
+		Name:        "wordpress",
+		Title:       "WordPress",
+		Description: "WordPress with a MariaDB database",
+		Category:    "cms",
+		Parameters: []TemplateParameter{
+			{Name: "port", Label: "Host port", Default: "8080"},
+			{Name: "db_password", Label: "Database password", Generate: true},
+		},
+		Compose: `services:
+  wordpress:
+    image: wordpress:latest
+    restart: unless-stopped
+    ports:
+      - "{{port}}:80"
+    environment:
+      WORDPRESS_DB_HOST: db
+      WORDPRESS_DB_USER: wordpress
+      WORDPRESS_DB_PASSWORD: "{{db_password}}"
+      WORDPRESS_DB_NAME: wordpress
+    volumes:
+      - wordpress:/var/www/html
+    depends_on:
+      - db
+  db:
+    image: mariadb:11
+    restart: unless-stopped
+    environment:
+      MARIADB_DATABASE: wordpress
+      MARIADB_USER: wordpress
+      MARIADB_PASSWORD: "{{db_password}}"
+      MARIADB_RANDOM_ROOT_PASSWORD: "1"
+    volumes:
+      - db:/var/lib/mysql
+volumes:
+  wordpress:
+  db:
+`,
+	},
+}
+
+// appTemplates is the built-in catalog, extended or overridden by name with
+// the JSON array in TEMPLATES_FILE.
+var appTemplates = loadTemplates(setting("TEMPLATES_FILE"))
+
+func loadTemplates(path string) []AppTemplate {
+	templates := map[string]AppTemplate{}
+	for _, t := range defaultTemplates {
+		templates[t.Name] = t
+	}
+	if path != "" {
+		var custom []AppTemplate
+		data, err := os.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(data, &custom)
+		}
+		if err != nil {
+			slog.Error("failed to load templates", "file", path, "error", err)
+		}
+		for _, t := range custom {
+			if err := t.Validate(); err != nil {
+				slog.Error("skipping invalid template", "file", path, "template", t.Name, "error", err)
+				continue
+			}
+			templates[t.Name] = t
+		}
+	}
+
+	list := make([]AppTemplate, 0, len(templates))
+	for _, t := range templates {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func findTemplate(name string) (AppTemplate, bool) {
+	for _, t := range appTemplates {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return AppTemplate{}, false
+}
+
+func (t AppTemplate) Validate() error {
+	if t.Name == "" {
+		return requestErrorf(codeInvalidRequest, "name is required")
+	}
+	if (t.Container == nil) == (t.Compose == "") {
+		return requestErrorf(codeInvalidRequest, "template needs either container or compose")
+	}
+	return nil
+}
+
+// renderTemplateString replaces every {{name}} placeholder with its value.
+func renderTemplateString(s string, values map[string]string) string {
+	return templatePlaceholder.ReplaceAllStringFunc(s, func(match string) string {
+		return values[templatePlaceholder.FindStringSubmatch(match)[1]]
+	})
+}
+
+// renderTemplateValue substitutes placeholders in every string of v, which
+// is decoded from JSON.
+func renderTemplateValue(v interface{}, values map[string]string) interface{} {
+	switch value := v.(type) {
+	case string:
+		return renderTemplateString(value, values)
+	case []interface{}:
+		for i := range value {
+			value[i] = renderTemplateValue(value[i], values)
+		}
+	case map[string]interface{}:
+		for key := range value {
+			value[key] = renderTemplateValue(value[key], values)
+		}
+	}
+	return v
+}
+
+// TemplateDeployRequest names the deployment and sets parameter values.
+type TemplateDeployRequest struct {
+	Name       string            `json:"name"`
+	Parameters map[string]string `json:"parameters"`
+}
+
+// values resolves the parameters with defaults and generated secrets.
+func (t AppTemplate) values(req TemplateDeployRequest) (map[string]string, error) {
+	values := map[string]string{"name": req.Name}
+	for _, p := range t.Parameters {
+		value, ok := req.Parameters[p.Name]
+		if !ok || value == "" {
+			value = p.Default
+		}
+		if value == "" && p.Generate {
+			value = newRequestID() + newRequestID()
+		}
+		if value == "" && p.Required {
+			return nil, requestErrorf(codeInvalidRequest, "parameter %s is required", p.Name)
+		}
+		// Compose files are rendered as text, where a line break could
+		// add arbitrary keys.
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, requestErrorf(codeInvalidRequest, "parameter %s must be a single line", p.Name)
+		}
+		values[p.Name] = value
+	}
+	return values, nil
+}
+
+func (t AppTemplate) renderContainer(values map[string]string) (ContainerCreateRequest, error) {
+	var req ContainerCreateRequest
+	data, err := json.Marshal(t.Container)
+	if err != nil {
+		return req, err
+	}
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return req, err
+	}
+	if data, err = json.Marshal(renderTemplateValue(raw, values)); err != nil {
+		return req, err
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		return req, err
+	}
+	if req.Name == "" {
+		req.Name = values["name"]
+	}
+	return req, nil
+}
+
+func templatesHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]interface{}{
+		"success":   true,
+		"templates": appTemplates,
+		"count":     len(appTemplates),
+	})
+}
+
+func templateDeployHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	manager, ok := serverManager(w, r)
+	if !ok {
+		return
+	}
+
+	t, found := findTemplate(mux.Vars(r)["name"])
+	if !found {
+		writeError(w, codeNotFound, "Template not found: "+mux.Vars(r)["name"])
+		return
+	}
+
+	var req TemplateDeployRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, codeInvalidRequest, "Invalid JSON format")
+		return
+	}
+	if req.Name == "" {
+		req.Name = t.Name
+	}
+	if err := validComposeProject(req.Name); err != nil {
+		writeFailure(w, err)
+		return
+	}
+	values, err := t.values(req)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":    true,
+		"template":   t.Name,
+		"name":       req.Name,
+		"parameters": values,
+	}
+	if t.Container != nil {
+		var create ContainerCreateRequest
+		var id string
+		if create, err = t.renderContainer(values); err == nil {
+			id, err = manager.CreateContainer(create)
+		}
+		recordAudit(r, manager.config.ID(), "template.deploy", t.Name+":"+req.Name, err)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		manager.logger.Info("template deployed", "template", t.Name, "container", create.Name)
+		response["kind"] = "container"
+		response["container_id"] = id
+		writeJSON(w, response)
+		return
+	}
+
+	output := []string{}
+	err = manager.WriteComposeFile(r.Context(), req.Name, []byte(renderTemplateString(t.Compose, values)))
+	if err == nil {
+		err = manager.ComposeUp(r.Context(), req.Name, func(line string) error {
+			output = append(output, line)
+			return nil
+		})
+	}
+	recordAudit(r, manager.config.ID(), "template.deploy", t.Name+":"+req.Name, err)
+	if err != nil {
+		response := failure(err)
+		response["output"] = strings.Join(output, "\n")
+		writeJSON(w, response)
+		return
+	}
+	manager.logger.Info("template deployed", "template", t.Name, "project", req.Name)
+	response["kind"] = "compose"
+	response["project"] = req.Name
+	response["output"] = strings.Join(output, "\n")
+	writeJSON(w, response)
+}
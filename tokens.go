@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	apiTokensCollection = "api_tokens"
+	apiTokenPrefix      = "rdm_"
+	tokenKey            = contextKey("api_token")
+	// tokenUseInterval limits how often LastUsedAt is written back.
+	tokenUseInterval = time.Minute
+)
+
+// tokenScopes are what a token may do: read allows GET requests, write also
+// allows changes and admin additionally allows the admin-only endpoints.
+var tokenScopes = []string{"read", "write", "admin"}
+
+// APIToken is a personal access token. Only a hash of the secret is kept; the
+// token itself is shown once, when it is created.
+type APIToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Username   string     `json:"username"`
+	Scopes     []string   `json:"scopes"`
+	Hash       string     `json:"hash,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+type APITokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresIn is a duration such as "720h"; empty means the token does
+	// not expire.
+	ExpiresIn string `json:"expires_in"`
+}
+
+func (t *APIToken) HasScope(scope string) bool {
+	if slices.Contains(t.Scopes, "admin") {
+		return true
+	}
+	return slices.Contains(t.Scopes, scope) || (scope == "read" && slices.Contains(t.Scopes, "write"))
+}
+
+func (t *APIToken) expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func tokenFrom(ctx context.Context) *APIToken {
+	token, _ := ctx.Value(tokenKey).(*APIToken)
+	return token
+}
+
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+func apiTokens() ([]APIToken, error) {
+	tokens := []APIToken{}
+	if store == nil {
+		return tokens, nil
+	}
+	docs, err := store.List(apiTokensCollection)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range sortedMetricKeys(docs) {
+		var token APIToken
+		if json.Unmarshal(docs[key], &token) == nil {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+// tokenUser resolves a bearer token to its owner. Expired tokens and tokens
+// of removed users are rejected.
+func tokenUser(secret string) (*User, *APIToken) {
+	if !strings.HasPrefix(secret, apiTokenPrefix) {
+		return nil, nil
+	}
+	tokens, err := apiTokens()
+	if err != nil {
+		return nil, nil
+	}
+	hash := hashToken(secret)
+	for i := range tokens {
+		token := &tokens[i]
+		if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hash)) != 1 || token.expired() {
+			continue
+		}
+		user, err := loadUser(token.Username)
+		if err != nil || user == nil {
+			return nil, nil
+		}
+		if token.LastUsedAt == nil || time.Since(*token.LastUsedAt) > tokenUseInterval {
+			now := time.Now().UTC()
+			token.LastUsedAt = &now
+			store.Put(apiTokensCollection, token.ID, token)
+		}
+		return user, token
+	}
+	return nil, nil
+}
+
+// tokenAllows reports whether the token's scopes cover the request. Reads
+// need the read scope, except that attaching to or running commands in a
+// container, and WebSocket upgrades, need the write scope whatever the
+// method.
+func tokenAllows(token *APIToken, r *http.Request) bool {
+	if interactiveRequest(r) {
+		return token.HasScope("write")
+	}
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return token.HasScope("read")
+	}
+	return token.HasScope("write")
+}
+
+func interactiveRequest(r *http.Request) bool {
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return true
+	}
+	path := r.URL.Path
+	return strings.HasPrefix(path, "/api/containers/") &&
+		(strings.HasSuffix(path, "/exec") || strings.Contains(path, "/attach"))
+}
+
+func createAPIToken(user *User, req APITokenRequest) (*APIToken, string, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return nil, "", requestErrorf(codeInvalidRequest, "name is required")
+	}
+	if len(req.Scopes) == 0 {
+		return nil, "", requestErrorf(codeInvalidRequest, "scopes are required: use %s", strings.Join(tokenScopes, ", "))
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(tokenScopes, scope) {
+			return nil, "", requestErrorf(codeInvalidRequest, "invalid scope %q: use %s", scope, strings.Join(tokenScopes, ", "))
+		}
+	}
+	if slices.Contains(req.Scopes, "admin") && !user.IsAdmin() {
+		return nil, "", requestErrorf(codeForbidden, "only admins can create tokens with the admin scope")
+	}
+
+	token := &APIToken{
+		ID:        newRequestID(),
+		Name:      req.Name,
+		Username:  user.Username,
+		Scopes:    req.Scopes,
+		CreatedAt: time.Now().UTC(),
+	}
+	if req.ExpiresIn != "" {
+		ttl, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || ttl <= 0 {
+			return nil, "", requestErrorf(codeInvalidRequest, "invalid expires_in %q", req.ExpiresIn)
+		}
+		expires := token.CreatedAt.Add(ttl)
+		token.ExpiresAt = &expires
+	}
+
+	buf := make([]byte, 24)
+	rand.Read(buf)
+	secret := apiTokenPrefix + hex.EncodeToString(buf)
+	token.Hash = hashToken(secret)
+	if err := store.Put(apiTokensCollection, token.ID, token); err != nil {
+		return nil, "", err
+	}
+	token.Hash = ""
+	return token, secret, nil
+}
+
+// revokeUserTokens deletes all tokens of a user, e.g. when the account is
+// removed.
+func revokeUserTokens(username string) {
+	tokens, err := apiTokens()
+	if err != nil {
+		return
+	}
+	for _, token := range tokens {
+		if strings.EqualFold(token.Username, username) {
+			store.Delete(apiTokensCollection, token.ID)
+		}
+	}
+}
+
+// requireSessionUser is for managing tokens: it must be done from a login
+// session or with an admin-scoped token, so a leaked token cannot mint more.
+func requireSessionUser(w http.ResponseWriter, r *http.Request) (*User, bool) {
+	user := userFrom(r.Context())
+	if user == nil {
+		writeError(w, codeConflict, "Authentication is disabled")
+		return nil, false
+	}
+	if token := tokenFrom(r.Context()); token != nil && !token.HasScope("admin") {
+		writeAuthError(w, http.StatusForbidden, "API tokens cannot manage tokens")
+		return nil, false
+	}
+	return user, true
+}
+
+func apiTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireSessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		tokens, err := apiTokens()
+		if err != nil {
+			writeFailure(w, fmt.Errorf("Failed to read tokens: %w", err))
+			return
+		}
+		// Admins may list everyone's tokens with ?all=true.
+		all := r.URL.Query().Get("all") == "true" && user.IsAdmin()
+		owned := []APIToken{}
+		for _, token := range tokens {
+			if all || strings.EqualFold(token.Username, user.Username) {
+				token.Hash = ""
+				owned = append(owned, token)
+			}
+		}
+		writeJSON(w, map[string]interface{}{
+			"success": true,
+			"tokens":  owned,
+			"count":   len(owned),
+		})
+	case "POST":
+		var req APITokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, codeInvalidRequest, "Invalid JSON format")
+			return
+		}
+		token, secret, err := createAPIToken(user, req)
+		recordAudit(r, "", "token.create", req.Name, err)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"success":  true,
+			"token":    secret,
+			"metadata": token,
+			"message":  "Store the token now; it cannot be shown again",
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func apiTokenRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user, ok := requireSessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	var token APIToken
+	found, err := store.Get(apiTokensCollection, id, &token)
+	if err == nil && (!found || (!strings.EqualFold(token.Username, user.Username) && !user.IsAdmin())) {
+		err = requestErrorf(codeNotFound, "token %s not found", id)
+	}
+	if err == nil {
+		err = store.Delete(apiTokensCollection, id)
+	}
+	recordAudit(r, "", "token.revoke", id, err)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"message": "Token " + token.Name + " revoked",
+	})
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadTokenCannotAttach(t *testing.T) {
+	var err error
+	if store, err = OpenStore(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	authEnabled = true
+	user := &User{Username: "viewer", Role: "admin", CreatedAt: time.Now().UTC()}
+	if err := saveUser(user); err != nil {
+		t.Fatal(err)
+	}
+	_, secret, err := createAPIToken(user, APITokenRequest{Name: "ci", Scopes: []string{"read"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for _, tc := range []struct {
+		method, path string
+		upgrade      bool
+		want         int
+	}{
+		{"GET", "/api/containers/abc/attach/ws", true, http.StatusForbidden},
+		{"GET", "/api/containers/abc/attach/ws", false, http.StatusForbidden},
+		{"GET", "/api/containers/abc/stats/ws", true, http.StatusForbidden},
+		{"GET", "/api/containers/abc/exec", false, http.StatusForbidden},
+		{"GET", "/api/containers/abc/logs", false, http.StatusOK},
+	} {
+		r := httptest.NewRequest(tc.method, tc.path, nil)
+		r.Header.Set("Authorization", "Bearer "+secret)
+		if tc.upgrade {
+			r.Header.Set("Connection", "Upgrade")
+			r.Header.Set("Upgrade", "websocket")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%s %s (upgrade %v) = %d, want %d", tc.method, tc.path, tc.upgrade, w.Code, tc.want)
+		}
+	}
+}
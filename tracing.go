@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Minimal OpenTelemetry-compatible tracing: spans follow W3C trace context
+// and are exported as OTLP/HTTP JSON when OTEL_EXPORTER_OTLP_ENDPOINT is set.
+
+const (
+	spanKindServer = 2
+	spanKindClient = 3
+)
+
+const spanKey contextKey = "span"
+
+type Span struct {
+	traceID    [16]byte
+	spanID     [8]byte
+	parentID   [8]byte
+	name       string
+	kind       int
+	start      time.Time
+	attributes map[string]string
+	mu         sync.Mutex
+}
+
+type spanExporter struct {
+	endpoint string
+	service  string
+	headers  map[string]string
+	queue    chan otlpSpan
+}
+
+var tracer *spanExporter
+
+// setupTracing enables span export using the standard OTEL_* variables.
+func setupTracing() {
+	endpoint := setting("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		if base := setting("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+			endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+		}
+	}
+	if endpoint == "" {
+		return
+	}
+
+	service := setting("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = "remote-docker-manager"
+	}
+
+	headers := map[string]string{}
+	for _, pair := range strings.Split(setting("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if key, value, ok := strings.Cut(pair, "="); ok {
+			headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+
+	tracer = &spanExporter{
+		endpoint: endpoint,
+		service:  service,
+		headers:  headers,
+		queue:    make(chan otlpSpan, 2048),
+	}
+	go tracer.run()
+	slog.Info("tracing enabled", "endpoint", endpoint, "service", service)
+}
+
+func spanFrom(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey).(*Span)
+	return span
+}
+
+// startSpan begins a span that is a child of the span in ctx, if any. It is
+// cheap enough to call unconditionally; nothing is recorded when tracing is off.
+func startSpan(ctx context.Context, name string, kind int, attrs ...string) (context.Context, *Span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+
+	span := &Span{name: name, kind: kind, start: time.Now(), attributes: map[string]string{}}
+	if parent := spanFrom(ctx); parent != nil {
+		span.traceID = parent.traceID
+		span.parentID = parent.spanID
+	} else {
+		rand.Read(span.traceID[:])
+	}
+	rand.Read(span.spanID[:])
+	span.SetAttributes(attrs...)
+	return context.WithValue(ctx, spanKey, span), span
+}
+
+func (s *Span) SetAttributes(attrs ...string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i+1 < len(attrs); i += 2 {
+		s.attributes[attrs[i]] = attrs[i+1]
+	}
+}
+
+func (s *Span) End(err error) {
+	if s == nil || tracer == nil {
+		return
+	}
+
+	s.mu.Lock()
+	attributes := make([]otlpAttribute, 0, len(s.attributes))
+	for _, key := range sortedKeys(s.attributes) {
+		attributes = append(attributes, otlpAttribute{Key: key, Value: otlpValue{StringValue: s.attributes[key]}})
+	}
+	s.mu.Unlock()
+
+	exported := otlpSpan{
+		TraceID:           hex.EncodeToString(s.traceID[:]),
+		SpanID:            hex.EncodeToString(s.spanID[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(time.Now().UnixNano(), 10),
+		Attributes:        attributes,
+		Status:            otlpStatus{Code: 1},
+	}
+	if s.parentID != [8]byte{} {
+		exported.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	if err != nil {
+		exported.Status = otlpStatus{Code: 2, Message: err.Error()}
+	}
+
+	select {
+	case tracer.queue <- exported:
+	default:
+		// Never block request handling on a slow collector.
+	}
+}
+
+// parseTraceparent extracts the remote parent from a W3C traceparent header.
+func parseTraceparent(header string) *Span {
+	parts := strings.Split(header, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return nil
+	}
+	span := &Span{}
+	if _, err := hex.Decode(span.traceID[:], []byte(parts[1])); err != nil {
+		return nil
+	}
+	if _, err := hex.Decode(span.spanID[:], []byte(parts[2])); err != nil {
+		return nil
+	}
+	return span
+}
+
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tracer == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		if remote := parseTraceparent(r.Header.Get("traceparent")); remote != nil {
+			ctx = context.WithValue(ctx, spanKey, remote)
+		}
+
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		ctx, span := startSpan(ctx, r.Method+" "+route, spanKindServer,
+			"http.request.method", r.Method,
+			"http.route", route,
+			"url.path", r.URL.Path,
+			"request.id", requestIDFrom(ctx),
+		)
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		span.SetAttributes("http.response.status_code", strconv.Itoa(recorder.status))
+		var err error
+		if recorder.status >= 500 {
+			err = fmt.Errorf("HTTP %d", recorder.status)
+		}
+		span.End(err)
+	})
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes"`
+	Status            otlpStatus      `json:"status"`
+}
+
+func (e *spanExporter) run() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	var batch []otlpSpan
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) < 256 {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := e.export(batch); err != nil {
+			slog.Error("trace export failed", "spans", len(batch), "error", err)
+		}
+		batch = nil
+	}
+}
+
+func (e *spanExporter) export(spans []otlpSpan) error {
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: e.service}}},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "remote-docker-manager"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	uptimeCollection = "uptime"
+	uptimeRetention  = 31 * 24 * time.Hour
+)
+
+// UptimeTransition records a container going up (running), down or being
+// removed, which ends its history.
+type UptimeTransition struct {
+	Server    string    `json:"server"`
+	Container string    `json:"container"`
+	State     string    `json:"state"`
+	Time      time.Time `json:"time"`
+}
+
+// UptimeSegment is a stretch of time a container spent in one state.
+type UptimeSegment struct {
+	State   string    `json:"state"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Seconds float64   `json:"seconds"`
+}
+
+var (
+	uptimeMu sync.Mutex
+	// uptimeStates are the last recorded state of every container, per
+	// server, loaded from the store when a server is first tracked.
+	uptimeStates = map[string]map[string]string{}
+)
+
+func loadUptimeStates(server string) map[string]string {
+	states, ok := uptimeStates[server]
+	if ok {
+		return states
+	}
+	states = map[string]string{}
+	for _, t := range uptimeTransitions(server, "") {
+		states[t.Container] = t.State
+	}
+	uptimeStates[server] = states
+	return states
+}
+
+// recordUptime appends a transition unless the container is already in
+// state.
+func recordUptime(server, container, state string, at time.Time) {
+	if store == nil || container == "" {
+		return
+	}
+	uptimeMu.Lock()
+	defer uptimeMu.Unlock()
+	states := loadUptimeStates(server)
+	if states[container] == state || (states[container] == "" && state == "removed") {
+		return
+	}
+	states[container] = state
+	t := UptimeTransition{Server: server, Container: container, State: state, Time: at.UTC()}
+	if err := store.Append(uptimeCollection, t); err != nil {
+		slog.Error("failed to record uptime", "server", server, "container", container, "error", err)
+	}
+}
+
+// recordUptimeEvent records the transitions docker events announce, so ones
+// shorter than the sampler interval are not missed.
+func recordUptimeEvent(server string, event *DockerEvent) {
+	switch event.Action {
+	case "start":
+		recordUptime(server, event.Name, "up", event.Time)
+	case "die":
+		recordUptime(server, event.Name, "down", event.Time)
+	case "destroy":
+		recordUptime(server, event.Name, "removed", event.Time)
+	}
+}
+
+// trackUptime brings the recorded states in line with the container list,
+// for changes no event was seen for, e.g. while the manager was not running.
+func trackUptime(dm *DockerManager) {
+	containers, _, err := dm.CachedContainers(false)
+	if err != nil {
+		dm.logger.Error("uptime tracking failed", "error", err)
+		return
+	}
+	server := dm.config.ID()
+	now := time.Now()
+	present := map[string]bool{}
+	for _, c := range containers {
+		present[c.Name] = true
+		state := "down"
+		if c.State == "running" {
+			state = "up"
+		}
+		recordUptime(server, c.Name, state, now)
+	}
+
+	uptimeMu.Lock()
+	var gone []string
+	for name, state := range loadUptimeStates(server) {
+		if !present[name] && state != "removed" {
+			gone = append(gone, name)
+		}
+	}
+	uptimeMu.Unlock()
+	for _, name := range gone {
+		recordUptime(server, name, "removed", now)
+	}
+}
+
+// uptimeTransitions returns the recorded transitions of the server, or of
+// one of its containers, oldest first.
+func uptimeTransitions(server, container string) []UptimeTransition {
+	transitions := []UptimeTransition{}
+	store.Scan(uptimeCollection, func(data []byte) error {
+		var t UptimeTransition
+		if json.Unmarshal(data, &t) == nil && t.Server == server && (container == "" || t.Container == container) {
+			transitions = append(transitions, t)
+		}
+		return nil
+	})
+	sort.SliceStable(transitions, func(i, j int) bool { return transitions[i].Time.Before(transitions[j].Time) })
+	return transitions
+}
+
+// uptimeSegments splits from..to by the state a container was in. Time
+// before its first transition or after its removal is left out.
+func uptimeSegments(transitions []UptimeTransition, from, to time.Time) []UptimeSegment {
+	segments := []UptimeSegment{}
+	state, at := "", from
+	add := func(end time.Time) {
+		if state == "up" || state == "down" {
+			if end.After(at) {
+				segments = append(segments, UptimeSegment{State: state, From: at, To: end, Seconds: end.Sub(at).Seconds()})
+			}
+		}
+	}
+	for _, t := range transitions {
+		if !t.Time.After(from) {
+			state = t.State
+			continue
+		}
+		if t.Time.After(to) {
+			break
+		}
+		add(t.Time)
+		state, at = t.State, t.Time
+	}
+	add(to)
+	return segments
+}
+
+// availability is the percentage of the known time in segments a container
+// was up, or nil when nothing is known.
+func availability(segments []UptimeSegment) *float64 {
+	var up, known float64
+	for _, segment := range segments {
+		known += segment.Seconds
+		if segment.State == "up" {
+			up += segment.Seconds
+		}
+	}
+	if known == 0 {
+		return nil
+	}
+	percent := up / known * 100
+	return &percent
+}
+
+type uptimeSummary struct {
+	Container string    `json:"container"`
+	State     string    `json:"state"`
+	Since     time.Time `json:"since"`
+	Day       *float64  `json:"availability_24h"`
+	Week      *float64  `json:"availability_7d"`
+	Month     *float64  `json:"availability_30d"`
+	Downs24h  int       `json:"downs_24h"`
+}
+
+// uptimeHandler reports the availability of every container of the current
+// server, least available first, so flapping services stand out.
+func uptimeHandler(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		writeError(w, codeUnavailable, "Uptime history is not available")
+		return
+	}
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	byContainer := map[string][]UptimeTransition{}
+	for _, t := range uptimeTransitions(manager.config.ID(), "") {
+		byContainer[t.Container] = append(byContainer[t.Container], t)
+	}
+	now := time.Now().UTC()
+	summaries := []uptimeSummary{}
+	for name, transitions := range byContainer {
+		last := transitions[len(transitions)-1]
+		if last.State == "removed" && r.URL.Query().Get("all") != "true" {
+			continue
+		}
+		summary := uptimeSummary{
+			Container: name,
+			State:     last.State,
+			Since:     last.Time,
+			Day:       availability(uptimeSegments(transitions, now.Add(-24*time.Hour), now)),
+			Week:      availability(uptimeSegments(transitions, now.Add(-7*24*time.Hour), now)),
+			Month:     availability(uptimeSegments(transitions, now.Add(-30*24*time.Hour), now)),
+		}
+		for i, t := range transitions {
+			if i > 0 && transitions[i-1].State == "up" && t.State == "down" && t.Time.After(now.Add(-24*time.Hour)) {
+				summary.Downs24h++
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		a, b := summaries[i].Day, summaries[j].Day
+		if a != nil && b != nil && *a != *b {
+			return *a < *b
+		}
+		if (a == nil) != (b == nil) {
+			return b == nil
+		}
+		return summaries[i].Container < summaries[j].Container
+	})
+
+	writeJSON(w, map[string]interface{}{
+		"success":    true,
+		"containers": summaries,
+		"count":      len(summaries),
+	})
+}
+
+// uptimeTimelineHandler returns the up and down segments of one container
+// since ?since= (default 24h) with its availability over that time.
+func uptimeTimelineHandler(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		writeError(w, codeUnavailable, "Uptime history is not available")
+		return
+	}
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	from, err := parseSince(r.URL.Query().Get("since"))
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	now := time.Now().UTC()
+	if from.IsZero() {
+		from = now.Add(-24 * time.Hour)
+	}
+
+	name := mux.Vars(r)["name"]
+	transitions := uptimeTransitions(manager.config.ID(), name)
+	if len(transitions) == 0 {
+		writeError(w, codeNotFound, "Uptime history not found for container: "+name)
+		return
+	}
+	segments := uptimeSegments(transitions, from, now)
+	inWindow := []UptimeTransition{}
+	for _, t := range transitions {
+		if t.Time.After(from) {
+			inWindow = append(inWindow, t)
+		}
+	}
+	writeJSON(w, map[string]interface{}{
+		"success":      true,
+		"container":    name,
+		"from":         from,
+		"to":           now,
+		"availability": availability(segments),
+		"segments":     segments,
+		"transitions":  inWindow,
+	})
+}
+
+// pruneUptimeHistory drops transitions older than uptimeRetention, keeping
+// the last one before the cutoff of each container so its state at the start
+// of the retained history stays known.
+func pruneUptimeHistory() {
+	cutoff := time.Now().Add(-uptimeRetention)
+	lastBefore := map[string]time.Time{}
+	store.Scan(uptimeCollection, func(data []byte) error {
+		var t UptimeTransition
+		if json.Unmarshal(data, &t) == nil && t.Time.Before(cutoff) {
+			key := t.Server + "\x00" + t.Container
+			if t.Time.After(lastBefore[key]) {
+				lastBefore[key] = t.Time
+			}
+		}
+		return nil
+	})
+	err := store.Compact(uptimeCollection, func(data []byte) bool {
+		var t UptimeTransition
+		if json.Unmarshal(data, &t) != nil {
+			return false
+		}
+		last, ok := lastBefore[t.Server+"\x00"+t.Container]
+		return !t.Time.Before(cutoff) || (ok && t.Time.Equal(last) && t.State != "removed")
+	})
+	if err != nil {
+		slog.Error("failed to prune uptime history", "error", err)
+	}
+}
+
+func startUptimeHistoryPruner() {
+	go func() {
+		for {
+			pruneUptimeHistory()
+			time.Sleep(24 * time.Hour)
+		}
+	}()
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	usersCollection   = "users"
+	minPasswordLength = 8
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.@-]{0,63}$`)
+
+// User is an account. Role is "admin", who may manage users, or "user".
+// Source is empty for local accounts and "ldap" for directory users.
+type User struct {
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"password_hash,omitempty"`
+	Role         string    `json:"role"`
+	Source       string    `json:"source,omitempty"`
+	Restricted   bool      `json:"restricted,omitempty"`
+	Servers      []string  `json:"servers,omitempty"`
+	Language     string    `json:"language,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type UserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+func (u *User) IsAdmin() bool {
+	return u.Role == "admin"
+}
+
+func validPassword(password string) error {
+	if len(password) < minPasswordLength {
+		return requestErrorf(codeInvalidRequest, "password must be at least %d characters", minPasswordLength)
+	}
+	// bcrypt ignores everything past 72 bytes.
+	if len(password) > 72 {
+		return requestErrorf(codeInvalidRequest, "password must be at most 72 bytes")
+	}
+	return nil
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+func loadUser(username string) (*User, error) {
+	if store == nil {
+		return nil, requestErrorf(codeUnavailable, "user store is not available")
+	}
+	var user User
+	found, err := store.Get(usersCollection, strings.ToLower(username), &user)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func saveUser(user *User) error {
+	if store == nil {
+		return requestErrorf(codeUnavailable, "user store is not available")
+	}
+	return store.Put(usersCollection, strings.ToLower(user.Username), user)
+}
+
+func listUsers() ([]User, error) {
+	users := []User{}
+	if store == nil {
+		return users, nil
+	}
+	docs, err := store.List(usersCollection)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range sortedMetricKeys(docs) {
+		var user User
+		if json.Unmarshal(docs[key], &user) == nil {
+			user.PasswordHash = ""
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+// createUser validates req and stores a new account.
+func createUser(req UserRequest) (*User, error) {
+	if !usernamePattern.MatchString(req.Username) {
+		return nil, requestErrorf(codeInvalidRequest, "invalid username %q", req.Username)
+	}
+	if err := validPassword(req.Password); err != nil {
+		return nil, err
+	}
+	switch req.Role {
+	case "":
+		req.Role = "user"
+	case "admin", "user":
+	default:
+		return nil, requestErrorf(codeInvalidRequest, "invalid role %q: use admin or user", req.Role)
+	}
+	if existing, err := loadUser(req.Username); err != nil {
+		return nil, err
+	} else if existing != nil {
+		return nil, fmt.Errorf("user %s already exists", req.Username)
+	}
+
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		return nil, err
+	}
+	user := &User{Username: req.Username, PasswordHash: hash, Role: req.Role, CreatedAt: time.Now().UTC()}
+	return user, saveUser(user)
+}
+
+// ensureAdminUser creates the first account when there is none:
+// ADMIN_USERNAME (default admin) with ADMIN_PASSWORD, or a generated password
+// that is printed once.
+func ensureAdminUser() error {
+	users, err := listUsers()
+	if err != nil || len(users) > 0 {
+		return err
+	}
+
+	username := setting("ADMIN_USERNAME")
+	if username == "" {
+		username = "admin"
+	}
+	password := setting("ADMIN_PASSWORD")
+	generated := password == ""
+	if generated {
+		password = newRequestID()
+	}
+	if _, err := createUser(UserRequest{Username: username, Password: password, Role: "admin"}); err != nil {
+		return fmt.Errorf("failed to create admin user: %v", err)
+	}
+	if generated {
+		fmt.Printf("🔑 Created user %s with password %s - change it after logging in\n", username, password)
+	}
+	return nil
+}
+
+// requireAdmin writes a 403 and returns false unless the request comes from
+// an admin, using a session or a token with the admin scope.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if user := userFrom(r.Context()); user == nil || !user.IsAdmin() {
+		writeAuthError(w, http.StatusForbidden, "Admin role required")
+		return false
+	}
+	if token := tokenFrom(r.Context()); token != nil && !token.HasScope("admin") {
+		writeAuthError(w, http.StatusForbidden, "API token lacks the admin scope")
+		return false
+	}
+	return true
+}
+
+func usersHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		users, err := listUsers()
+		if err != nil {
+			writeFailure(w, fmt.Errorf("Failed to read users: %w", err))
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"success": true,
+			"users":   users,
+			"count":   len(users),
+		})
+	case "POST":
+		var req UserRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, codeInvalidRequest, "Invalid JSON format")
+			return
+		}
+		user, err := createUser(req)
+		recordAudit(r, "", "user.create", req.Username, err)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		user.PasswordHash = ""
+		writeJSON(w, map[string]interface{}{
+			"success": true,
+			"user":    user,
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func userRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	username := mux.Vars(r)["name"]
+	if strings.EqualFold(username, userFrom(r.Context()).Username) {
+		writeError(w, codeConflict, "You cannot remove your own account")
+		return
+	}
+	user, err := loadUser(username)
+	if err == nil && user == nil {
+		err = requestErrorf(codeNotFound, "user %s not found", username)
+	}
+	if err == nil {
+		err = store.Delete(usersCollection, strings.ToLower(username))
+	}
+	recordAudit(r, "", "user.remove", username, err)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	endSessions(user.Username)
+	revokeUserTokens(user.Username)
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"message": "User " + user.Username + " removed",
+	})
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Volume struct {
+	Name       string   `json:"name"`
+	Driver     string   `json:"driver"`
+	Mountpoint string   `json:"mountpoint"`
+	Scope      string   `json:"scope"`
+	Size       string   `json:"size"`
+	Containers []string `json:"containers"`
+}
+
+type VolumeCreateRequest struct {
+	Name    string            `json:"name"`
+	Driver  string            `json:"driver"`
+	Labels  map[string]string `json:"labels"`
+	Options map[string]string `json:"options"`
+}
+
+func (dm *DockerManager) GetVolumes() ([]Volume, error) {
+	output, err := dm.executeSSHCommand("docker volume ls --format '{{.Name}}|{{.Driver}}|{{.Mountpoint}}|{{.Scope}}'")
+	if err != nil {
+		return nil, fmt.Errorf("Docker volume ls command failed: %w", err)
+	}
+
+	volumes := []Volume{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		parts := strings.Split(line, "|")
+		if len(parts) < 4 {
+			continue
+		}
+		volumes = append(volumes, Volume{
+			Name:       strings.TrimSpace(parts[0]),
+			Driver:     strings.TrimSpace(parts[1]),
+			Mountpoint: strings.TrimSpace(parts[2]),
+			Scope:      strings.TrimSpace(parts[3]),
+			Containers: []string{},
+		})
+	}
+
+	// Sizes and usage are best-effort: older daemons may not support the
+	// verbose df format, and the listing is still useful without them.
+	sizes := dm.volumeSizes()
+	users := dm.volumeUsers()
+	for i := range volumes {
+		volumes[i].Size = sizes[volumes[i].Name]
+		if names, ok := users[volumes[i].Name]; ok {
+			volumes[i].Containers = names
+		}
+	}
+
+	return volumes, nil
+}
+
+func (dm *DockerManager) volumeSizes() map[string]string {
+	sizes := map[string]string{}
+	output, err := dm.executeSSHCommand("docker system df -v --format '{{json .Volumes}}'")
+	if err != nil {
+		dm.logger.Error("failed to read volume sizes", "error", err)
+		return sizes
+	}
+
+	var entries []struct {
+		Name string
+		Size string
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(output)), &entries); err != nil {
+		dm.logger.Error("failed to parse volume sizes", "error", err)
+		return sizes
+	}
+	for _, entry := range entries {
+		sizes[entry.Name] = entry.Size
+	}
+	return sizes
+}
+
+func (dm *DockerManager) volumeUsers() map[string][]string {
+	users := map[string][]string{}
+	output, err := dm.executeSSHCommand("docker ps -a --no-trunc --format '{{.Names}}|{{.Mounts}}'")
+	if err != nil {
+		dm.logger.Error("failed to read container mounts", "error", err)
+		return users
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "|", 2)
+		if len(parts) < 2 || parts[1] == "" {
+			continue
+		}
+		for _, mount := range strings.Split(parts[1], ",") {
+			mount = strings.TrimSpace(mount)
+			if mount != "" {
+				users[mount] = append(users[mount], parts[0])
+			}
+		}
+	}
+	for name := range users {
+		sort.Strings(users[name])
+	}
+	return users
+}
+
+func (dm *DockerManager) CreateVolume(req VolumeCreateRequest) (string, error) {
+	args := []string{"docker", "volume", "create"}
+	if req.Driver != "" {
+		args = append(args, "--driver", shellQuote(req.Driver))
+	}
+	for _, key := range sortedKeys(req.Labels) {
+		args = append(args, "--label", shellQuote(key+"="+req.Labels[key]))
+	}
+	for _, key := range sortedKeys(req.Options) {
+		args = append(args, "--opt", shellQuote(key+"="+req.Options[key]))
+	}
+	if req.Name != "" {
+		args = append(args, shellQuote(req.Name))
+	}
+
+	output, err := dm.executeSSHCommand(strings.Join(args, " "))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(output), nil
+}
+
+func (dm *DockerManager) InspectVolume(name string) (map[string]interface{}, error) {
+	output, err := dm.executeSSHCommand("docker volume inspect " + shellQuote(name))
+	if err != nil {
+		return nil, err
+	}
+
+	var details []map[string]interface{}
+	if err := json.Unmarshal([]byte(output), &details); err != nil {
+		return nil, fmt.Errorf("failed to parse volume inspect output: %v", err)
+	}
+	if len(details) == 0 {
+		return nil, requestErrorf(codeNotFound, "volume %s not found", name)
+	}
+	return details[0], nil
+}
+
+func removeVolumeCommand(name string) string {
+	return "docker volume rm " + shellQuote(name)
+}
+
+func (dm *DockerManager) RemoveVolume(name string) error {
+	_, err := dm.executeSSHCommand(removeVolumeCommand(name))
+	return err
+}
+
+func (dm *DockerManager) PruneVolumes() (string, error) {
+	output, err := dm.executeSSHCommand(pruneTargets["volume"])
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(output), nil
+}
+
+func (dm *DockerManager) BackupVolume(ctx context.Context, name string, w io.Writer) error {
+	command := fmt.Sprintf("docker run --rm -v %s:/volume:ro alpine tar -czf - -C /volume .", shellQuote(name))
+	return dm.streamSSHCommand(ctx, command, nil, w)
+}
+
+type RestorePreview struct {
+	Overwritten []string `json:"overwritten"`
+	Added       []string `json:"added"`
+}
+
+// restorePreviewScript lists the files of the archive on stdin, marking the
+// ones that already exist in the mounted volume.
+const restorePreviewScript = `tar -tzf - | while read -r f; do
+case "$f" in */) continue ;; esac
+if [ -e "/volume/$f" ]; then echo "overwrite|$f"; else echo "add|$f"; fi
+done`
+
+// PreviewVolumeRestore compares the archive with the volume. Mounting a
+// volume creates it, so a volume that does not exist yet is not mounted:
+// everything in the archive would be added to it.
+func (dm *DockerManager) PreviewVolumeRestore(ctx context.Context, name string, archive io.Reader) (*RestorePreview, error) {
+	if _, err := dm.executeSSHCommand("docker volume inspect --format '{{.Name}}' " + shellQuote(name)); err != nil {
+		if errorCodeOf(err) != "" {
+			return nil, err
+		}
+		return previewNewVolume(archive)
+	}
+
+	command := fmt.Sprintf("docker run --rm -i -v %s:/volume:ro alpine sh -c %s", shellQuote(name), shellQuote(restorePreviewScript))
+
+	var output strings.Builder
+	if err := dm.streamSSHCommand(ctx, command, archive, &output); err != nil {
+		return nil, err
+	}
+
+	preview := &RestorePreview{Overwritten: []string{}, Added: []string{}}
+	for _, line := range strings.Split(output.String(), "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "|", 2)
+		if len(parts) < 2 {
+			continue
+		}
+		if parts[0] == "overwrite" {
+			preview.Overwritten = append(preview.Overwritten, parts[1])
+		} else {
+			preview.Added = append(preview.Added, parts[1])
+		}
+	}
+	return preview, nil
+}
+
+func previewNewVolume(archive io.Reader) (*RestorePreview, error) {
+	gz, err := gzip.NewReader(archive)
+	if err != nil {
+		return nil, requestErrorf(codeInvalidRequest, "invalid archive: %v", err)
+	}
+	defer gz.Close()
+
+	preview := &RestorePreview{Overwritten: []string{}, Added: []string{}}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return preview, nil
+		}
+		if err != nil {
+			return nil, requestErrorf(codeInvalidRequest, "invalid archive: %v", err)
+		}
+		if header.Typeflag != tar.TypeDir {
+			preview.Added = append(preview.Added, header.Name)
+		}
+	}
+}
+
+func (dm *DockerManager) RestoreVolume(ctx context.Context, name string, archive io.Reader) error {
+	if _, err := dm.executeSSHCommand("docker volume create " + shellQuote(name)); err != nil {
+		return err
+	}
+	command := fmt.Sprintf("docker run --rm -i -v %s:/volume alpine tar -xzf - -C /volume", shellQuote(name))
+	return dm.streamSSHCommand(ctx, command, archive, io.Discard)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func volumesHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		volumes, err := manager.GetVolumes()
+		if err != nil {
+			manager.logger.Error("failed to get volumes", "error", err)
+			writeFailure(w, err)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"success": true,
+			"volumes": volumes,
+			"count":   len(volumes),
+		})
+	case "POST":
+		var req VolumeCreateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, codeInvalidRequest, "Invalid JSON format")
+			return
+		}
+		name, err := manager.CreateVolume(req)
+		recordAudit(r, manager.config.ID(), "volume.create", req.Name, err)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		manager.logger.Info("volume created", "volume", name)
+		writeJSON(w, map[string]interface{}{
+			"success": true,
+			"name":    name,
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func volumeInspectHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	details, err := manager.InspectVolume(mux.Vars(r)["name"])
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"volume":  details,
+	})
+}
+
+func volumeRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	if dryRunRequested(r) {
+		if _, err := manager.InspectVolume(name); err != nil {
+			writeFailure(w, err)
+			return
+		}
+		// docker refuses to remove a volume that is still mounted.
+		users := manager.volumeUsers()[name]
+		if users == nil {
+			users = []string{}
+		}
+		writeDryRun(w, []string{removeVolumeCommand(name)}, map[string]interface{}{
+			"resources": []DryRunResource{{Type: "volume", Name: name}},
+			"in_use_by": users,
+		})
+		return
+	}
+
+	if !confirmOperation(w, r, manager, requestOperation(r), func() (string, map[string]interface{}, error) {
+		if _, err := manager.InspectVolume(name); err != nil {
+			return "", nil, err
+		}
+		return "Remove volume " + name + " and all of its data", nil, nil
+	}) {
+		return
+	}
+
+	err := manager.RemoveVolume(name)
+	recordAudit(r, manager.config.ID(), "volume.remove", name, err)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	manager.logger.Info("volume removed", "volume", name)
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"message": "Volume removed successfully",
+	})
+}
+
+func volumeBackupHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	if _, err := manager.InspectVolume(name); err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	filename := fmt.Sprintf("%s-%s.tar.gz", name, time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	manager.logger.Info("backing up volume", "volume", name)
+	if err := manager.BackupVolume(r.Context(), name, w); err != nil {
+		manager.logger.Error("volume backup failed", "volume", name, "error", err)
+		return
+	}
+	manager.logger.Info("volume backed up", "volume", name)
+}
+
+func volumeRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	archive, err := uploadReader(r)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	if dryRunRequested(r) {
+		preview, err := manager.PreviewVolumeRestore(r.Context(), name, archive)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"success":     true,
+			"dry_run":     true,
+			"overwritten": preview.Overwritten,
+			"added":       preview.Added,
+		})
+		return
+	}
+
+	manager.logger.Info("restoring volume", "volume", name)
+	err = manager.RestoreVolume(r.Context(), name, archive)
+	recordAudit(r, manager.config.ID(), "volume.restore", name, err)
+	if err != nil {
+		manager.logger.Error("volume restore failed", "volume", name, "error", err)
+		writeFailure(w, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"message": "Volume restored successfully",
+	})
+}
+
+func volumePruneHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	if dryRunRequested(r) {
+		preview, err := manager.PrunePreview("volume", PruneOptions{})
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		writeDryRun(w, []string{pruneTargets["volume"]}, map[string]interface{}{
+			"preview": preview,
+		})
+		return
+	}
+
+	if !confirmOperation(w, r, manager, requestOperation(r), func() (string, map[string]interface{}, error) {
+		preview, err := manager.PrunePreview("volume", PruneOptions{})
+		if err != nil {
+			return "", nil, err
+		}
+		return pruneSummary(preview), map[string]interface{}{"preview": preview}, nil
+	}) {
+		return
+	}
+
+	if asyncRequested(r) {
+		job, err := submitJob(r, manager, "volume.prune", "", false, func(ctx context.Context, run *jobRun) (interface{}, error) {
+			output, err := manager.PruneVolumes()
+			if output != "" {
+				run.Log(output)
+			}
+			return nil, err
+		})
+		writeJobResponse(w, job, err)
+		return
+	}
+
+	output, err := manager.PruneVolumes()
+	recordAudit(r, manager.config.ID(), "volume.prune", "", err)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	manager.logger.Info("volumes pruned", "output", output)
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"message": output,
+	})
+}
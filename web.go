@@ -0,0 +1,99 @@
+package main
+
+import (
+	"embed"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+)
+
+// webFS holds the web interface: page templates with a shared layout and
+// partials under web/templates, the stylesheet and script under web/static,
+// and the language packs under web/locales.
+//
+//go:embed web
+var webFS embed.FS
+
+// Page is a page of the web interface, linked from the navigation. Path is
+// relative to the <base> element.
+type Page struct {
+	Name  string
+	Title string
+	Path  string
+}
+
+var pages = []Page{
+	{Name: "containers", Title: "Containers", Path: "./"},
+	{Name: "images", Title: "Images", Path: "images"},
+	{Name: "volumes", Title: "Volumes", Path: "volumes"},
+	{Name: "stacks", Title: "Stacks", Path: "stacks"},
+	{Name: "services", Title: "Services", Path: "services"},
+	{Name: "settings", Title: "Settings", Path: "settings"},
+}
+
+// PageData is what the layout and the pages render. Server is blank when no
+// server is configured or the user may not access it.
+type PageData struct {
+	Page      string
+	Pages     []Page
+	Server    *ServerConfig
+	Lang      string
+	Languages []Language
+}
+
+// pageTemplates holds each page parsed together with the layout and the
+// partials, so that every page can define its own "title" and "content",
+// once per language for "t" to translate into.
+var pageTemplates = parsePages()
+
+func parsePages() map[string]map[string]*template.Template {
+	parsed := map[string]map[string]*template.Template{}
+	for _, language := range languages {
+		parsed[language.Code] = map[string]*template.Template{}
+		for _, page := range pages {
+			parsed[language.Code][page.Name] = template.Must(template.New(page.Name).Funcs(templateFuncs).Funcs(translator(language.Code)).ParseFS(webFS,
+				"web/templates/layout.html",
+				"web/templates/partials/*.html",
+				"web/templates/pages/"+page.Name+".html"))
+		}
+	}
+	return parsed
+}
+
+// pagePath reports whether path, after the base path is stripped, is one
+// of the pages, which send anonymous visitors to the login page.
+func pagePath(path string) bool {
+	for _, page := range pages {
+		if path == "/"+page.Path || (page.Path == "./" && path == "/") {
+			return true
+		}
+	}
+	return false
+}
+
+func pageHandler(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		language := languageFrom(r.Context())
+		data := PageData{Page: name, Pages: pages, Server: &ServerConfig{}, Lang: language, Languages: languages}
+		if current, err := selectedManager(r); err == nil {
+			if user := userFrom(r.Context()); user == nil || user.CanAccessServer(current.config.ID()) {
+				data.Server = current.config
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := pageTemplates[language][name].ExecuteTemplate(w, "layout", data); err != nil {
+			slog.Error("failed to render page", "page", name, "error", err)
+		}
+	}
+}
+
+// staticHandler serves web/static under /static/.
+func staticHandler() http.Handler {
+	static, err := fs.Sub(webFS, "web/static")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/static/", http.FileServer(http.FS(static)))
+}
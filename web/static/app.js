@@ -0,0 +1,1474 @@
+function saveConfig() {
+    const config = {
+        host: document.getElementById('host').value,
+        port: document.getElementById('port').value,
+        username: document.getElementById('username').value,
+        password: document.getElementById('password').value
+    };
+
+    fetch('api/config', {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify(config)
+    })
+    .then(response => response.json())
+    .then(data => {
+        if (data.success) {
+            // The connected server is shown on every page; start over on
+            // its containers.
+            window.location = './';
+        } else {
+            showMessage('Error: ' + data.error, 'error');
+        }
+    })
+    .catch(err => showMessage('Connection failed: ' + err, 'error'));
+}
+
+function refreshServerInfo() {
+    fetch('api/servers/current/info')
+    .then(response => response.json())
+    .then(data => {
+        if (!data.success) {
+            return;
+        }
+        const info = data.info;
+        document.getElementById('serverDetails').textContent =
+            ' · Docker ' + info.docker.server_version +
+            ' · ' + info.host.operating_system +
+            ' · kernel ' + info.host.kernel_version +
+            ' · ' + info.host.cpus + ' CPUs' +
+            ' · ' + (info.host.memory_total / 1073741824).toFixed(1) + ' GB RAM';
+    })
+    .catch(() => {});
+}
+
+// With more than one server configured, the header offers to switch the
+// server this session works on. Until one is picked, the session acts on
+// none of them.
+function loadServers() {
+    fetch('api/servers')
+    .then(response => response.json())
+    .then(data => {
+        if (!data.success || data.servers.length < 2) {
+            return;
+        }
+        const select = document.getElementById('serverSelect');
+        const placeholder = data.servers.some(server => server.current) ? '' :
+            `<option value="" selected disabled>${escapeHTML(select.dataset.placeholder)}</option>`;
+        select.innerHTML = placeholder + data.servers.map(server =>
+            `<option value="${escapeHTML(server.id)}"${server.current ? ' selected' : ''}>${escapeHTML(server.id)}</option>`
+        ).join('');
+        select.style.display = '';
+    })
+    .catch(() => {});
+}
+
+function selectServer(id) {
+    fetch('api/servers/' + encodeURIComponent(id) + '/select', {method: 'POST'})
+    .then(response => response.json())
+    .then(data => {
+        if (data.success) {
+            window.location = './';
+        } else {
+            showMessage('Error: ' + data.error, 'error');
+        }
+    })
+    .catch(err => showMessage('Switching server failed: ' + err, 'error'));
+}
+
+function meter(percent) {
+    return '<div class="meter"><span class="' + (percent > 85 ? 'high' : '') + '" style="width: ' + Math.min(percent, 100) + '%"></span></div>';
+}
+
+function refreshHostMetrics() {
+    fetch('api/servers/current/host-metrics')
+    .then(response => response.json())
+    .then(data => {
+        const panel = document.getElementById('hostHealth');
+        if (!data.success) {
+            panel.style.display = 'none';
+            return;
+        }
+        const m = data.metrics;
+        const memPercent = m.memory.total_mb ? 100 * m.memory.used_mb / m.memory.total_mb : 0;
+        let html =
+            '<div><strong>Uptime</strong><br>' + (m.uptime_seconds / 86400).toFixed(1) + ' days</div>' +
+            '<div><strong>Load</strong><br>' + m.load1.toFixed(2) + ' / ' + m.load5.toFixed(2) + ' / ' + m.load15.toFixed(2) + '</div>' +
+            '<div><strong>CPU</strong><br>' + m.cpu_percent.toFixed(1) + '%' + meter(m.cpu_percent) + '</div>' +
+            '<div><strong>Memory</strong><br>' + m.memory.used_mb + ' / ' + m.memory.total_mb + ' MB' + meter(memPercent) + '</div>';
+        m.disks.forEach(disk => {
+            html += '<div><strong>Disk ' + disk.mountpoint + '</strong><br>' + disk.used_percent + '%' + meter(disk.used_percent) + '</div>';
+        });
+        panel.innerHTML = html;
+        panel.style.display = 'flex';
+    })
+    .catch(() => {});
+}
+
+function refreshContainers() {
+    document.getElementById('loading').style.display = 'block';
+    document.getElementById('containersTable').style.display = 'none';
+
+    const params = new URLSearchParams();
+    const label = document.getElementById('labelFilter').value.trim();
+    if (label) {
+        params.set('label', label);
+    }
+    if (document.getElementById('showSizes').checked) {
+        params.set('size', 'true');
+    }
+    fetch('api/containers?' + params.toString())
+    .then(response => response.json())
+    .then(data => {
+        document.getElementById('loading').style.display = 'none';
+        document.getElementById('containersTable').style.display = 'table';
+        
+        if (data.success) {
+            updateContainersTable(data.containers || []);
+        } else {
+            showMessage('Error: ' + (data.error || 'Unknown error'), 'error');
+            updateContainersTable([]);
+        }
+    })
+    .catch(err => {
+        document.getElementById('loading').style.display = 'none';
+        document.getElementById('containersTable').style.display = 'table';
+        showMessage('Failed to fetch containers: ' + err.message, 'error');
+        updateContainersTable([]);
+    });
+}
+
+// liveContainers holds the rows on the containers page by ID, so pushed
+// changes can be applied to the table in place.
+let liveContainers = {};
+
+function updateContainersTable(containers) {
+    const tbody = document.getElementById('containersBody');
+    tbody.innerHTML = '';
+    liveContainers = {};
+
+    if (!containers || !Array.isArray(containers)) {
+        tbody.innerHTML = '<tr><td colspan="10">No containers found</td></tr>';
+        return;
+    }
+
+    containers.forEach(container => {
+        liveContainers[container.id] = container;
+        tbody.appendChild(containerRow(container));
+    });
+}
+
+function containerRow(container) {
+    const row = document.createElement('tr');
+    row.dataset.id = container.id;
+    row.innerHTML = 
+        '<td><input type="checkbox" class="containerSelect" value="' + container.id + '"></td>' +
+        '<td>' + container.id + '</td>' +
+        '<td title="' + escapeHTML(Object.entries(container.labels || {}).map(([k, v]) => k + '=' + v).join('\n')) + '">' + (container.protected ? '🔒 ' : '') + container.name +
+            (container.labels && container.labels['com.docker.compose.project']
+                ? '<br><small title="Show project logs" onclick="showProjectLogs(\'' + escapeHTML(container.labels['com.docker.compose.project']) + '\')">📦 ' +
+                    escapeHTML(container.labels['com.docker.compose.project']) + '</small>'
+                : '') + '</td>' +
+        '<td>' + container.image + '</td>' +
+        '<td class="' + container.state + '">' + container.status +
+            (container.crash_looping
+                ? '<br><small class="crash-looping" title="Died ' + container.recent_restarts + ' times recently, restarted ' + container.restart_count + ' times by docker">🔁 crash-looping</small>'
+                : '') +
+            (container.oom_killed ? '<br><small class="oom-killed" title="Last exit was an out-of-memory kill">💥 OOM killed</small>' : '') + '</td>' +
+        (container.health
+            ? '<td class="' + container.health + '" title="Show last healthcheck output" onclick="showHealth(\'' + container.id + '\')">' + container.health + '</td>'
+            : '<td>-</td>') +
+        '<td title="' + escapeHTML(container.created) + '">' + escapeHTML(container.age) + '</td>' +
+        '<td>' + container.ports_display + '</td>' +
+        '<td>' + (container.size_display || '-') + '</td>' +
+        '<td>' +
+            '<button class="btn btn-success" onclick="containerAction(\'' + container.id + '\', \'start\')">▶️ Start</button>' +
+            '<button class="btn btn-warning" onclick="containerAction(\'' + container.id + '\', \'stop\')">⏸️ Stop</button>' +
+            '<button class="btn btn-primary" onclick="containerAction(\'' + container.id + '\', \'restart\')">🔄 Restart</button>' +
+            '<button class="btn btn-danger" onclick="containerAction(\'' + container.id + '\', \'remove\')">🗑️ Remove</button>' +
+            '<button class="btn btn-warning" onclick="killContainer(\'' + container.id + '\')">⚡ Signal</button>' +
+            '<button class="btn btn-primary" onclick="setProtected(\'' + container.id + '\', ' + !container.protected + ')">' + (container.protected ? '🔓 Unprotect' : '🔒 Protect') + '</button>' +
+            '<button class="btn btn-warning" onclick="redeployContainer(\'' + container.id + '\', \'' + container.name + '\')">🚀 Redeploy</button>' +
+            '<button class="btn btn-primary" onclick="cloneContainer(\'' + container.id + '\', \'' + container.name + '\')">📋 Clone</button>' +
+            '<button class="btn btn-primary" onclick="window.open(\'api/containers/' + container.id + '/export?format=compose\')">📤 Export</button>' +
+            '<button class="btn btn-primary" onclick="showLimits(\'' + container.id + '\', \'' + container.name + '\')">⚙️ Limits</button>' +
+            '<button class="btn btn-primary" onclick="showInspect(\'' + container.id + '\', \'' + container.name + '\')">🔍 Inspect</button>' +
+            '<button class="btn btn-primary" onclick="showAttach(\'' + container.id + '\', \'' + container.name + '\')">🖥️ Attach</button>' +
+            '<button class="btn btn-primary" onclick="showFiles(\'' + container.id + '\', \'' + container.name + '\')">📁 Files</button>' +
+            '<button class="btn btn-primary" onclick="showLogs(\'' + container.id + '\', \'' + container.name + '\')">📜 Logs</button>' +
+            '<button class="btn btn-primary" onclick="showStats(\'' + container.id + '\', \'' + container.name + '\')">📈 Stats</button>' +
+        '</td>';
+    return row;
+}
+
+// confirmedFetch sends a request that the server may answer with a
+// confirmation token; the user is shown its summary and the request is
+// repeated with the token if they agree.
+function confirmedFetch(url, options) {
+    return fetch(url, options)
+    .then(response => response.json())
+    .then(data => {
+        if (!data.confirmation_required) {
+            return data;
+        }
+        if (!confirm(data.summary + '\n\nContinue?')) {
+            return {success: false, error: 'Cancelled'};
+        }
+        return fetch(url + (url.includes('?') ? '&' : '?') + 'confirm_token=' + encodeURIComponent(data.confirm_token), options)
+        .then(response => response.json());
+    });
+}
+
+function containerAction(containerID, action, query) {
+    confirmedFetch('api/container/' + containerID + '/' + action + (query || ''), {
+        method: 'POST'
+    })
+    .then(data => {
+        if (data.success) {
+            showMessage('Action completed successfully!', 'success');
+            refreshContainers();
+        } else if (data.protected && confirm('This container is protected. ' + action + ' it anyway?')) {
+            containerAction(containerID, action, '?override=true');
+        } else {
+            showMessage('Error: ' + data.error, 'error');
+        }
+    })
+    .catch(err => showMessage('Action failed: ' + err, 'error'));
+}
+
+function setProtected(containerID, protect) {
+    const reason = protect ? prompt('Why is this container protected? (optional)', '') : '';
+    if (reason === null) {
+        return;
+    }
+    fetch('api/containers/' + containerID + '/protection', {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify({protected: protect, reason: reason})
+    })
+    .then(response => response.json())
+    .then(data => {
+        if (!data.success) {
+            showMessage('Error: ' + data.error, 'error');
+            return;
+        }
+        if (!protect && data.protection.label) {
+            showMessage('Container is still protected by its rdm.protect label', 'error');
+        } else {
+            showMessage(protect ? 'Container protected' : 'Container unprotected', 'success');
+        }
+        refreshContainers();
+    })
+    .catch(err => showMessage('Failed to change protection: ' + err, 'error'));
+}
+
+function bulkAction(action) {
+    const containers = Array.from(document.querySelectorAll('.containerSelect:checked')).map(box => box.value);
+    if (!containers.length) {
+        showMessage('Select one or more containers first', 'error');
+        return;
+    }
+    confirmedFetch('api/containers/bulk', {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify({action: action, containers: containers})
+    })
+    .then(data => {
+        if (!data.results) {
+            showMessage('Error: ' + data.error, 'error');
+            return;
+        }
+        if (data.failed) {
+            showMessage(data.succeeded + ' succeeded, ' + data.failed + ' failed: ' +
+                data.results.filter(r => !r.success).map(r => r.container + ' (' + r.error + ')').join(', '), 'error');
+        } else {
+            showMessage(action + ' completed for ' + data.succeeded + ' containers', 'success');
+        }
+        document.getElementById('selectAll').checked = false;
+        refreshContainers();
+    })
+    .catch(err => showMessage('Action failed: ' + err, 'error'));
+}
+
+function showHealth(containerID) {
+    fetch('api/containers/' + containerID + '/health')
+    .then(response => response.json())
+    .then(data => {
+        if (!data.success) {
+            showMessage('Error: ' + data.error, 'error');
+            return;
+        }
+        const log = data.health.log;
+        const last = log.length ? log[log.length - 1] : null;
+        alert('Health: ' + data.health.status + ' (failing streak ' + data.health.failing_streak + ')\n\n' +
+            (last ? 'Last check exited ' + last.exit_code + ':\n' + last.output : 'No checks recorded yet'));
+    });
+}
+
+function jsonNode(key, value, open) {
+    if (value !== null && typeof value === 'object') {
+        const entries = Array.isArray(value) ? value.map((item, i) => [i, item]) : Object.entries(value);
+        const details = document.createElement('details');
+        details.open = open;
+        const summary = document.createElement('summary');
+        const name = document.createElement('span');
+        name.className = 'json-key';
+        name.textContent = key;
+        summary.appendChild(name);
+        summary.appendChild(document.createTextNode(Array.isArray(value) ? ' [' + entries.length + ']' : ' {' + entries.length + '}'));
+        details.appendChild(summary);
+        entries.forEach(([childKey, child]) => details.appendChild(jsonNode(childKey, child, false)));
+        return details;
+    }
+    const leaf = document.createElement('div');
+    leaf.className = 'json-leaf';
+    const name = document.createElement('span');
+    name.className = 'json-key';
+    name.textContent = key + ': ';
+    leaf.appendChild(name);
+    leaf.appendChild(document.createTextNode(JSON.stringify(value)));
+    return leaf;
+}
+
+function showInspect(containerID, name) {
+    fetch('api/containers/' + containerID + '/inspect')
+    .then(response => response.json())
+    .then(data => {
+        if (!data.success) {
+            showMessage('Error: ' + data.error, 'error');
+            return;
+        }
+        const tree = document.getElementById('inspectTree');
+        tree.innerHTML = '';
+        tree.appendChild(jsonNode(name, data.container, true));
+        document.getElementById('inspectTitle').textContent = '🔍 ' + name;
+        document.getElementById('inspectPanel').style.display = 'block';
+    })
+    .catch(err => showMessage('Inspect failed: ' + err, 'error'));
+}
+
+function escapeHTML(value) {
+    return String(value).replace(/[&<>"']/g, c => ({'&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;'})[c]);
+}
+
+function killContainer(containerID) {
+    const signal = prompt('Signal to send (e.g. SIGHUP, SIGTERM, SIGKILL):', 'SIGHUP');
+    if (signal) {
+        containerAction(containerID, 'kill', '?signal=' + encodeURIComponent(signal));
+    }
+}
+
+let attachSocket = null;
+
+function showAttach(containerID, name) {
+    hideAttach();
+    const output = document.getElementById('attachOutput');
+    const decoder = new TextDecoder();
+    output.textContent = '';
+    document.getElementById('attachTitle').textContent = '🖥️ ' + name + ' (detach leaves the container running)';
+    document.getElementById('attachPanel').style.display = 'block';
+
+    const protocol = location.protocol === 'https:' ? 'wss://' : 'ws://';
+    attachSocket = new WebSocket(protocol + location.host + basePath + '/api/containers/' + containerID + '/attach/ws');
+    attachSocket.binaryType = 'arraybuffer';
+    attachSocket.onmessage = event => {
+        output.textContent += decoder.decode(new Uint8Array(event.data), {stream: true});
+        output.scrollTop = output.scrollHeight;
+    };
+    attachSocket.onclose = () => {
+        output.textContent += '\n[detached]\n';
+    };
+}
+
+function sendAttachInput() {
+    const input = document.getElementById('attachInput');
+    if (attachSocket && attachSocket.readyState === WebSocket.OPEN) {
+        attachSocket.send(input.value + '\n');
+    }
+    input.value = '';
+}
+
+function hideAttach() {
+    if (attachSocket) {
+        attachSocket.close();
+        attachSocket = null;
+    }
+    document.getElementById('attachPanel').style.display = 'none';
+}
+
+let filesContainer = null;
+
+function showFiles(containerID, name) {
+    filesContainer = containerID;
+    document.getElementById('filesTitle').textContent = '📁 ' + name;
+    document.getElementById('filesPanel').style.display = 'block';
+    listFiles('/');
+}
+
+function joinPath(dir, name) {
+    return (dir.endsWith('/') ? dir : dir + '/') + name;
+}
+
+function listFiles(dir) {
+    fetch('api/containers/' + filesContainer + '/files?path=' + encodeURIComponent(dir))
+    .then(response => response.json())
+    .then(data => {
+        if (!data.success) {
+            showMessage('Error: ' + data.error, 'error');
+            return;
+        }
+        document.getElementById('filesPath').value = data.path;
+        const tbody = document.getElementById('filesBody');
+        tbody.innerHTML = '';
+        if (data.path !== '/') {
+            const up = document.createElement('tr');
+            up.innerHTML = '<td colspan="6"><a href="#">⬆️ ..</a></td>';
+            up.querySelector('a').onclick = () => { listFiles(data.path.replace(/\/[^\/]+$/, '') || '/'); return false; };
+            tbody.appendChild(up);
+        }
+        data.entries.forEach(entry => {
+            const full = joinPath(data.path, entry.name);
+            const row = document.createElement('tr');
+            row.innerHTML =
+                '<td>' + (entry.type === 'dir' ? '📁 <a href="#"></a>' : (entry.type === 'link' ? '🔗 ' : '📄 ') + '<span></span>') + '</td>' +
+                '<td>' + escapeHTML(entry.mode) + '</td>' +
+                '<td>' + escapeHTML(entry.owner + ':' + entry.group) + '</td>' +
+                '<td>' + entry.size + '</td>' +
+                '<td>' + escapeHTML(entry.modified) + '</td>' +
+                '<td><a href="api/containers/' + filesContainer + '/files/download?path=' + encodeURIComponent(full) + '">⬇️ Download</a></td>';
+            const label = entry.name + (entry.link_target ? ' → ' + entry.link_target : '');
+            if (entry.type === 'dir') {
+                const link = row.querySelector('td a');
+                link.textContent = label;
+                link.onclick = () => { listFiles(full); return false; };
+            } else {
+                row.querySelector('td span').textContent = label;
+            }
+            tbody.appendChild(row);
+        });
+    })
+    .catch(err => showMessage('Listing failed: ' + err, 'error'));
+}
+
+function uploadFile(overwrite) {
+    const file = document.getElementById('filesUpload').files[0];
+    if (!file) {
+        showMessage('Choose a file to upload', 'error');
+        return;
+    }
+    const params = new URLSearchParams({path: document.getElementById('filesPath').value});
+    if (document.getElementById('filesExtract').checked) {
+        params.set('extract', 'true');
+    }
+    if (overwrite) {
+        params.set('overwrite', 'true');
+    }
+    const form = new FormData();
+    form.append('file', file);
+
+    fetch('api/containers/' + filesContainer + '/files/upload?' + params.toString(), {method: 'POST', body: form})
+    .then(response => response.json())
+    .then(data => {
+        if (data.success) {
+            showMessage(data.message, 'success');
+            listFiles(document.getElementById('filesPath').value);
+        } else if (data.confirm && confirm(data.error.split(';')[0] + '. Overwrite?')) {
+            uploadFile(true);
+        } else {
+            showMessage('Error: ' + data.error, 'error');
+        }
+    })
+    .catch(err => showMessage('Upload failed: ' + err, 'error'));
+}
+
+let logsSource = null;
+let logsTarget = null;
+
+function showLogs(containerID, name) {
+    logsTarget = {path: 'api/logs/' + containerID, params: {}, name: name, download: true};
+    followLogs();
+}
+
+function showProjectLogs(project) {
+    logsTarget = {path: 'api/logs', params: {project: project}, name: project, download: false};
+    followLogs();
+}
+
+function followLogs() {
+    hideLogs();
+    const grep = document.getElementById('logsGrep').value;
+    const params = new URLSearchParams(logsTarget.params);
+    if (grep) {
+        params.set('grep', grep);
+    }
+    const output = document.getElementById('logsOutput');
+    output.textContent = '';
+    document.getElementById('logsTitle').textContent = '📜 ' + logsTarget.name;
+    const download = document.getElementById('logsDownload');
+    download.style.display = logsTarget.download ? '' : 'none';
+    download.href = logsTarget.path + '/download?' + params;
+    document.getElementById('logsPanel').style.display = 'block';
+
+    params.set('follow', 'true');
+    params.set('ansi', 'html');
+    logsSource = new EventSource(logsTarget.path + '?' + params);
+    logsSource.addEventListener('log', event => {
+        const atBottom = output.scrollTop + output.clientHeight >= output.scrollHeight - 5;
+        const entry = JSON.parse(event.data);
+        const line = document.createElement('span');
+        line.style.color = entry.stream === 'stderr' ? '#ff6b6b' : '#eee';
+        line.innerHTML = escapeHTML((entry.timestamp ? new Date(entry.timestamp).toLocaleTimeString() + ' ' : '') +
+            (entry.container ? entry.container + ' | ' : '')) + entry.message + '\n';
+        output.appendChild(line);
+        if (atBottom) {
+            output.scrollTop = output.scrollHeight;
+        }
+    });
+    logsSource.addEventListener('error', event => {
+        if (event.data) {
+            output.textContent += '[' + JSON.parse(event.data).error + ']\n';
+        }
+        logsSource.close();
+    });
+}
+
+function hideLogs() {
+    if (logsSource) {
+        logsSource.close();
+        logsSource = null;
+    }
+    document.getElementById('logsPanel').style.display = 'none';
+}
+
+let statsSocket = null;
+let statsSamples = [];
+
+function showStats(containerID, name) {
+    hideStats();
+    statsSamples = [];
+    document.getElementById('statsTitle').textContent = '📈 ' + name;
+    document.getElementById('statsPanel').style.display = 'block';
+
+    const protocol = location.protocol === 'https:' ? 'wss://' : 'ws://';
+    statsSocket = new WebSocket(protocol + location.host + basePath + '/api/containers/' + containerID + '/stats/ws');
+    statsSocket.onmessage = event => {
+        const stats = JSON.parse(event.data);
+        if (stats.error) {
+            showMessage('Error: ' + stats.error, 'error');
+            return;
+        }
+        statsSamples.push(stats);
+        if (statsSamples.length > 60) {
+            statsSamples.shift();
+        }
+        drawStats();
+    };
+}
+
+function hideStats() {
+    if (statsSocket) {
+        statsSocket.close();
+        statsSocket = null;
+    }
+    document.getElementById('statsPanel').style.display = 'none';
+}
+
+function drawStats() {
+    const canvas = document.getElementById('statsChart');
+    const ctx = canvas.getContext('2d');
+    ctx.clearRect(0, 0, canvas.width, canvas.height);
+
+    const maxCPU = Math.max(100, ...statsSamples.map(s => s.cpu_percent));
+    const step = canvas.width / 59;
+    [['cpu_percent', '#2196F3', maxCPU], ['memory_percent', '#FF9800', 100]].forEach(([field, color, max]) => {
+        ctx.strokeStyle = color;
+        ctx.lineWidth = 2;
+        ctx.beginPath();
+        statsSamples.forEach((s, i) => {
+            const y = canvas.height - (s[field] / max) * canvas.height;
+            i === 0 ? ctx.moveTo(i * step, y) : ctx.lineTo(i * step, y);
+        });
+        ctx.stroke();
+    });
+
+    const last = statsSamples[statsSamples.length - 1];
+    document.getElementById('statsSummary').innerHTML =
+        '<span style="color: #2196F3">CPU ' + last.cpu_percent.toFixed(2) + '%</span> · ' +
+        '<span style="color: #FF9800">Memory ' + (last.memory_usage / 1048576).toFixed(1) + ' MB (' + last.memory_percent.toFixed(1) + '%)</span> · ' +
+        'Net ' + (last.network_rx / 1024).toFixed(0) + ' kB in / ' + (last.network_tx / 1024).toFixed(0) + ' kB out · ' +
+        'PIDs ' + last.pids;
+}
+
+let limitsContainer = null;
+
+function formatLimit(bytes) {
+    if (bytes === -1) {
+        return 'unlimited';
+    }
+    return bytes > 0 ? (bytes / 1048576).toFixed(0) + ' MB' : 'unlimited';
+}
+
+function showLimits(containerID, name) {
+    limitsContainer = containerID;
+    document.getElementById('limitsTitle').textContent = '⚙️ Limits for ' + name;
+    loadLimits();
+    document.getElementById('limitsForm').style.display = 'block';
+}
+
+function hideLimits() {
+    document.getElementById('limitsForm').style.display = 'none';
+    limitsContainer = null;
+}
+
+function renderLimits(limits) {
+    document.getElementById('limitsCurrent').textContent =
+        'Current: CPUs ' + (limits.cpus > 0 ? limits.cpus : 'unlimited') +
+        ' · Memory ' + formatLimit(limits.memory) +
+        ' · Memory + swap ' + formatLimit(limits.memory_swap);
+}
+
+function renderRestartPolicy(policy) {
+    document.getElementById('limitsRestartCurrent').textContent = policy;
+    document.getElementById('limitsRestart').value = policy.split(':')[0];
+}
+
+function updateRestartPolicy() {
+    fetch('api/containers/' + limitsContainer + '/restart-policy', {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify({policy: document.getElementById('limitsRestart').value})
+    })
+    .then(response => response.json())
+    .then(data => {
+        if (data.success) {
+            renderRestartPolicy(data.policy);
+            showMessage('Restart policy set to ' + data.policy, 'success');
+        } else {
+            showMessage('Error: ' + data.error, 'error');
+        }
+    })
+    .catch(err => showMessage('Update failed: ' + err, 'error'));
+}
+
+function loadLimits() {
+    fetch('api/containers/' + limitsContainer + '/restart-policy')
+    .then(response => response.json())
+    .then(data => {
+        if (data.success) {
+            renderRestartPolicy(data.policy);
+        }
+    });
+
+    fetch('api/containers/' + limitsContainer + '/resources')
+    .then(response => response.json())
+    .then(data => {
+        if (data.success) {
+            renderLimits(data.limits);
+        } else {
+            showMessage('Error: ' + data.error, 'error');
+        }
+    });
+}
+
+function updateLimits() {
+    fetch('api/containers/' + limitsContainer + '/resources', {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify({
+            cpus: document.getElementById('limitsCPUs').value.trim(),
+            memory: document.getElementById('limitsMemory').value.trim(),
+            memory_swap: document.getElementById('limitsSwap').value.trim()
+        })
+    })
+    .then(response => response.json())
+    .then(data => {
+        if (data.success) {
+            renderLimits(data.limits);
+            showMessage('Limits updated', 'success');
+        } else {
+            showMessage('Error: ' + data.error, 'error');
+        }
+    })
+    .catch(err => showMessage('Update failed: ' + err, 'error'));
+}
+
+function toggleRunForm() {
+    const form = document.getElementById('runForm');
+    form.style.display = form.style.display === 'none' ? 'block' : 'none';
+}
+
+function splitList(value) {
+    return value.split(',').map(item => item.trim()).filter(item => item !== '');
+}
+
+function parsePairs(value) {
+    const pairs = {};
+    value.split('\n').forEach(line => {
+        const index = line.indexOf('=');
+        if (index > 0) {
+            pairs[line.slice(0, index).trim()] = line.slice(index + 1).trim();
+        }
+    });
+    return pairs;
+}
+
+function runContainer() {
+    const request = {
+        image: document.getElementById('runImage').value,
+        name: document.getElementById('runName').value,
+        ports: splitList(document.getElementById('runPorts').value),
+        env: parsePairs(document.getElementById('runEnv').value),
+        volumes: splitList(document.getElementById('runVolumes').value),
+        restart_policy: document.getElementById('runRestart').value,
+        network: document.getElementById('runNetwork').value,
+        labels: parsePairs(document.getElementById('runLabels').value)
+    };
+
+    fetch('api/servers/current/containers', {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify(request)
+    })
+    .then(response => response.json())
+    .then(data => {
+        if (data.success) {
+            showMessage('Container ' + data.id.substring(0, 12) + ' started!', 'success');
+            toggleRunForm();
+            refreshContainers();
+        } else {
+            showMessage('Error: ' + data.error, 'error');
+        }
+    })
+    .catch(err => showMessage('Container creation failed: ' + err, 'error'));
+}
+
+// Each page refreshes its own content; the server details and host
+// health in the header are on every page.
+const pageRefresh = {
+    containers: refreshContainers,
+    images: refreshImages,
+    volumes: refreshVolumes,
+    stacks: () => {
+        if (!templates) {
+            loadTemplates();
+        }
+        refreshStacks();
+    },
+    services: refreshServices,
+    settings: () => {}
+};
+
+function refreshPage() {
+    (pageRefresh[document.body.dataset.page] || pageRefresh.settings)();
+}
+
+// readEventStream parses a Server-Sent Events response body, for
+// streams that are not opened with a GET and so can't use EventSource.
+function readEventStream(response, onEvent) {
+    const reader = response.body.getReader();
+    const decoder = new TextDecoder();
+    let buffer = '';
+    function read() {
+        return reader.read().then(({done, value}) => {
+            if (done) {
+                return;
+            }
+            buffer += decoder.decode(value, {stream: true});
+            let end;
+            while ((end = buffer.indexOf('\n\n')) >= 0) {
+                let event = 'message';
+                let data = '';
+                buffer.slice(0, end).split('\n').forEach(line => {
+                    if (line.startsWith('event: ')) {
+                        event = line.slice(7);
+                    } else if (line.startsWith('data: ')) {
+                        data += line.slice(6);
+                    }
+                });
+                buffer = buffer.slice(end + 2);
+                onEvent(event, data ? JSON.parse(data) : null);
+            }
+            return read();
+        });
+    }
+    return read();
+}
+
+let templates = null;
+
+function loadTemplates() {
+    fetch('api/templates')
+    .then(response => response.json())
+    .then(data => {
+        templates = data.templates || [];
+        document.getElementById('templateSelect').innerHTML = templates.map(t =>
+            '<option value="' + escapeHTML(t.name) + '">' + escapeHTML(t.title) + ' (' + escapeHTML(t.category) + ')</option>'
+        ).join('');
+        showTemplateParams();
+    })
+    .catch(err => showMessage('Failed to fetch templates: ' + err.message, 'error'));
+}
+
+function selectedTemplate() {
+    const name = document.getElementById('templateSelect').value;
+    return (templates || []).find(t => t.name === name);
+}
+
+function showTemplateParams() {
+    const template = selectedTemplate();
+    if (!template) {
+        return;
+    }
+    document.getElementById('templateName').value = template.name;
+    document.getElementById('templateParams').innerHTML = (template.parameters || []).map(p =>
+        '<input type="text" data-param="' + escapeHTML(p.name) + '" title="' + escapeHTML(p.description || p.label) + '"' +
+        ' placeholder="' + escapeHTML(p.label + (p.generate ? ' (generated)' : '')) + '" value="' + escapeHTML(p.default || '') + '">'
+    ).join('');
+}
+
+function deployTemplate() {
+    const template = selectedTemplate();
+    if (!template) {
+        return;
+    }
+    const parameters = {};
+    document.querySelectorAll('#templateParams input').forEach(input => {
+        parameters[input.dataset.param] = input.value;
+    });
+
+    const output = document.getElementById('stackOutput');
+    output.textContent = 'Deploying ' + template.title + '...';
+    output.style.display = 'block';
+    fetch('api/servers/current/templates/' + encodeURIComponent(template.name) + '/deploy', {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify({name: document.getElementById('templateName').value.trim(), parameters: parameters})
+    })
+    .then(response => response.json())
+    .then(data => {
+        output.textContent = (data.output ? data.output + '\n' : '') +
+            (data.parameters ? Object.entries(data.parameters).map(([k, v]) => k + ' = ' + v).join('\n') : '');
+        if (data.success) {
+            showMessage(template.title + ' deployed as ' + data.name, 'success');
+            refreshStacks();
+        } else {
+            showMessage('Error: ' + data.error, 'error');
+        }
+    })
+    .catch(err => showMessage('Template deploy failed: ' + err, 'error'));
+}
+
+function refreshStacks() {
+    fetch('api/compose')
+    .then(response => response.json())
+    .then(data => {
+        const tbody = document.getElementById('stacksBody');
+        tbody.innerHTML = '';
+        if (!data.success) {
+            showMessage('Error: ' + (data.error || 'Unknown error'), 'error');
+            return;
+        }
+        if (data.projects.length === 0) {
+            tbody.innerHTML = '<tr><td colspan="5">No compose projects found</td></tr>';
+            return;
+        }
+        data.projects.forEach(project => {
+            const row = document.createElement('tr');
+            row.innerHTML =
+                '<td>' + escapeHTML(project.name) + (project.managed ? ' <small>(managed)</small>' : '') + '</td>' +
+                '<td>' + escapeHTML(project.status) + '</td>' +
+                '<td>' + escapeHTML(project.services.join(', ') || '-') + '</td>' +
+                '<td>' + escapeHTML(project.working_dir || '-') + '</td>' +
+                '<td>' +
+                    '<button class="btn btn-primary" onclick="composePs(\'' + escapeHTML(project.name) + '\')">📋 Services</button>' +
+                    '<button class="btn btn-primary" onclick="showProjectLogs(\'' + escapeHTML(project.name) + '\')">📜 Logs</button>' +
+                    ['up', 'down', 'restart', 'pull'].map(action =>
+                        '<button class="btn btn-primary" onclick="composeAction(\'' + escapeHTML(project.name) + '\', \'' + action + '\')">' + action + '</button>'
+                    ).join('') +
+                '</td>';
+            tbody.appendChild(row);
+        });
+    })
+    .catch(err => showMessage('Failed to fetch compose projects: ' + err.message, 'error'));
+}
+
+function composePs(project) {
+    fetch('api/compose/' + encodeURIComponent(project) + '/ps')
+    .then(response => response.json())
+    .then(data => {
+        if (!data.success) {
+            showMessage('Error: ' + data.error, 'error');
+            return;
+        }
+        const output = document.getElementById('stackOutput');
+        output.textContent = data.services.map(service =>
+            service.name + ' (' + service.running + '/' + service.containers.length + ' running)\n' +
+            service.containers.map(c => '  ' + c.name + '  ' + c.status + (c.ports ? '  ' + c.ports : '')).join('\n')
+        ).join('\n') || 'No containers';
+        output.style.display = 'block';
+    })
+    .catch(err => showMessage('Failed to fetch services: ' + err, 'error'));
+}
+
+function composeAction(project, action) {
+    const output = document.getElementById('stackOutput');
+    output.textContent = '';
+    output.style.display = 'block';
+    confirmedFetch('api/compose/' + encodeURIComponent(project) + '/' + action, {method: 'POST'})
+    .then(data => {
+        output.textContent = data.output || '';
+        if (data.success) {
+            showMessage('Project ' + project + ': ' + action + ' completed', 'success');
+        } else {
+            showMessage('Error: ' + data.error, 'error');
+        }
+        refreshStacks();
+    })
+    .catch(err => showMessage('Action failed: ' + err, 'error'));
+}
+
+function refreshServices() {
+    fetch('api/services')
+    .then(response => response.json())
+    .then(data => {
+        const tbody = document.getElementById('servicesBody');
+        tbody.innerHTML = '';
+        if (!data.success) {
+            tbody.innerHTML = '<tr><td colspan="6">' + escapeHTML(data.error || 'Unknown error') + '</td></tr>';
+            return;
+        }
+        if (data.services.length === 0) {
+            tbody.innerHTML = '<tr><td colspan="6">No services found</td></tr>';
+            return;
+        }
+        data.services.forEach(service => {
+            const name = escapeHTML(service.name);
+            const replicated = service.mode.startsWith('replicated');
+            const row = document.createElement('tr');
+            row.innerHTML =
+                '<td>' + name + '</td>' +
+                '<td>' + escapeHTML(service.mode) + '</td>' +
+                '<td>' + escapeHTML(service.image) + '</td>' +
+                '<td>' + service.running_replicas + '/' + service.desired_replicas + '</td>' +
+                '<td>' + escapeHTML(service.update_status ? service.update_status.state : '-') + '</td>' +
+                '<td>' +
+                    (replicated ?
+                        '<input type="number" min="0" id="scale-' + name + '" value="' + service.desired_replicas + '" style="width: 60px;">' +
+                        '<button class="btn btn-primary" onclick="scaleService(\'' + name + '\')">Scale</button>' : '') +
+                    '<button class="btn btn-primary" onclick="serviceAction(\'' + name + '\', \'restart\')">restart</button>' +
+                '</td>';
+            tbody.appendChild(row);
+        });
+    })
+    .catch(err => showMessage('Failed to fetch services: ' + err.message, 'error'));
+}
+
+function scaleService(name) {
+    const replicas = parseInt(document.getElementById('scale-' + name).value, 10);
+    if (isNaN(replicas) || replicas < 0) {
+        showMessage('Enter a replica count', 'error');
+        return;
+    }
+    fetch('api/services/' + encodeURIComponent(name) + '/scale', {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify({replicas: replicas})
+    })
+    .then(response => response.json())
+    .then(data => {
+        if (data.success) {
+            showMessage('Scaling ' + name + ' from ' + data.previous_replicas + ' to ' + data.desired_replicas + ' replicas', 'success');
+            refreshServices();
+        } else {
+            showMessage('Error: ' + data.error, 'error');
+        }
+    })
+    .catch(err => showMessage('Scale failed: ' + err, 'error'));
+}
+
+function serviceAction(name, action) {
+    fetch('api/services/' + encodeURIComponent(name) + '/' + action, {method: 'POST'})
+    .then(response => response.json())
+    .then(data => {
+        if (data.success) {
+            showMessage(data.message, 'success');
+            refreshServices();
+        } else {
+            showMessage('Error: ' + data.error, 'error');
+        }
+    })
+    .catch(err => showMessage('Action failed: ' + err, 'error'));
+}
+
+function deployStack() {
+    const project = document.getElementById('stackProject').value.trim();
+    const file = document.getElementById('stackFile').files[0];
+    const body = file || document.getElementById('stackYaml').value;
+    if (!project || !body) {
+        showMessage('Project name and compose file are required', 'error');
+        return;
+    }
+
+    const output = document.getElementById('stackOutput');
+    output.textContent = '';
+    output.style.display = 'block';
+    fetch('api/compose/' + encodeURIComponent(project) + '/deploy?stream=true', {method: 'POST', body: body})
+    .then(response => {
+        if (!(response.headers.get('Content-Type') || '').startsWith('text/event-stream')) {
+            return response.json().then(data => showMessage('Error: ' + data.error, 'error'));
+        }
+        return readEventStream(response, (event, data) => {
+            if (event === 'output') {
+                output.textContent += data.line + '\n';
+            } else if (event === 'done') {
+                showMessage('Project ' + project + ' deployed', 'success');
+                refreshStacks();
+            } else if (event === 'error') {
+                showMessage('Deploy failed: ' + data.error, 'error');
+            }
+        });
+    })
+    .catch(err => showMessage('Deploy failed: ' + err, 'error'));
+}
+
+function refreshVolumes() {
+    fetch('api/volumes')
+    .then(response => response.json())
+    .then(data => {
+        if (data.success) {
+            updateVolumesTable(data.volumes || []);
+        } else {
+            showMessage('Error: ' + (data.error || 'Unknown error'), 'error');
+            updateVolumesTable([]);
+        }
+    })
+    .catch(err => showMessage('Failed to fetch volumes: ' + err.message, 'error'));
+}
+
+function updateVolumesTable(volumes) {
+    const tbody = document.getElementById('volumesBody');
+    tbody.innerHTML = '';
+
+    if (volumes.length === 0) {
+        tbody.innerHTML = '<tr><td colspan="6">No volumes found</td></tr>';
+        return;
+    }
+
+    volumes.forEach(volume => {
+        const row = document.createElement('tr');
+        row.innerHTML =
+            '<td>' + volume.name + '</td>' +
+            '<td>' + volume.driver + '</td>' +
+            '<td>' + volume.mountpoint + '</td>' +
+            '<td>' + (volume.size || '-') + '</td>' +
+            '<td>' + (volume.containers.join(', ') || '-') + '</td>' +
+            '<td>' +
+                '<button class="btn btn-primary" onclick="inspectVolume(\'' + volume.name + '\')">🔍 Inspect</button>' +
+                '<button class="btn btn-success" onclick="backupVolume(\'' + volume.name + '\')">💾 Backup</button>' +
+                '<button class="btn btn-danger" onclick="removeVolume(\'' + volume.name + '\')">🗑️ Remove</button>' +
+            '</td>';
+        tbody.appendChild(row);
+    });
+}
+
+function createVolume() {
+    const volume = {
+        name: document.getElementById('volumeName').value,
+        driver: document.getElementById('volumeDriver').value
+    };
+
+    fetch('api/volumes', {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify(volume)
+    })
+    .then(response => response.json())
+    .then(data => {
+        if (data.success) {
+            showMessage('Volume ' + data.name + ' created!', 'success');
+            refreshVolumes();
+        } else {
+            showMessage('Error: ' + data.error, 'error');
+        }
+    })
+    .catch(err => showMessage('Volume creation failed: ' + err, 'error'));
+}
+
+function inspectVolume(name) {
+    fetch('api/volumes/' + encodeURIComponent(name) + '/inspect')
+    .then(response => response.json())
+    .then(data => {
+        const details = document.getElementById('volumeDetails');
+        if (data.success) {
+            details.textContent = JSON.stringify(data.volume, null, 2);
+            details.style.display = 'block';
+        } else {
+            showMessage('Error: ' + data.error, 'error');
+        }
+    })
+    .catch(err => showMessage('Inspect failed: ' + err, 'error'));
+}
+
+function backupVolume(name) {
+    window.location = 'api/volumes/' + encodeURIComponent(name) + '/backup';
+}
+
+function removeVolume(name) {
+    volumeRequest('api/volumes/' + encodeURIComponent(name) + '/remove');
+}
+
+function pruneVolumes() {
+    volumeRequest('api/volumes/prune');
+}
+
+function volumeRequest(url) {
+    confirmedFetch(url, {method: 'POST'})
+    .then(data => {
+        if (data.success) {
+            showMessage(data.message || 'Action completed successfully!', 'success');
+            refreshVolumes();
+        } else {
+            showMessage('Error: ' + data.error, 'error');
+        }
+    })
+    .catch(err => showMessage('Action failed: ' + err, 'error'));
+}
+
+function redeployContainer(containerID, name, query = '') {
+    if (!query && !confirm('Pull the latest image and recreate ' + name + '?')) {
+        return;
+    }
+    showMessage('Redeploying ' + name + '...', 'success');
+
+    fetch('api/containers/' + containerID + '/redeploy' + query, {method: 'POST'})
+    .then(response => response.json())
+    .then(data => {
+        if (data.success) {
+            showMessage(name + ' redeployed' + (data.result.image_updated ? ' with a new image' : ' (image unchanged)'), 'success');
+            refreshContainers();
+        } else if (data.protected && confirm('This container is protected. Redeploy it anyway?')) {
+            redeployContainer(containerID, name, '?override=true');
+        } else {
+            showMessage('Error: ' + data.error, 'error');
+        }
+    })
+    .catch(err => showMessage('Redeploy failed: ' + err, 'error'));
+}
+
+function cloneContainer(containerID, name) {
+    const cloneName = prompt('Name for the copy of ' + name + ':', name + '-staging');
+    if (!cloneName) {
+        return;
+    }
+    const ports = prompt('Port mappings for the copy (comma separated, empty for none):', '');
+    if (ports === null) {
+        return;
+    }
+
+    fetch('api/containers/' + containerID + '/clone', {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify({name: cloneName, ports: splitList(ports)})
+    })
+    .then(response => response.json())
+    .then(data => {
+        if (data.success) {
+            showMessage(name + ' cloned as ' + cloneName, 'success');
+            refreshContainers();
+        } else {
+            showMessage('Error: ' + data.error, 'error');
+        }
+    })
+    .catch(err => showMessage('Clone failed: ' + err, 'error'));
+}
+
+function showMessage(message, type) {
+    const messageDiv = document.getElementById('message');
+    messageDiv.innerHTML = '<div class="' + type + '">' + message + '</div>';
+    setTimeout(() => messageDiv.innerHTML = '', 5000);
+}
+
+let liveSocket = null;
+let liveReconnectTimer = null;
+
+// followJob shows a background job's progress bar and output until
+// it finishes. Progress -1 means unknown and shows an indeterminate bar.
+function followJob(id, label, onDone) {
+    const panel = document.getElementById('jobPanel');
+    const bar = document.getElementById('jobProgress');
+    const output = document.getElementById('jobOutput');
+    panel.style.display = 'block';
+    output.textContent = '';
+    bar.removeAttribute('value');
+    document.getElementById('jobLabel').textContent = label + ': queued';
+
+    const source = new EventSource('api/jobs/' + encodeURIComponent(id) + '/stream');
+    source.addEventListener('output', e => {
+        output.textContent += JSON.parse(e.data).line + '\n';
+        output.scrollTop = output.scrollHeight;
+    });
+    source.addEventListener('progress', e => {
+        const data = JSON.parse(e.data);
+        if (data.progress >= 0) {
+            bar.value = data.progress;
+        }
+        document.getElementById('jobLabel').textContent = label + ': ' + data.state + (data.progress >= 0 ? ' ' + data.progress + '%' : '');
+    });
+    source.addEventListener('done', e => {
+        source.close();
+        const job = JSON.parse(e.data);
+        bar.value = job.state === 'succeeded' ? 100 : bar.value;
+        document.getElementById('jobLabel').textContent = label + ': ' + job.state;
+        if (job.state === 'succeeded') {
+            showMessage(label + ' finished', 'success');
+        } else {
+            showMessage(label + ' ' + job.state + ': ' + (job.error || ''), 'error');
+        }
+        if (onDone) {
+            onDone(job);
+        }
+    });
+}
+
+function pullImage() {
+    const image = document.getElementById('pullImage').value.trim();
+    if (!image) {
+        return;
+    }
+    fetch('api/images/pull', {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify({image: image})
+    })
+    .then(response => response.json())
+    .then(data => {
+        if (data.success) {
+            followJob(data.job_id, 'Pull ' + image, refreshImages);
+        } else {
+            showMessage('Error: ' + data.error, 'error');
+        }
+    })
+    .catch(err => showMessage('Pull failed: ' + err, 'error'));
+}
+
+function refreshImages() {
+    fetch('api/images')
+    .then(response => response.json())
+    .then(data => {
+        const tbody = document.getElementById('imagesBody');
+        tbody.innerHTML = '';
+        if (!data.success) {
+            showMessage('Error: ' + data.error, 'error');
+            return;
+        }
+        data.images.forEach(image => {
+            const ref = image.repository !== '<none>' ? image.repository + ':' + image.tag : image.id;
+            const row = document.createElement('tr');
+            row.innerHTML =
+                '<td>' + escapeHTML(image.repository) + '</td>' +
+                '<td>' + escapeHTML(image.tag) + '</td>' +
+                '<td>' + escapeHTML(image.id.replace('sha256:', '').substring(0, 12)) + '</td>' +
+                '<td>' + escapeHTML(image.created) + '</td>' +
+                '<td>' + escapeHTML(image.size) + '</td>' +
+                '<td>' +
+                    '<button class="btn btn-primary" onclick="showImageInspect(\'' + escapeHTML(ref) + '\')">🔍 Inspect</button>' +
+                    '<button class="btn btn-primary" onclick="showImageHistory(\'' + escapeHTML(ref) + '\')">📚 History</button>' +
+                    '<a class="btn btn-primary" href="api/images/' + encodeURIComponent(ref) + '/save">⬇️ Save</a>' +
+                '</td>';
+            tbody.appendChild(row);
+        });
+    })
+    .catch(err => showMessage('Failed to fetch images: ' + err.message, 'error'));
+}
+
+function showImageInspect(image) {
+    fetch('api/images/' + encodeURIComponent(image) + '/inspect')
+    .then(response => response.json())
+    .then(data => {
+        if (!data.success) {
+            showMessage('Error: ' + data.error, 'error');
+            return;
+        }
+        const tree = document.getElementById('inspectTree');
+        tree.innerHTML = '';
+        tree.appendChild(jsonNode(image, data.image, true));
+        document.getElementById('inspectTitle').textContent = '🔍 ' + image;
+        document.getElementById('inspectPanel').style.display = 'block';
+    })
+    .catch(err => showMessage('Inspect failed: ' + err, 'error'));
+}
+
+function showImageHistory(image) {
+    fetch('api/images/' + encodeURIComponent(image) + '/history')
+    .then(response => response.json())
+    .then(data => {
+        if (!data.success) {
+            showMessage('Error: ' + data.error, 'error');
+            return;
+        }
+        const history = document.getElementById('imageHistory');
+        history.textContent = image + ' (' + (data.total_size / 1048576).toFixed(1) + ' MB)\n\n' +
+            data.layers.map(layer => (layer.size / 1048576).toFixed(1).padStart(8) + ' MB  ' + layer.created_by).join('\n');
+        history.style.display = 'block';
+    })
+    .catch(err => showMessage('History failed: ' + err, 'error'));
+}
+
+function changePassword() {
+    fetch('api/auth/password', {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify({
+            current_password: document.getElementById('currentPassword').value,
+            new_password: document.getElementById('newPassword').value
+        })
+    })
+    .then(response => response.json())
+    .then(data => {
+        if (data.success) {
+            showMessage(data.message, 'success');
+            document.getElementById('currentPassword').value = '';
+            document.getElementById('newPassword').value = '';
+        } else {
+            showMessage('Error: ' + data.error, 'error');
+        }
+    })
+    .catch(err => showMessage('Password change failed: ' + err, 'error'));
+}
+
+// An expired session makes every API call fail; send the user back to
+// the login page instead.
+const apiFetch = window.fetch;
+window.fetch = (url, options = {}) => {
+    // Changes must carry the session's CSRF token.
+    const method = (options.method || 'GET').toUpperCase();
+    if (method !== 'GET' && method !== 'HEAD') {
+        const match = document.cookie.match(/(?:^|; )rdm_csrf=([^;]*)/);
+        options = Object.assign({}, options, {
+            headers: Object.assign({}, options.headers, {'X-CSRF-Token': match ? match[1] : ''})
+        });
+    }
+    return apiFetch(url, options).then(response => {
+        if (response.status === 401) {
+            window.location = 'login';
+        }
+        return response;
+    });
+};
+
+function showCurrentUser() {
+    fetch('api/auth/me')
+    .then(response => response.json())
+    .then(data => {
+        if (data.auth_enabled) {
+            document.getElementById('currentUser').textContent = '👤 ' + data.username;
+            document.getElementById('logoutButton').style.display = 'inline-block';
+            const passwordSection = document.getElementById('passwordSection');
+            if (passwordSection) {
+                passwordSection.style.display = 'block';
+            }
+        }
+    });
+}
+
+function selectLanguage(code) {
+    fetch('api/auth/language', {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify({language: code})
+    })
+    .then(response => response.json())
+    .then(data => {
+        if (data.success) {
+            window.location.reload();
+        } else {
+            showMessage('Error: ' + data.error, 'error');
+        }
+    })
+    .catch(err => showMessage('Switching language failed: ' + err, 'error'));
+}
+
+function logout() {
+    fetch('api/auth/logout', {method: 'POST'})
+    .then(() => window.location = 'login');
+}
+
+// withKnownSize keeps the sizes of a container already in the table, since
+// live updates are listed without them.
+function withKnownSize(container) {
+    const known = liveContainers[container.id];
+    if (known && container.size_display === undefined) {
+        container.size_rw = known.size_rw;
+        container.size_virtual = known.size_virtual;
+        container.size_display = known.size_display;
+    }
+    return container;
+}
+
+function applyContainerChange(change) {
+    const tbody = document.getElementById('containersBody');
+    const row = tbody.querySelector('tr[data-id="' + (change.id || change.container.id) + '"]');
+    if (change.type === 'remove') {
+        delete liveContainers[change.id];
+        if (row) {
+            row.remove();
+        }
+        return;
+    }
+
+    const container = withKnownSize(change.container);
+    liveContainers[container.id] = container;
+    const updated = containerRow(container);
+    if (row) {
+        // Keep the selection for bulk actions.
+        updated.querySelector('.containerSelect').checked = row.querySelector('.containerSelect').checked;
+        row.replaceWith(updated);
+    } else {
+        tbody.querySelectorAll('tr:not([data-id])').forEach(placeholder => placeholder.remove());
+        tbody.appendChild(updated);
+    }
+}
+
+// watchContainers keeps the containers table current from the live
+// WebSocket feed, reconnecting (and so starting over from a snapshot)
+// whenever the connection drops.
+function watchContainers() {
+    clearTimeout(liveReconnectTimer);
+    if (liveSocket) {
+        liveSocket.onclose = null;
+        liveSocket.close();
+    }
+
+    const params = new URLSearchParams();
+    const label = document.getElementById('labelFilter').value.trim();
+    if (label) {
+        params.set('label', label);
+    }
+    const protocol = location.protocol === 'https:' ? 'wss://' : 'ws://';
+    liveSocket = new WebSocket(protocol + location.host + basePath + '/api/containers/live?' + params.toString());
+    liveSocket.onmessage = event => {
+        const data = JSON.parse(event.data);
+        if (data.error) {
+            showMessage('Live updates failed: ' + data.error, 'error');
+        } else if (data.type === 'snapshot') {
+            updateContainersTable(data.containers.map(withKnownSize));
+        } else {
+            applyContainerChange(data);
+        }
+    };
+    liveSocket.onclose = () => {
+        liveReconnectTimer = setTimeout(watchContainers, 5000);
+    };
+}
+
+window.onload = function() {
+    showCurrentUser();
+    refreshServerInfo();
+    loadServers();
+    refreshHostMetrics();
+    setInterval(refreshHostMetrics, 30000);
+    refreshPage();
+    if (document.body.dataset.page === 'containers') {
+        watchContainers();
+    }
+};
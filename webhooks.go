@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const webhooksCollection = "webhooks"
+
+// Webhook lets a CI pipeline redeploy a container or a compose stack by
+// POSTing to its URL. The secret is only returned when the webhook is
+// created.
+type Webhook struct {
+	ID            string    `json:"id"`
+	Server        string    `json:"server"`
+	Kind          string    `json:"kind"`
+	Target        string    `json:"target"`
+	Secret        string    `json:"secret,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	LastTriggered time.Time `json:"last_triggered"`
+}
+
+func (h *Webhook) Validate() error {
+	switch h.Kind {
+	case "container":
+		if h.Target == "" {
+			return requestErrorf(codeInvalidRequest, "target container is required")
+		}
+	case "stack":
+		return validComposeProject(h.Target)
+	default:
+		return requestErrorf(codeInvalidRequest, "invalid kind %q: use container or stack", h.Kind)
+	}
+	return nil
+}
+
+func (h *Webhook) url(r *http.Request) string {
+	return externalURL(r, fmt.Sprintf("/api/webhooks/%s/trigger?secret=%s", h.ID, h.Secret))
+}
+
+func webhooks(server string) ([]Webhook, error) {
+	docs, err := store.List(webhooksCollection)
+	if err != nil {
+		return nil, err
+	}
+	hooks := []Webhook{}
+	for _, key := range sortedMetricKeys(docs) {
+		var hook Webhook
+		if json.Unmarshal(docs[key], &hook) == nil && hook.Server == server {
+			hook.Secret = ""
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks, nil
+}
+
+// deployWebhookTarget pulls and recreates the target. A stack registered for
+// GitOps is deployed from its repository instead.
+func (dm *DockerManager) deployWebhookTarget(ctx context.Context, hook *Webhook, run *jobRun) (interface{}, error) {
+	if hook.Kind == "container" {
+		result, err := dm.RedeployContainer(hook.Target, false)
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	var stack GitStack
+	found, err := store.Get(gitStacksCollection, hook.Server+"/"+hook.Target, &stack)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		deployment := dm.DeployGitStack(ctx, &stack, "webhook")
+		if deployment.Output != "" {
+			run.Log(deployment.Output)
+		}
+		if deployment.Error != "" {
+			return deployment, fmt.Errorf("%s", deployment.Error)
+		}
+		return deployment, nil
+	}
+	if err := dm.ComposeAction(ctx, hook.Target, "pull", run.Log); err != nil {
+		return nil, err
+	}
+	return nil, dm.ComposeAction(ctx, hook.Target, "up", run.Log)
+}
+
+func webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		writeError(w, codeUnavailable, "Webhooks are not available")
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		hooks, err := webhooks(manager.config.ID())
+		if err != nil {
+			writeFailure(w, fmt.Errorf("Failed to read webhooks: %w", err))
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"success":  true,
+			"webhooks": hooks,
+			"count":    len(hooks),
+		})
+	case "POST":
+		var hook Webhook
+		if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+			writeError(w, codeInvalidRequest, "Invalid JSON format")
+			return
+		}
+		if err := hook.Validate(); err != nil {
+			writeFailure(w, err)
+			return
+		}
+		if hook.Kind == "container" {
+			// Store the name, which survives the container being recreated.
+			info, err := manager.inspectContainer(hook.Target)
+			if err != nil {
+				writeFailure(w, err)
+				return
+			}
+			hook.Target = strings.TrimPrefix(info.Name, "/")
+		}
+		hook.ID = newRequestID()
+		hook.Secret = newRequestID() + newRequestID()
+		hook.Server = manager.config.ID()
+		hook.CreatedAt = time.Now().UTC()
+		hook.LastTriggered = time.Time{}
+
+		err := store.Put(webhooksCollection, hook.ID, hook)
+		recordAudit(r, manager.config.ID(), "webhook.create", hook.Kind+":"+hook.Target, err)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"success": true,
+			"webhook": hook,
+			"url":     hook.url(r),
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func webhookRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if store == nil {
+		writeError(w, codeUnavailable, "Webhooks are not available")
+		return
+	}
+
+	manager, ok := requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	var hook Webhook
+	found, err := store.Get(webhooksCollection, id, &hook)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	if !found || hook.Server != manager.config.ID() {
+		writeError(w, codeNotFound, "Webhook not found: "+id)
+		return
+	}
+
+	err = store.Delete(webhooksCollection, id)
+	recordAudit(r, manager.config.ID(), "webhook.remove", hook.Kind+":"+hook.Target, err)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"message": "Webhook " + id + " removed",
+	})
+}
+
+// webhookTriggerHandler is called by CI. The secret comes from the URL or
+// the X-Webhook-Secret header; a wrong secret and an unknown webhook get the
+// same 403 so neither can be probed.
+func webhookTriggerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if store == nil {
+		writeError(w, codeUnavailable, "Webhooks are not available")
+		return
+	}
+
+	secret := r.URL.Query().Get("secret")
+	if secret == "" {
+		secret = r.Header.Get("X-Webhook-Secret")
+	}
+	var hook Webhook
+	found, err := store.Get(webhooksCollection, mux.Vars(r)["id"], &hook)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	if !found || secret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(hook.Secret)) != 1 {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Triggers have no session to select a server by; the hook names its own.
+	registered, ok := registry.Get(hook.Server)
+	if !ok {
+		writeError(w, codeConflict, "Server of this webhook is not configured: "+hook.Server)
+		return
+	}
+	manager := registered.withRequest(r)
+
+	target := hook
+	job, err := submitJob(r, manager, "webhook.deploy", hook.Kind+":"+hook.Target, hook.Kind != "container", func(ctx context.Context, run *jobRun) (interface{}, error) {
+		return manager.deployWebhookTarget(ctx, &target, run)
+	})
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	hook.LastTriggered = job.CreatedAt
+	if err := store.Put(webhooksCollection, hook.ID, hook); err != nil {
+		manager.logger.Error("failed to save webhook", "webhook", hook.ID, "error", err)
+	}
+	manager.logger.Info("webhook triggered", "webhook", hook.ID, "job", job.ID, "target", hook.Target)
+	writeJSON(w, map[string]interface{}{
+		"success": true,
+		"job_id":  job.ID,
+		"state":   job.State,
+		"message": "Deployment of " + hook.Target + " queued",
+	})
+}